@@ -41,6 +41,9 @@ type (
 		storageServiceUrl string
 		builderManagerUrl string
 		workflowApiUrl    string
+		routerInternalUrl string
+		executorUrl       string
+		functionNamespace string
 	}
 
 	logDBConfig struct {
@@ -67,8 +70,31 @@ func MakeAPI() (*API, error) {
 		api.builderManagerUrl = "http://buildermgr"
 	}
 
-	wfEnv := os.Getenv("WORKFLOW_API_URL")
+	// the routers' internal API, which serves captured requests
+	u = os.Getenv("ROUTER_INTERNAL_URL")
+	if len(u) > 0 {
+		api.routerInternalUrl = strings.TrimSuffix(u, "/")
+	} else {
+		api.routerInternalUrl = "http://router-internal.fission:8889"
+	}
+
+	u = os.Getenv("EXECUTOR_URL")
 	if len(u) > 0 {
+		api.executorUrl = strings.TrimSuffix(u, "/")
+	} else {
+		api.executorUrl = "http://executor.fission"
+	}
+
+	// where the pool manager runs functions and pre-pulls images
+	ns := os.Getenv("FISSION_FUNCTION_NAMESPACE")
+	if len(ns) > 0 {
+		api.functionNamespace = ns
+	} else {
+		api.functionNamespace = "fission-function"
+	}
+
+	wfEnv := os.Getenv("WORKFLOW_API_URL")
+	if len(wfEnv) > 0 {
 		api.workflowApiUrl = strings.TrimSuffix(wfEnv, "/")
 	} else {
 		api.workflowApiUrl = "http://workflows-apiserver"
@@ -182,6 +208,11 @@ func (api *API) Serve(port int) {
 	r.HandleFunc("/proxy/storage/v1/archive", api.StorageServiceProxy)
 	r.HandleFunc("/proxy/logs/{function}", api.FunctionPodLogs).Methods("POST")
 	r.HandleFunc("/proxy/workflows-apiserver/{path:.*}", api.WorkflowApiserverProxy)
+	r.HandleFunc("/proxy/router/captures", api.RequestCaptureProxy).Methods("GET")
+	r.HandleFunc("/proxy/router/captures/{capture}", api.RequestCaptureProxy).Methods("GET")
+	r.HandleFunc("/proxy/executor/functionServices", api.ExecutorProxy)
+	r.HandleFunc("/proxy/executor/functionServices/{rest:.*}", api.ExecutorProxy)
+	r.HandleFunc("/proxy/executor/usage", api.ExecutorProxy).Methods("GET")
 
 	address := fmt.Sprintf(":%v", port)
 
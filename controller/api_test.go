@@ -23,6 +23,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -163,7 +164,7 @@ func TestHTTPTriggerApi(t *testing.T) {
 
 	tr, err := g.client.HTTPTriggerGet(m)
 	panicIf(err)
-	assert(testTrigger.Spec == tr.Spec, "trigger should match after reading")
+	assert(reflect.DeepEqual(testTrigger.Spec, tr.Spec), "trigger should match after reading")
 
 	testTrigger.Metadata.ResourceVersion = m.ResourceVersion
 	testTrigger.Spec.RelativeURL = "/hi"
@@ -268,7 +269,7 @@ func TestWatchApi(t *testing.T) {
 	panicIf(err)
 	assert((testWatch.Spec.Namespace == w.Spec.Namespace &&
 		testWatch.Spec.Type == w.Spec.Type &&
-		testWatch.Spec.FunctionReference == w.Spec.FunctionReference), "watch should match after reading")
+		reflect.DeepEqual(testWatch.Spec.FunctionReference, w.Spec.FunctionReference)), "watch should match after reading")
 
 	testWatch.Metadata.Name = "yyy"
 	m2, err := g.client.WatchCreate(testWatch)
@@ -306,7 +307,7 @@ func TestTimeTriggerApi(t *testing.T) {
 
 	tr, err := g.client.TimeTriggerGet(m)
 	panicIf(err)
-	assert(testTrigger.Spec == tr.Spec, "trigger should match after reading")
+	assert(reflect.DeepEqual(testTrigger.Spec, tr.Spec), "trigger should match after reading")
 
 	testTrigger.Metadata.ResourceVersion = m.ResourceVersion
 	testTrigger.Spec.Cron = "@hourly"
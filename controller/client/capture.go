@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+func (c *Client) captureUrl(relativeUrl string) string {
+	return c.Url + "/proxy/router/captures" + relativeUrl
+}
+
+func (c *Client) CaptureList(m *metav1.ObjectMeta) ([]fission.CapturedRequest, error) {
+	query := url.Values{}
+	if m != nil {
+		query.Set("function", m.Name)
+		query.Set("namespace", m.Namespace)
+	}
+
+	resp, err := http.Get(c.captureUrl("?" + query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	captures := make([]fission.CapturedRequest, 0)
+	err = json.Unmarshal(body, &captures)
+	if err != nil {
+		return nil, err
+	}
+	return captures, nil
+}
+
+func (c *Client) CaptureGet(id string) (*fission.CapturedRequest, error) {
+	resp, err := http.Get(c.captureUrl(fmt.Sprintf("/%v", id)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var capture fission.CapturedRequest
+	err = json.Unmarshal(body, &capture)
+	if err != nil {
+		return nil, err
+	}
+	return &capture, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+func (c *Client) functionServiceUrl(relativeUrl string) string {
+	return c.Url + "/proxy/executor/functionServices" + relativeUrl
+}
+
+func (c *Client) functionServiceObjectUrl(m *metav1.ObjectMeta, action string) string {
+	namespace := m.Namespace
+	if len(namespace) == 0 {
+		namespace = metav1.NamespaceDefault
+	}
+	return c.functionServiceUrl(fmt.Sprintf("/%v/%v%v", url.PathEscape(namespace), url.PathEscape(m.Name), action))
+}
+
+func (c *Client) decodeFunctionServices(resp *http.Response) ([]fission.FunctionServiceInfo, error) {
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fission.FunctionServiceInfo, 0)
+	err = json.Unmarshal(body, &infos)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// FunctionServiceList lists the running instances of a function, or of
+// all functions if m is nil.
+func (c *Client) FunctionServiceList(m *metav1.ObjectMeta) ([]fission.FunctionServiceInfo, error) {
+	query := url.Values{}
+	if m != nil {
+		query.Set("function", m.Name)
+		query.Set("namespace", m.Namespace)
+	}
+
+	resp, err := http.Get(c.functionServiceUrl("?" + query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.decodeFunctionServices(resp)
+}
+
+// FunctionServiceEvict releases all running instances of a function.
+func (c *Client) FunctionServiceEvict(m *metav1.ObjectMeta) error {
+	req, err := http.NewRequest("DELETE", c.functionServiceObjectUrl(m, ""), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = c.handleResponse(resp)
+	return err
+}
+
+// FunctionServiceSpecialize replaces the running instances of a
+// function with a freshly specialized one.
+func (c *Client) FunctionServiceSpecialize(m *metav1.ObjectMeta) ([]fission.FunctionServiceInfo, error) {
+	resp, err := http.Post(c.functionServiceObjectUrl(m, "/specialize"), "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.decodeFunctionServices(resp)
+}
+
+// FunctionServiceWarm starts an instance of a function, unless it's
+// already running.  If holdFor is set, the function's instances are
+// kept that long even when idle.
+func (c *Client) FunctionServiceWarm(m *metav1.ObjectMeta, holdFor time.Duration) ([]fission.FunctionServiceInfo, error) {
+	warmUrl := c.functionServiceObjectUrl(m, "/warm")
+	if holdFor > 0 {
+		warmUrl += "?" + url.Values{"duration": []string{holdFor.String()}}.Encode()
+	}
+	resp, err := http.Post(warmUrl, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.decodeFunctionServices(resp)
+}
+
+// FunctionServiceColdStarts returns the timelines of a function's
+// latest cold starts, oldest first.
+func (c *Client) FunctionServiceColdStarts(m *metav1.ObjectMeta) ([]fission.ColdStart, error) {
+	resp, err := http.Get(c.functionServiceObjectUrl(m, "/coldstarts"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	coldStarts := make([]fission.ColdStart, 0)
+	err = json.Unmarshal(body, &coldStarts)
+	if err != nil {
+		return nil, err
+	}
+	return coldStarts, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// Usage returns the resource usage and invocations of each function
+// over the hours that overlap [start, end).  Empty namespace or
+// function names match all functions.
+func (c *Client) Usage(start time.Time, end time.Time, namespace string, function string) ([]fission.UsageRecord, error) {
+	query := url.Values{}
+	query.Set("start", start.Format(time.RFC3339))
+	query.Set("end", end.Format(time.RFC3339))
+	if len(namespace) > 0 {
+		query.Set("namespace", namespace)
+	}
+	if len(function) > 0 {
+		query.Set("function", function)
+	}
+
+	resp, err := http.Get(c.Url + "/proxy/executor/usage?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := c.handleResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]fission.UsageRecord, 0)
+	err = json.Unmarshal(body, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
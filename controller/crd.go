@@ -18,9 +18,17 @@ package controller
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
 
+	"github.com/robfig/cron"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	_ "github.com/fission/fission/executor/backends"
 )
 
 func makeCRDBackedAPI() (*API, error) {
@@ -38,3 +46,162 @@ func validateResourceName(name string) error {
 	}
 	return nil
 }
+
+func validateFunctionReference(fr *fission.FunctionReference) error {
+	switch fr.Type {
+	case fission.FunctionReferenceTypeFunctionName:
+		if len(fr.Name) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Function reference needs a function name")
+		}
+	case fission.FunctionReferenceTypeFunctionPipeline:
+		if len(fr.Pipeline.Steps) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Function pipeline needs at least one step")
+		}
+		for i, step := range fr.Pipeline.Steps {
+			if len(step.FunctionName) == 0 {
+				return fission.MakeError(fission.ErrorInvalidArgument,
+					fmt.Sprintf("Function pipeline step %v needs a function name", i))
+			}
+			if step.Timeout.Duration < 0 {
+				return fission.MakeError(fission.ErrorInvalidArgument,
+					fmt.Sprintf("Function pipeline step %v has a negative timeout", i))
+			}
+		}
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown function reference type '%v'", fr.Type))
+	}
+	return nil
+}
+
+// validateFunction checks that a function runs on a known executor
+// backend, only refers to Secrets and ConfigMaps in its own namespace,
+// that its keep-warm schedule parses, and that its pod template
+// applies.
+func validateFunction(f *crd.Function) error {
+	executorType := f.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType
+	if len(executorType) > 0 && !backend.IsRegistered(executorType) {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown executor type '%v', expected one of %v", executorType, backend.Types()))
+	}
+
+	ns := f.Metadata.Namespace
+	if len(ns) == 0 {
+		ns = metav1.NamespaceDefault
+	}
+	for _, s := range f.Spec.Secrets {
+		if len(s.Name) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Secret reference needs a name")
+		}
+		if len(s.Namespace) > 0 && s.Namespace != ns {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("Secret %v/%v is not in the function's namespace %v", s.Namespace, s.Name, ns))
+		}
+	}
+	for _, c := range f.Spec.ConfigMaps {
+		if len(c.Name) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "ConfigMap reference needs a name")
+		}
+		if len(c.Namespace) > 0 && c.Namespace != ns {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				fmt.Sprintf("ConfigMap %v/%v is not in the function's namespace %v", c.Namespace, c.Name, ns))
+		}
+	}
+	for _, e := range f.Spec.Env {
+		if len(e.Name) == 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Environment variables need a name")
+		}
+	}
+	if keepWarm := f.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm; keepWarm != nil {
+		if keepWarm.IdleTTL.Duration < 0 || keepWarm.WindowDuration.Duration < 0 {
+			return fission.MakeError(fission.ErrorInvalidArgument, "Keep-warm TTL and window must not be negative")
+		}
+		if len(keepWarm.Schedule) > 0 {
+			_, err := cron.Parse(keepWarm.Schedule)
+			if err != nil {
+				return fission.MakeError(fission.ErrorInvalidArgument, "Keep-warm cron spec is not valid")
+			}
+			if keepWarm.WindowDuration.Duration == 0 {
+				return fission.MakeError(fission.ErrorInvalidArgument, "Keep-warm schedule needs a window duration")
+			}
+		}
+	}
+	if f.Spec.PodTemplate != nil {
+		if f.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeNewdeploy {
+			return fission.MakeError(fission.ErrorInvalidArgument,
+				"Pod templates can only be set on newdeploy functions; set it on the environment instead")
+		}
+		// the runtime image comes from the environment
+		err := validatePodTemplate(f.Metadata.Name, "runtime", f.Spec.PodTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePodTemplate merges a pod template override into a stand-in
+// for the pods executors create, with a runtime container of the
+// given name and image, and checks the result.
+func validatePodTemplate(runtimeName string, runtimeImage string, override *apiv1.PodTemplateSpec) error {
+	sample := &apiv1.PodTemplateSpec{
+		Spec: apiv1.PodSpec{
+			Volumes: []apiv1.Volume{
+				{
+					Name: "userfunc",
+					VolumeSource: apiv1.VolumeSource{
+						EmptyDir: &apiv1.EmptyDirVolumeSource{},
+					},
+				},
+			},
+			Containers: []apiv1.Container{
+				{
+					Name:         runtimeName,
+					Image:        runtimeImage,
+					VolumeMounts: []apiv1.VolumeMount{{Name: "userfunc", MountPath: "/userfunc"}},
+				},
+				{
+					Name:         "fetcher",
+					Image:        "fetcher",
+					VolumeMounts: []apiv1.VolumeMount{{Name: "userfunc", MountPath: "/userfunc"}},
+				},
+			},
+		},
+	}
+	merged, err := crd.MergePodTemplate(sample, override)
+	if err != nil {
+		return fission.MakeError(fission.ErrorInvalidArgument, err.Error())
+	}
+	err = crd.ValidatePodTemplate(merged)
+	if err != nil {
+		return fission.MakeError(fission.ErrorInvalidArgument, fmt.Sprintf("Invalid pod template: %v", err))
+	}
+	return nil
+}
+
+func validateEnvironmentSpec(spec *fission.EnvironmentSpec) error {
+	if spec.Poolsize < 0 || spec.MinPoolsize < 0 || spec.MaxPoolsize < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Pool sizes must not be negative")
+	}
+	if spec.MaxPoolsize > 0 && spec.MaxPoolsize < spec.MinPoolsize {
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Maximum pool size (%v) is less than the minimum pool size (%v)", spec.MaxPoolsize, spec.MinPoolsize))
+	}
+	switch spec.PodSelectionStrategy {
+	case "", fission.PodSelectionStrategyRandom, fission.PodSelectionStrategySpread,
+		fission.PodSelectionStrategyPackageCache, fission.PodSelectionStrategyLeastLoaded:
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown pod selection strategy '%v'", spec.PodSelectionStrategy))
+	}
+	if spec.ReadinessTimeout.Duration < 0 || spec.IdlePodTTL.Duration < 0 || spec.SpecializationRetries < 0 {
+		return fission.MakeError(fission.ErrorInvalidArgument, "Pool timeouts and retries must not be negative")
+	}
+	switch spec.ImagePullPolicy {
+	case "", apiv1.PullAlways, apiv1.PullNever, apiv1.PullIfNotPresent:
+	default:
+		return fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown image pull policy '%v'", spec.ImagePullPolicy))
+	}
+	return nil
+}
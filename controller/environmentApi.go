@@ -24,6 +24,9 @@ import (
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
@@ -35,6 +38,7 @@ func (a *API) EnvironmentApiList(w http.ResponseWriter, r *http.Request) {
 		a.respondWithError(w, err)
 		return
 	}
+	a.addEnvironmentStatus(envs.Items)
 
 	resp, err := json.Marshal(envs.Items)
 	if err != nil {
@@ -66,6 +70,23 @@ func (a *API) EnvironmentApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateEnvironmentSpec(&env.Spec)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
+	if env.Spec.PodTemplate != nil {
+		err = validatePodTemplate(env.Metadata.Name, env.Spec.Runtime.Image, env.Spec.PodTemplate)
+		if err != nil {
+			a.respondWithError(w, err)
+			return
+		}
+	}
+
+	// the status is Fission's
+	env.Status = fission.EnvironmentStatus{}
+
 	enew, err := a.fissionClient.Environments(env.Metadata.Namespace).Create(&env)
 	if err != nil {
 		a.respondWithError(w, err)
@@ -95,6 +116,9 @@ func (a *API) EnvironmentApiGet(w http.ResponseWriter, r *http.Request) {
 		a.respondWithError(w, err)
 		return
 	}
+	envs := []crd.Environment{*env}
+	a.addEnvironmentStatus(envs)
+	env = &envs[0]
 
 	resp, err := json.Marshal(env)
 	if err != nil {
@@ -128,6 +152,23 @@ func (a *API) EnvironmentApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateEnvironmentSpec(&env.Spec)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
+	if env.Spec.PodTemplate != nil {
+		err = validatePodTemplate(env.Metadata.Name, env.Spec.Runtime.Image, env.Spec.PodTemplate)
+		if err != nil {
+			a.respondWithError(w, err)
+			return
+		}
+	}
+
+	// the status isn't stored; see addEnvironmentStatus
+	env.Status = fission.EnvironmentStatus{}
+
 	enew, err := a.fissionClient.Environments(env.Metadata.Namespace).Update(&env)
 	if err != nil {
 		a.respondWithError(w, err)
@@ -159,3 +200,50 @@ func (a *API) EnvironmentApiDelete(w http.ResponseWriter, r *http.Request) {
 
 	a.respondWithSuccess(w, []byte(""))
 }
+
+// addEnvironmentStatus fills in the status of envs.  The progress of
+// image pre-pulls is kept by the pool manager in an annotation of each
+// environment's pre-pull DaemonSet; it's left out if the DaemonSets
+// can't be listed.
+func (a *API) addEnvironmentStatus(envs []crd.Environment) {
+	for i := range envs {
+		envs[i].Status = fission.EnvironmentStatus{}
+	}
+	dsList, err := a.kubernetesClient.ExtensionsV1beta1().DaemonSets(a.functionNamespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"executorType": fission.ExecutorTypePoolmgr,
+		}).String(),
+	})
+	if err != nil {
+		log.Printf("Error listing image pre-pulls: %v", err)
+		return
+	}
+
+	// while the images change, the old DaemonSet may linger
+	latest := make(map[types.UID]*v1beta1.DaemonSet)
+	for i := range dsList.Items {
+		ds := &dsList.Items[i]
+		if _, ok := ds.ObjectMeta.Annotations[fission.IMAGE_PREPULL_STATUS_ANNOTATION]; !ok {
+			continue
+		}
+		uid := types.UID(ds.ObjectMeta.Labels["environmentUid"])
+		if other, ok := latest[uid]; ok &&
+			!other.ObjectMeta.CreationTimestamp.Time.Before(ds.ObjectMeta.CreationTimestamp.Time) {
+			continue
+		}
+		latest[uid] = ds
+	}
+	for i := range envs {
+		ds, ok := latest[envs[i].Metadata.UID]
+		if !ok || !envs[i].Spec.PrePullImages {
+			continue
+		}
+		status := &fission.ImagePrePullStatus{}
+		err := json.Unmarshal([]byte(ds.ObjectMeta.Annotations[fission.IMAGE_PREPULL_STATUS_ANNOTATION]), status)
+		if err != nil {
+			log.Printf("Ignoring invalid image pre-pull status of %v: %v", ds.ObjectMeta.Name, err)
+			continue
+		}
+		envs[i].Status.ImagePrePull = status
+	}
+}
@@ -80,6 +80,12 @@ func (a *API) HTTPTriggerApiCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateFunctionReference(&t.Spec.FunctionReference)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
 	// Ensure we don't have a duplicate HTTP route defined (same URL and method)
 	err = a.checkHTTPTriggerDuplicates(&t)
 	if err != nil {
@@ -149,6 +155,12 @@ func (a *API) HTTPTriggerApiUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = validateFunctionReference(&t.Spec.FunctionReference)
+	if err != nil {
+		a.respondWithError(w, err)
+		return
+	}
+
 	err = a.checkHTTPTriggerDuplicates(&t)
 	if err != nil {
 		a.respondWithError(w, err)
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// routerClient is used to collect captures from the routers
+var routerClient = &http.Client{Timeout: 10 * time.Second}
+
+// RequestCaptureProxy serves captured function requests.  Each router
+// keeps the requests it captured in memory, on its internal port, so
+// the controller asks all of them: the internal router service is
+// headless, and its name resolves to the address of each router.
+func (api *API) RequestCaptureProxy(w http.ResponseWriter, r *http.Request) {
+	routers, err := api.routerInternalUrls()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	path := "/fission-router/" + strings.TrimPrefix(r.URL.Path, "/proxy/router/")
+	if path == "/fission-router/captures" {
+		api.listCaptures(w, routers, path+"?"+r.URL.RawQuery)
+	} else {
+		api.getCapture(w, routers, path)
+	}
+}
+
+// routerInternalUrls returns the internal API url of each router.
+func (api *API) routerInternalUrls() ([]string, error) {
+	u, err := url.Parse(api.routerInternalUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing url %v: %v", api.routerInternalUrl, err)
+	}
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up routers at %v: %v", u.Hostname(), err)
+	}
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := addr
+		if len(u.Port()) > 0 {
+			host = net.JoinHostPort(addr, u.Port())
+		}
+		urls = append(urls, fmt.Sprintf("%v://%v", u.Scheme, host))
+	}
+	return urls, nil
+}
+
+// getFromRouter gets path from a router, returning the response code
+// and body.
+func getFromRouter(routerUrl string, path string) (int, []byte, error) {
+	resp, err := routerClient.Get(routerUrl + path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// listCaptures merges the captures of all routers, newest first.
+func (api *API) listCaptures(w http.ResponseWriter, routers []string, path string) {
+	captures := make([]fission.CapturedRequest, 0)
+	for _, router := range routers {
+		code, body, err := getFromRouter(router, path)
+		if err != nil || code != http.StatusOK {
+			// the other routers' captures are still useful
+			log.Printf("Error listing captures of router %v: %v (status %v)", router, err, code)
+			continue
+		}
+		rc := make([]fission.CapturedRequest, 0)
+		err = json.Unmarshal(body, &rc)
+		if err != nil {
+			log.Printf("Error parsing captures of router %v: %v", router, err)
+			continue
+		}
+		captures = append(captures, rc...)
+	}
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].Timestamp.After(captures[j].Timestamp)
+	})
+
+	resp, err := json.Marshal(captures)
+	if err != nil {
+		api.respondWithError(w, err)
+		return
+	}
+	api.respondWithSuccess(w, resp)
+}
+
+// getCapture returns a capture from whichever router has it.
+func (api *API) getCapture(w http.ResponseWriter, routers []string, path string) {
+	for _, router := range routers {
+		code, body, err := getFromRouter(router, path)
+		if err != nil {
+			log.Printf("Error getting capture from router %v: %v", router, err)
+			continue
+		}
+		if code == http.StatusOK {
+			api.respondWithSuccess(w, body)
+			return
+		}
+	}
+	api.respondWithError(w, fission.MakeError(fission.ErrorNotFound,
+		fmt.Sprintf("capture '%v' not found", strings.TrimPrefix(path, "/fission-router/captures/"))))
+}
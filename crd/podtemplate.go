@@ -0,0 +1,146 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// MergePodTemplate merges each override, in order, into a copy of
+// tmpl using strategic merge patch semantics.  Nil overrides are
+// skipped.  The labels of tmpl are kept whatever the overrides say,
+// since executors find their pods by them.
+func MergePodTemplate(tmpl *apiv1.PodTemplateSpec, overrides ...*apiv1.PodTemplateSpec) (*apiv1.PodTemplateSpec, error) {
+	merged := tmpl
+	for _, override := range overrides {
+		if override == nil {
+			continue
+		}
+
+		original, err := json.Marshal(merged)
+		if err != nil {
+			return nil, err
+		}
+		patch, err := podTemplatePatch(override)
+		if err != nil {
+			return nil, err
+		}
+		result, err := strategicpatch.StrategicMergePatch(original, patch, apiv1.PodTemplateSpec{})
+		if err != nil {
+			return nil, fmt.Errorf("error merging pod template: %v", err)
+		}
+
+		var t apiv1.PodTemplateSpec
+		err = json.Unmarshal(result, &t)
+		if err != nil {
+			return nil, err
+		}
+		merged = &t
+	}
+
+	if merged != tmpl && len(tmpl.ObjectMeta.Labels) > 0 {
+		if merged.ObjectMeta.Labels == nil {
+			merged.ObjectMeta.Labels = make(map[string]string)
+		}
+		for k, v := range tmpl.ObjectMeta.Labels {
+			merged.ObjectMeta.Labels[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// podTemplatePatch serializes a partial pod template as a patch.
+// Fields that aren't set in the override serialize as null, which
+// would delete them in a merge patch, so nulls are dropped.
+func podTemplatePatch(override *apiv1.PodTemplateSpec) ([]byte, error) {
+	b, err := json.Marshal(override)
+	if err != nil {
+		return nil, err
+	}
+	var patch interface{}
+	err = json.Unmarshal(b, &patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dropNulls(patch))
+}
+
+func dropNulls(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropNulls(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = dropNulls(e)
+		}
+	}
+	return v
+}
+
+// ValidatePodTemplate checks that a merged pod template still makes
+// sense: every container has a unique name and an image, and every
+// volume mount refers to a volume.
+func ValidatePodTemplate(tmpl *apiv1.PodTemplateSpec) error {
+	spec := &tmpl.Spec
+	if len(spec.Containers) == 0 {
+		return fmt.Errorf("pod template has no containers")
+	}
+
+	volumes := make(map[string]bool)
+	for _, v := range spec.Volumes {
+		if len(v.Name) == 0 {
+			return fmt.Errorf("pod template has a volume without a name")
+		}
+		if volumes[v.Name] {
+			return fmt.Errorf("pod template has more than one volume named '%v'", v.Name)
+		}
+		volumes[v.Name] = true
+	}
+
+	all := make([]apiv1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	all = append(all, spec.InitContainers...)
+	all = append(all, spec.Containers...)
+	containers := make(map[string]bool)
+	for _, c := range all {
+		if len(c.Name) == 0 {
+			return fmt.Errorf("pod template has a container without a name")
+		}
+		if containers[c.Name] {
+			return fmt.Errorf("pod template has more than one container named '%v'", c.Name)
+		}
+		containers[c.Name] = true
+		if len(c.Image) == 0 {
+			return fmt.Errorf("container '%v' has no image", c.Name)
+		}
+		for _, m := range c.VolumeMounts {
+			if !volumes[m.Name] {
+				return fmt.Errorf("container '%v' mounts unknown volume '%v'", c.Name, m.Name)
+			}
+		}
+	}
+	return nil
+}
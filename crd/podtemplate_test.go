@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func makeTestPodTemplate() *apiv1.PodTemplateSpec {
+	return &apiv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"environmentName": "nodejs"},
+		},
+		Spec: apiv1.PodSpec{
+			Volumes: []apiv1.Volume{
+				{
+					Name: "userfunc",
+					VolumeSource: apiv1.VolumeSource{
+						EmptyDir: &apiv1.EmptyDirVolumeSource{},
+					},
+				},
+			},
+			Containers: []apiv1.Container{
+				{
+					Name:         "nodejs",
+					Image:        "fission/node-env",
+					VolumeMounts: []apiv1.VolumeMount{{Name: "userfunc", MountPath: "/userfunc"}},
+				},
+				{
+					Name:         "fetcher",
+					Image:        "fission/fetcher",
+					VolumeMounts: []apiv1.VolumeMount{{Name: "userfunc", MountPath: "/userfunc"}},
+				},
+			},
+			ServiceAccountName: "fission-fetcher",
+		},
+	}
+}
+
+func TestMergePodTemplate(t *testing.T) {
+	tmpl := makeTestPodTemplate()
+
+	runAsNonRoot := true
+	envOverride := &apiv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"environmentName": "hijacked", "team": "web"},
+			Annotations: map[string]string{"sidecar.istio.io/inject": "true"},
+		},
+		Spec: apiv1.PodSpec{
+			NodeSelector: map[string]string{"disktype": "ssd"},
+			Tolerations:  []apiv1.Toleration{{Key: "dedicated", Operator: apiv1.TolerationOpExists}},
+			Containers: []apiv1.Container{
+				{
+					Name:            "nodejs",
+					SecurityContext: &apiv1.SecurityContext{RunAsNonRoot: &runAsNonRoot},
+				},
+				{
+					Name:  "log-shipper",
+					Image: "fluent/fluent-bit",
+				},
+			},
+		},
+	}
+	fnOverride := &apiv1.PodTemplateSpec{
+		Spec: apiv1.PodSpec{
+			NodeSelector: map[string]string{"disktype": "nvme"},
+		},
+	}
+
+	merged, err := MergePodTemplate(tmpl, envOverride, nil, fnOverride)
+	if err != nil {
+		t.Fatalf("error merging pod template: %v", err)
+	}
+	err = ValidatePodTemplate(merged)
+	if err != nil {
+		t.Fatalf("merged pod template is invalid: %v", err)
+	}
+
+	// the generated template is untouched
+	if len(tmpl.Spec.Containers) != 2 || len(tmpl.Spec.NodeSelector) != 0 {
+		t.Fatalf("original pod template was modified: %+v", tmpl)
+	}
+
+	// fission's labels win; others are added
+	if merged.Labels["environmentName"] != "nodejs" || merged.Labels["team"] != "web" {
+		t.Fatalf("unexpected labels %v", merged.Labels)
+	}
+	if merged.Annotations["sidecar.istio.io/inject"] != "true" {
+		t.Fatalf("unexpected annotations %v", merged.Annotations)
+	}
+
+	// later overrides win
+	if merged.Spec.NodeSelector["disktype"] != "nvme" {
+		t.Fatalf("expected function override to win, got %v", merged.Spec.NodeSelector)
+	}
+	if len(merged.Spec.Tolerations) != 1 {
+		t.Fatalf("unexpected tolerations %v", merged.Spec.Tolerations)
+	}
+
+	// containers are merged by name
+	if len(merged.Spec.Containers) != 3 {
+		t.Fatalf("expected the sidecar to be added, got %v containers", len(merged.Spec.Containers))
+	}
+	runtime := merged.Spec.Containers[0]
+	if runtime.Name != "nodejs" || runtime.Image != "fission/node-env" || len(runtime.VolumeMounts) != 1 {
+		t.Fatalf("runtime container lost its settings: %+v", runtime)
+	}
+	if runtime.SecurityContext == nil || runtime.SecurityContext.RunAsNonRoot == nil || !*runtime.SecurityContext.RunAsNonRoot {
+		t.Fatalf("expected runtime container security context to be set, got %+v", runtime.SecurityContext)
+	}
+
+	// unset fields in the override don't clear generated ones
+	if merged.Spec.ServiceAccountName != "fission-fetcher" || len(merged.Spec.Volumes) != 1 {
+		t.Fatalf("generated fields were cleared: %+v", merged.Spec)
+	}
+}
+
+func TestMergePodTemplateNoOverrides(t *testing.T) {
+	tmpl := makeTestPodTemplate()
+	merged, err := MergePodTemplate(tmpl, nil)
+	if err != nil {
+		t.Fatalf("error merging pod template: %v", err)
+	}
+	if merged != tmpl {
+		t.Fatalf("expected the template to be returned as is")
+	}
+}
+
+func TestValidatePodTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		override *apiv1.PodTemplateSpec
+	}{
+		{
+			name: "container without image",
+			override: &apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{Containers: []apiv1.Container{{Name: "sidecar"}}},
+			},
+		},
+		{
+			name: "mount of unknown volume",
+			override: &apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{Containers: []apiv1.Container{{
+					Name:         "nodejs",
+					VolumeMounts: []apiv1.VolumeMount{{Name: "certs", MountPath: "/certs"}},
+				}}},
+			},
+		},
+		{
+			name: "init container clashing with a container",
+			override: &apiv1.PodTemplateSpec{
+				Spec: apiv1.PodSpec{InitContainers: []apiv1.Container{{Name: "fetcher", Image: "busybox"}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		merged, err := MergePodTemplate(makeTestPodTemplate(), test.override)
+		if err != nil {
+			t.Fatalf("%v: error merging pod template: %v", test.name, err)
+		}
+		err = ValidatePodTemplate(merged)
+		if err == nil {
+			t.Fatalf("%v: expected pod template to be invalid", test.name)
+		}
+	}
+}
@@ -70,6 +70,8 @@ type (
 		metav1.TypeMeta `json:",inline"`
 		Metadata        metav1.ObjectMeta       `json:"metadata"`
 		Spec            fission.EnvironmentSpec `json:"spec"`
+
+		Status fission.EnvironmentStatus `json:"status"`
 	}
 	EnvironmentList struct {
 		metav1.TypeMeta `json:",inline"`
@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/fission/fission"
 )
@@ -20,20 +21,93 @@ const (
 	DEFAULT_INTERNAL_CODE_PATH = "/bin/userfunc"
 )
 
-var specialized bool
-
 type BinaryServer struct {
 	fetchedCodePath  string
 	internalCodePath string
+
+	// Executables of the loaded functions, and the paths they were
+	// loaded from, by URL.  A container running a single function
+	// loads it at "/", where it serves every request; containers
+	// running several functions load each at its own URL.
+	lock      sync.RWMutex
+	functions map[string]string
+	codePaths map[string]string
+
+	// the functions' own environment variables, by URL
+	envs map[string]map[string]string
 }
 
-func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request) {
-	if specialized {
-		w.WriteHeader(400)
-		w.Write([]byte("Not a generic container"))
-		return
+func MakeBinaryServer(fetchedCodePath string, internalCodePath string) *BinaryServer {
+	return &BinaryServer{
+		fetchedCodePath:  fetchedCodePath,
+		internalCodePath: internalCodePath,
+		functions:        make(map[string]string),
+		codePaths:        make(map[string]string),
+		envs:             make(map[string]map[string]string),
+	}
+}
+
+// executablePath returns the path to copy the executable of a new
+// function at url to, or "" if no function can be loaded there.
+func (bs *BinaryServer) executablePath(url string) string {
+	bs.lock.RLock()
+	defer bs.lock.RUnlock()
+	if _, ok := bs.functions["/"]; ok {
+		return ""
+	}
+	if url == "/" {
+		if len(bs.functions) > 0 {
+			return ""
+		}
+		return bs.internalCodePath
+	}
+	if _, ok := bs.functions[url]; ok {
+		return ""
+	}
+	return fmt.Sprintf("%v-%v", bs.internalCodePath, len(bs.functions))
+}
+
+// isLoaded returns true if the function at codePath is already loaded
+// at url.  The executor may ask again, e.g. when a new leader takes
+// over a pod running several functions, and gets the loaded function.
+func (bs *BinaryServer) isLoaded(url string, codePath string) bool {
+	bs.lock.RLock()
+	defer bs.lock.RUnlock()
+	path, ok := bs.codePaths[url]
+	return ok && path == codePath
+}
+
+// getExecutable returns the executable for a request path, or "" if
+// there's none, its environment variables and the number of loaded
+// functions.
+func (bs *BinaryServer) getExecutable(path string) (string, map[string]string, int) {
+	bs.lock.RLock()
+	defer bs.lock.RUnlock()
+	if executable, ok := bs.functions[path]; ok {
+		return executable, bs.envs[path], len(bs.functions)
+	}
+	return bs.functions["/"], bs.envs["/"], len(bs.functions)
+}
+
+// readEnv reads the environment variables in configPath/env.json, if
+// there are any.
+func readEnv(configPath string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(configPath, "env.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	env := make(map[string]string)
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return nil, err
 	}
+	return env, nil
+}
 
+func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request) {
 	request := fission.FunctionLoadRequest{}
 
 	codePath := bs.fetchedCodePath
@@ -43,6 +117,9 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 	case err != nil:
 		panic(err)
 	}
+	if len(request.URL) == 0 {
+		request.URL = "/"
+	}
 
 	if request.FilePath != "" {
 		fileStat, err := os.Stat(request.FilePath)
@@ -68,8 +145,32 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	if bs.isLoaded(request.URL, codePath) {
+		fmt.Printf("Already specialized %v\n", request.URL)
+		return
+	}
+	executablePath := bs.executablePath(request.URL)
+	if len(executablePath) == 0 {
+		w.WriteHeader(400)
+		w.Write([]byte("Not a generic container"))
+		return
+	}
+
 	// Future: Check if executable is correct architecture/executable.
 
+	// v1 environments aren't told where the config is; it's next to
+	// the fetched code.
+	configPath := request.ConfigPath
+	if len(configPath) == 0 && len(request.FilePath) == 0 {
+		configPath = bs.fetchedCodePath + "-config"
+	}
+	env, err := readEnv(configPath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Failed to read environment variables: %v", err)))
+		return
+	}
+
 	// Copy the executable to ensure that file is executable and immutable.
 	userFunc, err := ioutil.ReadFile(codePath)
 	if err != nil {
@@ -77,27 +178,40 @@ func (bs *BinaryServer) SpecializeHandler(w http.ResponseWriter, r *http.Request
 		w.Write([]byte("Failed to read executable."))
 		return
 	}
-	err = ioutil.WriteFile(bs.internalCodePath, userFunc, 0555)
+	err = ioutil.WriteFile(executablePath, userFunc, 0555)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to write executable to target location."))
 		return
 	}
 
-	fmt.Println("Specializing ...")
-	specialized = true
+	fmt.Printf("Specializing %v ...\n", request.URL)
+	bs.lock.Lock()
+	bs.functions[request.URL] = executablePath
+	bs.codePaths[request.URL] = codePath
+	bs.envs[request.URL] = env
+	bs.lock.Unlock()
 	fmt.Println("Done")
 }
 
 func (bs *BinaryServer) InvocationHandler(w http.ResponseWriter, r *http.Request) {
-	if !specialized {
+	executable, fnEnv, loaded := bs.getExecutable(r.URL.Path)
+	if loaded == 0 {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Generic container: no requests supported"))
 		return
 	}
+	if len(executable) == 0 {
+		http.NotFound(w, r)
+		return
+	}
 
-	// CGI-like passing of environment variables
+	// the function's own variables, then CGI-like passing of
+	// request variables
 	execEnv := NewEnv(nil)
+	for k, v := range fnEnv {
+		execEnv.SetEnv(&EnvVar{k, v})
+	}
 	execEnv.SetEnv(&EnvVar{"REQUEST_METHOD", r.Method})
 	execEnv.SetEnv(&EnvVar{"REQUEST_URI", r.RequestURI})
 	execEnv.SetEnv(&EnvVar{"CONTENT_LENGTH", fmt.Sprintf("%d", r.ContentLength)})
@@ -107,7 +221,7 @@ func (bs *BinaryServer) InvocationHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Future: could be improved by keeping subprocess open while environment is specialized
-	cmd := exec.Command(bs.internalCodePath)
+	cmd := exec.Command(executable)
 	cmd.Env = execEnv.ToStringEnv()
 
 	if r.ContentLength != 0 {
@@ -148,13 +262,19 @@ func main() {
 	fmt.Printf("Using fetched code path: %s\n", *codePath)
 	fmt.Printf("Using internal code path: %s\n", absInternalCodePath)
 
-	server := &BinaryServer{*codePath, absInternalCodePath}
+	server := MakeBinaryServer(*codePath, absInternalCodePath)
 	http.HandleFunc("/", server.InvocationHandler)
 	http.HandleFunc("/specialize", server.SpecializeHandler)
 	http.HandleFunc("/v2/specialize", server.SpecializeHandler)
 
-	fmt.Println("Listening on 8888 ...")
-	err = http.ListenAndServe(":8888", nil)
+	// The local executor runs several environments on one host,
+	// each on its own port.
+	port := os.Getenv("PORT")
+	if len(port) == 0 {
+		port = "8888"
+	}
+	fmt.Printf("Listening on %v ...\n", port)
+	err = http.ListenAndServe(":"+port, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func specialize(t *testing.T, bs *BinaryServer, request fission.FunctionLoadRequest) int {
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("error encoding request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	bs.SpecializeHandler(w, httptest.NewRequest("POST", "/v2/specialize", bytes.NewReader(body)))
+	return w.Code
+}
+
+func TestSpecializeAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binary")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"hello-1", "hello-2"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\necho hello\n"), 0644)
+		if err != nil {
+			t.Fatalf("error writing function: %v", err)
+		}
+	}
+	bs := MakeBinaryServer(filepath.Join(dir, "user"), filepath.Join(dir, "userfunc"))
+
+	request := fission.FunctionLoadRequest{
+		FilePath: filepath.Join(dir, "hello-1"),
+		URL:      "/fission-function/hello-1",
+	}
+	if code := specialize(t, bs, request); code != http.StatusOK {
+		t.Fatalf("error specializing: %v", code)
+	}
+
+	// a new executor leader asks for the same function again
+	if code := specialize(t, bs, request); code != http.StatusOK {
+		t.Fatalf("expected specializing the loaded function again to succeed, got %v", code)
+	}
+	executable, _, loaded := bs.getExecutable(request.URL)
+	if loaded != 1 || executable != filepath.Join(dir, "userfunc-0") {
+		t.Fatalf("expected the function to be loaded once, got %v of %v", executable, loaded)
+	}
+
+	// another function can't take its URL
+	request.FilePath = filepath.Join(dir, "hello-2")
+	if code := specialize(t, bs, request); code != http.StatusBadRequest {
+		t.Fatalf("expected another function at the same URL to be refused, got %v", code)
+	}
+}
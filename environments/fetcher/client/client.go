@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
@@ -27,20 +28,42 @@ func MakeClient(fetcherUrl string) *Client {
 }
 
 func (c *Client) Fetch(fr *fetcher.FetchRequest) error {
+	_, err := c.FetchWithStats(context.Background(), fr)
+	return err
+}
+
+// FetchWithStats is Fetch, returning how long the fetch took, and
+// giving up when ctx is done.  Older fetchers don't tell how long the
+// fetch took; their stats are zero.
+func (c *Client) FetchWithStats(ctx context.Context, fr *fetcher.FetchRequest) (*fetcher.FetchResponse, error) {
 	body, err := json.Marshal(fr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	maxRetries := 20
 	var resp *http.Response
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err = http.Post(c.url, "application/json", bytes.NewReader(body))
+		var req *http.Request
+		req, err = http.NewRequest("POST", c.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = http.DefaultClient.Do(req.WithContext(ctx))
 
 		if err == nil && resp.StatusCode == 200 {
 			defer resp.Body.Close()
-			return nil
+			stats := &fetcher.FetchResponse{}
+			rBody, err := ioutil.ReadAll(resp.Body)
+			if err == nil && len(rBody) > 0 {
+				err = json.Unmarshal(rBody, stats)
+			}
+			if err != nil {
+				log.Printf("Error reading fetch response: %v", err)
+			}
+			return stats, nil
 		}
 
 		// Only retry for the specific case of a connection error.
@@ -48,7 +71,11 @@ func (c *Client) Fetch(fr *fetcher.FetchRequest) error {
 			if netErr, ok := urlErr.Err.(*net.OpError); ok {
 				if netErr.Op == "dial" {
 					if i < maxRetries-1 {
-						time.Sleep(50 * time.Duration(2*i) * time.Millisecond)
+						select {
+						case <-ctx.Done():
+							return nil, ctx.Err()
+						case <-time.After(50 * time.Duration(2*i) * time.Millisecond):
+						}
 						continue
 					}
 				}
@@ -60,7 +87,7 @@ func (c *Client) Fetch(fr *fetcher.FetchRequest) error {
 		err = fission.MakeErrorFromHTTP(resp)
 	}
 	log.Printf("Failed to fetch: %v", err)
-	return err
+	return nil, err
 }
 
 func (c *Client) Upload(fr *fetcher.UploadRequest) (*fetcher.UploadResponse, error) {
@@ -0,0 +1,237 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satori/go.uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission/crd"
+)
+
+const (
+	configSecretsDir    = "secrets"
+	configConfigMapsDir = "configmaps"
+	configEnvFile       = "env.json"
+
+	// SERVICE_ACCOUNT is the service account of function pods.  It
+	// can read Secrets, so its token, which the chart keeps in the
+	// Secret SERVICE_ACCOUNT_TOKEN, is only mounted into the fetcher.
+	SERVICE_ACCOUNT         = "fission-fetcher"
+	SERVICE_ACCOUNT_TOKEN   = "fission-fetcher-token"
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// MountServiceAccountToken mounts the fetcher service account's token
+// into the fetcher container of spec's pod only, rather than into
+// every container, so that user code doesn't get to call the
+// Kubernetes API with it.  Pods that a pod template gave another
+// service account are left alone.
+func MountServiceAccountToken(spec *apiv1.PodSpec, fetcherContainer string) {
+	if spec.ServiceAccountName != SERVICE_ACCOUNT {
+		return
+	}
+	automount := false
+	spec.AutomountServiceAccountToken = &automount
+	spec.Volumes = append(spec.Volumes, apiv1.Volume{
+		Name: SERVICE_ACCOUNT_TOKEN,
+		VolumeSource: apiv1.VolumeSource{
+			Secret: &apiv1.SecretVolumeSource{SecretName: SERVICE_ACCOUNT_TOKEN},
+		},
+	})
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == fetcherContainer {
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, apiv1.VolumeMount{
+				Name:      SERVICE_ACCOUNT_TOKEN,
+				MountPath: serviceAccountTokenPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// SetFunctionConfig tells the fetcher which function the request is
+// for, and asks for the function's Secrets, ConfigMaps and environment
+// variables to be placed at configFilename.  The request only names
+// the function; the fetcher looks up its configuration.  It returns
+// false if the function has no configuration, in which case nothing
+// is placed at configFilename.
+func (req *FetchRequest) SetFunctionConfig(fn *crd.Function, configFilename string) bool {
+	ns := fn.Metadata.Namespace
+	if len(ns) == 0 {
+		ns = metav1.NamespaceDefault
+	}
+	req.Function = metav1.ObjectMeta{Namespace: ns, Name: fn.Metadata.Name}
+
+	if len(fn.Spec.Secrets) == 0 && len(fn.Spec.ConfigMaps) == 0 && len(fn.Spec.Env) == 0 {
+		return false
+	}
+	req.ConfigFilename = configFilename
+	return true
+}
+
+// fetchConfig materializes the Secrets, ConfigMaps and environment
+// variables of req.Function under req.ConfigFilename.
+//
+// User code can reach the fetcher of its pod, so the fetcher doesn't
+// take Secret names from requests: it looks up the function, and reads
+// the function's references in the function's own namespace.  In pods
+// running a single function, Fetch also only serves requests for that
+// function's namespace (see pinNamespace), so the function can't ask
+// for the config of another namespace's function.
+func (fetcher *Fetcher) fetchConfig(req FetchRequest) (int, error) {
+	if fetcher.getFunction == nil {
+		return 500, errors.New("Failed to fetch function config: no function store")
+	}
+	fn, err := fetcher.getFunction(req.Function.Namespace, req.Function.Name)
+	if err != nil {
+		e := fmt.Sprintf("Failed to get function %v/%v: %v", req.Function.Namespace, req.Function.Name, err)
+		log.Printf(e)
+		return 500, errors.New(e)
+	}
+
+	tmpPath := filepath.Join(fetcher.sharedVolumePath, uuid.NewV4().String())
+	err = writeConfig(fetcher.kubernetesClient, tmpPath, fn)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		e := fmt.Sprintf("Failed to fetch function config: %v", err)
+		log.Printf(e)
+		return 500, errors.New(e)
+	}
+
+	dst := filepath.Join(fetcher.sharedVolumePath, req.ConfigFilename)
+	err = os.RemoveAll(dst)
+	if err != nil {
+		log.Println(err.Error())
+		return 500, err
+	}
+	err = fetcher.rename(tmpPath, dst)
+	if err != nil {
+		log.Println(err.Error())
+		return 500, err
+	}
+	log.Printf("Successfully placed function config at %v", dst)
+	return 200, nil
+}
+
+// pinNamespace checks that ns is a namespace the fetcher's pod runs
+// functions of.  The first request the fetcher serves comes from the
+// executor, before any user code runs in the pod: if it asks for pin,
+// the pod runs a single function, and only requests for its namespace
+// are served from then on.  Otherwise the pod is shared by functions
+// of any namespace (AllowedFunctionsPerContainer infinite), which all
+// run in the same container and so can already read each other's
+// config, and requests for any namespace are served; later requests
+// can't pin it.
+func (fetcher *Fetcher) pinNamespace(ns string, pin bool) (int, error) {
+	fetcher.lock.Lock()
+	defer fetcher.lock.Unlock()
+	if len(fetcher.configNamespace) == 0 && !fetcher.configShared {
+		if pin {
+			fetcher.configNamespace = ns
+		} else {
+			fetcher.configShared = true
+		}
+	}
+	if fetcher.configShared {
+		return 200, nil
+	}
+	if ns != fetcher.configNamespace {
+		return http.StatusForbidden, fmt.Errorf("Failed to fetch: this pod runs functions of namespace %v, not %v",
+			fetcher.configNamespace, ns)
+	}
+	return 200, nil
+}
+
+// writeConfig writes the configuration of fn into a new directory at
+// dir.  Secrets and ConfigMaps are read from the function's namespace,
+// whatever namespace the references name.  Secrets are written
+// readable only by the owner.
+func writeConfig(kubernetesClient kubernetes.Interface, dir string, fn *crd.Function) error {
+	if kubernetesClient == nil && (len(fn.Spec.Secrets) > 0 || len(fn.Spec.ConfigMaps) > 0) {
+		return fmt.Errorf("secrets and configmaps can only be fetched in a Kubernetes cluster")
+	}
+
+	ns := fn.Metadata.Namespace
+	if len(ns) == 0 {
+		ns = metav1.NamespaceDefault
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range fn.Spec.Secrets {
+		secret, err := kubernetesClient.CoreV1().Secrets(ns).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting secret %v/%v: %v", ns, ref.Name, err)
+		}
+		err = writeConfigFiles(filepath.Join(dir, configSecretsDir, ref.Name), secret.Data, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range fn.Spec.ConfigMaps {
+		cm, err := kubernetesClient.CoreV1().ConfigMaps(ns).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting configmap %v/%v: %v", ns, ref.Name, err)
+		}
+		data := make(map[string][]byte)
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		err = writeConfigFiles(filepath.Join(dir, configConfigMapsDir, ref.Name), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(fn.Spec.Env) > 0 {
+		env := make(map[string]string)
+		for _, e := range fn.Spec.Env {
+			env[e.Name] = e.Value
+		}
+		b, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, configEnvFile), b, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeConfigFiles writes one file per key into dir.
+func writeConfigFiles(dir string, data map[string][]byte, mode os.FileMode) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		// Kubernetes validates keys, but don't let a bad one
+		// escape the config directory.
+		if len(k) == 0 || strings.Contains(k, "/") || k == "." || k == ".." {
+			return fmt.Errorf("invalid key '%v'", k)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, k), v, mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func TestFetchConfig(t *testing.T) {
+	kubernetesClient := fake.NewSimpleClientset(
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: metav1.NamespaceDefault},
+			Data:       map[string][]byte{"password": []byte("s3cret")},
+		},
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "kube-system"},
+			Data:       map[string][]byte{"password": []byte("other")},
+		},
+		&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: metav1.NamespaceDefault},
+			Data:       map[string]string{"greeting": "hello"},
+		},
+	)
+
+	sharedVolumePath, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("error creating shared volume: %v", err)
+	}
+	defer os.RemoveAll(sharedVolumePath)
+	// a reference to another namespace resolves in the function's own
+	fn := &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault},
+		Spec: fission.FunctionSpec{
+			Secrets:    []fission.SecretReference{{Namespace: "kube-system", Name: "db"}},
+			ConfigMaps: []fission.ConfigMapReference{{Name: "settings"}},
+			Env:        []fission.EnvVar{{Name: "GREETING", Value: "hi"}},
+		},
+	}
+	fetcher := &Fetcher{
+		sharedVolumePath: sharedVolumePath,
+		kubernetesClient: kubernetesClient,
+		getFunction: func(namespace string, name string) (*crd.Function, error) {
+			f := *fn
+			f.Metadata.Namespace = namespace
+			f.Metadata.Name = name
+			return &f, nil
+		},
+	}
+
+	req := FetchRequest{}
+	if !req.SetFunctionConfig(fn, "user-config") {
+		t.Fatalf("expected function to have config")
+	}
+	// the request names the function, not its Secrets
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("error encoding request: %v", err)
+	}
+	if strings.Contains(string(b), "kube-system") {
+		t.Fatalf("expected the request not to name secrets: %v", string(b))
+	}
+	code, err := fetcher.fetchConfig(req)
+	if err != nil {
+		t.Fatalf("error fetching config (%v): %v", code, err)
+	}
+
+	configPath := filepath.Join(sharedVolumePath, "user-config")
+	password, err := ioutil.ReadFile(filepath.Join(configPath, "secrets", "db", "password"))
+	if err != nil {
+		t.Fatalf("error reading secret: %v", err)
+	}
+	if string(password) != "s3cret" {
+		t.Fatalf("expected secret from the function's namespace, got %v", string(password))
+	}
+	info, err := os.Stat(filepath.Join(configPath, "secrets", "db", "password"))
+	if err != nil {
+		t.Fatalf("error reading secret: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected secret to be private, got mode %v", info.Mode())
+	}
+
+	greeting, err := ioutil.ReadFile(filepath.Join(configPath, "configmaps", "settings", "greeting"))
+	if err != nil {
+		t.Fatalf("error reading configmap: %v", err)
+	}
+	if string(greeting) != "hello" {
+		t.Fatalf("unexpected configmap value %v", string(greeting))
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(configPath, "env.json"))
+	if err != nil {
+		t.Fatalf("error reading environment variables: %v", err)
+	}
+	env := make(map[string]string)
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		t.Fatalf("error parsing environment variables: %v", err)
+	}
+	if env["GREETING"] != "hi" {
+		t.Fatalf("unexpected environment variables %v", env)
+	}
+
+	// the pod runs a single function, so config of other
+	// namespaces' functions isn't served
+	_, err = fetcher.pinNamespace(req.Function.Namespace, true)
+	if err != nil {
+		t.Fatalf("error pinning namespace: %v", err)
+	}
+	req.Function.Namespace = "kube-system"
+	code, err = fetcher.Fetch(req)
+	if err == nil || code != http.StatusForbidden {
+		t.Fatalf("expected config of another namespace to be refused, got %v, %v", code, err)
+	}
+}
+
+func TestFetchConfigSharedPod(t *testing.T) {
+	sharedVolumePath, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("error creating shared volume: %v", err)
+	}
+	defer os.RemoveAll(sharedVolumePath)
+	fetcher := &Fetcher{
+		sharedVolumePath: sharedVolumePath,
+		kubernetesClient: fake.NewSimpleClientset(),
+		getPackage: func(namespace string, name string) (*crd.Package, error) {
+			return &crd.Package{
+				Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: fission.PackageSpec{
+					Deployment: fission.Archive{Literal: []byte("hello")},
+				},
+			}, nil
+		},
+		getFunction: func(namespace string, name string) (*crd.Function, error) {
+			return &crd.Function{
+				Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: fission.FunctionSpec{
+					Env: []fission.EnvVar{{Name: "NAMESPACE", Value: namespace}},
+				},
+			}, nil
+		},
+	}
+
+	// a pod running several functions loads functions of two
+	// namespaces
+	for _, ns := range []string{metav1.NamespaceDefault, "team-b"} {
+		fn := &crd.Function{
+			Metadata: metav1.ObjectMeta{Name: "hello", Namespace: ns},
+			Spec: fission.FunctionSpec{
+				Env: []fission.EnvVar{{Name: "NAMESPACE", Value: ns}},
+			},
+		}
+		req := FetchRequest{
+			FetchType: FETCH_DEPLOYMENT,
+			Package:   metav1.ObjectMeta{Name: "hello-pkg", Namespace: ns},
+			Filename:  ns,
+		}
+		req.SetFunctionConfig(fn, ns+"-config")
+		code, err := fetcher.Fetch(req)
+		if err != nil {
+			t.Fatalf("expected config of namespace %v to be served, got %v, %v", ns, code, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(sharedVolumePath, ns+"-config", "env.json"))
+		if err != nil {
+			t.Fatalf("error reading environment variables of namespace %v: %v", ns, err)
+		}
+		if !strings.Contains(string(b), ns) {
+			t.Fatalf("unexpected environment variables %v", string(b))
+		}
+	}
+
+	// once the pod is shared, later requests can't pin it
+	_, err = fetcher.pinNamespace("team-b", true)
+	if err != nil {
+		t.Fatalf("error pinning namespace: %v", err)
+	}
+	_, err = fetcher.pinNamespace(metav1.NamespaceDefault, false)
+	if err != nil {
+		t.Fatalf("expected a shared pod to serve any namespace, got %v", err)
+	}
+}
+
+func TestFetchConfigMissingSecret(t *testing.T) {
+	sharedVolumePath, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("error creating shared volume: %v", err)
+	}
+	defer os.RemoveAll(sharedVolumePath)
+	fetcher := &Fetcher{
+		sharedVolumePath: sharedVolumePath,
+		kubernetesClient: fake.NewSimpleClientset(),
+		getFunction: func(namespace string, name string) (*crd.Function, error) {
+			return &crd.Function{
+				Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: fission.FunctionSpec{
+					Secrets: []fission.SecretReference{{Name: "missing"}},
+				},
+			}, nil
+		},
+	}
+
+	req := FetchRequest{
+		ConfigFilename: "user-config",
+		Function:       metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault},
+	}
+	_, err = fetcher.fetchConfig(req)
+	if err == nil {
+		t.Fatalf("expected error fetching a missing secret")
+	}
+	files, err := ioutil.ReadDir(sharedVolumePath)
+	if err != nil {
+		t.Fatalf("error reading shared volume: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no partial config to be left behind, found %v files", len(files))
+	}
+}
+
+func TestMountServiceAccountToken(t *testing.T) {
+	spec := apiv1.PodSpec{
+		Containers: []apiv1.Container{
+			{Name: "runtime"},
+			{Name: "fetcher"},
+		},
+		ServiceAccountName: SERVICE_ACCOUNT,
+	}
+	MountServiceAccountToken(&spec, "fetcher")
+	if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+		t.Fatalf("expected the token not to be mounted into every container")
+	}
+	if len(spec.Containers[0].VolumeMounts) != 0 || len(spec.Containers[1].VolumeMounts) != 1 {
+		t.Fatalf("expected the token to be mounted into the fetcher only")
+	}
+
+	// a pod template picked another service account
+	spec = apiv1.PodSpec{
+		Containers:         []apiv1.Container{{Name: "fetcher"}},
+		ServiceAccountName: "hello",
+	}
+	MountServiceAccountToken(&spec, "fetcher")
+	if spec.AutomountServiceAccountToken != nil || len(spec.Volumes) != 0 {
+		t.Fatalf("expected a pod with another service account to be left alone")
+	}
+}
@@ -12,11 +12,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/mholt/archiver"
 	"github.com/satori/go.uuid"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
@@ -32,6 +34,27 @@ type (
 		Url           string            `json:"url"`
 		StorageSvcUrl string            `json:"storagesvcurl"`
 		Filename      string            `json:"filename"`
+
+		// The function the request is for, and where to
+		// materialize its Secrets, ConfigMaps and literal
+		// environment variables, relative to the shared volume.
+		// See FunctionLoadRequest.ConfigPath for the layout.
+		ConfigFilename string            `json:"configfilename,omitempty"`
+		Function       metav1.ObjectMeta `json:"function,omitempty"`
+
+		// PinNamespace is set on the first request to a pod that
+		// only runs one function, and restricts the fetcher to
+		// the function's namespace from then on.  See
+		// pinNamespace.
+		PinNamespace bool `json:"pinnamespace,omitempty"`
+	}
+
+	// FetchResponse tells how long a fetch took, for the executor's
+	// cold start timelines.
+	FetchResponse struct {
+		Bytes             int64           `json:"bytes"` // size of the fetched package
+		DownloadDuration  metav1.Duration `json:"downloadDuration"`
+		UnarchiveDuration metav1.Duration `json:"unarchiveDuration"`
 	}
 
 	// UploadRequest send from builder manager describes which
@@ -50,8 +73,24 @@ type (
 
 	Fetcher struct {
 		sharedVolumePath string
-		fissionClient    *crd.FissionClient
+		getPackage       PackageGetter
+		getFunction      FunctionGetter
+		kubernetesClient kubernetes.Interface
+
+		// the namespace of the function this fetcher's pod runs,
+		// if the pod runs a single function, or configShared if
+		// it runs functions of any namespace
+		lock            sync.Mutex
+		configNamespace string
+		configShared    bool
 	}
+
+	// PackageGetter gets the package a FetchRequest refers to.
+	PackageGetter func(namespace string, name string) (*crd.Package, error)
+
+	// FunctionGetter gets the function whose config a FetchRequest
+	// asks for.
+	FunctionGetter func(namespace string, name string) (*crd.Function, error)
 )
 
 const (
@@ -61,37 +100,56 @@ const (
 )
 
 func MakeFetcher(sharedVolumePath string) *Fetcher {
-	fissionClient, _, _, err := crd.MakeFissionClient()
+	fissionClient, kubernetesClient, _, err := crd.MakeFissionClient()
 	if err != nil {
 		return nil
 	}
 	return &Fetcher{
 		sharedVolumePath: sharedVolumePath,
-		fissionClient:    fissionClient,
+		getPackage: func(namespace string, name string) (*crd.Package, error) {
+			return fissionClient.Packages(namespace).Get(name)
+		},
+		getFunction: func(namespace string, name string) (*crd.Function, error) {
+			return fissionClient.Functions(namespace).Get(name)
+		},
+		kubernetesClient: kubernetesClient,
+	}
+}
+
+// MakeLocalFetcher makes a fetcher that runs outside Kubernetes, and
+// gets packages and functions with getPackage and getFunction.  It
+// can't fetch Secrets or ConfigMaps.
+func MakeLocalFetcher(sharedVolumePath string, getPackage PackageGetter, getFunction FunctionGetter) *Fetcher {
+	return &Fetcher{
+		sharedVolumePath: sharedVolumePath,
+		getPackage:       getPackage,
+		getFunction:      getFunction,
 	}
 }
 
-func downloadUrl(url string, localPath string) error {
+// downloadUrl downloads url to localPath, returning the number of
+// bytes downloaded.
+func downloadUrl(url string, localPath string) (int64, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	w, err := os.Create(localPath)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = io.Copy(w, resp.Body)
+	n, err := io.Copy(w, resp.Body)
 	if err != nil {
-		return err
+		return n, err
 	}
 	err = os.Chmod(localPath, 0600)
 	if err != nil {
-		return err
+		return n, err
 	}
 
-	return nil
+	return n, nil
 }
 
 func getChecksum(path string) (*fission.Checksum, error) {
@@ -157,25 +215,47 @@ func (fetcher *Fetcher) FetchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("fetcher received fetch request and started downloading: %v", req)
 
-	code, err := fetcher.Fetch(req)
+	var stats FetchResponse
+	code, err := fetcher.fetch(req, &stats)
 	if err != nil {
 		http.Error(w, err.Error(), code)
 		return
 	}
 
 	// all done
-	w.WriteHeader(http.StatusOK)
+	resp, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
 }
 
 // Fetch takes FetchRequest and makes the fetch call
 // It returns the HTTP code and error if any
 func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
+	return fetcher.fetch(req, &FetchResponse{})
+}
+
+// fetch is Fetch, keeping track of the time spent in stats
+func (fetcher *Fetcher) fetch(req FetchRequest, stats *FetchResponse) (int, error) {
+	if len(req.Function.Namespace) > 0 {
+		code, err := fetcher.pinNamespace(req.Function.Namespace, req.PinNamespace)
+		if err != nil {
+			log.Printf(err.Error())
+			return code, err
+		}
+	}
+
 	tmpFile := req.Filename + ".tmp"
 	tmpPath := filepath.Join(fetcher.sharedVolumePath, tmpFile)
 
+	downloadStart := time.Now()
 	if req.FetchType == FETCH_URL {
 		// fetch the file and save it to the tmp path
-		err := downloadUrl(req.Url, tmpPath)
+		n, err := downloadUrl(req.Url, tmpPath)
+		stats.Bytes = n
 		if err != nil {
 			e := fmt.Sprintf("Failed to download url %v: %v", req.Url, err)
 			log.Printf(e)
@@ -183,7 +263,7 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 		}
 	} else {
 		// get pkg
-		pkg, err := fetcher.fissionClient.Packages(req.Package.Namespace).Get(req.Package.Name)
+		pkg, err := fetcher.getPackage(req.Package.Namespace, req.Package.Name)
 		if err != nil {
 			e := fmt.Sprintf("Failed to get package: %v", err)
 			log.Printf(e)
@@ -199,6 +279,7 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 		// get package data as literal or by url
 		if len(archive.Literal) > 0 {
 			// write pkg.Literal into tmpPath
+			stats.Bytes = int64(len(archive.Literal))
 			err = ioutil.WriteFile(tmpPath, archive.Literal, 0600)
 			if err != nil {
 				e := fmt.Sprintf("Failed to write file %v: %v", tmpPath, err)
@@ -207,7 +288,7 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 			}
 		} else {
 			// download and verify
-			err = downloadUrl(archive.URL, tmpPath)
+			stats.Bytes, err = downloadUrl(archive.URL, tmpPath)
 			if err != nil {
 				e := fmt.Sprintf("Failed to download url %v: %v", req.Url, err)
 				log.Printf(e)
@@ -223,9 +304,12 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 		}
 	}
 
+	stats.DownloadDuration = metav1.Duration{Duration: time.Since(downloadStart)}
+
 	// check file type here, if the file is a zip file unarchive it.
 	if archiver.Zip.Match(tmpPath) {
 		// unarchive tmp file to a tmp unarchive path
+		unarchiveStart := time.Now()
 		tmpUnarchivePath := filepath.Join(fetcher.sharedVolumePath, uuid.NewV4().String())
 		err := fetcher.unarchive(tmpPath, tmpUnarchivePath)
 		if err != nil {
@@ -233,6 +317,7 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 			return 500, err
 		}
 		tmpPath = tmpUnarchivePath
+		stats.UnarchiveDuration = metav1.Duration{Duration: time.Since(unarchiveStart)}
 	}
 
 	// move tmp file to requested filename
@@ -242,6 +327,10 @@ func (fetcher *Fetcher) Fetch(req FetchRequest) (int, error) {
 		return 500, err
 	}
 	log.Printf("Successfully placed at %v", filepath.Join(fetcher.sharedVolumePath, req.Filename))
+
+	if len(req.ConfigFilename) > 0 {
+		return fetcher.fetchConfig(req)
+	}
 	return 200, nil
 }
 
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func TestLocalFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault},
+		Spec: fission.FunctionSpec{
+			Env: []fission.EnvVar{{Name: "GREETING", Value: "hi"}},
+		},
+	}
+	fetcher := MakeLocalFetcher(dir, func(namespace string, name string) (*crd.Package, error) {
+		if namespace != metav1.NamespaceDefault || name != "hello-pkg" {
+			t.Fatalf("unexpected package %v/%v", namespace, name)
+		}
+		return &crd.Package{
+			Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: fission.PackageSpec{
+				Deployment: fission.Archive{Literal: []byte("hello")},
+			},
+		}, nil
+	}, func(namespace string, name string) (*crd.Function, error) {
+		return fn, nil
+	})
+
+	req := FetchRequest{
+		FetchType: FETCH_DEPLOYMENT,
+		Package:   metav1.ObjectMeta{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+		Filename:  "user",
+	}
+	req.SetFunctionConfig(fn, "user-config")
+	code, err := fetcher.Fetch(req)
+	if err != nil {
+		t.Fatalf("error fetching (%v): %v", code, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "user"))
+	if err != nil {
+		t.Fatalf("error reading fetched package: %v", err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected package contents %v", string(b))
+	}
+	_, err = os.Stat(filepath.Join(dir, "user-config", "env.json"))
+	if err != nil {
+		t.Fatalf("expected environment variables to be fetched: %v", err)
+	}
+
+	// there's no cluster to get secrets from
+	fn.Spec.Secrets = []fission.SecretReference{{Name: "db"}}
+	_, err = fetcher.Fetch(req)
+	if err == nil {
+		t.Fatalf("expected fetching secrets without a cluster to fail")
+	}
+}
+
+func TestFetchHandlerStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetcher")
+	if err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetcher := MakeLocalFetcher(dir, func(namespace string, name string) (*crd.Package, error) {
+		return &crd.Package{
+			Metadata: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: fission.PackageSpec{
+				Deployment: fission.Archive{Literal: []byte("hello")},
+			},
+		}, nil
+	}, nil)
+
+	body, err := json.Marshal(FetchRequest{
+		FetchType: FETCH_DEPLOYMENT,
+		Package:   metav1.ObjectMeta{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+		Filename:  "user",
+	})
+	if err != nil {
+		t.Fatalf("error encoding request: %v", err)
+	}
+	w := httptest.NewRecorder()
+	fetcher.FetchHandler(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("fetch failed: %v %v", w.Code, w.Body.String())
+	}
+
+	// the executor keeps track of package sizes
+	var stats FetchResponse
+	err = json.Unmarshal(w.Body.Bytes(), &stats)
+	if err != nil {
+		t.Fatalf("error decoding fetch response: %v", err)
+	}
+	if stats.Bytes != 5 {
+		t.Fatalf("expected 5 bytes fetched, got %v", stats.Bytes)
+	}
+}
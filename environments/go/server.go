@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"plugin"
+	"sync"
 
 	"github.com/fission/fission/environments/go/context"
 )
@@ -32,10 +33,65 @@ type (
 		// URL to expose this function at. Optional; defaults
 		// to "/".
 		URL string `json:"url"`
+
+		// ConfigPath is the directory of the function's
+		// Secrets, ConfigMaps and environment variables, if it
+		// has any.  The variables are in env.json.
+		ConfigPath string `json:"configPath"`
 	}
 )
 
-var userFunc http.HandlerFunc
+// Loaded functions, and the paths they were loaded from, by URL.  A
+// container running a single function loads it at "/", where it serves
+// every request; containers running several functions load each at its
+// own URL.
+var (
+	userFuncsLock sync.RWMutex
+	userFuncs     = make(map[string]http.HandlerFunc)
+	userFuncPaths = make(map[string]string)
+)
+
+// isLoaded returns true if the function at filePath is already loaded
+// at url.  The executor may ask again, e.g. when a new leader takes
+// over a pod running several functions, and gets the loaded function.
+func isLoaded(url string, filePath string) bool {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+	path, ok := userFuncPaths[url]
+	return ok && path == filePath
+}
+
+// canLoad returns true if a function can be loaded at url.
+func canLoad(url string) bool {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+	if _, ok := userFuncs["/"]; ok {
+		return false
+	}
+	if url == "/" && len(userFuncs) > 0 {
+		return false
+	}
+	_, ok := userFuncs[url]
+	return !ok
+}
+
+func setUserFunc(url string, filePath string, f http.HandlerFunc) {
+	userFuncsLock.Lock()
+	defer userFuncsLock.Unlock()
+	userFuncs[url] = f
+	userFuncPaths[url] = filePath
+}
+
+// getUserFunc returns the function for a request path, or nil if
+// there's none, and the number of loaded functions.
+func getUserFunc(path string) (http.HandlerFunc, int) {
+	userFuncsLock.RLock()
+	defer userFuncsLock.RUnlock()
+	if f, ok := userFuncs[path]; ok {
+		return f, len(userFuncs)
+	}
+	return userFuncs["/"], len(userFuncs)
+}
 
 func loadPlugin(codePath, entrypoint string) http.HandlerFunc {
 
@@ -83,8 +139,33 @@ func loadPlugin(codePath, entrypoint string) http.HandlerFunc {
 	}
 }
 
+// setEnv sets the environment variables in configPath/env.json, if
+// there are any.  Functions share the server's process, so they share
+// the variables too.
+func setEnv(configPath string) error {
+	b, err := ioutil.ReadFile(filepath.Join(configPath, "env.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	env := make(map[string]string)
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return err
+	}
+	for k, v := range env {
+		err = os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func specializeHandler(w http.ResponseWriter, r *http.Request) {
-	if userFunc != nil {
+	if !canLoad("/") {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Not a generic container"))
 		return
@@ -102,17 +183,17 @@ func specializeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fmt.Println("Specializing ...")
-	userFunc = loadPlugin(CODE_PATH, "Handler")
+	err = setEnv(CODE_PATH + "-config")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Failed to set environment variables: %v", err)))
+		return
+	}
+	setUserFunc("/", CODE_PATH, loadPlugin(CODE_PATH, "Handler"))
 	fmt.Println("Done")
 }
 
 func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
-	if userFunc != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Not a generic container"))
-		return
-	}
-
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -124,6 +205,19 @@ func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if len(loadreq.URL) == 0 {
+		loadreq.URL = "/"
+	}
+
+	if isLoaded(loadreq.URL, loadreq.FilePath) {
+		fmt.Printf("Already specialized %v\n", loadreq.URL)
+		return
+	}
+	if !canLoad(loadreq.URL) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Not a generic container"))
+		return
+	}
 
 	_, err = os.Stat(loadreq.FilePath)
 	if err != nil {
@@ -136,8 +230,16 @@ func specializeHandlerV2(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	fmt.Println("Specializing ...")
-	userFunc = loadPlugin(loadreq.FilePath, loadreq.FunctionName)
+	fmt.Printf("Specializing %v ...\n", loadreq.URL)
+	if len(loadreq.ConfigPath) > 0 {
+		err = setEnv(loadreq.ConfigPath)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("Failed to set environment variables: %v", err)))
+			return
+		}
+	}
+	setUserFunc(loadreq.URL, loadreq.FilePath, loadPlugin(loadreq.FilePath, loadreq.FunctionName))
 	fmt.Println("Done")
 }
 
@@ -150,16 +252,28 @@ func main() {
 	http.HandleFunc("/specialize", specializeHandler)
 	http.HandleFunc("/v2/specialize", specializeHandlerV2)
 
-	// Generic route -- all http requests go to the user function.
+	// Generic route -- all http requests go to the user function
+	// at their URL, or the one at "/".
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if userFunc == nil {
+		userFunc, loaded := getUserFunc(r.URL.Path)
+		if loaded == 0 {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Generic container: no requests supported"))
 			return
 		}
+		if userFunc == nil {
+			http.NotFound(w, r)
+			return
+		}
 		userFunc(w, r)
 	})
 
-	fmt.Println("Listening on 8888 ...")
-	http.ListenAndServe(":8888", nil)
+	// The local executor runs several environments on one host,
+	// each on its own port.
+	port := os.Getenv("PORT")
+	if len(port) == 0 {
+		port = "8888"
+	}
+	fmt.Printf("Listening on %v ...\n", port)
+	http.ListenAndServe(":"+port, nil)
 }
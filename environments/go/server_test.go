@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpecializeAgain(t *testing.T) {
+	// plugins can't be built here, so the function is already
+	// loaded
+	request := FunctionLoadRequest{
+		FilePath:     "/userfunc/hello-1",
+		FunctionName: "Handler",
+		URL:          "/fission-function/hello-1",
+	}
+	setUserFunc(request.URL, request.FilePath, func(w http.ResponseWriter, r *http.Request) {})
+	defer func() {
+		userFuncs = make(map[string]http.HandlerFunc)
+		userFuncPaths = make(map[string]string)
+	}()
+
+	specialize := func(request FunctionLoadRequest) int {
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+		w := httptest.NewRecorder()
+		specializeHandlerV2(w, httptest.NewRequest("POST", "/v2/specialize", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	// a new executor leader asks for the same function again
+	if code := specialize(request); code != http.StatusOK {
+		t.Fatalf("expected specializing the loaded function again to succeed, got %v", code)
+	}
+
+	// another function can't take its URL
+	request.FilePath = "/userfunc/hello-2"
+	if code := specialize(request); code != http.StatusBadRequest {
+		t.Fatalf("expected another function at the same URL to be refused, got %v", code)
+	}
+}
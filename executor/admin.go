@@ -0,0 +1,255 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// The administration API lists the running instances of functions,
+// evicts them, and starts them ahead of requests.  The CLI reaches it
+// through the controller's executor proxy.
+
+// listFunctionServices returns the instances of a function, or of all
+// functions if name is empty, sorted by function and address.
+func (executor *Executor) listFunctionServices(namespace string, name string) ([]*fscache.FuncSvc, error) {
+	all, err := executor.fsCache.List()
+	if err != nil {
+		return nil, err
+	}
+	fsvcs := make([]*fscache.FuncSvc, 0, len(all))
+	for _, fsvc := range all {
+		if len(name) > 0 && (fsvc.Function.Namespace != namespace || fsvc.Function.Name != name) {
+			continue
+		}
+		fsvcs = append(fsvcs, fsvc)
+	}
+	sort.Slice(fsvcs, func(i, j int) bool {
+		if fsvcs[i].Function.Name != fsvcs[j].Function.Name {
+			return fsvcs[i].Function.Name < fsvcs[j].Function.Name
+		}
+		return fsvcs[i].Address < fsvcs[j].Address
+	})
+	return fsvcs, nil
+}
+
+func makeFunctionServiceInfo(fsvc *fscache.FuncSvc) fission.FunctionServiceInfo {
+	info := fission.FunctionServiceInfo{
+		Function:        fsvc.Function.Name,
+		Namespace:       fsvc.Function.Namespace,
+		ResourceVersion: fsvc.Function.ResourceVersion,
+		Name:            fsvc.Name,
+		Address:         fsvc.Address,
+		Executor:        fsvc.Executor,
+		Draining:        !fsvc.Draining.IsZero(),
+		Ctime:           fsvc.Ctime,
+		Atime:           fsvc.Atime,
+	}
+	if fsvc.Environment != nil {
+		info.Environment = fsvc.Environment.Metadata.Name
+	}
+	for _, obj := range fsvc.KubernetesObjects {
+		if strings.ToLower(obj.Kind) == "pod" {
+			info.Pod = obj.Name
+			break
+		}
+	}
+	return info
+}
+
+// evictFunction releases all instances of a function.  The next
+// request for the function specializes a new one.
+func (executor *Executor) evictFunction(namespace string, name string) error {
+	fsvcs, err := executor.listFunctionServices(namespace, name)
+	if err != nil {
+		return err
+	}
+	if len(fsvcs) == 0 {
+		return fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("function %v has no running instances", name))
+	}
+	for _, fsvc := range fsvcs {
+		b, ok := executor.backends[fsvc.Executor]
+		if !ok {
+			return fmt.Errorf("no backend for executor type %v", fsvc.Executor)
+		}
+		log.Printf("[%v] Evicting function service %v at %v", name, fsvc.Name, fsvc.Address)
+		err = b.Evict(fsvc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmFunction makes sure a function has a running instance, so that
+// the next request doesn't wait for one.  The function's instances are
+// kept for at least holdFor, whether they're used or not.
+func (executor *Executor) warmFunction(ctx context.Context, namespace string, name string, holdFor time.Duration) error {
+	fn, err := executor.store.GetFunction(namespace, name)
+	if err != nil {
+		return err
+	}
+	_, err = executor.getServiceForFunction(ctx, &fn.Metadata)
+	if err != nil {
+		return err
+	}
+	if holdFor > 0 {
+		log.Printf("[%v] Keeping function warm for %v", name, holdFor)
+		executor.keepWarmState.hold(fn.Metadata.UID, time.Now().Add(holdFor))
+	}
+	return nil
+}
+
+func (executor *Executor) respondWithFunctionServices(w http.ResponseWriter, namespace string, name string) {
+	fsvcs, err := executor.listFunctionServices(namespace, name)
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	infos := make([]fission.FunctionServiceInfo, 0, len(fsvcs))
+	for _, fsvc := range fsvcs {
+		infos = append(infos, makeFunctionServiceInfo(fsvc))
+	}
+	resp, err := json.Marshal(infos)
+	if err != nil {
+		http.Error(w, "Failed to encode response", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func (executor *Executor) functionServiceListApi(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+	executor.respondWithFunctionServices(w, namespace, query.Get("function"))
+}
+
+func (executor *Executor) functionServiceEvictApi(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := executor.evictFunction(vars["namespace"], vars["function"])
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// functionServiceSpecializeApi replaces a function's instances with a
+// freshly specialized one.
+func (executor *Executor) functionServiceSpecializeApi(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace, name := vars["namespace"], vars["function"]
+	err := executor.evictFunction(namespace, name)
+	if err == nil || isNotFound(err) {
+		err = executor.warmFunction(r.Context(), namespace, name, 0)
+	}
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	executor.respondWithFunctionServices(w, namespace, name)
+}
+
+// functionServiceWarmApi starts an instance of a function.  With a
+// duration, the function is kept warm that long, even when idle.
+func (executor *Executor) functionServiceWarmApi(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace, name := vars["namespace"], vars["function"]
+	var holdFor time.Duration
+	if value := r.URL.Query().Get("duration"); len(value) > 0 {
+		var err error
+		holdFor, err = time.ParseDuration(value)
+		if err != nil || holdFor < 0 {
+			http.Error(w, fmt.Sprintf("Invalid duration '%v'", value), http.StatusBadRequest)
+			return
+		}
+	}
+	err := executor.warmFunction(r.Context(), namespace, name, holdFor)
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	executor.respondWithFunctionServices(w, namespace, name)
+}
+
+// functionColdStartsApi returns the timelines of a function's latest
+// cold starts, oldest first.
+func (executor *Executor) functionColdStartsApi(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	coldStarts := executor.coldStarts.list(vars["namespace"], vars["function"])
+	resp, err := json.Marshal(coldStarts)
+	if err != nil {
+		http.Error(w, "Failed to encode response", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// usageApi returns the usage of functions over a time range, given as
+// RFC 3339 start and end times; the last day by default.
+func (executor *Executor) usageApi(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	end := time.Now()
+	start := end.Add(-24 * time.Hour)
+	for name, t := range map[string]*time.Time{"start": &start, "end": &end} {
+		value := query.Get(name)
+		if len(value) == 0 {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid %v time '%v'", name, value), http.StatusBadRequest)
+			return
+		}
+		*t = parsed
+	}
+	records := executor.usage.query(start, end, query.Get("namespace"), query.Get("function"))
+	resp, err := json.Marshal(records)
+	if err != nil {
+		http.Error(w, "Failed to encode response", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func isNotFound(err error) bool {
+	fe, ok := err.(fission.Error)
+	return ok && fe.Code == fission.ErrorNotFound
+}
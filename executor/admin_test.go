@@ -0,0 +1,186 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/testutil"
+)
+
+// testBackend "specializes" a new pod for every function service
+type testBackend struct {
+	fsCache *fscache.FunctionServiceCache
+	started int
+	evicted []string
+	stopped bool
+}
+
+func (b *testBackend) GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	b.started++
+	name := fmt.Sprintf("%v-%v", fn.Metadata.Name, b.started)
+	fsvc := &fscache.FuncSvc{
+		Name:        name,
+		Function:    &fn.Metadata,
+		Environment: env,
+		Address:     fmt.Sprintf("10.0.0.%v:8888", b.started),
+		KubernetesObjects: []api.ObjectReference{
+			{Kind: "pod", Name: name},
+		},
+		Executor:  fission.ExecutorTypePoolmgr,
+		ColdStart: &fission.ColdStart{Pod: name},
+	}
+	_, err := b.fsCache.Add(*fsvc)
+	return fsvc, err
+}
+func (b *testBackend) TapService(fsvc *fscache.FuncSvc, address string) error { return nil }
+func (b *testBackend) Reap()                                                  {}
+func (b *testBackend) Evict(fsvc *fscache.FuncSvc) error {
+	b.evicted = append(b.evicted, fsvc.Name)
+	_, err := b.fsCache.DeleteOld(fsvc, 0)
+	return err
+}
+func (b *testBackend) Cleanup() error { return nil }
+func (b *testBackend) OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function) {
+	if oldFn != nil {
+		b.fsCache.Drain(&oldFn.Metadata)
+	}
+}
+func (b *testBackend) Stop() { b.stopped = true }
+
+func makeTestExecutor() (*Executor, *testBackend) {
+	env := &crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "nodejs", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+	}
+	store := &testutil.Store{
+		Functions:   make(map[string]*crd.Function),
+		Environment: env,
+	}
+	for i, name := range []string{"hello", "world"} {
+		store.Functions[name] = &crd.Function{
+			Metadata: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       metav1.NamespaceDefault,
+				UID:             types.UID(fmt.Sprintf("fn-%v", i)),
+				ResourceVersion: "1",
+			},
+			Spec: fission.FunctionSpec{
+				Environment: fission.EnvironmentReference{Name: "nodejs", Namespace: metav1.NamespaceDefault},
+			},
+		}
+	}
+	fsCache := fscache.MakeFunctionServiceCache()
+	b := &testBackend{fsCache: fsCache}
+	backends := map[fission.ExecutorType]backend.ExecutorBackend{
+		fission.ExecutorTypePoolmgr: b,
+	}
+	return MakeExecutor(backends, store, nil, fsCache), b
+}
+
+func doAdminRequest(t *testing.T, server *httptest.Server, method string, path string) (int, []fission.FunctionServiceInfo) {
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	infos := make([]fission.FunctionServiceInfo, 0)
+	if resp.StatusCode == http.StatusOK && method != "DELETE" {
+		err = json.NewDecoder(resp.Body).Decode(&infos)
+		if err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+	}
+	return resp.StatusCode, infos
+}
+
+func TestFunctionServiceAdminApi(t *testing.T) {
+	executor, b := makeTestExecutor()
+	server := httptest.NewServer(executor.getHandler())
+	defer server.Close()
+
+	// warm functions
+	for _, name := range []string{"hello", "world"} {
+		code, infos := doAdminRequest(t, server, "POST", "/v2/functionServices/default/"+name+"/warm")
+		if code != http.StatusOK || len(infos) != 1 || infos[0].Function != name {
+			t.Fatalf("unexpected response to warming %v: %v %v", name, code, infos)
+		}
+	}
+	// warming a running function doesn't start another instance
+	code, infos := doAdminRequest(t, server, "POST", "/v2/functionServices/default/hello/warm")
+	if code != http.StatusOK || len(infos) != 1 || b.started != 2 {
+		t.Fatalf("expected warming a running function to do nothing, got %v %v", code, infos)
+	}
+	code, _ = doAdminRequest(t, server, "POST", "/v2/functionServices/default/missing/warm")
+	if code != http.StatusNotFound {
+		t.Fatalf("expected %v warming a missing function, got %v", http.StatusNotFound, code)
+	}
+
+	// list
+	code, infos = doAdminRequest(t, server, "GET", "/v2/functionServices")
+	if code != http.StatusOK || len(infos) != 2 {
+		t.Fatalf("expected 2 function services, got %v %v", code, infos)
+	}
+	hello := infos[0]
+	if hello.Function != "hello" || hello.Pod != "hello-1" || hello.Address != "10.0.0.1:8888" ||
+		hello.Executor != fission.ExecutorTypePoolmgr || hello.Environment != "nodejs" || hello.Ctime.IsZero() {
+		t.Fatalf("unexpected function service %#v", hello)
+	}
+	code, infos = doAdminRequest(t, server, "GET", "/v2/functionServices?function=world&namespace=default")
+	if code != http.StatusOK || len(infos) != 1 || infos[0].Function != "world" {
+		t.Fatalf("expected only world's function service, got %v %v", code, infos)
+	}
+
+	// re-specialize
+	code, infos = doAdminRequest(t, server, "POST", "/v2/functionServices/default/hello/specialize")
+	if code != http.StatusOK || len(infos) != 1 || infos[0].Name != "hello-3" {
+		t.Fatalf("expected a new instance of hello, got %v %v", code, infos)
+	}
+	if len(b.evicted) != 1 || b.evicted[0] != "hello-1" {
+		t.Fatalf("expected hello-1 to be evicted, got %v", b.evicted)
+	}
+
+	// evict
+	code, _ = doAdminRequest(t, server, "DELETE", "/v2/functionServices/default/world")
+	if code != http.StatusOK {
+		t.Fatalf("error evicting function: %v", code)
+	}
+	code, infos = doAdminRequest(t, server, "GET", "/v2/functionServices?function=world&namespace=default")
+	if code != http.StatusOK || len(infos) != 0 {
+		t.Fatalf("expected world to be evicted, got %v %v", code, infos)
+	}
+	code, _ = doAdminRequest(t, server, "DELETE", "/v2/functionServices/default/world")
+	if code != http.StatusNotFound {
+		t.Fatalf("expected %v evicting a function without instances, got %v", http.StatusNotFound, code)
+	}
+}
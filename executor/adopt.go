@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/poolmgr"
+)
+
+// discoverInstanceId returns the instance id of the executor that most
+// recently created objects in the function namespace, so that a
+// restarted executor can adopt them instead of starting from
+// scratch. Returns an empty string if there's nothing to adopt.
+func discoverInstanceId(client kubernetes.Interface, namespace string) (string, error) {
+	// any object with an instance id label
+	selector := metav1.ListOptions{LabelSelector: fission.EXECUTOR_INSTANCEID_LABEL}
+
+	var newest time.Time
+	instanceId := ""
+	consider := func(m *metav1.ObjectMeta) {
+		id := m.Labels[fission.EXECUTOR_INSTANCEID_LABEL]
+		if len(id) > 0 && (len(instanceId) == 0 || m.CreationTimestamp.Time.After(newest)) {
+			instanceId = id
+			newest = m.CreationTimestamp.Time
+		}
+	}
+
+	deplList, err := client.ExtensionsV1beta1().Deployments(namespace).List(selector)
+	if err != nil {
+		return "", err
+	}
+	for i := range deplList.Items {
+		consider(&deplList.Items[i].ObjectMeta)
+	}
+
+	podList, err := client.CoreV1().Pods(namespace).List(selector)
+	if err != nil {
+		return "", err
+	}
+	for i := range podList.Items {
+		consider(&podList.Items[i].ObjectMeta)
+	}
+
+	return instanceId, nil
+}
+
+// rebuildState picks up where the previous executor left off: it
+// reuses the instance id of the executor that most recently created
+// objects in the function namespace, and adopts its specialized pods,
+// so they keep serving their functions.  This is what a new leader
+// does on takeover, and what a restarted executor does on startup.
+// The objects of deleted functions and environments are cleaned up by
+// each backend, see ExecutorBackend.Cleanup.  Returns the instance id
+// to use, which is a new one if there's nothing to adopt.
+func rebuildState(kubernetesClient kubernetes.Interface, fsCache *fscache.FunctionServiceCache,
+	namespace string, functions []crd.Function, envs []crd.Environment) string {
+
+	instanceId, err := discoverInstanceId(kubernetesClient, namespace)
+	if err != nil {
+		log.Printf("Failed to discover previous executor instance: %v", err)
+	}
+	if len(instanceId) == 0 {
+		return strings.ToLower(uniuri.NewLen(8))
+	}
+
+	log.Printf("Adopting objects of executor instance %v", instanceId)
+	err = adoptFunctionServices(kubernetesClient, fsCache, namespace, instanceId, functions, envs)
+	if err != nil {
+		log.Printf("Failed to adopt objects: %v", err)
+	}
+	return instanceId
+}
+
+// adoptFunctionServices adds the specialized pods of an earlier
+// executor to the function service cache, so they keep serving their
+// functions.  Additional pods of a scaled out function are adopted as
+// extra instances.  Pods whose function is gone, was updated, or that
+// aren't ready any more are deleted.
+func adoptFunctionServices(kubernetesClient kubernetes.Interface, fsCache *fscache.FunctionServiceCache,
+	namespace string, instanceId string, functions []crd.Function, envs []crd.Environment) error {
+
+	fnByUid := make(map[string]*crd.Function)
+	for i := range functions {
+		fnByUid[string(functions[i].Metadata.UID)] = &functions[i]
+	}
+	envByName := make(map[string]*crd.Environment)
+	for i := range envs {
+		envByName[envs[i].Metadata.Namespace+"/"+envs[i].Metadata.Name] = &envs[i]
+	}
+
+	podList, err := kubernetesClient.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			"unmanaged":                       "true",
+			fission.EXECUTOR_INSTANCEID_LABEL: instanceId,
+		}).AsSelector().String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	adopted := 0
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+
+		var env *crd.Environment
+		fn := fnByUid[pod.ObjectMeta.Labels["functionUid"]]
+		if fn != nil {
+			env = envByName[fn.Spec.Environment.Namespace+"/"+fn.Spec.Environment.Name]
+		}
+		if fn == nil || env == nil ||
+			fn.Metadata.ResourceVersion != pod.ObjectMeta.Labels["functionResourceVersion"] ||
+			!poolmgr.IsPodReady(pod) {
+			log.Printf("Cleaning up orphaned function pod %v", pod.ObjectMeta.Name)
+			err := kubernetesClient.CoreV1().Pods(namespace).Delete(pod.ObjectMeta.Name, nil)
+			logErr("cleaning up pod", err)
+			continue
+		}
+
+		fsvc := fscache.FuncSvc{
+			Name:        pod.ObjectMeta.Name,
+			Function:    &fn.Metadata,
+			Environment: env,
+			Address:     fmt.Sprintf("%v:8888", pod.Status.PodIP),
+			KubernetesObjects: []api.ObjectReference{
+				{
+					Kind:            "pod",
+					Name:            pod.ObjectMeta.Name,
+					APIVersion:      pod.TypeMeta.APIVersion,
+					Namespace:       pod.ObjectMeta.Namespace,
+					ResourceVersion: pod.ObjectMeta.ResourceVersion,
+					UID:             pod.ObjectMeta.UID,
+				},
+			},
+			Executor: fission.ExecutorTypePoolmgr,
+		}
+		existing, err := fsCache.Add(fsvc)
+		if err != nil {
+			if existing == nil {
+				return err
+			}
+			// another pod already serves this function
+			err = fsCache.AddInstance(fsvc)
+			if err != nil {
+				return err
+			}
+		}
+		adopted++
+	}
+	log.Printf("Adopted %v function pods", adopted)
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const adoptTestNamespace = "fission-function"
+
+func makeAdoptTestPod(name string, fn *crd.Function, resourceVersion string, ip string) *apiv1.Pod {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: adoptTestNamespace,
+			Labels: map[string]string{
+				"functionName":                    fn.Metadata.Name,
+				"functionUid":                     string(fn.Metadata.UID),
+				"functionResourceVersion":         resourceVersion,
+				"unmanaged":                       "true",
+				fission.EXECUTOR_INSTANCEID_LABEL: "abcd1234",
+			},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodPending},
+	}
+	if len(ip) > 0 {
+		pod.Status.Phase = apiv1.PodRunning
+		pod.Status.PodIP = ip
+		pod.Status.ContainerStatuses = []apiv1.ContainerStatus{{Ready: true}, {Ready: true}}
+	}
+	return pod
+}
+
+func TestDiscoverInstanceId(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset(
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "old-pool",
+				Namespace:         adoptTestNamespace,
+				Labels:            map[string]string{fission.EXECUTOR_INSTANCEID_LABEL: "old"},
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			},
+		},
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "new-pod",
+				Namespace:         adoptTestNamespace,
+				Labels:            map[string]string{fission.EXECUTOR_INSTANCEID_LABEL: "new"},
+				CreationTimestamp: metav1.NewTime(now),
+			},
+		},
+	)
+
+	id, err := discoverInstanceId(client, adoptTestNamespace)
+	if err != nil {
+		t.Fatalf("error discovering instance id: %v", err)
+	}
+	if id != "new" {
+		t.Fatalf("expected the newest instance id 'new', got '%v'", id)
+	}
+
+	id, err = discoverInstanceId(fake.NewSimpleClientset(), adoptTestNamespace)
+	if err != nil {
+		t.Fatalf("error discovering instance id: %v", err)
+	}
+	if len(id) != 0 {
+		t.Fatalf("expected no instance id in an empty namespace, got '%v'", id)
+	}
+}
+
+func TestAdoptFunctionServices(t *testing.T) {
+	env := crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "nodejs", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+	}
+	hello := crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1", ResourceVersion: "7"},
+		Spec: fission.FunctionSpec{
+			Environment: fission.EnvironmentReference{Name: "nodejs", Namespace: metav1.NamespaceDefault},
+		},
+	}
+	deleted := crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "deleted", Namespace: metav1.NamespaceDefault, UID: "fn-2"},
+	}
+
+	client := fake.NewSimpleClientset(
+		makeAdoptTestPod("hello-1", &hello, "7", "10.0.0.1"),
+		makeAdoptTestPod("hello-2", &hello, "7", "10.0.0.2"),
+		makeAdoptTestPod("hello-old", &hello, "6", "10.0.0.3"),
+		makeAdoptTestPod("hello-starting", &hello, "7", ""),
+		makeAdoptTestPod("deleted-1", &deleted, "3", "10.0.0.4"),
+	)
+	fsCache := fscache.MakeFunctionServiceCache()
+
+	err := adoptFunctionServices(client, fsCache, adoptTestNamespace, "abcd1234",
+		[]crd.Function{hello}, []crd.Environment{env})
+	if err != nil {
+		t.Fatalf("error adopting function pods: %v", err)
+	}
+
+	fsvc, err := fsCache.GetByFunction(&hello.Metadata)
+	if err != nil {
+		t.Fatalf("function pod wasn't adopted: %v", err)
+	}
+	if fsvc.Executor != fission.ExecutorTypePoolmgr {
+		t.Fatalf("unexpected function service %v", fsvc)
+	}
+
+	// both pods of the scaled out function are kept
+	addresses := make(map[string]bool)
+	for _, address := range fsvc.Addresses {
+		addresses[address] = true
+	}
+	if len(fsvc.Addresses) != 2 || !addresses["10.0.0.1:8888"] || !addresses["10.0.0.2:8888"] {
+		t.Fatalf("expected two instances of the function, got %v", fsvc.Addresses)
+	}
+
+	// the outdated and orphaned pods are deleted
+	podList, err := client.CoreV1().Pods(adoptTestNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing pods: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, pod := range podList.Items {
+		names[pod.ObjectMeta.Name] = true
+	}
+	if len(names) != 2 || !names["hello-1"] || !names["hello-2"] {
+		t.Fatalf("expected only the adopted pods to remain, got %v", names)
+	}
+}
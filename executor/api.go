@@ -22,17 +22,28 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	executorClient "github.com/fission/fission/executor/client"
+	"github.com/fission/fission/executor/fscache"
 )
 
+// Requests in flight per pod above which a pool manager function is
+// scaled out, unless its execution strategy says otherwise.
+const defaultTargetConcurrency = 10
+
 func (executor *Executor) getServiceForFunctionApi(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -48,7 +59,7 @@ func (executor *Executor) getServiceForFunctionApi(w http.ResponseWriter, r *htt
 		return
 	}
 
-	serviceName, err := executor.getServiceForFunction(&m)
+	serviceName, err := executor.getServiceForFunction(r.Context(), &m)
 	if err != nil {
 		code, msg := fission.GetHTTPError(err)
 		log.Printf("Error: %v: %v", code, msg)
@@ -59,7 +70,11 @@ func (executor *Executor) getServiceForFunctionApi(w http.ResponseWriter, r *htt
 	w.Write([]byte(serviceName))
 }
 
-func (executor *Executor) getServiceForFunction(m *metav1.ObjectMeta) (string, error) {
+// getServiceForFunction returns the address of a function's service,
+// specializing one if there's none.  Concurrent requests for the same
+// function share one specialization.  Gives up when ctx is done, e.g.
+// when the router's request was aborted.
+func (executor *Executor) getServiceForFunction(ctx context.Context, m *metav1.ObjectMeta) (string, error) {
 	// Check function -> svc cache
 	log.Printf("[%v] Checking for cached function service", m.Name)
 	fsvc, err := executor.fsCache.GetByFunction(m)
@@ -68,16 +83,116 @@ func (executor *Executor) getServiceForFunction(m *metav1.ObjectMeta) (string, e
 		return fsvc.Address, nil
 	}
 
-	respChan := make(chan *createFuncServiceResponse)
-	executor.requestChan <- &createFuncServiceRequest{
-		funcMeta: m,
-		respChan: respChan,
+	fsvc, err = executor.specializations.do(ctx, crd.CacheKey(m), func(ctx context.Context) (*fscache.FuncSvc, error) {
+		return executor.createServiceForFunction(ctx, m)
+	})
+	if err != nil {
+		return "", err
+	}
+	return fsvc.Address, nil
+}
+
+func (executor *Executor) reportLoadApi(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", 500)
+		return
+	}
+
+	report := executorClient.LoadReport{}
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		http.Error(w, "Failed to parse request", 400)
+		return
+	}
+
+	// routers are told apart by their address
+	router, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		router = r.RemoteAddr
 	}
-	resp := <-respChan
-	if resp.err != nil {
-		return "", resp.err
+	addresses, err := executor.reportLoad(&report, router)
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
 	}
-	return resp.funcSvc.Address, resp.err
+
+	resp, err := json.Marshal(addresses)
+	if err != nil {
+		http.Error(w, "Failed to encode response", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// reportLoad scales out a busy function, if its backend can, and
+// returns the addresses the function is served at.  The number of
+// requests in flight at the router is recorded even for the old
+// version of an updated function, whose instances are released once
+// it drops to zero.
+func (executor *Executor) reportLoad(report *executorClient.LoadReport, router string) ([]string, error) {
+	executor.fsCache.ReportLoad(&report.Function, router, report.Inflight)
+	fsvc, err := executor.fsCache.GetByFunction(&report.Function)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := executor.backends[fsvc.Executor].(backend.ScaleOuter); !ok {
+		return fsvc.Addresses, nil
+	}
+
+	fn, err := executor.getFunction(&report.Function)
+	if err != nil {
+		return nil, err
+	}
+	if scaleOutNeeded(&fn.Spec.InvokeStrategy.ExecutionStrategy, len(fsvc.Addresses), report) {
+		log.Printf("[%v] Function is busy (%v in flight, %v latency, %v instances)",
+			report.Function.Name, report.Inflight, report.Latency.Duration, len(fsvc.Addresses))
+		// dropped while the function is being specialized anyway
+		m := report.Function
+		executor.specializations.startIfIdle(crd.CacheKey(&m), func(ctx context.Context) (*fscache.FuncSvc, error) {
+			fsvc, err := executor.scaleOutFunction(ctx, &m)
+			if err != nil {
+				log.Printf("[%v] Error scaling out function: %v", m.Name, err)
+			}
+			return fsvc, err
+		})
+	}
+	return fsvc.Addresses, nil
+}
+
+func (executor *Executor) reportInvocationsApi(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request", 500)
+		return
+	}
+
+	invocations := make([]executorClient.Invocations, 0)
+	err = json.Unmarshal(body, &invocations)
+	if err != nil {
+		http.Error(w, "Failed to parse request", 400)
+		return
+	}
+	executor.reportInvocations(invocations, time.Now())
+	w.WriteHeader(http.StatusOK)
+}
+
+// scaleOutNeeded is true if a function with the given number of
+// instances is busier than its execution strategy allows.
+func scaleOutNeeded(strategy *fission.ExecutionStrategy, instances int, report *executorClient.LoadReport) bool {
+	if instances >= strategy.MaxScale {
+		return false
+	}
+	target := strategy.TargetConcurrency
+	if target <= 0 {
+		target = defaultTargetConcurrency
+	}
+	if report.Inflight > target*instances {
+		return true
+	}
+	return strategy.TargetLatency.Duration > 0 && report.Latency.Duration > strategy.TargetLatency.Duration
 }
 
 // find funcSvc and update its atime
@@ -90,7 +205,15 @@ func (executor *Executor) tapService(w http.ResponseWriter, r *http.Request) {
 	svcName := string(body)
 	svcHost := strings.TrimPrefix(svcName, "http://")
 
-	err = executor.fsCache.TouchByAddress(svcHost)
+	fsvc, err := executor.fsCache.GetByAddress(svcHost)
+	if err == nil {
+		b, ok := executor.backends[fsvc.Executor]
+		if !ok {
+			err = fmt.Errorf("no backend for executor type %v", fsvc.Executor)
+		} else {
+			err = b.TapService(fsvc, svcHost)
+		}
+	}
 	if err != nil {
 		log.Printf("funcSvc tap error: %v", err)
 		http.Error(w, "Not found", 404)
@@ -99,14 +222,31 @@ func (executor *Executor) tapService(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (executor *Executor) Serve(port int) {
+func (executor *Executor) getHandler() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/v2/getServiceForFunction", executor.getServiceForFunctionApi).Methods("POST")
 	r.HandleFunc("/v2/tapService", executor.tapService).Methods("POST")
+	r.HandleFunc("/v2/reportLoad", executor.reportLoadApi).Methods("POST")
+	r.HandleFunc("/v2/reportInvocations", executor.reportInvocationsApi).Methods("POST")
+
+	r.HandleFunc("/v2/functionServices", executor.functionServiceListApi).Methods("GET")
+	r.HandleFunc("/v2/functionServices/{namespace}/{function}", executor.functionServiceEvictApi).Methods("DELETE")
+	r.HandleFunc("/v2/functionServices/{namespace}/{function}/specialize", executor.functionServiceSpecializeApi).Methods("POST")
+	r.HandleFunc("/v2/functionServices/{namespace}/{function}/warm", executor.functionServiceWarmApi).Methods("POST")
+	r.HandleFunc("/v2/functionServices/{namespace}/{function}/coldstarts", executor.functionColdStartsApi).Methods("GET")
+	r.HandleFunc("/v2/usage", executor.usageApi).Methods("GET")
+
+	r.Handle("/metrics", promhttp.Handler())
+	return r
+}
+
+func (executor *Executor) Serve(port int) {
 	address := fmt.Sprintf(":%v", port)
 	log.Printf("starting executor at port %v", port)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	executor.ndm.Run(ctx)
-	log.Fatal(http.ListenAndServe(address, handlers.LoggingHandler(os.Stdout, r)))
+	if executor.funcController != nil {
+		go executor.funcController.Run(ctx.Done())
+	}
+	log.Fatal(http.ListenAndServe(address, handlers.LoggingHandler(os.Stdout, executor.getHandler())))
 }
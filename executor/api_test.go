@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	executorClient "github.com/fission/fission/executor/client"
+)
+
+func TestScaleOutNeeded(t *testing.T) {
+	strategy := &fission.ExecutionStrategy{
+		ExecutorType:      fission.ExecutorTypePoolmgr,
+		MaxScale:          3,
+		TargetConcurrency: 4,
+	}
+	tests := []struct {
+		instances int
+		inflight  int
+		latency   time.Duration
+		scaleOut  bool
+	}{
+		{1, 4, 0, false},
+		{1, 5, 0, true},
+		{2, 8, 0, false},
+		{2, 9, 0, true},
+		{3, 100, 0, false}, // at MaxScale
+	}
+	for _, test := range tests {
+		report := &executorClient.LoadReport{Inflight: test.inflight, Latency: metav1.Duration{Duration: test.latency}}
+		if scaleOutNeeded(strategy, test.instances, report) != test.scaleOut {
+			t.Errorf("expected scale out %v for %v in flight on %v instances",
+				test.scaleOut, test.inflight, test.instances)
+		}
+	}
+
+	// latency target
+	strategy.TargetLatency = metav1.Duration{Duration: 100 * time.Millisecond}
+	report := &executorClient.LoadReport{Inflight: 1, Latency: metav1.Duration{Duration: 300 * time.Millisecond}}
+	if !scaleOutNeeded(strategy, 1, report) {
+		t.Errorf("expected slow function to be scaled out")
+	}
+
+	// functions are only scaled out if their MaxScale allows it
+	strategy = &fission.ExecutionStrategy{ExecutorType: fission.ExecutorTypePoolmgr, MaxScale: 1}
+	report = &executorClient.LoadReport{Inflight: 1000}
+	if scaleOutNeeded(strategy, 1, report) {
+		t.Errorf("function with MaxScale 1 was scaled out")
+	}
+	strategy.MaxScale = 2
+	if !scaleOutNeeded(strategy, 1, report) {
+		t.Errorf("expected default target concurrency to apply")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+type (
+	// ExecutorBackend runs functions for the executor.  Each
+	// function's execution strategy names the backend that runs it;
+	// the executor core handles caching, request coalescing and the
+	// API, and hands everything else to the backend.
+	ExecutorBackend interface {
+		// GetFuncSvc makes a function ready to serve requests and
+		// returns its service.  It's only called when the function
+		// has no cached service, and never concurrently for the same
+		// function.  The backend adds the service to the function
+		// service cache.  It gives up when ctx is done: nobody waits
+		// for the service any more, or the specialization timed out.
+		GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error)
+
+		// TapService records a request for the function service at
+		// address, which keeps it from being reaped.
+		TapService(fsvc *fscache.FuncSvc, address string) error
+
+		// Reap releases the resources of idle function services.
+		// The executor calls it periodically.
+		Reap()
+
+		// Evict uncaches a function service and releases its
+		// resources right away, as if it had been idle.  The next
+		// request for the function gets a new service.
+		Evict(fsvc *fscache.FuncSvc) error
+
+		// Cleanup removes the objects an earlier executor with the
+		// same instance id left behind for functions or environments
+		// that no longer exist.  It's called once on startup.
+		Cleanup() error
+
+		// OnFunctionUpdate is called when a function is created
+		// (oldFn is nil), updated, or deleted (newFn is nil).  When
+		// an update changes a function's executor type, both the old
+		// and the new backend are called.
+		OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function)
+
+		// Stop stops the backend's background work, when the
+		// executor is no longer the leader.  Its pods and other
+		// objects are left for the next leader to adopt.
+		Stop()
+	}
+
+	// ScaleOuter is implemented by backends that can run more than
+	// one instance of a function on request of the router's load
+	// reports.  Backends that scale on their own don't implement it.
+	ScaleOuter interface {
+		// ScaleOut starts another instance of a function that
+		// already has a cached service, giving up when ctx is done.
+		ScaleOut(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error)
+	}
+
+	// PodCounter is implemented by backends whose function services
+	// don't each stand for one pod.  Usage accounting charges a
+	// function for the pods of its function services.
+	PodCounter interface {
+		// CountPods returns how many pods' worth of resources a
+		// function service takes; a share of a pod if the pod
+		// serves several functions.
+		CountPods(fsvc *fscache.FuncSvc) (float64, error)
+	}
+
+	// FunctionStore is where the executor looks up functions, their
+	// environments and packages.  In a cluster it's the Kubernetes
+	// API; the local executor reads them from files.
+	FunctionStore interface {
+		GetFunction(namespace string, name string) (*crd.Function, error)
+		GetEnvironment(namespace string, name string) (*crd.Environment, error)
+		ListEnvironments() ([]crd.Environment, error)
+		GetPackage(namespace string, name string) (*crd.Package, error)
+	}
+
+	// crdStore is the FunctionStore of an executor in a cluster.
+	crdStore struct {
+		fissionClient *crd.FissionClient
+	}
+
+	// Config is what backends are made with.
+	Config struct {
+		Store             FunctionStore
+		FissionClient     *crd.FissionClient
+		KubernetesClient  kubernetes.Interface
+		CrdClient         *rest.RESTClient
+		FissionNamespace  string
+		FunctionNamespace string
+		FsCache           *fscache.FunctionServiceCache
+		InstanceID        string
+	}
+
+	// Factory makes a backend.
+	Factory func(config *Config) (ExecutorBackend, error)
+)
+
+var (
+	lock      sync.Mutex
+	factories = make(map[fission.ExecutorType]Factory)
+)
+
+// Register makes a backend available under an executor type.  Backends
+// register themselves from an init function; registering the same
+// type twice panics.
+func Register(executorType fission.ExecutorType, factory Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(executorType) == 0 || factory == nil {
+		panic("executor backend registered without a type or factory")
+	}
+	if _, ok := factories[executorType]; ok {
+		panic(fmt.Sprintf("executor backend %v registered twice", executorType))
+	}
+	factories[executorType] = factory
+}
+
+// IsRegistered returns true if there's a backend for executorType.
+func IsRegistered(executorType fission.ExecutorType) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	_, ok := factories[executorType]
+	return ok
+}
+
+// Types returns the registered executor types, sorted.
+func Types() []fission.ExecutorType {
+	lock.Lock()
+	defer lock.Unlock()
+	types := make([]fission.ExecutorType, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Make makes the backend registered for executorType.
+func Make(executorType fission.ExecutorType, config *Config) (ExecutorBackend, error) {
+	lock.Lock()
+	factory, ok := factories[executorType]
+	lock.Unlock()
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown executor type '%v'", executorType))
+	}
+	return factory(config)
+}
+
+// TypeOf returns the executor type of a function.  Functions that
+// don't set one run on the pool manager.
+func TypeOf(fn *crd.Function) fission.ExecutorType {
+	executorType := fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType
+	if len(executorType) == 0 {
+		return fission.ExecutorTypePoolmgr
+	}
+	return executorType
+}
+
+// MakeCrdStore makes a FunctionStore backed by the Kubernetes API.
+func MakeCrdStore(fissionClient *crd.FissionClient) FunctionStore {
+	return &crdStore{fissionClient: fissionClient}
+}
+
+func (s *crdStore) GetFunction(namespace string, name string) (*crd.Function, error) {
+	return s.fissionClient.Functions(namespace).Get(name)
+}
+
+func (s *crdStore) GetEnvironment(namespace string, name string) (*crd.Environment, error) {
+	return s.fissionClient.Environments(namespace).Get(name)
+}
+
+func (s *crdStore) ListEnvironments() ([]crd.Environment, error) {
+	envs, err := s.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return envs.Items, nil
+}
+
+func (s *crdStore) GetPackage(namespace string, name string) (*crd.Package, error) {
+	return s.fissionClient.Packages(namespace).Get(name)
+}
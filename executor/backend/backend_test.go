@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+type testBackend struct {
+	config *Config
+}
+
+func (b *testBackend) GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	return nil, nil
+}
+func (b *testBackend) TapService(fsvc *fscache.FuncSvc, address string) error    { return nil }
+func (b *testBackend) Reap()                                                     {}
+func (b *testBackend) Evict(fsvc *fscache.FuncSvc) error                         { return nil }
+func (b *testBackend) Cleanup() error                                            { return nil }
+func (b *testBackend) OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function) {}
+func (b *testBackend) Stop()                                                     {}
+
+func makeTestBackend(config *Config) (ExecutorBackend, error) {
+	return &testBackend{config: config}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	var executorType fission.ExecutorType = "test-registry"
+	if IsRegistered(executorType) {
+		t.Fatalf("backend registered before Register")
+	}
+	_, err := Make(executorType, &Config{})
+	if err == nil {
+		t.Fatalf("expected making an unregistered backend to fail")
+	}
+
+	Register(executorType, makeTestBackend)
+	if !IsRegistered(executorType) {
+		t.Fatalf("backend not registered")
+	}
+	found := false
+	for _, et := range Types() {
+		if et == executorType {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %v in %v", executorType, Types())
+	}
+
+	config := &Config{InstanceID: "abcd"}
+	b, err := Make(executorType, config)
+	if err != nil {
+		t.Fatalf("error making backend: %v", err)
+	}
+	if b.(*testBackend).config != config {
+		t.Fatalf("backend wasn't made with the given config")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	var executorType fission.ExecutorType = "test-twice"
+	Register(executorType, makeTestBackend)
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected registering a type twice to panic")
+		}
+	}()
+	Register(executorType, makeTestBackend)
+}
+
+func TestTypeOf(t *testing.T) {
+	fn := &crd.Function{}
+	if TypeOf(fn) != fission.ExecutorTypePoolmgr {
+		t.Fatalf("expected functions without an executor type to run on poolmgr, got %v", TypeOf(fn))
+	}
+	fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType = fission.ExecutorTypeNewdeploy
+	if TypeOf(fn) != fission.ExecutorTypeNewdeploy {
+		t.Fatalf("unexpected executor type %v", TypeOf(fn))
+	}
+}
@@ -17,23 +17,17 @@ limitations under the License.
 package executor
 
 import (
-	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/pkg/api"
 
 	"github.com/fission/fission"
-	"github.com/fission/fission/crd"
-	"github.com/fission/fission/executor/fscache"
 )
 
 // cleanupObjects cleans up resources created by old executortype instances
-func cleanupObjects(kubernetesClient *kubernetes.Clientset,
+func cleanupObjects(kubernetesClient kubernetes.Interface,
 	namespace string,
 	instanceId string) {
 	go func() {
@@ -45,7 +39,7 @@ func cleanupObjects(kubernetesClient *kubernetes.Clientset,
 	}()
 }
 
-func cleanup(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanup(client kubernetes.Interface, namespace string, instanceId string) error {
 
 	err := cleanupServices(client, namespace, instanceId)
 	if err != nil {
@@ -58,8 +52,8 @@ func cleanup(client *kubernetes.Clientset, namespace string, instanceId string)
 	}
 
 	// Deployments are used for idle pools and can be cleaned up
-	// immediately.  (We should "adopt" these instead of creating
-	// a new pool.)
+	// immediately.  (Pools of the current instance id are adopted
+	// instead, see rebuildState.)
 	err = cleanupDeployments(client, namespace, instanceId)
 	if err != nil {
 		return err
@@ -88,108 +82,7 @@ func cleanup(client *kubernetes.Clientset, namespace string, instanceId string)
 	return nil
 }
 
-// idleObjectReaper reaps objects after certain idle time
-func idleObjectReaper(kubeClient *kubernetes.Clientset,
-	fissionClient *crd.FissionClient,
-	fsCache *fscache.FunctionServiceCache,
-	idlePodReapTime time.Duration) {
-
-	pollSleep := time.Duration(2 * time.Minute)
-	for {
-		time.Sleep(pollSleep)
-
-		envs, err := fissionClient.Environments(meta_v1.NamespaceAll).List(meta_v1.ListOptions{})
-		if err != nil {
-			log.Fatalf("Failed to get environment list: %v", err)
-		}
-
-		for i := range envs.Items {
-			env := envs.Items[i]
-			if env.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
-				continue
-			}
-			funcSvcs, err := fsCache.ListOld(&env.Metadata, idlePodReapTime)
-			if err != nil {
-				log.Printf("Error reaping idle pods: %v", err)
-				continue
-			}
-
-			for _, fsvc := range funcSvcs {
-
-				fn, err := fissionClient.Functions(fsvc.Function.Namespace).Get(fsvc.Function.Name)
-				if err != nil {
-					log.Printf("Error getting function: %v", fsvc.Function.Name)
-					continue
-				}
-
-				// Ignore functions of NewDeploy ExecutorType with MinScale > 0
-				if fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale > 0 && fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeNewdeploy {
-					continue
-				}
-				deleted, err := fsCache.DeleteOld(fsvc, idlePodReapTime)
-
-				if err != nil {
-					log.Printf("Error deleting Kubernetes objects for fsvc '%v': %v", fsvc, err)
-					log.Printf("Object Name| Object Kind | Object Namespace")
-					for _, kubeobj := range fsvc.KubernetesObjects {
-						log.Printf("%v | %v | %v", kubeobj.Name, kubeobj.Kind, kubeobj.Namespace)
-					}
-				}
-
-				if !deleted {
-					continue
-				}
-				for _, kubeobj := range fsvc.KubernetesObjects {
-					deleteKubeobject(kubeClient, &kubeobj)
-				}
-			}
-		}
-	}
-}
-
-func deleteKubeobject(kubeClient *kubernetes.Clientset, kubeobj *api.ObjectReference) {
-	switch strings.ToLower(kubeobj.Kind) {
-	case "pod":
-		err := kubeClient.CoreV1().Pods(kubeobj.Namespace).Delete(kubeobj.Name, nil)
-		logErr(fmt.Sprintf("cleaning up pod %v ", kubeobj.Name), err)
-
-	case "service":
-		err := kubeClient.CoreV1().Services(kubeobj.Namespace).Delete(kubeobj.Name, nil)
-		logErr(fmt.Sprintf("cleaning up service %v ", kubeobj.Name), err)
-
-	case "deployment":
-		depl, err := kubeClient.ExtensionsV1beta1().Deployments(kubeobj.Namespace).Get(kubeobj.Name, meta_v1.GetOptions{})
-		err = kubeClient.ExtensionsV1beta1().Deployments(kubeobj.Namespace).Delete(kubeobj.Name, nil)
-		logErr(fmt.Sprintf("cleaning up deployment %v ", kubeobj.Name), err)
-		cleanupDeploymentObjects(kubeClient, kubeobj.Namespace, depl.Labels)
-
-	case "horizontalpodautoscaler":
-		err := kubeClient.AutoscalingV1().HorizontalPodAutoscalers(kubeobj.Namespace).Delete(kubeobj.Name, nil)
-		logErr(fmt.Sprintf("cleaning up horizontalpodautoscaler %v ", kubeobj.Name), err)
-
-	default:
-		log.Printf("There was an error identifying the object type: %v for obj: %v", kubeobj.Kind, kubeobj)
-
-	}
-}
-
-func cleanupDeploymentObjects(kubeClient *kubernetes.Clientset, namespace string, sel map[string]string) {
-	rsList, err := kubeClient.ExtensionsV1beta1().ReplicaSets(namespace).List(meta_v1.ListOptions{LabelSelector: labels.Set(sel).AsSelector().String()})
-	logErr("Getting replicaset for deployment ", err)
-	for _, rs := range rsList.Items {
-		err = kubeClient.ExtensionsV1beta1().ReplicaSets(namespace).Delete(rs.Name, nil)
-		logErr(fmt.Sprintf("Cleaning replicaset %v for deployment", rs.Name), err)
-	}
-
-	podList, err := kubeClient.CoreV1().Pods(namespace).List(meta_v1.ListOptions{LabelSelector: labels.Set(sel).AsSelector().String()})
-	logErr("Getting pods for deployment ", err)
-	for _, pod := range podList.Items {
-		err = kubeClient.CoreV1().Pods(namespace).Delete(pod.Name, nil)
-		logErr(fmt.Sprintf("Cleaning pod %v for deployment", pod.Name), err)
-	}
-}
-
-func cleanupDeployments(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanupDeployments(client kubernetes.Interface, namespace string, instanceId string) error {
 	deploymentList, err := client.ExtensionsV1beta1().Deployments(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return err
@@ -206,7 +99,7 @@ func cleanupDeployments(client *kubernetes.Clientset, namespace string, instance
 	return nil
 }
 
-func cleanupReplicaSets(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanupReplicaSets(client kubernetes.Interface, namespace string, instanceId string) error {
 	rsList, err := client.ExtensionsV1beta1().ReplicaSets(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return err
@@ -222,7 +115,7 @@ func cleanupReplicaSets(client *kubernetes.Clientset, namespace string, instance
 	return nil
 }
 
-func cleanupPods(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanupPods(client kubernetes.Interface, namespace string, instanceId string) error {
 	podList, err := client.CoreV1().Pods(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return err
@@ -239,7 +132,7 @@ func cleanupPods(client *kubernetes.Clientset, namespace string, instanceId stri
 	return nil
 }
 
-func cleanupServices(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanupServices(client kubernetes.Interface, namespace string, instanceId string) error {
 	svcList, err := client.CoreV1().Services(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return err
@@ -256,7 +149,7 @@ func cleanupServices(client *kubernetes.Clientset, namespace string, instanceId
 	return nil
 }
 
-func cleanupHpa(client *kubernetes.Clientset, namespace string, instanceId string) error {
+func cleanupHpa(client kubernetes.Interface, namespace string, instanceId string) error {
 	hpaList, err := client.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return err
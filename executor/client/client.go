@@ -18,6 +18,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
@@ -31,23 +32,54 @@ import (
 	"github.com/fission/fission"
 )
 
-type Client struct {
-	executorUrl string
-	tappedByUrl map[string]bool
-	requestChan chan string
-}
+// how often invocation counts are sent to the executor
+const invocationReportInterval = 30 * time.Second
+
+type (
+	Client struct {
+		executorUrl string
+		tappedByUrl map[string]bool
+		requestChan chan string
+
+		// invocations since the last report, by namespace/name
+		invocations    map[string]*Invocations
+		invocationChan chan Invocations
+	}
+
+	// LoadReport tells the executor how busy a function has been
+	// at a router since its last report.
+	LoadReport struct {
+		Function metav1.ObjectMeta `json:"function"`
+		Inflight int               `json:"inflight"` // peak number of requests in flight, counting those still in flight at the last report
+		Latency  metav1.Duration   `json:"latency"`  // average response time
+	}
+
+	// Invocations tells the executor how often a function was
+	// invoked at a router since its last report, for usage
+	// accounting.
+	Invocations struct {
+		Function metav1.ObjectMeta `json:"function"`
+		Count    int64             `json:"count"`
+	}
+)
 
 func MakeClient(executorUrl string) *Client {
 	c := &Client{
 		executorUrl: strings.TrimSuffix(executorUrl, "/"),
 		tappedByUrl: make(map[string]bool),
 		requestChan: make(chan string),
+
+		invocations:    make(map[string]*Invocations),
+		invocationChan: make(chan Invocations),
 	}
 	go c.service()
 	return c
 }
 
-func (c *Client) GetServiceForFunction(metadata *metav1.ObjectMeta) (string, error) {
+// GetServiceForFunction returns the address of a function's service,
+// waiting for the executor to specialize one if necessary.  The
+// executor stops waiting for the specialization when ctx is done.
+func (c *Client) GetServiceForFunction(ctx context.Context, metadata *metav1.ObjectMeta) (string, error) {
 	executorUrl := c.executorUrl + "/v2/getServiceForFunction"
 
 	body, err := json.Marshal(metadata)
@@ -55,7 +87,12 @@ func (c *Client) GetServiceForFunction(metadata *metav1.ObjectMeta) (string, err
 		return "", err
 	}
 
-	resp, err := http.Post(executorUrl, "application/json", bytes.NewReader(body))
+	req, err := http.NewRequest("POST", executorUrl, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
@@ -75,21 +112,47 @@ func (c *Client) GetServiceForFunction(metadata *metav1.ObjectMeta) (string, err
 
 func (c *Client) service() {
 	ticker := time.NewTicker(time.Second * 5)
+	invocationTicker := time.NewTicker(invocationReportInterval)
 	for {
 		select {
 		case serviceUrl := <-c.requestChan:
 			c.tappedByUrl[serviceUrl] = true
+		case i := <-c.invocationChan:
+			key := i.Function.Namespace + "/" + i.Function.Name
+			if counted, ok := c.invocations[key]; ok {
+				counted.Count += i.Count
+			} else {
+				c.invocations[key] = &i
+			}
+		case <-invocationTicker.C:
+			if len(c.invocations) == 0 {
+				continue
+			}
+			invocations := make([]Invocations, 0, len(c.invocations))
+			for _, i := range c.invocations {
+				invocations = append(invocations, *i)
+			}
+			c.invocations = make(map[string]*Invocations)
+			go func() {
+				err := c.ReportInvocations(invocations)
+				if err != nil {
+					// counted again, and sent with the next report
+					log.Printf("Error reporting invocations: %v", err)
+					for _, i := range invocations {
+						c.invocationChan <- i
+					}
+				}
+			}()
 		case <-ticker.C:
 			urls := c.tappedByUrl
 			c.tappedByUrl = make(map[string]bool)
 			if len(urls) > 0 {
 				go func() {
-					for u := range c.tappedByUrl {
+					for u := range urls {
 						c._tapService(u)
 					}
 					log.Printf("Tapped %v services in batch", len(urls))
 				}()
-				log.Printf("Tapped %v services in batch", len(urls))
 			}
 		}
 	}
@@ -99,6 +162,12 @@ func (c *Client) TapService(serviceUrl *url.URL) {
 	c.requestChan <- serviceUrl.String()
 }
 
+// CountInvocation counts a request for a function.  The counts are
+// sent to the executor in batches, for usage accounting.
+func (c *Client) CountInvocation(m *metav1.ObjectMeta) {
+	c.invocationChan <- Invocations{Function: *m, Count: 1}
+}
+
 func (c *Client) _tapService(serviceUrlStr string) error {
 	executorUrl := c.executorUrl + "/v2/tapService"
 
@@ -112,3 +181,54 @@ func (c *Client) _tapService(serviceUrlStr string) error {
 	}
 	return nil
 }
+
+// ReportLoad sends a function's load to the executor, which may scale
+// the function out.  It returns the addresses the function is
+// currently served at.
+func (c *Client) ReportLoad(report *LoadReport) ([]string, error) {
+	executorUrl := c.executorUrl + "/v2/reportLoad"
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(executorUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fission.MakeErrorFromHTTP(resp)
+	}
+
+	addresses := make([]string, 0)
+	err = json.NewDecoder(resp.Body).Decode(&addresses)
+	if err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// ReportInvocations sends the invocation counts of functions to the
+// executor.
+func (c *Client) ReportInvocations(invocations []Invocations) error {
+	executorUrl := c.executorUrl + "/v2/reportInvocations"
+
+	body, err := json.Marshal(invocations)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(executorUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fission.MakeErrorFromHTTP(resp)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// The executor keeps this many cold start timelines per function,
+// unless EXECUTOR_COLDSTART_HISTORY says otherwise.
+const defaultColdStartHistory = 20
+
+var (
+	coldStartSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "fission",
+			Subsystem: "executor",
+			Name:      "coldstart_seconds",
+			Help:      "Time taken by the steps of cold starts of functions.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+		},
+		[]string{"environment", "executor", "step"},
+	)
+	coldStartFetchBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "fission",
+			Subsystem: "executor",
+			Name:      "coldstart_fetch_bytes",
+			Help:      "Size of the packages fetched for cold starts of functions.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"environment", "executor"},
+	)
+	coldStartSpecializeRetries = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "fission",
+			Subsystem: "executor",
+			Name:      "coldstart_specialize_retries",
+			Help:      "Retries of the environment's specialize call in cold starts of functions.",
+			Buckets:   prometheus.LinearBuckets(0, 1, 10),
+		},
+		[]string{"environment", "executor"},
+	)
+	coldStartFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "fission",
+			Subsystem: "executor",
+			Name:      "coldstart_failures_total",
+			Help:      "Cold starts of functions that failed.",
+		},
+		[]string{"environment", "executor"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(coldStartSeconds, coldStartFetchBytes, coldStartSpecializeRetries, coldStartFailures)
+}
+
+// coldStartHistory keeps the latest cold start timelines of each
+// function.
+type coldStartHistory struct {
+	lock       sync.Mutex
+	size       int
+	coldStarts map[string][]fission.ColdStart // by namespace/function, oldest first
+}
+
+func makeColdStartHistory(size int) *coldStartHistory {
+	return &coldStartHistory{
+		size:       size,
+		coldStarts: make(map[string][]fission.ColdStart),
+	}
+}
+
+// getColdStartHistorySize returns the number of timelines to keep per
+// function, from EXECUTOR_COLDSTART_HISTORY.
+func getColdStartHistorySize() int {
+	value := os.Getenv("EXECUTOR_COLDSTART_HISTORY")
+	if len(value) == 0 {
+		return defaultColdStartHistory
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		log.Printf("Invalid EXECUTOR_COLDSTART_HISTORY '%v', using %v", value, defaultColdStartHistory)
+		return defaultColdStartHistory
+	}
+	return size
+}
+
+func coldStartKey(namespace string, name string) string {
+	return fmt.Sprintf("%v/%v", namespace, name)
+}
+
+// record adds a timeline to the function's history and to the metrics.
+func (h *coldStartHistory) record(coldStart fission.ColdStart) {
+	observeColdStart(&coldStart)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := coldStartKey(coldStart.Namespace, coldStart.Function)
+	history := append(h.coldStarts[key], coldStart)
+	if len(history) > h.size {
+		// copy, so that lists handed out earlier don't change
+		history = append([]fission.ColdStart{}, history[len(history)-h.size:]...)
+	}
+	h.coldStarts[key] = history
+}
+
+// list returns the function's latest timelines, oldest first.
+func (h *coldStartHistory) list(namespace string, name string) []fission.ColdStart {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	history := h.coldStarts[coldStartKey(namespace, name)]
+	return append(make([]fission.ColdStart, 0, len(history)), history...)
+}
+
+// forget drops the history of a deleted function.
+func (h *coldStartHistory) forget(namespace string, name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.coldStarts, coldStartKey(namespace, name))
+}
+
+func observeColdStart(coldStart *fission.ColdStart) {
+	executorType := string(coldStart.Executor)
+	if len(coldStart.Error) > 0 {
+		coldStartFailures.WithLabelValues(coldStart.Environment, executorType).Inc()
+		return
+	}
+	steps := map[string]metav1.Duration{
+		"choose_pod":      coldStart.ChoosePod,
+		"relabel":         coldStart.Relabel,
+		"fetch":           coldStart.Fetch,
+		"fetch_download":  coldStart.FetchDownload,
+		"fetch_unarchive": coldStart.FetchUnarchive,
+		"specialize":      coldStart.Specialize,
+		"total":           coldStart.Total,
+	}
+	for step, d := range steps {
+		// steps the backend doesn't have
+		if d.Duration == 0 && step != "total" {
+			continue
+		}
+		coldStartSeconds.WithLabelValues(coldStart.Environment, executorType, step).Observe(d.Duration.Seconds())
+	}
+	if coldStart.FetchBytes > 0 {
+		coldStartFetchBytes.WithLabelValues(coldStart.Environment, executorType).Observe(float64(coldStart.FetchBytes))
+	}
+	if coldStart.Specialize.Duration > 0 {
+		coldStartSpecializeRetries.WithLabelValues(coldStart.Environment, executorType).
+			Observe(float64(coldStart.SpecializeRetries))
+	}
+}
+
+// recordColdStart records the timeline of a new instance of fn.
+// Backends that don't keep timelines only get the total time.
+func (executor *Executor) recordColdStart(fn *metav1.ObjectMeta, envName string, executorType fission.ExecutorType,
+	startTime time.Time, fsvc *fscache.FuncSvc, err error) {
+
+	coldStart := fission.ColdStart{}
+	if fsvc != nil && fsvc.ColdStart != nil {
+		coldStart = *fsvc.ColdStart
+	}
+	coldStart.Function = fn.Name
+	coldStart.Namespace = fn.Namespace
+	coldStart.Environment = envName
+	coldStart.Executor = executorType
+	coldStart.StartTime = startTime
+	coldStart.Total = metav1.Duration{Duration: time.Since(startTime)}
+	if err != nil {
+		coldStart.Error = err.Error()
+	}
+	executor.coldStarts.record(coldStart)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func TestColdStartHistory(t *testing.T) {
+	h := makeColdStartHistory(3)
+	for i := 0; i < 5; i++ {
+		h.record(fission.ColdStart{Function: "hello", Namespace: "default", SpecializeRetries: i})
+	}
+	h.record(fission.ColdStart{Function: "world", Namespace: "default"})
+
+	// only the latest are kept, oldest first
+	coldStarts := h.list("default", "hello")
+	if len(coldStarts) != 3 {
+		t.Fatalf("expected 3 cold starts, got %v", len(coldStarts))
+	}
+	for i, coldStart := range coldStarts {
+		if coldStart.SpecializeRetries != i+2 {
+			t.Fatalf("unexpected cold start %v: %+v", i, coldStart)
+		}
+	}
+
+	h.forget("default", "hello")
+	if coldStarts := h.list("default", "hello"); len(coldStarts) != 0 {
+		t.Fatalf("expected deleted function's cold starts to be forgotten, got %v", len(coldStarts))
+	}
+	if coldStarts := h.list("default", "world"); len(coldStarts) != 1 {
+		t.Fatalf("expected other functions' cold starts to be kept, got %v", len(coldStarts))
+	}
+}
+
+func TestColdStartApi(t *testing.T) {
+	executor, _ := makeTestExecutor()
+	server := httptest.NewServer(executor.getHandler())
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		code, _ := doAdminRequest(t, server, "POST", "/v2/functionServices/default/hello/specialize")
+		if code != http.StatusOK {
+			t.Fatalf("error specializing function: %v", code)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/v2/functionServices/default/hello/coldstarts")
+	if err != nil {
+		t.Fatalf("error getting cold starts: %v", err)
+	}
+	defer resp.Body.Close()
+	coldStarts := make([]fission.ColdStart, 0)
+	err = json.NewDecoder(resp.Body).Decode(&coldStarts)
+	if err != nil {
+		t.Fatalf("error decoding cold starts: %v", err)
+	}
+	if len(coldStarts) != 2 {
+		t.Fatalf("expected 2 cold starts, got %v", len(coldStarts))
+	}
+	// the backend's timeline, completed by the executor
+	coldStart := coldStarts[1]
+	if coldStart.Pod != "hello-2" || coldStart.Function != "hello" || coldStart.Environment != "nodejs" ||
+		coldStart.Executor != fission.ExecutorTypePoolmgr || coldStart.StartTime.IsZero() || coldStart.Total.Duration <= 0 {
+		t.Fatalf("unexpected cold start %+v", coldStart)
+	}
+
+	// the metrics include cold starts
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("error getting metrics: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("error getting metrics: %v", resp.Status)
+	}
+}
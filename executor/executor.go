@@ -17,108 +17,180 @@ limitations under the License.
 package executor
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"strings"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/dchest/uniuri"
+	"github.com/gorilla/handlers"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	k8sCache "k8s.io/client-go/tools/cache"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/cache"
 	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	_ "github.com/fission/fission/executor/backends"
 	"github.com/fission/fission/executor/fscache"
-	"github.com/fission/fission/executor/newdeploy"
-	"github.com/fission/fission/executor/poolmgr"
 )
 
-type (
-	Executor struct {
-		gpm           *poolmgr.GenericPoolManager
-		ndm           *newdeploy.NewDeploy
-		functionEnv   *cache.Cache
-		fissionClient *crd.FissionClient
-		fsCache       *fscache.FunctionServiceCache
+// Backends release the resources of idle functions this often
+const reapInterval = 30 * time.Second
+
+type Executor struct {
+	backends       map[fission.ExecutorType]backend.ExecutorBackend
+	functionEnv    *cache.Cache
+	functions      *cache.Cache
+	store          backend.FunctionStore
+	fsCache        *fscache.FunctionServiceCache
+	funcController k8sCache.Controller
+
+	// specializations of uncached functions in progress
+	specializations *specializationGroup
+	keepWarmState   *keepWarmState
+	coldStarts      *coldStartHistory
+	usage           *usageStore
+
+	// closed when the executor is no longer the leader
+	stopCh chan struct{}
+}
+
+func MakeExecutor(backends map[fission.ExecutorType]backend.ExecutorBackend, store backend.FunctionStore,
+	crdClient *rest.RESTClient, fsCache *fscache.FunctionServiceCache) *Executor {
 
-		requestChan chan *createFuncServiceRequest
-		fsCreateWg  map[string]*sync.WaitGroup
+	executor := &Executor{
+		backends:    backends,
+		functionEnv: cache.MakeCache(10*time.Second, 0),
+		functions:   cache.MakeCache(10*time.Second, 0),
+		store:       store,
+		fsCache:     fsCache,
+
+		specializations: makeSpecializationGroup(getSpecializationTimeout()),
+		keepWarmState:   makeKeepWarmState(),
+		coldStarts:      makeColdStartHistory(getColdStartHistorySize()),
+		usage:           makeUsageStore(os.Getenv("EXECUTOR_USAGE_STORE"), getUsageRetention()),
+		stopCh:          make(chan struct{}),
 	}
-	createFuncServiceRequest struct {
-		funcMeta *metav1.ObjectMeta
-		respChan chan *createFuncServiceResponse
+	if crdClient != nil {
+		executor.funcController = executor.initFuncController(crdClient)
 	}
+	return executor
+}
 
-	createFuncServiceResponse struct {
-		funcSvc *fscache.FuncSvc
-		err     error
+// initFuncController watches functions, and tells their backends
+// about changes.
+func (executor *Executor) initFuncController(crdClient *rest.RESTClient) k8sCache.Controller {
+	resyncPeriod := 30 * time.Second
+	listWatch := k8sCache.NewListWatchFromClient(crdClient, "functions", metav1.NamespaceDefault, fields.Everything())
+	_, controller := k8sCache.NewInformer(listWatch, &crd.Function{}, resyncPeriod, k8sCache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			fn := obj.(*crd.Function)
+			executor.functionUpdated(nil, fn)
+		},
+		DeleteFunc: func(obj interface{}) {
+			fn := obj.(*crd.Function)
+			executor.functionUpdated(fn, nil)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			oldFn := oldObj.(*crd.Function)
+			newFn := newObj.(*crd.Function)
+			// resyncs call us with unchanged functions
+			if oldFn.Metadata.ResourceVersion == newFn.Metadata.ResourceVersion {
+				return
+			}
+			executor.functionUpdated(oldFn, newFn)
+		},
+	})
+	return controller
+}
+
+// functionUpdated passes a function change on to the function's
+// backend, and to its previous backend if the executor type changed.
+// It also keeps track of keep-warm schedules, forgets the cold starts
+// of deleted functions, and replaces the instances of updated
+// functions with warm ones.
+func (executor *Executor) functionUpdated(oldFn *crd.Function, newFn *crd.Function) {
+	executor.keepWarmState.functionUpdated(oldFn, newFn)
+	if newFn == nil && oldFn != nil {
+		executor.coldStarts.forget(oldFn.Metadata.Namespace, oldFn.Metadata.Name)
 	}
-)
+	if oldFn != nil && newFn != nil {
+		// backends stop sending requests to the old version
+		defer executor.warmUpdatedFunction(oldFn, newFn)
+	}
+	if newFn != nil {
+		executor.notifyBackend(backend.TypeOf(newFn), oldFn, newFn)
+	}
+	if oldFn != nil && (newFn == nil || backend.TypeOf(oldFn) != backend.TypeOf(newFn)) {
+		executor.notifyBackend(backend.TypeOf(oldFn), oldFn, newFn)
+	}
+}
 
-func MakeExecutor(gpm *poolmgr.GenericPoolManager, ndm *newdeploy.NewDeploy, fissionClient *crd.FissionClient, fsCache *fscache.FunctionServiceCache) *Executor {
-	executor := &Executor{
-		gpm:           gpm,
-		ndm:           ndm,
-		functionEnv:   cache.MakeCache(10*time.Second, 0),
-		fissionClient: fissionClient,
-		fsCache:       fsCache,
+func (executor *Executor) notifyBackend(executorType fission.ExecutorType, oldFn *crd.Function, newFn *crd.Function) {
+	b, ok := executor.backends[executorType]
+	if !ok {
+		log.Printf("No backend for executor type %v", executorType)
+		return
+	}
+	b.OnFunctionUpdate(oldFn, newFn)
+}
 
-		requestChan: make(chan *createFuncServiceRequest),
-		fsCreateWg:  make(map[string]*sync.WaitGroup),
+// getBackend returns the backend that runs fn.
+func (executor *Executor) getBackend(fn *crd.Function) (backend.ExecutorBackend, error) {
+	executorType := backend.TypeOf(fn)
+	b, ok := executor.backends[executorType]
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Unknown executor type '%v' for function %v", executorType, fn.Metadata.Name))
 	}
-	go executor.serveCreateFuncServices()
-	return executor
+	return b, nil
 }
 
-// All non-cached function service requests go through this goroutine
-// serially. It parallelizes requests for different functions, and
-// ensures that for a given function, only one request causes a pod to
-// get specialized. In other words, it ensures that when there's an
-// ongoing request for a certain function, all other requests wait for
-// that request to complete.
-func (executor *Executor) serveCreateFuncServices() {
+// reaper periodically lets all backends release the resources of idle
+// functions, after applying the functions' keep-warm settings.
+func (executor *Executor) reaper() {
 	for {
-		req := <-executor.requestChan
-		m := req.funcMeta
-
-		// Cache miss -- is this first one to request the func?
-		wg, found := executor.fsCreateWg[crd.CacheKey(m)]
-		if !found {
-			// create a waitgroup for other requests for
-			// the same function to wait on
-			wg := &sync.WaitGroup{}
-			wg.Add(1)
-			executor.fsCreateWg[crd.CacheKey(m)] = wg
-
-			// launch a goroutine for each request, to parallelize
-			// the specialization of different functions
-			go func() {
-				fsvc, err := executor.createServiceForFunction(m)
-				req.respChan <- &createFuncServiceResponse{
-					funcSvc: fsvc,
-					err:     err,
-				}
-				delete(executor.fsCreateWg, crd.CacheKey(m))
-				wg.Done()
-			}()
-		} else {
-			// There's an existing request for this function, wait for it to finish
-			go func() {
-				log.Printf("Waiting for concurrent request for the same function: %v", m)
-				wg.Wait()
-
-				// get the function service from the cache
-				fsvc, err := executor.fsCache.GetByFunction(m)
-				req.respChan <- &createFuncServiceResponse{
-					funcSvc: fsvc,
-					err:     err,
-				}
-			}()
+		select {
+		case <-executor.stopCh:
+			return
+		case <-time.After(reapInterval):
+		}
+		executor.keepWarm(time.Now())
+		for _, b := range executor.backends {
+			b.Reap()
+		}
+	}
+}
+
+// stop stops the executor's background work and its backends', once
+// it's no longer the leader, so that it leaves the function pods to
+// the next leader.
+func (executor *Executor) stop() {
+	close(executor.stopCh)
+	// the next leader picks the usage up from the store
+	err := executor.usage.save(time.Now())
+	if err != nil {
+		log.Printf("Error saving usage: %v", err)
+	}
+	stopped := make(map[backend.ExecutorBackend]bool)
+	for _, b := range executor.backends {
+		// the local executor runs all executor types on one backend
+		if !stopped[b] {
+			b.Stop()
+			stopped[b] = true
 		}
 	}
 }
 
-func (executor *Executor) createServiceForFunction(meta *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+func (executor *Executor) createServiceForFunction(ctx context.Context, meta *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
 	log.Printf("[%v] No cached function service found, creating one", meta.Name)
 
 	// from Func -> get Env
@@ -128,28 +200,61 @@ func (executor *Executor) createServiceForFunction(meta *metav1.ObjectMeta) (*fs
 		return nil, err
 	}
 
-	fn, err := executor.fissionClient.
-		Functions(meta.Namespace).
-		Get(meta.Name)
+	fn, err := executor.store.GetFunction(meta.Namespace, meta.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	switch fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType {
-	case fission.ExecutorTypeNewdeploy:
-		fs, err := executor.ndm.GetFuncSvc(meta)
-		return fs, err
-	default:
-		pool, err := executor.gpm.GetPool(env)
-		if err != nil {
-			return nil, err
-		}
-		// from GenericPool -> get one function container
-		// (this also adds to the cache)
-		log.Printf("[%v] getting function service from pool", meta.Name)
-		fsvc, err := pool.GetFuncSvc(meta)
-		return fsvc, err
+	b, err := executor.getBackend(fn)
+	if err != nil {
+		return nil, err
 	}
+	startTime := time.Now()
+	fsvc, err := b.GetFuncSvc(ctx, fn, env)
+	executor.recordColdStart(meta, env.Metadata.Name, backend.TypeOf(fn), startTime, fsvc, err)
+	return fsvc, err
+}
+
+// scaleOutFunction starts another instance of a function, if its
+// backend supports that
+func (executor *Executor) scaleOutFunction(ctx context.Context, meta *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	log.Printf("[%v] Scaling out function", meta.Name)
+	env, err := executor.getFunctionEnv(meta)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := executor.getFunction(meta)
+	if err != nil {
+		return nil, err
+	}
+	b, err := executor.getBackend(fn)
+	if err != nil {
+		return nil, err
+	}
+	scaleOuter, ok := b.(backend.ScaleOuter)
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("Executor type '%v' can't scale out functions", backend.TypeOf(fn)))
+	}
+	startTime := time.Now()
+	fsvc, err := scaleOuter.ScaleOut(ctx, fn, env)
+	executor.recordColdStart(meta, env.Metadata.Name, backend.TypeOf(fn), startTime, fsvc, err)
+	return fsvc, err
+}
+
+// getFunction returns a function, caching it for a few seconds
+func (executor *Executor) getFunction(m *metav1.ObjectMeta) (*crd.Function, error) {
+	result, err := executor.functions.Get(crd.CacheKey(m))
+	if err == nil {
+		return result.(*crd.Function), nil
+	}
+
+	fn, err := executor.store.GetFunction(m.Namespace, m.Name)
+	if err != nil {
+		return nil, err
+	}
+	executor.functions.Set(crd.CacheKey(m), fn)
+	return fn, nil
 }
 
 func (executor *Executor) getFunctionEnv(m *metav1.ObjectMeta) (*crd.Environment, error) {
@@ -163,14 +268,14 @@ func (executor *Executor) getFunctionEnv(m *metav1.ObjectMeta) (*crd.Environment
 	}
 
 	// Cache miss -- get func from controller
-	f, err := executor.fissionClient.Functions(m.Namespace).Get(m.Name)
+	f, err := executor.store.GetFunction(m.Namespace, m.Name)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get env from metadata
 	log.Printf("[%v] getting env", m)
-	env, err = executor.fissionClient.Environments(f.Spec.Environment.Namespace).Get(f.Spec.Environment.Name)
+	env, err = executor.store.GetEnvironment(f.Spec.Environment.Namespace, f.Spec.Environment.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -181,32 +286,131 @@ func (executor *Executor) getFunctionEnv(m *metav1.ObjectMeta) (*crd.Environment
 	return env, nil
 }
 
-// StartExecutor Starts executor and the executor components such as Poolmgr,
-// deploymgr and potential future executor types
+// StartExecutor starts an executor replica.  Replicas elect a leader,
+// which runs all registered executor backends; the others proxy the
+// API to it until they take over.
 func StartExecutor(fissionNamespace string, functionNamespace string, port int) error {
 	fissionClient, kubernetesClient, _, err := crd.MakeFissionClient()
-	restClient := fissionClient.GetCrdClient()
 	if err != nil {
 		log.Printf("Failed to get kubernetes client: %v", err)
 		return err
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	name := os.Getenv("POD_NAME")
+	if len(name) == 0 {
+		name = hostname
+	}
+	identity := leaderIdentity(name, leaderAddress(os.Getenv("POD_IP"), hostname, port))
+
+	var lock sync.Mutex
+	var leader *Executor
+	stopLeader := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if leader != nil {
+			leader.stop()
+			leader = nil
+		}
+	}
+	handler := &leaderHandler{}
+
+	elector, err := makeLeaderElector(makeLeaderLock(kubernetesClient, fissionNamespace, identity),
+		defaultLeaseDuration, defaultRenewDeadline, defaultRetryPeriod,
+		func(<-chan struct{}) {
+			log.Printf("Executor %v is the leader", identity)
+			api, err := startLeader(fissionClient, kubernetesClient, fissionNamespace, functionNamespace)
+			if err != nil {
+				log.Fatalf("Failed to start executor: %v", err)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			leader = api
+			handler.setHandler(api.getHandler())
+		}, func() {
+			// Stop acting on function pods before another
+			// replica takes them over.
+			handler.setHandler(nil)
+			stopLeader()
+			log.Fatalf("Executor %v lost its leader lease, exiting", identity)
+		})
+	if err != nil {
+		return err
+	}
+	handler.elector = elector
+	go elector.run()
+
+	// Give up the lease on shutdown, so that another replica takes
+	// over right away.
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		<-sigs
+		stopLeader()
+		elector.release()
+		os.Exit(0)
+	}()
+
+	go func() {
+		log.Printf("starting executor replica %v at port %v", name, port)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", port), handlers.LoggingHandler(os.Stdout, handler)))
+	}()
+	return nil
+}
+
+// startLeader rebuilds the executor's state from the function pods of
+// the previous leader, and starts all registered executor backends.
+func startLeader(fissionClient *crd.FissionClient, kubernetesClient kubernetes.Interface,
+	fissionNamespace string, functionNamespace string) (*Executor, error) {
+
+	restClient := fissionClient.GetCrdClient()
 	fsCache := fscache.MakeFunctionServiceCache()
 
-	poolID := strings.ToLower(uniuri.NewLen(8))
+	fnList, err := fissionClient.Functions(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	envList, err := fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	poolID := rebuildState(kubernetesClient, fsCache, functionNamespace, fnList.Items, envList.Items)
 	cleanupObjects(kubernetesClient, functionNamespace, poolID)
-	go idleObjectReaper(kubernetesClient, fissionClient, fsCache, time.Minute*2)
-	gpm := poolmgr.MakeGenericPoolManager(
-		fissionClient, kubernetesClient, fissionNamespace,
-		functionNamespace, fsCache, poolID)
 
-	ndm := newdeploy.MakeNewDeploy(
-		fissionClient, kubernetesClient, restClient,
-		functionNamespace, fsCache, poolID)
-
-	api := MakeExecutor(gpm, ndm, fissionClient, fsCache)
+	config := &backend.Config{
+		Store:             backend.MakeCrdStore(fissionClient),
+		FissionClient:     fissionClient,
+		KubernetesClient:  kubernetesClient,
+		CrdClient:         restClient,
+		FissionNamespace:  fissionNamespace,
+		FunctionNamespace: functionNamespace,
+		FsCache:           fsCache,
+		InstanceID:        poolID,
+	}
+	backends := make(map[fission.ExecutorType]backend.ExecutorBackend)
+	for _, executorType := range backend.Types() {
+		b, err := backend.Make(executorType, config)
+		if err != nil {
+			log.Printf("Failed to start %v executor backend: %v", executorType, err)
+			return nil, err
+		}
+		backends[executorType] = b
 
-	go api.Serve(port)
+		// remove what an earlier executor left behind for deleted
+		// functions and environments
+		err = b.Cleanup()
+		if err != nil {
+			log.Printf("Failed to clean up after %v executor backend: %v", executorType, err)
+		}
+	}
 
-	return nil
+	api := MakeExecutor(backends, config.Store, restClient, fsCache)
+	go api.reaper()
+	go api.healthCheck(getHealthCheckInterval())
+	go api.usageMeter()
+	go api.funcController.Run(api.stopCh)
+	return api, nil
 }
@@ -29,17 +29,19 @@ import (
 )
 
 type fscRequestType int
-type executorType int
 
 const (
 	TOUCH fscRequestType = iota
 	LISTOLD
 	LOG
-)
-
-const (
-	POOLMGR executorType = iota
-	NEWDEPLOY
+	ADD_INSTANCE
+	DELETE_OLD
+	LIST
+	SET_IDLE_TTL
+	DRAIN
+	LIST_DRAINED
+	GET_BY_ADDRESS
+	REPORT_LOAD
 )
 
 type (
@@ -49,24 +51,60 @@ type (
 		Environment       *crd.Environment      // function's environment
 		Address           string                // Host:Port or IP:Port that the function's service can be reached at.
 		KubernetesObjects []api.ObjectReference // Kubernetes Objects (within the function namespace)
-		Executor          executorType
+		Executor          fission.ExecutorType  // backend that runs the function
+		ColdStart         *fission.ColdStart    // how the instance was specialized, if the backend keeps track
+
+		// Addresses of all the function's instances, starting with
+		// Address. Only set on copies returned by GetByFunction.
+		Addresses []string
+
+		// When the instance stopped getting new requests because its
+		// function was updated; zero unless it's draining.
+		Draining time.Time
 
 		Ctime time.Time
 		Atime time.Time
 	}
 
+	// A function usually has one FuncSvc.  When it's scaled out, the
+	// additional instances are kept in byFunctionExtras; each has its
+	// own address and atime, and is reaped on its own.  The extras
+	// slices are replaced, never modified, and only by the service
+	// goroutine.
 	FunctionServiceCache struct {
-		byFunction *cache.Cache // function-key -> funcSvc  : map[string]*funcSvc
-		byAddress  *cache.Cache // address      -> function : map[string]metav1.ObjectMeta
+		byFunction       *cache.Cache // function-key -> funcSvc  : map[string]*funcSvc
+		byFunctionExtras *cache.Cache // function-key -> extra funcSvcs : map[string][]*funcSvc
+		byAddress        *cache.Cache // address      -> function : map[string]metav1.ObjectMeta
+
+		// function-key -> idle time that replaces the one reapers ask
+		// for.  Only used by the service goroutine.
+		idleTTL map[string]time.Duration
+
+		// address -> instance of an old version of a function, which
+		// serves the requests it already got until it's released.
+		// Draining instances keep their byAddress entries, so that
+		// they can still be tapped.  Only used by the service
+		// goroutine.
+		draining map[string]*FuncSvc
+
+		// function-key -> router -> requests in flight, from the
+		// routers' latest load reports.  Kept while the function has
+		// cached or draining instances.  Only used by the service
+		// goroutine.
+		inflight map[string]map[string]int
 
 		requestChannel chan *fscRequest
 	}
 	fscRequest struct {
 		requestType       fscRequestType
+		fsvc              *FuncSvc
 		address           string
 		kubernetesObjects []api.ObjectReference
 		age               time.Duration
 		env               *metav1.ObjectMeta // used for ListOld
+		function          *metav1.ObjectMeta // used for SetIdleTTL, Drain and ReportLoad
+		gracePeriod       time.Duration      // used for ListDrained
+		inflight          int                // used for ReportLoad
 		responseChannel   chan *fscResponse
 	}
 	fscResponse struct {
@@ -78,9 +116,13 @@ type (
 
 func MakeFunctionServiceCache() *FunctionServiceCache {
 	fsc := &FunctionServiceCache{
-		byFunction:     cache.MakeCache(0, 0),
-		byAddress:      cache.MakeCache(0, 0),
-		requestChannel: make(chan *fscRequest),
+		byFunction:       cache.MakeCache(0, 0),
+		byFunctionExtras: cache.MakeCache(0, 0),
+		byAddress:        cache.MakeCache(0, 0),
+		idleTTL:          make(map[string]time.Duration),
+		draining:         make(map[string]*FuncSvc),
+		inflight:         make(map[string]map[string]int),
+		requestChannel:   make(chan *fscRequest),
 	}
 	go fsc.service()
 	return fsc
@@ -95,15 +137,24 @@ func (fsc *FunctionServiceCache) service() {
 			// update atime for this function svc
 			resp.error = fsc._touchByAddress(req.address)
 		case LISTOLD:
-			// get svcs idle for > req.age
+			// get svcs idle for > req.age, including extra
+			// instances of scaled out functions
 			fscs := fsc.byFunction.Copy()
 			funcObjects := make([]*FuncSvc, 0)
-			for _, funcSvc := range fscs {
+			for key, funcSvc := range fscs {
 				fsvc := funcSvc.(*FuncSvc)
-				if fsvc.Environment.Metadata.UID == req.env.UID &&
-					time.Since(fsvc.Atime) > req.age {
+				if fsvc.Environment.Metadata.UID != req.env.UID {
+					continue
+				}
+				age := fsc.getIdleTTL(key.(string), req.age)
+				if time.Since(fsvc.Atime) > age {
 					funcObjects = append(funcObjects, fsvc)
 				}
+				for _, extra := range fsc.getExtras(key.(string)) {
+					if time.Since(extra.Atime) > age {
+						funcObjects = append(funcObjects, extra)
+					}
+				}
 			}
 			resp.objects = funcObjects
 		case LOG:
@@ -114,7 +165,59 @@ func (fsc *FunctionServiceCache) service() {
 				for _, kubeObj := range fsvc.KubernetesObjects {
 					log.Printf("%v\t%v\t%v", key, kubeObj.Kind, kubeObj.Name)
 				}
+				for _, extra := range fsc.getExtras(key.(string)) {
+					for _, kubeObj := range extra.KubernetesObjects {
+						log.Printf("%v\t%v\t%v (extra)", key, kubeObj.Kind, kubeObj.Name)
+					}
+				}
 			}
+		case ADD_INSTANCE:
+			resp.error = fsc._addInstance(req.fsvc)
+		case DELETE_OLD:
+			resp.deleted, resp.error = fsc._deleteOld(req.fsvc, req.age)
+		case LIST:
+			funcObjects := make([]*FuncSvc, 0)
+			for key, fsvcI := range fsc.byFunction.Copy() {
+				fsvcCopy := *fsvcI.(*FuncSvc)
+				funcObjects = append(funcObjects, &fsvcCopy)
+				for _, extra := range fsc.getExtras(key.(string)) {
+					extraCopy := *extra
+					funcObjects = append(funcObjects, &extraCopy)
+				}
+			}
+			for _, fsvc := range fsc.draining {
+				fsvcCopy := *fsvc
+				funcObjects = append(funcObjects, &fsvcCopy)
+			}
+			resp.objects = funcObjects
+		case SET_IDLE_TTL:
+			key := crd.CacheKey(req.function)
+			if req.age > 0 {
+				fsc.idleTTL[key] = req.age
+			} else {
+				delete(fsc.idleTTL, key)
+			}
+		case GET_BY_ADDRESS:
+			var fsvc *FuncSvc
+			fsvc, resp.error = fsc._getByAddress(req.address)
+			resp.objects = []*FuncSvc{fsvc}
+		case DRAIN:
+			resp.objects = fsc._drain(req.function)
+		case LIST_DRAINED:
+			// get draining svcs draining for > req.age whose
+			// requests are done, or draining for > req.gracePeriod
+			funcObjects := make([]*FuncSvc, 0)
+			for _, fsvc := range fsc.draining {
+				draining := time.Since(fsvc.Draining)
+				if draining > req.gracePeriod ||
+					(draining > req.age && fsc.isIdle(crd.CacheKey(fsvc.Function))) {
+					fsvcCopy := *fsvc
+					funcObjects = append(funcObjects, &fsvcCopy)
+				}
+			}
+			resp.objects = funcObjects
+		case REPORT_LOAD:
+			fsc._reportLoad(req.function, req.address, req.inflight)
 		}
 		req.responseChannel <- resp
 	}
@@ -133,9 +236,56 @@ func (fsc *FunctionServiceCache) GetByFunction(m *metav1.ObjectMeta) (*FuncSvc,
 	fsvc.Atime = time.Now()
 
 	fsvcCopy := *fsvc
+	fsvcCopy.Addresses = []string{fsvc.Address}
+	for _, extra := range fsc.getExtras(key) {
+		fsvcCopy.Addresses = append(fsvcCopy.Addresses, extra.Address)
+	}
 	return &fsvcCopy, nil
 }
 
+// getExtras returns the additional instances of a function
+func (fsc *FunctionServiceCache) getExtras(key string) []*FuncSvc {
+	extrasI, err := fsc.byFunctionExtras.Get(key)
+	if err != nil {
+		return nil
+	}
+	return extrasI.([]*FuncSvc)
+}
+
+// AddInstance adds another instance of a function that's already
+// cached, when the function is scaled out.
+func (fsc *FunctionServiceCache) AddInstance(fsvc FuncSvc) error {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     ADD_INSTANCE,
+		fsvc:            &fsvc,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.error
+}
+
+func (fsc *FunctionServiceCache) _addInstance(fsvc *FuncSvc) error {
+	key := crd.CacheKey(fsvc.Function)
+	_, err := fsc.byFunction.Get(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fsvc.Ctime = now
+	fsvc.Atime = now
+	err, _ = fsc.byAddress.Set(fsvc.Address, *fsvc.Function)
+	if err != nil {
+		return err
+	}
+
+	extras := append(append([]*FuncSvc{}, fsc.getExtras(key)...), fsvc)
+	fsc.byFunctionExtras.Delete(key)
+	fsc.byFunctionExtras.Set(key, extras)
+	return nil
+}
+
 func (fsc *FunctionServiceCache) Add(fsvc FuncSvc) (*FuncSvc, error) {
 	err, existing := fsc.byFunction.Set(crd.CacheKey(fsvc.Function), &fsvc)
 	if err != nil {
@@ -181,12 +331,23 @@ func (fsc *FunctionServiceCache) TouchByAddress(address string) error {
 }
 
 func (fsc *FunctionServiceCache) _touchByAddress(address string) error {
+	if fsvc, ok := fsc.draining[address]; ok {
+		fsvc.Atime = time.Now()
+		return nil
+	}
 	mI, err := fsc.byAddress.Get(address)
 	if err != nil {
 		return err
 	}
 	m := mI.(metav1.ObjectMeta)
-	fsvcI, err := fsc.byFunction.Get(crd.CacheKey(&m))
+	key := crd.CacheKey(&m)
+	for _, extra := range fsc.getExtras(key) {
+		if extra.Address == address {
+			extra.Atime = time.Now()
+			return nil
+		}
+	}
+	fsvcI, err := fsc.byFunction.Get(key)
 	if err != nil {
 		return err
 	}
@@ -195,17 +356,262 @@ func (fsc *FunctionServiceCache) _touchByAddress(address string) error {
 	return nil
 }
 
+// GetByAddress returns a copy of the function service, or the extra
+// instance of a scaled out function, or the draining instance, at
+// address.  Unlike GetByFunction, it doesn't update atime.
+func (fsc *FunctionServiceCache) GetByAddress(address string) (*FuncSvc, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     GET_BY_ADDRESS,
+		address:         address,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	if resp.error != nil {
+		return nil, resp.error
+	}
+	return resp.objects[0], nil
+}
+
+func (fsc *FunctionServiceCache) _getByAddress(address string) (*FuncSvc, error) {
+	if fsvc, ok := fsc.draining[address]; ok {
+		fsvcCopy := *fsvc
+		return &fsvcCopy, nil
+	}
+	mI, err := fsc.byAddress.Get(address)
+	if err != nil {
+		return nil, err
+	}
+	m := mI.(metav1.ObjectMeta)
+	key := crd.CacheKey(&m)
+	for _, extra := range fsc.getExtras(key) {
+		if extra.Address == address {
+			fsvcCopy := *extra
+			return &fsvcCopy, nil
+		}
+	}
+	fsvcI, err := fsc.byFunction.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	fsvcCopy := *fsvcI.(*FuncSvc)
+	return &fsvcCopy, nil
+}
+
+// DeleteOld removes a function service, if it's been idle for at least
+// minAge.  When a scaled out function loses its first instance, one of
+// the others takes its place.
 func (fsc *FunctionServiceCache) DeleteOld(fsvc *FuncSvc, minAge time.Duration) (bool, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     DELETE_OLD,
+		fsvc:            fsvc,
+		age:             minAge,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.deleted, resp.error
+}
+
+func (fsc *FunctionServiceCache) _deleteOld(fsvc *FuncSvc, minAge time.Duration) (bool, error) {
+	if draining, ok := fsc.draining[fsvc.Address]; ok {
+		if time.Since(draining.Atime) < minAge {
+			return false, nil
+		}
+		delete(fsc.draining, fsvc.Address)
+		// unless a new instance took over the address
+		if mI, err := fsc.byAddress.Get(fsvc.Address); err == nil {
+			m := mI.(metav1.ObjectMeta)
+			if crd.CacheKey(&m) == crd.CacheKey(draining.Function) {
+				fsc.byAddress.Delete(fsvc.Address)
+			}
+		}
+		fsc.forgetLoad(crd.CacheKey(draining.Function))
+		return true, nil
+	}
+
+	key := crd.CacheKey(fsvc.Function)
+	if minAge > 0 {
+		minAge = fsc.getIdleTTL(key, minAge)
+	}
 	if time.Since(fsvc.Atime) < minAge {
 		return false, nil
 	}
 
-	fsc.byFunction.Delete(crd.CacheKey(fsvc.Function))
+	extras := fsc.getExtras(key)
+	remaining := make([]*FuncSvc, 0, len(extras))
+	for _, extra := range extras {
+		if extra.Address != fsvc.Address {
+			remaining = append(remaining, extra)
+		}
+	}
+
+	if len(remaining) == len(extras) {
+		// not an extra instance, so it should be the function's
+		// first one
+		fsvcI, err := fsc.byFunction.Get(key)
+		if err != nil || fsvcI.(*FuncSvc).Address != fsvc.Address {
+			return false, err
+		}
+		fsc.byFunction.Delete(key)
+		if len(remaining) > 0 {
+			fsc.byFunction.Set(key, remaining[0])
+			remaining = remaining[1:]
+		}
+	}
+	fsc.byFunctionExtras.Delete(key)
+	if len(remaining) > 0 {
+		fsc.byFunctionExtras.Set(key, remaining)
+	}
 	fsc.byAddress.Delete(fsvc.Address)
+	if _, err := fsc.byFunction.Get(key); err != nil {
+		delete(fsc.idleTTL, key)
+	}
+	fsc.forgetLoad(key)
 
 	return true, nil
 }
 
+// Drain stops a function's instances from getting new requests, e.g.
+// because the function was updated: they're uncached, so the next
+// request for the function gets a new instance, but they can still be
+// tapped until they're released with DeleteOld.  Returns copies of the
+// draining instances.
+func (fsc *FunctionServiceCache) Drain(m *metav1.ObjectMeta) ([]*FuncSvc, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     DRAIN,
+		function:        m,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.objects, resp.error
+}
+
+func (fsc *FunctionServiceCache) _drain(m *metav1.ObjectMeta) []*FuncSvc {
+	key := crd.CacheKey(m)
+	instances := fsc.getExtras(key)
+	if fsvcI, err := fsc.byFunction.Get(key); err == nil {
+		instances = append([]*FuncSvc{fsvcI.(*FuncSvc)}, instances...)
+	}
+	fsc.byFunction.Delete(key)
+	fsc.byFunctionExtras.Delete(key)
+	delete(fsc.idleTTL, key)
+
+	now := time.Now()
+	drained := make([]*FuncSvc, 0, len(instances))
+	for _, fsvc := range instances {
+		fsvc.Draining = now
+		fsc.draining[fsvc.Address] = fsvc
+		fsvcCopy := *fsvc
+		drained = append(drained, &fsvcCopy)
+	}
+	return drained
+}
+
+// ListDrained returns the draining instances that are done: those that
+// have been draining for longer than settleTime, and whose function has
+// no requests in flight at any router that reported its load; and
+// those that have been draining for longer than gracePeriod.
+// settleTime should leave the routers time to report the requests
+// that were in flight when the instances started draining.
+func (fsc *FunctionServiceCache) ListDrained(settleTime time.Duration, gracePeriod time.Duration) ([]*FuncSvc, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     LIST_DRAINED,
+		age:             settleTime,
+		gracePeriod:     gracePeriod,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.objects, resp.error
+}
+
+// ReportLoad records how many requests for a function are in flight
+// at a router, from the router's load report, for ListDrained.
+// Reports for functions without instances are ignored.
+func (fsc *FunctionServiceCache) ReportLoad(m *metav1.ObjectMeta, router string, inflight int) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     REPORT_LOAD,
+		function:        m,
+		address:         router,
+		inflight:        inflight,
+		responseChannel: responseChannel,
+	}
+	<-responseChannel
+}
+
+func (fsc *FunctionServiceCache) _reportLoad(m *metav1.ObjectMeta, router string, inflight int) {
+	key := crd.CacheKey(m)
+	if !fsc.hasInstances(key) {
+		return
+	}
+	routers, ok := fsc.inflight[key]
+	if !ok {
+		routers = make(map[string]int)
+		fsc.inflight[key] = routers
+	}
+	routers[router] = inflight
+}
+
+// hasInstances is true if a function has cached or draining
+// instances.  Called from service().
+func (fsc *FunctionServiceCache) hasInstances(key string) bool {
+	if _, err := fsc.byFunction.Get(key); err == nil {
+		return true
+	}
+	for _, fsvc := range fsc.draining {
+		if crd.CacheKey(fsvc.Function) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdle is true if no router reported requests in flight for a
+// function.  Called from service().
+func (fsc *FunctionServiceCache) isIdle(key string) bool {
+	for _, inflight := range fsc.inflight[key] {
+		if inflight > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetLoad drops the load reports of a function once it has no
+// instances left.  Called from service().
+func (fsc *FunctionServiceCache) forgetLoad(key string) {
+	if !fsc.hasInstances(key) {
+		delete(fsc.inflight, key)
+	}
+}
+
+// SetIdleTTL sets how long the instances of a function may be idle
+// before ListOld and DeleteOld consider them old, whatever age reapers
+// ask for.  Zero restores the reapers' idle time.  Evicting with a
+// minimum age of zero still works as usual.
+func (fsc *FunctionServiceCache) SetIdleTTL(m *metav1.ObjectMeta, ttl time.Duration) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     SET_IDLE_TTL,
+		function:        m,
+		age:             ttl,
+		responseChannel: responseChannel,
+	}
+	<-responseChannel
+}
+
+// getIdleTTL returns the idle time of a function's instances.  Called
+// from service().
+func (fsc *FunctionServiceCache) getIdleTTL(key string, defaultTTL time.Duration) time.Duration {
+	if ttl, ok := fsc.idleTTL[key]; ok {
+		return ttl
+	}
+	return defaultTTL
+}
+
 func (fsc *FunctionServiceCache) ListOld(env *metav1.ObjectMeta, age time.Duration) ([]*FuncSvc, error) {
 	responseChannel := make(chan *fscResponse)
 	fsc.requestChannel <- &fscRequest{
@@ -218,6 +624,18 @@ func (fsc *FunctionServiceCache) ListOld(env *metav1.ObjectMeta, age time.Durati
 	return resp.objects, resp.error
 }
 
+// List returns copies of all function services, including the extra
+// instances of scaled out functions, in no particular order.
+func (fsc *FunctionServiceCache) List() ([]*FuncSvc, error) {
+	responseChannel := make(chan *fscResponse)
+	fsc.requestChannel <- &fscRequest{
+		requestType:     LIST,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.objects, resp.error
+}
+
 func (fsc *FunctionServiceCache) Log() {
 	log.Printf("--- FunctionService Cache Contents")
 	responseChannel := make(chan *fscResponse)
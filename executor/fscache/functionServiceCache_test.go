@@ -99,3 +99,251 @@ func TestFunctionServiceCache(t *testing.T) {
 		log.Panicf("found fsvc while expecting empty cache: %v", err)
 	}
 }
+
+func TestFunctionServiceCacheInstances(t *testing.T) {
+	fsc := MakeFunctionServiceCache()
+	fn := &metav1.ObjectMeta{Name: "foo", UID: "1212"}
+	env := &crd.Environment{Metadata: metav1.ObjectMeta{Name: "foo-env", UID: "2323"}}
+	makeFsvc := func(address string) FuncSvc {
+		return FuncSvc{Function: fn, Environment: env, Address: address}
+	}
+
+	// instances can only be added to cached functions
+	err := fsc.AddInstance(makeFsvc("extra-0"))
+	if err == nil {
+		t.Fatalf("expected error adding instance of uncached function")
+	}
+
+	_, err = fsc.Add(makeFsvc("first"))
+	if err != nil {
+		t.Fatalf("error adding fsvc: %v", err)
+	}
+	for _, address := range []string{"extra-1", "extra-2"} {
+		err = fsc.AddInstance(makeFsvc(address))
+		if err != nil {
+			t.Fatalf("error adding instance: %v", err)
+		}
+	}
+
+	f, err := fsc.GetByFunction(fn)
+	if err != nil {
+		t.Fatalf("error getting fsvc: %v", err)
+	}
+	if f.Address != "first" || len(f.Addresses) != 3 || f.Addresses[2] != "extra-2" {
+		t.Fatalf("unexpected addresses %v, %v", f.Address, f.Addresses)
+	}
+	for _, address := range []string{"first", "extra-1"} {
+		f, err := fsc.GetByAddress(address)
+		if err != nil {
+			t.Fatalf("error getting fsvc by address: %v", err)
+		}
+		if f.Address != address || f.Function.Name != fn.Name {
+			t.Fatalf("unexpected fsvc for %v: %v", address, f)
+		}
+	}
+	_, err = fsc.GetByAddress("unknown")
+	if err == nil {
+		t.Fatalf("expected error getting fsvc of unknown address")
+	}
+	all, err := fsc.List()
+	if err != nil {
+		t.Fatalf("error listing fsvcs: %v", err)
+	}
+	listed := make(map[string]bool)
+	for _, fsvc := range all {
+		listed[fsvc.Address] = true
+	}
+	if len(all) != 3 || !listed["first"] || !listed["extra-1"] || !listed["extra-2"] {
+		t.Fatalf("expected all instances to be listed, got %v", listed)
+	}
+
+	// everything is idle, except the touched instances
+	time.Sleep(20 * time.Millisecond)
+	for _, address := range []string{"first", "extra-1"} {
+		err = fsc.TouchByAddress(address)
+		if err != nil {
+			t.Fatalf("error touching instance: %v", err)
+		}
+	}
+	old, err := fsc.ListOld(&env.Metadata, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("error listing old fsvcs: %v", err)
+	}
+	oldAddresses := make(map[string]bool)
+	for _, fsvc := range old {
+		oldAddresses[fsvc.Address] = true
+	}
+	if len(old) != 1 || !oldAddresses["extra-2"] {
+		t.Fatalf("expected only extra-2 to be idle, got %v", oldAddresses)
+	}
+
+	// an idle extra instance is reaped on its own
+	deleted, err := fsc.DeleteOld(old[0], 0)
+	if err != nil || !deleted {
+		t.Fatalf("failed to delete instance: %v", err)
+	}
+	f, err = fsc.GetByFunction(fn)
+	if err != nil {
+		t.Fatalf("error getting fsvc: %v", err)
+	}
+	if len(f.Addresses) != 2 || f.Addresses[1] != "extra-1" {
+		t.Fatalf("unexpected addresses after reaping extra instance: %v", f.Addresses)
+	}
+
+	// when the first instance goes, the next one takes its place
+	deleted, err = fsc.DeleteOld(f, 0)
+	if err != nil || !deleted {
+		t.Fatalf("failed to delete first instance: %v", err)
+	}
+	f, err = fsc.GetByFunction(fn)
+	if err != nil {
+		t.Fatalf("function with remaining instance not found: %v", err)
+	}
+	if f.Address != "extra-1" || len(f.Addresses) != 1 {
+		t.Fatalf("unexpected addresses after reaping first instance: %v, %v", f.Address, f.Addresses)
+	}
+	err = fsc.TouchByAddress("first")
+	if err == nil {
+		t.Fatalf("reaped instance can still be touched")
+	}
+}
+
+func TestFunctionServiceCacheIdleTTL(t *testing.T) {
+	fsc := MakeFunctionServiceCache()
+	env := &crd.Environment{Metadata: metav1.ObjectMeta{Name: "foo-env", UID: "2323"}}
+	short := &metav1.ObjectMeta{Name: "short", UID: "1"}
+	long := &metav1.ObjectMeta{Name: "long", UID: "2"}
+	for _, fn := range []*metav1.ObjectMeta{short, long} {
+		_, err := fsc.Add(FuncSvc{Function: fn, Environment: env, Address: fn.Name})
+		if err != nil {
+			t.Fatalf("error adding fsvc: %v", err)
+		}
+	}
+	fsc.SetIdleTTL(short, 10*time.Millisecond)
+	fsc.SetIdleTTL(long, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+
+	// the functions' idle TTLs replace the reaper's
+	old, err := fsc.ListOld(&env.Metadata, time.Minute)
+	if err != nil {
+		t.Fatalf("error listing old fsvcs: %v", err)
+	}
+	if len(old) != 1 || old[0].Function.Name != "short" {
+		t.Fatalf("expected only the function with a short TTL to be old, got %v", old)
+	}
+	f, err := fsc.GetByFunction(long)
+	if err != nil {
+		t.Fatalf("error getting fsvc: %v", err)
+	}
+	deleted, err := fsc.DeleteOld(f, time.Millisecond)
+	if err != nil || deleted {
+		t.Fatalf("expected function with a long TTL to be kept: %v", err)
+	}
+
+	// evicting ignores the TTL
+	deleted, err = fsc.DeleteOld(f, 0)
+	if err != nil || !deleted {
+		t.Fatalf("failed to evict function: %v", err)
+	}
+	fsc.SetIdleTTL(short, 0)
+	old, err = fsc.ListOld(&env.Metadata, time.Minute)
+	if err != nil || len(old) != 0 {
+		t.Fatalf("expected reaper's idle time to apply after clearing the TTL, got %v, %v", old, err)
+	}
+}
+
+func TestFunctionServiceCacheDrain(t *testing.T) {
+	fsc := MakeFunctionServiceCache()
+	env := &crd.Environment{Metadata: metav1.ObjectMeta{Name: "foo-env", UID: "2323"}}
+	oldFn := &metav1.ObjectMeta{Name: "foo", UID: "1212", ResourceVersion: "1"}
+	newFn := &metav1.ObjectMeta{Name: "foo", UID: "1212", ResourceVersion: "2"}
+
+	_, err := fsc.Add(FuncSvc{Function: oldFn, Environment: env, Address: "old"})
+	if err != nil {
+		t.Fatalf("error adding fsvc: %v", err)
+	}
+	err = fsc.AddInstance(FuncSvc{Function: oldFn, Environment: env, Address: "old-extra"})
+	if err != nil {
+		t.Fatalf("error adding instance: %v", err)
+	}
+
+	drained, err := fsc.Drain(oldFn)
+	if err != nil {
+		t.Fatalf("error draining function: %v", err)
+	}
+	if len(drained) != 2 || drained[0].Address != "old" || drained[0].Draining.IsZero() {
+		t.Fatalf("expected both instances to be draining, got %v", drained)
+	}
+
+	// the old version gets no new requests, but can still be tapped
+	_, err = fsc.GetByFunction(oldFn)
+	if err == nil {
+		t.Fatalf("expected draining function to be uncached")
+	}
+	err = fsc.TouchByAddress("old")
+	if err != nil {
+		t.Fatalf("error touching draining instance: %v", err)
+	}
+	f, err := fsc.GetByAddress("old")
+	if err != nil || f.Draining.IsZero() {
+		t.Fatalf("expected draining instance at address, got %v, %v", f, err)
+	}
+	_, err = fsc.Add(FuncSvc{Function: newFn, Environment: env, Address: "new"})
+	if err != nil {
+		t.Fatalf("error adding fsvc of new version: %v", err)
+	}
+	all, err := fsc.List()
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected draining instances to be listed, got %v, %v", all, err)
+	}
+	old, err := fsc.ListOld(&env.Metadata, time.Hour)
+	if err != nil || len(old) != 0 {
+		t.Fatalf("expected draining instances to be left to ListDrained, got %v, %v", old, err)
+	}
+
+	// the instances are done once every router reports the old
+	// version's requests done, and they had time to report
+	fsc.ReportLoad(oldFn, "router-1", 1)
+	fsc.ReportLoad(oldFn, "router-2", 0)
+	time.Sleep(20 * time.Millisecond)
+	done, err := fsc.ListDrained(10*time.Millisecond, time.Hour)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("expected instances with requests in flight to keep draining, got %v, %v", done, err)
+	}
+	fsc.ReportLoad(oldFn, "router-1", 0)
+	done, err = fsc.ListDrained(time.Hour, time.Hour)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("expected instances to wait for the routers' reports, got %v, %v", done, err)
+	}
+	done, err = fsc.ListDrained(10*time.Millisecond, time.Hour)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("expected every instance to be done, got %v, %v", done, err)
+	}
+	// or at the end of the grace period, whatever the routers say
+	fsc.ReportLoad(oldFn, "router-1", 1)
+	done, err = fsc.ListDrained(time.Hour, 10*time.Millisecond)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("expected every instance to be done after the grace period, got %v, %v", done, err)
+	}
+
+	for _, fsvc := range done {
+		deleted, err := fsc.DeleteOld(fsvc, 0)
+		if err != nil || !deleted {
+			t.Fatalf("failed to delete draining instance: %v", err)
+		}
+	}
+	_, err = fsc.GetByAddress("old")
+	if err == nil {
+		t.Fatalf("released instance can still be found")
+	}
+	f, err = fsc.GetByFunction(newFn)
+	if err != nil || f.Address != "new" {
+		t.Fatalf("expected new version to be unaffected, got %v, %v", f, err)
+	}
+	// the old version's load reports go with its last instance, and
+	// reports for functions without instances aren't kept
+	fsc.ReportLoad(oldFn, "router-1", 1)
+	if len(fsc.inflight) != 0 {
+		t.Fatalf("expected load reports to be dropped, got %v", fsc.inflight)
+	}
+}
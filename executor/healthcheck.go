@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/fission/fission"
+)
+
+// Function instances are probed at their environment's /healthz, and
+// evicted after failing this many probes in a row.  Crashed pods are
+// evicted by their backend as soon as Kubernetes notices; probes catch
+// the instances that are up but no longer serving, e.g. a hung runtime.
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckTimeout         = 5 * time.Second
+	healthCheckFailures        = 3
+)
+
+type healthChecker struct {
+	executor *Executor
+	client   *http.Client
+	failures map[string]int // consecutive failed probes, by address
+}
+
+func makeHealthChecker(executor *Executor, timeout time.Duration) *healthChecker {
+	return &healthChecker{
+		executor: executor,
+		client:   &http.Client{Timeout: timeout},
+		failures: make(map[string]int),
+	}
+}
+
+// getHealthCheckInterval returns how often function instances are
+// probed, from EXECUTOR_HEALTH_CHECK_INTERVAL.  Zero turns probing off.
+func getHealthCheckInterval() time.Duration {
+	value := os.Getenv("EXECUTOR_HEALTH_CHECK_INTERVAL")
+	if len(value) == 0 {
+		return defaultHealthCheckInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid EXECUTOR_HEALTH_CHECK_INTERVAL '%v', using %v", value, defaultHealthCheckInterval)
+		return defaultHealthCheckInterval
+	}
+	return interval
+}
+
+// healthCheck periodically probes all function instances.
+func (executor *Executor) healthCheck(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	hc := makeHealthChecker(executor, healthCheckTimeout)
+	for {
+		select {
+		case <-executor.stopCh:
+			return
+		case <-time.After(interval):
+		}
+		hc.checkAll()
+	}
+}
+
+// checkAll probes every function instance once, and evicts the ones
+// that have failed too many probes.
+func (hc *healthChecker) checkAll() {
+	fsvcs, err := hc.executor.fsCache.List()
+	if err != nil {
+		log.Printf("Error listing function services for health check: %v", err)
+		return
+	}
+
+	failures := make(map[string]int)
+	for _, fsvc := range fsvcs {
+		// Kubernetes restarts the pods of deployments itself, and
+		// they're reached through a service that skips unready pods.
+		if fsvc.Executor == fission.ExecutorTypeNewdeploy {
+			continue
+		}
+		err := hc.probe(fsvc.Address)
+		if err == nil {
+			continue
+		}
+		failures[fsvc.Address] = hc.failures[fsvc.Address] + 1
+		if failures[fsvc.Address] < healthCheckFailures {
+			continue
+		}
+
+		log.Printf("[%v] Function service %v at %v failed %v health checks, evicting it: %v",
+			fsvc.Function.Name, fsvc.Name, fsvc.Address, failures[fsvc.Address], err)
+		delete(failures, fsvc.Address)
+		b, ok := hc.executor.backends[fsvc.Executor]
+		if !ok {
+			log.Printf("No backend for executor type %v", fsvc.Executor)
+			continue
+		}
+		err = b.Evict(fsvc)
+		if err != nil {
+			log.Printf("Error evicting function service %v: %v", fsvc.Name, err)
+		}
+	}
+	// forget instances that are gone or have recovered
+	hc.failures = failures
+}
+
+// probe checks a function instance's health.  Environments without a
+// health check route answer 404, which only tells us the server is up.
+// The addresses of functions in multi-function pods have the function's
+// path; health is checked for the whole pod.
+func (hc *healthChecker) probe(address string) error {
+	u, err := url.Parse(fmt.Sprintf("http://%v", address))
+	if err != nil {
+		return err
+	}
+	resp, err := hc.client.Get(fmt.Sprintf("http://%v/healthz", u.Host))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("health check returned %v", resp.Status)
+	}
+	return nil
+}
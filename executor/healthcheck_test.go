@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func TestHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	noHealthz := httptest.NewServer(http.NotFoundHandler())
+	defer noHealthz.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unhealthy", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	executor, b := makeTestExecutor()
+	servers := map[string]*httptest.Server{
+		"healthy":   healthy,
+		"nohealthz": noHealthz,
+		"failing":   failing,
+	}
+	for name, server := range servers {
+		_, err := b.fsCache.Add(fscache.FuncSvc{
+			Name:     name,
+			Function: &metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, UID: types.UID("fn-" + name)},
+			Address:  strings.TrimPrefix(server.URL, "http://"),
+			Executor: fission.ExecutorTypePoolmgr,
+			Ctime:    time.Now(),
+			Atime:    time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("error caching function service: %v", err)
+		}
+	}
+
+	hc := makeHealthChecker(executor, time.Second)
+	for i := 1; i < healthCheckFailures; i++ {
+		hc.checkAll()
+	}
+	if len(b.evicted) != 0 {
+		t.Fatalf("expected no evictions before %v failed checks, got %v", healthCheckFailures, b.evicted)
+	}
+	hc.checkAll()
+	if len(b.evicted) != 1 || b.evicted[0] != "failing" {
+		t.Fatalf("expected only the failing instance to be evicted, got %v", b.evicted)
+	}
+	fsvcs, err := executor.fsCache.List()
+	if err != nil {
+		t.Fatalf("error listing function services: %v", err)
+	}
+	if len(fsvcs) != 2 {
+		t.Fatalf("expected 2 remaining function services, got %v", len(fsvcs))
+	}
+	if len(hc.failures) != 0 {
+		t.Fatalf("expected failures of evicted instance to be forgotten, got %v", hc.failures)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// Keep-warm settings are applied right before backends reap idle
+// instances: the function's idle TTL is handed to the function service
+// cache, which backends reap from, and instances that are kept warm are
+// touched so they don't look idle.  Once a keep-warm window ends, the
+// instances are released after their usual idle time.
+type keepWarmState struct {
+	lock sync.Mutex
+	// warm API holds, by function UID
+	holds map[types.UID]time.Time
+	// functions with a keep-warm schedule, by UID
+	scheduled map[types.UID]*crd.Function
+}
+
+func makeKeepWarmState() *keepWarmState {
+	return &keepWarmState{
+		holds:     make(map[types.UID]time.Time),
+		scheduled: make(map[types.UID]*crd.Function),
+	}
+}
+
+// hold keeps a function's instances from being released until the
+// given time.
+func (kw *keepWarmState) hold(uid types.UID, until time.Time) {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	if until.After(kw.holds[uid]) {
+		kw.holds[uid] = until
+	}
+}
+
+func (kw *keepWarmState) isHeld(uid types.UID, now time.Time) bool {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	until, ok := kw.holds[uid]
+	if ok && !now.Before(until) {
+		delete(kw.holds, uid)
+		return false
+	}
+	return ok
+}
+
+// functionUpdated keeps track of the functions with a keep-warm
+// schedule.  newFn is nil for deleted functions.
+func (kw *keepWarmState) functionUpdated(oldFn *crd.Function, newFn *crd.Function) {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	if oldFn != nil {
+		delete(kw.scheduled, oldFn.Metadata.UID)
+	}
+	if newFn == nil {
+		return
+	}
+	keepWarm := newFn.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm
+	if keepWarm != nil && len(keepWarm.Schedule) > 0 {
+		kw.scheduled[newFn.Metadata.UID] = newFn
+	}
+}
+
+// listScheduled returns the functions whose keep-warm window is open.
+func (kw *keepWarmState) listScheduled(now time.Time) []*crd.Function {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	fns := make([]*crd.Function, 0)
+	for _, fn := range kw.scheduled {
+		if inKeepWarmWindow(fn.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm, now) {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// inKeepWarmWindow is true if the keep-warm schedule fired within the
+// window duration before now.
+func inKeepWarmWindow(keepWarm *fission.KeepWarm, now time.Time) bool {
+	if keepWarm == nil || len(keepWarm.Schedule) == 0 || keepWarm.WindowDuration.Duration <= 0 {
+		return false
+	}
+	schedule, err := cron.Parse(keepWarm.Schedule)
+	if err != nil {
+		return false
+	}
+	// the first time the schedule fires in the window
+	fired := schedule.Next(now.Add(-keepWarm.WindowDuration.Duration))
+	return !fired.After(now)
+}
+
+// warmUpdatedFunction specializes the new version of an updated
+// function if the old version was running, so that requests don't wait
+// for a cold start while the old version's instances drain.
+func (executor *Executor) warmUpdatedFunction(oldFn *crd.Function, newFn *crd.Function) {
+	fsvcs, err := executor.fsCache.List()
+	if err != nil {
+		log.Printf("Error listing function services: %v", err)
+		return
+	}
+	running := false
+	for _, fsvc := range fsvcs {
+		if fsvc.Function.UID == oldFn.Metadata.UID && fsvc.Function.ResourceVersion == oldFn.Metadata.ResourceVersion {
+			running = true
+			break
+		}
+	}
+	if !running {
+		return
+	}
+
+	m := newFn.Metadata
+	log.Printf("[%v] Function updated, specializing version %v", m.Name, m.ResourceVersion)
+	executor.specializations.startIfIdle(crd.CacheKey(&m), func(ctx context.Context) (*fscache.FuncSvc, error) {
+		fsvc, err := executor.createServiceForFunction(ctx, &m)
+		if err != nil {
+			log.Printf("[%v] Error specializing updated function: %v", m.Name, err)
+		}
+		return fsvc, err
+	})
+}
+
+// keepWarm applies the keep-warm settings of running functions, and
+// specializes the functions whose keep-warm window is open but that
+// aren't running.
+func (executor *Executor) keepWarm(now time.Time) {
+	fsvcs, err := executor.fsCache.List()
+	if err != nil {
+		log.Printf("Error listing function services: %v", err)
+		return
+	}
+
+	running := make(map[types.UID]bool)
+	for _, fsvc := range fsvcs {
+		if !fsvc.Draining.IsZero() {
+			// old versions of functions aren't kept warm
+			continue
+		}
+		running[fsvc.Function.UID] = true
+		fn, err := executor.getFunction(fsvc.Function)
+		if err != nil {
+			// deleted functions are cleaned up by their backends
+			continue
+		}
+		keepWarm := fn.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm
+		var ttl time.Duration
+		if keepWarm != nil {
+			ttl = keepWarm.IdleTTL.Duration
+		}
+		executor.fsCache.SetIdleTTL(fsvc.Function, ttl)
+
+		if executor.keepWarmState.isHeld(fn.Metadata.UID, now) || inKeepWarmWindow(keepWarm, now) {
+			err = executor.fsCache.TouchByAddress(fsvc.Address)
+			if err != nil {
+				log.Printf("Error keeping function %v warm: %v", fsvc.Function.Name, err)
+			}
+		}
+	}
+
+	for _, fn := range executor.keepWarmState.listScheduled(now) {
+		if running[fn.Metadata.UID] {
+			continue
+		}
+		log.Printf("[%v] Keep-warm window started, specializing function", fn.Metadata.Name)
+		go func(fn *crd.Function) {
+			_, err := executor.getServiceForFunction(context.Background(), &fn.Metadata)
+			if err != nil {
+				log.Printf("[%v] Error warming function: %v", fn.Metadata.Name, err)
+			}
+		}(fn)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/testutil"
+)
+
+func TestInKeepWarmWindow(t *testing.T) {
+	keepWarm := &fission.KeepWarm{
+		Schedule:       "@daily",
+		WindowDuration: metav1.Duration{Duration: 30 * time.Minute},
+	}
+	midnight := time.Date(2017, 11, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		now    time.Time
+		inside bool
+	}{
+		{midnight.Add(-time.Minute), false},
+		{midnight, true},
+		{midnight.Add(10 * time.Minute), true},
+		{midnight.Add(31 * time.Minute), false},
+	}
+	for _, test := range tests {
+		if inKeepWarmWindow(keepWarm, test.now) != test.inside {
+			t.Errorf("expected %v to be in the keep-warm window: %v", test.now, test.inside)
+		}
+	}
+
+	if inKeepWarmWindow(nil, midnight) {
+		t.Errorf("expected function without keep-warm settings to have no window")
+	}
+	keepWarm.Schedule = "not a cron spec"
+	if inKeepWarmWindow(keepWarm, midnight) {
+		t.Errorf("expected invalid schedule to have no window")
+	}
+}
+
+func TestKeepWarm(t *testing.T) {
+	executor, b := makeTestExecutor()
+	store := executor.store.(*testutil.Store)
+	hello := store.Functions["hello"]
+	hello.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm = &fission.KeepWarm{
+		IdleTTL: metav1.Duration{Duration: time.Hour},
+	}
+	world := store.Functions["world"]
+	for _, fn := range []*crd.Function{hello, world} {
+		_, err := executor.getServiceForFunction(context.Background(), &fn.Metadata)
+		if err != nil {
+			t.Fatalf("error warming %v: %v", fn.Metadata.Name, err)
+		}
+	}
+
+	// world is held, so it's touched
+	executor.keepWarmState.hold(world.Metadata.UID, time.Now().Add(time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	executor.keepWarm(time.Now())
+
+	// hello's idle TTL applies, world was used just now
+	old, err := b.fsCache.ListOld(&store.Environment.Metadata, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("error listing old function services: %v", err)
+	}
+	if len(old) != 0 {
+		t.Fatalf("expected no function to be idle, got %v", old[0].Function.Name)
+	}
+
+	// once the hold expires, world is idle again
+	time.Sleep(20 * time.Millisecond)
+	executor.keepWarm(time.Now().Add(2 * time.Hour))
+	old, err = b.fsCache.ListOld(&store.Environment.Metadata, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("error listing old function services: %v", err)
+	}
+	if len(old) != 1 || old[0].Function.Name != "world" {
+		t.Fatalf("expected only world to be idle, got %v", old)
+	}
+}
+
+func TestKeepWarmSchedule(t *testing.T) {
+	executor, b := makeTestExecutor()
+	store := executor.store.(*testutil.Store)
+	hello := store.Functions["hello"]
+	hello.Spec.InvokeStrategy.ExecutionStrategy.KeepWarm = &fission.KeepWarm{
+		Schedule: "@daily",
+		// always in the window
+		WindowDuration: metav1.Duration{Duration: 25 * time.Hour},
+	}
+	executor.keepWarmState.functionUpdated(nil, hello)
+	executor.keepWarmState.functionUpdated(nil, store.Functions["world"])
+
+	executor.keepWarm(time.Now())
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		_, err := b.fsCache.GetByFunction(&hello.Metadata)
+		if err == nil {
+			_, err = b.fsCache.GetByFunction(&store.Functions["world"].Metadata)
+			if err == nil {
+				t.Fatalf("expected only the scheduled function to be warmed")
+			}
+			break
+		}
+	}
+	_, err := b.fsCache.GetByFunction(&hello.Metadata)
+	if err != nil {
+		t.Fatalf("expected the scheduled function to be warmed: %v", err)
+	}
+
+	// no longer scheduled once deleted
+	executor.keepWarmState.functionUpdated(hello, nil)
+	if fns := executor.keepWarmState.listScheduled(time.Now()); len(fns) != 0 {
+		t.Fatalf("expected deleted function not to be scheduled, got %v", len(fns))
+	}
+}
+
+func TestWarmUpdatedFunction(t *testing.T) {
+	executor, b := makeTestExecutor()
+	store := executor.store.(*testutil.Store)
+	hello := store.Functions["hello"]
+	_, err := executor.getServiceForFunction(context.Background(), &hello.Metadata)
+	if err != nil {
+		t.Fatalf("error warming function: %v", err)
+	}
+
+	newHello := *hello
+	newHello.Metadata.ResourceVersion = "2"
+	store.Functions["hello"] = &newHello
+	executor.functionUpdated(hello, &newHello)
+
+	// the new version is specialized while the old one drains
+	var fsvc *fscache.FuncSvc
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		fsvc, err = b.fsCache.GetByFunction(&newHello.Metadata)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("expected the new version to be warmed: %v", err)
+	}
+	if fsvc.Name != "hello-2" {
+		t.Fatalf("unexpected function service %v", fsvc.Name)
+	}
+	old, err := b.fsCache.GetByAddress("10.0.0.1:8888")
+	if err != nil || old.Draining.IsZero() {
+		t.Fatalf("expected the old version to be draining, got %v, %v", old, err)
+	}
+
+	// functions that weren't running are left alone
+	world := store.Functions["world"]
+	newWorld := *world
+	newWorld.Metadata.ResourceVersion = "2"
+	store.Functions["world"] = &newWorld
+	executor.functionUpdated(world, &newWorld)
+	time.Sleep(50 * time.Millisecond)
+	_, err = b.fsCache.GetByFunction(&newWorld.Metadata)
+	if err == nil {
+		t.Fatalf("expected function that wasn't running not to be warmed")
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+// Executor replicas elect a leader through a lease kept in a config map
+// in the fission namespace.  Only the leader runs backends and keeps
+// function service state; the other replicas proxy the API to it.  A
+// leader that can't renew its lease within the renew deadline stops
+// its backends and exits, so there's at most one leader once the lease
+// has expired.
+const (
+	leaderConfigMap      = "executor-leader"
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+type (
+	leaderElector struct {
+		identity string // see leaderIdentity
+		lock     resourcelock.Interface
+		elector  *leaderelection.LeaderElector
+	}
+
+	// leaderEventLogger logs the events of the leader lease, instead
+	// of recording them in the API.
+	leaderEventLogger struct{}
+
+	// leaderHandler serves the executor API once this replica is the
+	// leader, and proxies it to the leader until then.
+	leaderHandler struct {
+		elector *leaderElector
+
+		lock    sync.RWMutex
+		handler http.Handler
+	}
+)
+
+// leaderIdentity is a replica's identity in the lease: its name, which
+// is unique (usually the pod name), and the host:port of its API, for
+// the other replicas to proxy to.
+func leaderIdentity(name string, address string) string {
+	return name + "_" + address
+}
+
+// leaderIdentityAddress returns the address in a replica's identity.
+// Pod names can't contain underscores, so the first one separates the
+// name from the address.
+func leaderIdentityAddress(identity string) string {
+	i := strings.Index(identity, "_")
+	if i < 0 {
+		return ""
+	}
+	return identity[i+1:]
+}
+
+// makeLeaderLock makes the lease of the executors in namespace, for the
+// replica with identity.
+func makeLeaderLock(kubernetesClient kubernetes.Interface, namespace string, identity string) resourcelock.Interface {
+	return &resourcelock.ConfigMapLock{
+		ConfigMapMeta: metav1.ObjectMeta{
+			Name:      leaderConfigMap,
+			Namespace: namespace,
+		},
+		Client: kubernetesClient.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: leaderEventLogger{},
+		},
+	}
+}
+
+// makeLeaderElector makes an elector for lock.  Once it holds the
+// lease, it calls onStartedLeading, with a channel that's closed when
+// it loses the lease again; then it calls onStoppedLeading.
+func makeLeaderElector(lock resourcelock.Interface, leaseDuration time.Duration, renewDeadline time.Duration,
+	retryPeriod time.Duration, onStartedLeading func(stop <-chan struct{}), onStoppedLeading func()) (*leaderElector, error) {
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: onStartedLeading,
+			OnStoppedLeading: onStoppedLeading,
+			OnNewLeader: func(identity string) {
+				log.Printf("Executor leader is %v", identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &leaderElector{
+		identity: lock.Identity(),
+		lock:     lock,
+		elector:  elector,
+	}, nil
+}
+
+// run acquires the lease and keeps renewing it, until that fails for
+// longer than the renew deadline.
+func (le *leaderElector) run() {
+	le.elector.Run()
+}
+
+// release gives up the lease, if we hold it, so that another replica
+// can take over without waiting for it to expire.
+func (le *leaderElector) release() {
+	if !le.isLeader() {
+		return
+	}
+	record, err := le.lock.Get()
+	if err != nil {
+		log.Printf("Error getting executor leader lease: %v", err)
+		return
+	}
+	if record.HolderIdentity != le.identity {
+		return
+	}
+	now := metav1.Now()
+	err = le.lock.Update(resourcelock.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    record.LeaderTransitions,
+	})
+	if err != nil {
+		log.Printf("Error releasing executor leader lease: %v", err)
+	}
+}
+
+// leader returns the identity and address of the current leader, as
+// far as we know.
+func (le *leaderElector) leader() (string, string) {
+	identity := le.elector.GetLeader()
+	return identity, leaderIdentityAddress(identity)
+}
+
+func (le *leaderElector) isLeader() bool {
+	return le.elector.IsLeader()
+}
+
+// leaderEventLogger is a record.EventRecorder
+var _ record.EventRecorder = leaderEventLogger{}
+
+func (leaderEventLogger) Event(obj runtime.Object, eventType string, reason string, message string) {
+	log.Printf("%v: %v", reason, message)
+}
+
+func (leaderEventLogger) Eventf(obj runtime.Object, eventType string, reason string, message string, args ...interface{}) {
+	log.Printf("%v: %v", reason, fmt.Sprintf(message, args...))
+}
+
+func (leaderEventLogger) PastEventf(obj runtime.Object, timestamp metav1.Time, eventType string, reason string, message string, args ...interface{}) {
+	log.Printf("%v: %v", reason, fmt.Sprintf(message, args...))
+}
+
+func (lh *leaderHandler) setHandler(handler http.Handler) {
+	lh.lock.Lock()
+	defer lh.lock.Unlock()
+	lh.handler = handler
+}
+
+func (lh *leaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lh.lock.RLock()
+	handler := lh.handler
+	lh.lock.RUnlock()
+	if handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	identity, address := lh.elector.leader()
+	if len(address) == 0 || identity == lh.elector.identity {
+		// no leader yet, or we're it and still starting up
+		http.Error(w, "No executor leader available", http.StatusServiceUnavailable)
+		return
+	}
+	director := func(req *http.Request) {
+		req.URL.Scheme = "http"
+		req.URL.Host = address
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: director,
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// leaderAddress returns the address other replicas reach this one at.
+func leaderAddress(podIP string, hostname string, port int) string {
+	host := podIP
+	if len(host) == 0 {
+		host = hostname
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
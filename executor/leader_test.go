@@ -0,0 +1,301 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const leaderTestNamespace = "fission"
+
+// Short leases, so that the tests don't take long
+const (
+	testLeaseDuration = 600 * time.Millisecond
+	testRenewDeadline = 400 * time.Millisecond
+	testRetryPeriod   = 100 * time.Millisecond
+)
+
+// failingLock is a lease that can be made to fail updates, as if the
+// replica holding it lost its connection to the API.
+type failingLock struct {
+	resourcelock.Interface
+
+	lock    sync.Mutex
+	failing bool
+}
+
+func (l *failingLock) fail() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.failing = true
+}
+
+func (l *failingLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.failing {
+		return fmt.Errorf("connection refused")
+	}
+	return l.Interface.Update(ler)
+}
+
+// testElector is an elector with short leases, and channels closed
+// when it starts and stops leading.
+type testElector struct {
+	*leaderElector
+	lock     *failingLock
+	started  chan struct{}
+	stopped  chan struct{}
+	leaderCh <-chan struct{} // the stop channel the elector gave us
+}
+
+func makeTestElector(t *testing.T, client kubernetes.Interface, name string, address string) *testElector {
+	te := &testElector{
+		lock:    &failingLock{Interface: makeLeaderLock(client, leaderTestNamespace, leaderIdentity(name, address))},
+		started: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	le, err := makeLeaderElector(te.lock, testLeaseDuration, testRenewDeadline, testRetryPeriod,
+		func(stop <-chan struct{}) {
+			te.leaderCh = stop
+			close(te.started)
+		}, func() {
+			close(te.stopped)
+		})
+	if err != nil {
+		t.Fatalf("error making leader elector: %v", err)
+	}
+	te.leaderElector = le
+	return te
+}
+
+// waitFor fails the test if cond isn't true within a few leases
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * testLeaseDuration)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(testRetryPeriod / 2)
+	}
+}
+
+func waitForChannel(t *testing.T, what string, c <-chan struct{}) {
+	select {
+	case <-c:
+	case <-time.After(10 * testLeaseDuration):
+		t.Fatalf("timed out waiting for %v", what)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	a := makeTestElector(t, client, "executor-a", "10.0.0.1:8888")
+	go a.run()
+	waitForChannel(t, "a to acquire the free lease", a.started)
+	if !a.isLeader() {
+		t.Fatalf("expected a to know it's the leader")
+	}
+
+	b := makeTestElector(t, client, "executor-b", "10.0.0.2:8888")
+	go b.run()
+	waitFor(t, "b to see a's lease", func() bool {
+		identity, _ := b.leader()
+		return identity == a.identity
+	})
+	_, address := b.leader()
+	if address != "10.0.0.1:8888" {
+		t.Fatalf("expected b to know the leader's address, got %v", address)
+	}
+
+	// a renews within its lease, so it stays the leader
+	time.Sleep(3 * testLeaseDuration)
+	if b.isLeader() {
+		t.Fatalf("expected b not to acquire a renewed lease")
+	}
+
+	// a can't renew its lease any more: it stops leading, and b
+	// takes over once the lease expires
+	a.lock.fail()
+	waitForChannel(t, "a to stop leading", a.stopped)
+	select {
+	case <-a.leaderCh:
+	default:
+		t.Fatalf("expected a's stop channel to be closed before it stopped leading")
+	}
+	waitForChannel(t, "b to acquire the expired lease", b.started)
+	identity, _ := b.leader()
+	if identity != b.identity {
+		t.Fatalf("expected b to be the leader, got %v", identity)
+	}
+}
+
+func TestLeaderRelease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	a := makeTestElector(t, client, "executor-a", "10.0.0.1:8888")
+	go a.run()
+	waitForChannel(t, "a to acquire the free lease", a.started)
+
+	// when a shuts down, b takes over without waiting for the lease
+	// to expire.  a releases the lease and exits, so it doesn't
+	// renew it any more.
+	a.lock.fail()
+	exiting := *a.leaderElector
+	exiting.lock = a.lock.Interface
+	exiting.release()
+	b := makeTestElector(t, client, "executor-b", "10.0.0.2:8888")
+	go b.run()
+	select {
+	case <-b.started:
+	case <-time.After(testLeaseDuration / 2):
+		t.Fatalf("expected b to acquire the released lease right away")
+	}
+}
+
+func TestLeaderIdentity(t *testing.T) {
+	identity := leaderIdentity("executor-5d8f9", "[fd00::1]:8888")
+	if address := leaderIdentityAddress(identity); address != "[fd00::1]:8888" {
+		t.Fatalf("unexpected address %v in identity %v", address, identity)
+	}
+	if address := leaderIdentityAddress(""); len(address) != 0 {
+		t.Fatalf("expected no address without a leader, got %v", address)
+	}
+}
+
+func TestLeaderFailoverRebuildsState(t *testing.T) {
+	env := crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "nodejs", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+	}
+	hello := crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1", ResourceVersion: "7"},
+		Spec: fission.FunctionSpec{
+			Environment: fission.EnvironmentReference{Name: "nodejs", Namespace: metav1.NamespaceDefault},
+		},
+	}
+
+	// the old leader specialized a pod, and then went away; the new
+	// leader adopts it
+	client := fake.NewSimpleClientset(
+		makeAdoptTestPod("hello-1", &hello, "7", "10.0.0.1"),
+	)
+	fsCache := fscache.MakeFunctionServiceCache()
+	instanceId := rebuildState(client, fsCache, adoptTestNamespace, []crd.Function{hello}, []crd.Environment{env})
+	if instanceId != "abcd1234" {
+		t.Fatalf("expected the new leader to reuse instance id abcd1234, got %v", instanceId)
+	}
+	fsvc, err := fsCache.GetByFunction(&hello.Metadata)
+	if err != nil {
+		t.Fatalf("expected the new leader to adopt the old leader's pod: %v", err)
+	}
+	if fsvc.Address != "10.0.0.1:8888" {
+		t.Fatalf("unexpected address %v", fsvc.Address)
+	}
+
+	// nothing to adopt: a new instance id
+	instanceId = rebuildState(fake.NewSimpleClientset(), fscache.MakeFunctionServiceCache(), adoptTestNamespace, nil, nil)
+	if len(instanceId) == 0 || instanceId == "abcd1234" {
+		t.Fatalf("expected a new instance id, got '%v'", instanceId)
+	}
+}
+
+func TestLeaderHandler(t *testing.T) {
+	leaderServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("leader " + r.URL.Path))
+	}))
+	defer leaderServer.Close()
+
+	client := fake.NewSimpleClientset()
+	follower := makeTestElector(t, client, "executor-b", "10.0.0.2:8888")
+	handler := &leaderHandler{elector: follower.leaderElector}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	post := func() (int, string) {
+		resp, err := http.Post(server.URL+"/v2/getServiceForFunction", "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatalf("error making request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	// no leader yet
+	code, _ := post()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v without a leader, got %v", http.StatusServiceUnavailable, code)
+	}
+
+	// followers proxy to the leader
+	leader := makeTestElector(t, client, "executor-a", strings.TrimPrefix(leaderServer.URL, "http://"))
+	go leader.run()
+	waitForChannel(t, "the leader to acquire the lease", leader.started)
+	go follower.run()
+	waitFor(t, "the follower to see the leader's lease", func() bool {
+		identity, _ := follower.leader()
+		return identity == leader.identity
+	})
+	code, body := post()
+	if code != http.StatusOK || body != "leader /v2/getServiceForFunction" {
+		t.Fatalf("expected the request to be proxied to the leader, got %v %v", code, body)
+	}
+
+	// once it's the leader, it serves requests itself
+	handler.setHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("local"))
+	}))
+	code, body = post()
+	if code != http.StatusOK || body != "local" {
+		t.Fatalf("expected the request to be served locally, got %v %v", code, body)
+	}
+}
+
+func TestLeaderStop(t *testing.T) {
+	executor, b := makeTestExecutor()
+	go executor.reaper()
+
+	// a leader that lost its lease stops its backends and background
+	// work before it exits
+	executor.stop()
+	if !b.stopped {
+		t.Fatalf("expected the backend to be stopped")
+	}
+	select {
+	case <-executor.stopCh:
+	default:
+		t.Fatalf("expected the executor's background work to be stopped")
+	}
+}
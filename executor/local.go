@@ -0,0 +1,65 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"log"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/localprocess"
+)
+
+// StartLocalExecutor starts an executor that doesn't need Kubernetes:
+// functions, environments and packages are read from JSON files under
+// storePath, and every function runs as a local process, whatever its
+// executor type.  See the localprocess package for how runtimes are
+// configured.  Functions aren't watched: an edited function file is a
+// new version of the function, which gets a new process, and the old
+// process is reaped once it's idle.
+func StartLocalExecutor(storePath string, port int) error {
+	fsCache := fscache.MakeFunctionServiceCache()
+	config := &backend.Config{
+		Store:   localprocess.MakeFileStore(storePath),
+		FsCache: fsCache,
+	}
+
+	local, err := localprocess.MakeBackend(config)
+	if err != nil {
+		log.Printf("Failed to start local executor backend: %v", err)
+		return err
+	}
+	err = local.Cleanup()
+	if err != nil {
+		log.Printf("Failed to clean up after local executor backend: %v", err)
+	}
+
+	backends := map[fission.ExecutorType]backend.ExecutorBackend{
+		localprocess.ExecutorType: local,
+	}
+	for _, executorType := range backend.Types() {
+		backends[executorType] = local
+	}
+
+	api := MakeExecutor(backends, config.Store, nil, fsCache)
+	go api.reaper()
+	go api.usageMeter()
+	go api.Serve(port)
+
+	return nil
+}
@@ -0,0 +1,452 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package localprocess is an executor backend that runs environment
+// runtimes as child processes of the executor, for running fission on
+// a laptop or in CI without Kubernetes.
+//
+// Which command runs an environment is configured per runtime image,
+// in a JSON file named by LOCAL_RUNTIMES:
+//
+//	{
+//	  "fission/go-env": {"command": ["/usr/local/bin/go-env"]},
+//	  "fission/binary-env": {"command": ["/usr/local/bin/binary-env", "-i", "${DIR}/userfunc"]}
+//	}
+//
+// Each function gets its own process and directory.  ${PORT} and
+// ${DIR} in the command are replaced by the port the runtime must
+// listen on and the function's directory; the port is also in the
+// runtime's PORT environment variable.  The function's package is
+// fetched into the directory as "user", and the runtime is
+// specialized through its usual specialize endpoint.
+package localprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/environments/fetcher"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// ExecutorType is the executor type of functions run as local processes.
+const ExecutorType fission.ExecutorType = "local"
+
+type (
+	// Runtime says how to run an environment's runtime locally.
+	Runtime struct {
+		Command []string          `json:"command"`
+		Env     map[string]string `json:"env,omitempty"`
+	}
+
+	LocalProcess struct {
+		store    backend.FunctionStore
+		fsCache  *fscache.FunctionServiceCache
+		runtimes map[string]*Runtime // by runtime image
+		workDir  string
+
+		// processes are stopped after being idle this long
+		idleTimeout time.Duration
+		// and must listen on their port within startTimeout
+		startTimeout time.Duration
+
+		lock      sync.Mutex
+		processes map[string]*process // by address
+	}
+
+	process struct {
+		cmd  *exec.Cmd
+		dir  string
+		fsvc *fscache.FuncSvc
+		done chan struct{} // closed when the process exits
+	}
+)
+
+// MakeBackend makes the local process backend, configured by the
+// LOCAL_RUNTIMES, LOCAL_WORKDIR and LOCAL_IDLE_TIMEOUT environment
+// variables.
+func MakeBackend(config *backend.Config) (backend.ExecutorBackend, error) {
+	runtimes := make(map[string]*Runtime)
+	if path := os.Getenv("LOCAL_RUNTIMES"); len(path) > 0 {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &runtimes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %v: %v", path, err)
+		}
+	}
+	workDir := os.Getenv("LOCAL_WORKDIR")
+	if len(workDir) == 0 {
+		workDir = os.TempDir()
+	}
+	idleTimeout := 2 * time.Minute
+	if t := os.Getenv("LOCAL_IDLE_TIMEOUT"); len(t) > 0 {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			log.Printf("Ignoring invalid LOCAL_IDLE_TIMEOUT '%v': %v", t, err)
+		} else {
+			idleTimeout = d
+		}
+	}
+	return MakeLocalProcess(config.Store, config.FsCache, runtimes, workDir, idleTimeout), nil
+}
+
+// MakeLocalProcess makes a local process backend.  Functions get
+// their directories under workDir/fission-local.
+func MakeLocalProcess(store backend.FunctionStore, fsCache *fscache.FunctionServiceCache,
+	runtimes map[string]*Runtime, workDir string, idleTimeout time.Duration) *LocalProcess {
+
+	return &LocalProcess{
+		store:        store,
+		fsCache:      fsCache,
+		runtimes:     runtimes,
+		workDir:      filepath.Join(workDir, "fission-local"),
+		idleTimeout:  idleTimeout,
+		startTimeout: 30 * time.Second,
+		processes:    make(map[string]*process),
+	}
+}
+
+// GetFuncSvc starts a runtime process for fn and specializes it,
+// stopping the process if ctx is done first.
+func (lp *LocalProcess) GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	runtime, ok := lp.runtimes[env.Spec.Runtime.Image]
+	if !ok || len(runtime.Command) == 0 {
+		return nil, fission.MakeError(fission.ErrorInvalidArgument,
+			fmt.Sprintf("No local runtime configured for image %v of environment %v", env.Spec.Runtime.Image, env.Metadata.Name))
+	}
+
+	err := os.MkdirAll(lp.workDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(lp.workDir, fn.Metadata.Name+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := lp.start(ctx, fn, env, runtime, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	_, err = lp.fsCache.Add(*p.fsvc)
+	if err != nil {
+		lp.stop(p)
+		return nil, err
+	}
+	lp.lock.Lock()
+	lp.processes[p.fsvc.Address] = p
+	lp.lock.Unlock()
+	go lp.watch(p)
+	return p.fsvc, nil
+}
+
+// start fetches fn into dir, and runs and specializes a runtime process
+// for it.
+func (lp *LocalProcess) start(ctx context.Context, fn *crd.Function, env *crd.Environment, runtime *Runtime, dir string) (*process, error) {
+	fetchReq := fetcher.FetchRequest{
+		FetchType: fetcher.FETCH_DEPLOYMENT,
+		Package: metav1.ObjectMeta{
+			Namespace: fn.Spec.Package.PackageRef.Namespace,
+			Name:      fn.Spec.Package.PackageRef.Name,
+		},
+		Filename: "user",
+	}
+	loadReq := fission.FunctionLoadRequest{
+		FilePath:         filepath.Join(dir, "user"),
+		FunctionName:     fn.Spec.Package.FunctionName,
+		FunctionMetadata: &fn.Metadata,
+	}
+	if fetchReq.SetFunctionConfig(fn, "user-config") {
+		loadReq.ConfigPath = filepath.Join(dir, "user-config")
+	}
+	log.Printf("[%v] fetching function into %v", fn.Metadata.Name, dir)
+	_, err := fetcher.MakeLocalFetcher(dir, lp.store.GetPackage, lp.store.GetFunction).Fetch(fetchReq)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	address := fmt.Sprintf("127.0.0.1:%v", port)
+	expand := func(s string) string {
+		return os.Expand(s, func(v string) string {
+			switch v {
+			case "PORT":
+				return fmt.Sprintf("%v", port)
+			case "DIR":
+				return dir
+			}
+			return os.Getenv(v)
+		})
+	}
+
+	args := make([]string, 0, len(runtime.Command)-1)
+	for _, arg := range runtime.Command[1:] {
+		args = append(args, expand(arg))
+	}
+	cmd := exec.Command(expand(runtime.Command[0]), args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range runtime.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", k, expand(v)))
+	}
+	for _, e := range fn.Spec.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", e.Name, e.Value))
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%v", port))
+
+	log.Printf("[%v] starting %v at %v", fn.Metadata.Name, runtime.Command[0], address)
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	p := &process{
+		cmd:  cmd,
+		dir:  dir,
+		done: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(p.done)
+	}()
+
+	err = lp.specialize(ctx, p, address, env.Spec.Version, &loadReq)
+	if err != nil {
+		lp.stop(p)
+		return nil, err
+	}
+
+	now := time.Now()
+	p.fsvc = &fscache.FuncSvc{
+		Name:        fmt.Sprintf("%v-%v", fn.Metadata.Name, cmd.Process.Pid),
+		Function:    &fn.Metadata,
+		Environment: env,
+		Address:     address,
+		Executor:    ExecutorType,
+		Ctime:       now,
+		Atime:       now,
+	}
+	return p, nil
+}
+
+// specialize waits for the runtime to listen at address, and loads the
+// function into it.
+func (lp *LocalProcess) specialize(ctx context.Context, p *process, address string, version int, loadReq *fission.FunctionLoadRequest) error {
+	deadline := time.Now().Add(lp.startTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("runtime didn't listen at %v within %v", address, lp.startTimeout)
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("runtime exited before listening at %v", address)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	var req *http.Request
+	if version == 2 {
+		body, err := json.Marshal(loadReq)
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest("POST", fmt.Sprintf("http://%v/v2/specialize", address), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		var err error
+		req, err = http.NewRequest("POST", fmt.Sprintf("http://%v/specialize", address), bytes.NewReader([]byte{}))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fission.MakeErrorFromHTTP(resp)
+	}
+	return nil
+}
+
+// watch uncaches a function whose process exited on its own, so that
+// the next request starts a new one.
+func (lp *LocalProcess) watch(p *process) {
+	<-p.done
+
+	lp.lock.Lock()
+	current, ok := lp.processes[p.fsvc.Address]
+	if ok && current == p {
+		delete(lp.processes, p.fsvc.Address)
+	}
+	lp.lock.Unlock()
+	if !ok || current != p {
+		// stopped by us
+		return
+	}
+
+	log.Printf("[%v] runtime process %v exited", p.fsvc.Function.Name, p.fsvc.Name)
+	_, err := lp.fsCache.DeleteOld(p.fsvc, 0)
+	if err != nil {
+		log.Printf("Error uncaching function %v: %v", p.fsvc.Function.Name, err)
+	}
+	os.RemoveAll(p.dir)
+}
+
+// stop kills a process and removes its directory.
+func (lp *LocalProcess) stop(p *process) {
+	if p.fsvc != nil {
+		lp.lock.Lock()
+		if lp.processes[p.fsvc.Address] == p {
+			delete(lp.processes, p.fsvc.Address)
+		}
+		lp.lock.Unlock()
+	}
+	p.cmd.Process.Kill()
+	<-p.done
+	err := os.RemoveAll(p.dir)
+	if err != nil {
+		log.Printf("Error removing %v: %v", p.dir, err)
+	}
+}
+
+// stopAddress stops the process serving at address, if any.
+func (lp *LocalProcess) stopAddress(address string) {
+	lp.lock.Lock()
+	p, ok := lp.processes[address]
+	lp.lock.Unlock()
+	if ok {
+		log.Printf("[%v] stopping runtime process %v", p.fsvc.Function.Name, p.fsvc.Name)
+		lp.stop(p)
+	}
+}
+
+func (lp *LocalProcess) TapService(fsvc *fscache.FuncSvc, address string) error {
+	return lp.fsCache.TouchByAddress(address)
+}
+
+// Reap stops the processes of idle functions.
+func (lp *LocalProcess) Reap() {
+	envs, err := lp.store.ListEnvironments()
+	if err != nil {
+		log.Printf("Failed to get environment list: %v", err)
+		return
+	}
+	for i := range envs {
+		funcSvcs, err := lp.fsCache.ListOld(&envs[i].Metadata, lp.idleTimeout)
+		if err != nil {
+			log.Printf("Error listing idle functions: %v", err)
+			continue
+		}
+		for _, fsvc := range funcSvcs {
+			if fsvc.Executor != ExecutorType {
+				continue
+			}
+			deleted, err := lp.fsCache.DeleteOld(fsvc, lp.idleTimeout)
+			if err != nil {
+				log.Printf("Error uncaching function %v: %v", fsvc.Function.Name, err)
+			}
+			if deleted {
+				lp.stopAddress(fsvc.Address)
+			}
+		}
+	}
+}
+
+// Evict stops a function's process right away.
+func (lp *LocalProcess) Evict(fsvc *fscache.FuncSvc) error {
+	_, err := lp.fsCache.DeleteOld(fsvc, 0)
+	if err != nil {
+		return err
+	}
+	lp.stopAddress(fsvc.Address)
+	return nil
+}
+
+// Cleanup removes the function directories of earlier executors.
+func (lp *LocalProcess) Cleanup() error {
+	return os.RemoveAll(lp.workDir)
+}
+
+// Stop does nothing: the local executor is the only one, so it's
+// never replaced by another leader.
+func (lp *LocalProcess) Stop() {}
+
+// OnFunctionUpdate stops the process of an updated or deleted function.
+// Processes are only started on request.
+func (lp *LocalProcess) OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function) {
+	if oldFn == nil {
+		return
+	}
+	fsvc, err := lp.fsCache.GetByFunction(&oldFn.Metadata)
+	if err != nil {
+		return
+	}
+	_, err = lp.fsCache.DeleteOld(fsvc, 0)
+	if err != nil {
+		log.Printf("Error uncaching function %v: %v", oldFn.Metadata.Name, err)
+	}
+	for _, address := range fsvc.Addresses {
+		lp.stopAddress(address)
+	}
+}
+
+// freePort returns a port that's free to listen on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localprocess
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/testutil"
+)
+
+// TestHelperRuntime isn't a test: it's the environment runtime that
+// the other tests run, as a child process of the test binary.  It
+// serves the contents of the file it's specialized with.
+func TestHelperRuntime(t *testing.T) {
+	if os.Getenv("LOCALPROCESS_TEST_RUNTIME") != "1" {
+		return
+	}
+	var code []byte
+	http.HandleFunc("/v2/specialize", func(w http.ResponseWriter, r *http.Request) {
+		var loadReq fission.FunctionLoadRequest
+		err := json.NewDecoder(r.Body).Decode(&loadReq)
+		if err == nil {
+			code, err = ioutil.ReadFile(loadReq.FilePath)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+		}
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(code)
+	})
+	http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", os.Getenv("PORT")), nil)
+	os.Exit(0)
+}
+
+func makeTestLocalProcess(t *testing.T, idleTimeout time.Duration) (*LocalProcess, *testutil.Store, func()) {
+	workDir, err := ioutil.TempDir("", "localprocess")
+	if err != nil {
+		t.Fatalf("error creating work directory: %v", err)
+	}
+	store := &testutil.Store{
+		Environment: &crd.Environment{
+			Metadata: metav1.ObjectMeta{Name: "test-env", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+			Spec: fission.EnvironmentSpec{
+				Version: 2,
+				Runtime: fission.Runtime{Image: "fission/test-env"},
+			},
+		},
+		Package: &crd.Package{
+			Metadata: metav1.ObjectMeta{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+			Spec: fission.PackageSpec{
+				Deployment: fission.Archive{Literal: []byte("hello, world")},
+			},
+		},
+	}
+	runtimes := map[string]*Runtime{
+		"fission/test-env": {
+			Command: []string{os.Args[0], "-test.run=TestHelperRuntime"},
+			Env:     map[string]string{"LOCALPROCESS_TEST_RUNTIME": "1"},
+		},
+	}
+	lp := MakeLocalProcess(store, fscache.MakeFunctionServiceCache(), runtimes, workDir, idleTimeout)
+	return lp, store, func() { os.RemoveAll(workDir) }
+}
+
+func makeTestFunction() *crd.Function {
+	return &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1", ResourceVersion: "1"},
+		Spec: fission.FunctionSpec{
+			Environment: fission.EnvironmentReference{Name: "test-env", Namespace: metav1.NamespaceDefault},
+			Package: fission.FunctionPackageRef{
+				PackageRef: fission.PackageRef{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+			},
+		},
+	}
+}
+
+func get(address string) (string, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%v/", address))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestLocalProcess(t *testing.T) {
+	lp, store, cleanup := makeTestLocalProcess(t, time.Minute)
+	defer cleanup()
+
+	fn := makeTestFunction()
+	fsvc, err := lp.GetFuncSvc(context.Background(), fn, store.Environment)
+	if err != nil {
+		t.Fatalf("error starting function: %v", err)
+	}
+	if fsvc.Executor != ExecutorType {
+		t.Fatalf("unexpected executor type %v", fsvc.Executor)
+	}
+	body, err := get(fsvc.Address)
+	if err != nil {
+		t.Fatalf("error calling function: %v", err)
+	}
+	if body != "hello, world" {
+		t.Fatalf("unexpected response %v", body)
+	}
+	cached, err := lp.fsCache.GetByFunction(&fn.Metadata)
+	if err != nil || cached.Address != fsvc.Address {
+		t.Fatalf("function service wasn't cached: %v", err)
+	}
+
+	// updating the function stops its process
+	updated := *fn
+	updated.Metadata.ResourceVersion = "2"
+	lp.OnFunctionUpdate(fn, &updated)
+	_, err = get(fsvc.Address)
+	if err == nil {
+		t.Fatalf("expected the old process to be stopped")
+	}
+	_, err = lp.fsCache.GetByFunction(&fn.Metadata)
+	if err == nil {
+		t.Fatalf("expected the old function to be uncached")
+	}
+	files, err := ioutil.ReadDir(lp.workDir)
+	if err != nil {
+		t.Fatalf("error reading work directory: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the function directory to be removed, found %v files", len(files))
+	}
+}
+
+func TestLocalProcessReap(t *testing.T) {
+	lp, store, cleanup := makeTestLocalProcess(t, 10*time.Millisecond)
+	defer cleanup()
+
+	fsvc, err := lp.GetFuncSvc(context.Background(), makeTestFunction(), store.Environment)
+	if err != nil {
+		t.Fatalf("error starting function: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	lp.Reap()
+
+	_, err = get(fsvc.Address)
+	if err == nil {
+		t.Fatalf("expected the idle process to be stopped")
+	}
+	lp.lock.Lock()
+	defer lp.lock.Unlock()
+	if len(lp.processes) != 0 {
+		t.Fatalf("expected no processes, got %v", len(lp.processes))
+	}
+}
+
+func TestLocalProcessUnknownRuntime(t *testing.T) {
+	lp, store, cleanup := makeTestLocalProcess(t, time.Minute)
+	defer cleanup()
+
+	store.Environment.Spec.Runtime.Image = "fission/other-env"
+	_, err := lp.GetFuncSvc(context.Background(), makeTestFunction(), store.Environment)
+	if err == nil {
+		t.Fatalf("expected an environment without a local runtime to fail")
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localprocess
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// FileStore is a function store backed by a directory of JSON files,
+// one object per file, in the same format as the Kubernetes API uses:
+//
+//	<dir>/functions/*.json
+//	<dir>/environments/*.json
+//	<dir>/packages/*.json
+//	<dir>/httptriggers/*.json
+//
+// The router reads the functions and HTTP triggers from the same
+// directory when it runs without Kubernetes.
+//
+// Objects without a name are named after their file, and objects
+// without a namespace are in the default namespace.  Hand-written
+// objects don't have a uid or resource version, so the uid is derived
+// from the object's name and the resource version is the file's
+// modification time; editing a function makes it a new version.
+// Files are read on every lookup.
+type FileStore struct {
+	dir string
+}
+
+// MakeFileStore makes a FileStore reading from dir.
+func MakeFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) GetFunction(namespace string, name string) (*crd.Function, error) {
+	var fn *crd.Function
+	err := s.find("functions", namespace, name, func() (interface{}, *metav1.ObjectMeta) {
+		fn = &crd.Function{}
+		return fn, &fn.Metadata
+	})
+	return fn, err
+}
+
+func (s *FileStore) GetEnvironment(namespace string, name string) (*crd.Environment, error) {
+	var env *crd.Environment
+	err := s.find("environments", namespace, name, func() (interface{}, *metav1.ObjectMeta) {
+		env = &crd.Environment{}
+		return env, &env.Metadata
+	})
+	return env, err
+}
+
+func (s *FileStore) GetPackage(namespace string, name string) (*crd.Package, error) {
+	var pkg *crd.Package
+	err := s.find("packages", namespace, name, func() (interface{}, *metav1.ObjectMeta) {
+		pkg = &crd.Package{}
+		return pkg, &pkg.Metadata
+	})
+	return pkg, err
+}
+
+func (s *FileStore) ListEnvironments() ([]crd.Environment, error) {
+	envs := make([]crd.Environment, 0)
+	err := s.walk("environments", func() (interface{}, *metav1.ObjectMeta) {
+		envs = append(envs, crd.Environment{})
+		env := &envs[len(envs)-1]
+		return env, &env.Metadata
+	}, func(m *metav1.ObjectMeta) bool {
+		return false
+	})
+	return envs, err
+}
+
+func (s *FileStore) ListFunctions() ([]crd.Function, error) {
+	fns := make([]crd.Function, 0)
+	err := s.walk("functions", func() (interface{}, *metav1.ObjectMeta) {
+		fns = append(fns, crd.Function{})
+		fn := &fns[len(fns)-1]
+		return fn, &fn.Metadata
+	}, func(m *metav1.ObjectMeta) bool {
+		return false
+	})
+	return fns, err
+}
+
+func (s *FileStore) ListHTTPTriggers() ([]crd.HTTPTrigger, error) {
+	triggers := make([]crd.HTTPTrigger, 0)
+	err := s.walk("httptriggers", func() (interface{}, *metav1.ObjectMeta) {
+		triggers = append(triggers, crd.HTTPTrigger{})
+		t := &triggers[len(triggers)-1]
+		return t, &t.Metadata
+	}, func(m *metav1.ObjectMeta) bool {
+		return false
+	})
+	return triggers, err
+}
+
+// find reads the objects of a kind until one matches namespace and
+// name.  newObject is called for every file, and returns the object to
+// read the file into and its metadata.
+func (s *FileStore) find(kind string, namespace string, name string,
+	newObject func() (interface{}, *metav1.ObjectMeta)) error {
+
+	if len(namespace) == 0 {
+		namespace = metav1.NamespaceDefault
+	}
+	found := false
+	err := s.walk(kind, newObject, func(m *metav1.ObjectMeta) bool {
+		found = m.Namespace == namespace && m.Name == name
+		return found
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fission.MakeError(fission.ErrorNotFound,
+			fmt.Sprintf("%v %v/%v not found in %v", strings.TrimSuffix(kind, "s"), namespace, name, s.dir))
+	}
+	return nil
+}
+
+// walk reads the objects of a kind, in file name order, until done
+// returns true.
+func (s *FileStore) walk(kind string, newObject func() (interface{}, *metav1.ObjectMeta),
+	done func(*metav1.ObjectMeta) bool) error {
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, kind, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		obj, m := newObject()
+		err = json.Unmarshal(b, obj)
+		if err != nil {
+			return fmt.Errorf("error parsing %v: %v", path, err)
+		}
+		setDefaults(kind, m, strings.TrimSuffix(filepath.Base(path), ".json"), info)
+		if done(m) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// setDefaults fills in the metadata the API server would have set.
+func setDefaults(kind string, m *metav1.ObjectMeta, filename string, info os.FileInfo) {
+	if len(m.Name) == 0 {
+		m.Name = filename
+	}
+	if len(m.Namespace) == 0 {
+		m.Namespace = metav1.NamespaceDefault
+	}
+	if len(m.UID) == 0 {
+		sum := sha256.Sum256([]byte(kind + "/" + m.Namespace + "/" + m.Name))
+		m.UID = types.UID(hex.EncodeToString(sum[:8]))
+	}
+	if len(m.ResourceVersion) == 0 {
+		m.ResourceVersion = strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	}
+}
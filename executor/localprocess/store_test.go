@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localprocess
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fission/fission"
+)
+
+func writeFile(t *testing.T, dir string, kind string, name string, contents string) {
+	err := os.MkdirAll(filepath.Join(dir, kind), 0755)
+	if err != nil {
+		t.Fatalf("error creating %v directory: %v", kind, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, kind, name+".json"), []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("error writing %v/%v: %v", kind, name, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatalf("error creating store directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "functions", "hello", `{
+		"spec": {"environment": {"name": "go"}}
+	}`)
+	writeFile(t, dir, "functions", "other", `{
+		"metadata": {"name": "hello", "namespace": "other", "uid": "1234", "resourceVersion": "7"}
+	}`)
+	writeFile(t, dir, "environments", "go", `{
+		"spec": {"version": 2, "runtime": {"image": "fission/go-env"}}
+	}`)
+	writeFile(t, dir, "environments", "python", `{}`)
+	store := MakeFileStore(dir)
+
+	// names and namespaces default to the file name and default namespace
+	fn, err := store.GetFunction("", "hello")
+	if err != nil {
+		t.Fatalf("error getting function: %v", err)
+	}
+	if fn.Metadata.Namespace != "default" || fn.Spec.Environment.Name != "go" {
+		t.Fatalf("unexpected function %#v", fn)
+	}
+	if len(fn.Metadata.UID) == 0 || len(fn.Metadata.ResourceVersion) == 0 {
+		t.Fatalf("expected a uid and resource version, got %#v", fn.Metadata)
+	}
+	fn2, err := store.GetFunction("default", "hello")
+	if err != nil || fn2.Metadata.UID != fn.Metadata.UID {
+		t.Fatalf("expected the same uid on every lookup")
+	}
+
+	// metadata in the file wins
+	fn, err = store.GetFunction("other", "hello")
+	if err != nil {
+		t.Fatalf("error getting function: %v", err)
+	}
+	if fn.Metadata.UID != "1234" || fn.Metadata.ResourceVersion != "7" {
+		t.Fatalf("unexpected function metadata %#v", fn.Metadata)
+	}
+
+	env, err := store.GetEnvironment("default", "go")
+	if err != nil {
+		t.Fatalf("error getting environment: %v", err)
+	}
+	if env.Spec.Version != 2 || env.Spec.Runtime.Image != "fission/go-env" {
+		t.Fatalf("unexpected environment %#v", env)
+	}
+
+	envs, err := store.ListEnvironments()
+	if err != nil {
+		t.Fatalf("error listing environments: %v", err)
+	}
+	if len(envs) != 2 || envs[0].Metadata.Name != "go" || envs[1].Metadata.Name != "python" {
+		t.Fatalf("unexpected environments %#v", envs)
+	}
+
+	_, err = store.GetPackage("default", "hello")
+	fe, ok := err.(fission.Error)
+	if !ok || fe.Code != fission.ErrorNotFound {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	writeFile(t, dir, "functions", "broken", `{`)
+	_, err = store.GetFunction("default", "missing")
+	if err == nil {
+		t.Fatalf("expected an error for an unparseable file")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package newdeploy
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func init() {
+	backend.Register(fission.ExecutorTypeNewdeploy, MakeBackend)
+}
+
+// MakeBackend makes the newdeploy executor backend.
+func MakeBackend(config *backend.Config) (backend.ExecutorBackend, error) {
+	return MakeNewDeploy(
+		config.FissionClient, config.KubernetesClient,
+		config.FunctionNamespace, config.FsCache, config.InstanceID), nil
+}
+
+func (deploy *NewDeploy) TapService(fsvc *fscache.FuncSvc, address string) error {
+	return deploy.fsCache.TouchByAddress(address)
+}
+
+// OnFunctionUpdate creates the objects of functions with a minimum
+// scale eagerly, and updates or deletes them along with the function.
+func (deploy *NewDeploy) OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function) {
+	switch {
+	case oldFn == nil:
+		deploy.createFunction(newFn)
+	case newFn == nil:
+		deploy.deleteFunction(oldFn)
+	default:
+		deploy.updateFunction(oldFn, newFn)
+	}
+}
+
+// Stop does nothing: newdeploy has no background work of its own, it
+// only acts on the executor's calls.
+func (deploy *NewDeploy) Stop() {}
+
+// CountPods returns the number of replicas of the function's
+// deployment, which its HPA scales.
+func (deploy *NewDeploy) CountPods(fsvc *fscache.FuncSvc) (float64, error) {
+	for _, obj := range fsvc.KubernetesObjects {
+		if strings.ToLower(obj.Kind) != "deployment" {
+			continue
+		}
+		depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(obj.Namespace).Get(obj.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		return float64(depl.Status.Replicas), nil
+	}
+	return 0, fmt.Errorf("function service %v has no deployment", fsvc.Name)
+}
+
+// Cleanup deletes the objects of deleted functions left behind by an
+// earlier executor.
+func (deploy *NewDeploy) Cleanup() error {
+	functions, err := deploy.fissionClient.Functions(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	return deploy.cleanupOrphanedFunctions(functions.Items)
+}
+
+func (deploy *NewDeploy) cleanupOrphanedFunctions(functions []crd.Function) error {
+	fnUids := make(map[string]bool)
+	for _, fn := range functions {
+		fnUids[string(fn.Metadata.UID)] = true
+	}
+
+	deplList, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			fission.EXECUTOR_INSTANCEID_LABEL: deploy.instanceID,
+			"executorType":                    fission.ExecutorTypeNewdeploy,
+		}).AsSelector().String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, depl := range deplList.Items {
+		if fnUids[depl.ObjectMeta.Labels["functionUid"]] {
+			continue
+		}
+		// the deployment, service and HPA of a function share a name
+		objName := depl.ObjectMeta.Name
+		log.Printf("Cleaning up orphaned function objects %v", objName)
+		err = deploy.deleteDeployment(deploy.namespace, objName)
+		if err != nil {
+			log.Printf("Error deleting the deployment %v: %v", objName, err)
+		}
+		err = deploy.deleteSvc(deploy.namespace, objName)
+		if err != nil {
+			log.Printf("Error deleting the service %v: %v", objName, err)
+		}
+		err = deploy.deleteHpa(deploy.namespace, objName)
+		if err != nil {
+			log.Printf("Error deleting the HPA %v: %v", objName, err)
+		}
+	}
+	return nil
+}
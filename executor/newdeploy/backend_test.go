@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package newdeploy
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func TestBackendRegistered(t *testing.T) {
+	if !backend.IsRegistered(fission.ExecutorTypeNewdeploy) {
+		t.Fatalf("newdeploy backend isn't registered")
+	}
+}
+
+func TestCleanupOrphanedFunctions(t *testing.T) {
+	fnLabels := func(instanceId string, fnUid string) map[string]string {
+		return map[string]string{
+			"executorType":                    fission.ExecutorTypeNewdeploy,
+			"functionUid":                     fnUid,
+			fission.EXECUTOR_INSTANCEID_LABEL: instanceId,
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "live-fn", Namespace: testNamespace, Labels: fnLabels("test", "fn-1")},
+		},
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphaned-fn", Namespace: testNamespace, Labels: fnLabels("test", "fn-2")},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphaned-fn", Namespace: testNamespace},
+		},
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pool",
+				Namespace: testNamespace,
+				Labels: map[string]string{
+					"executorType":                    fission.ExecutorTypePoolmgr,
+					"environmentUid":                  "env-2",
+					fission.EXECUTOR_INSTANCEID_LABEL: "test",
+				},
+			},
+		},
+	)
+	deploy := MakeNewDeploy(nil, client, testNamespace, fscache.MakeFunctionServiceCache(), "test")
+
+	functions := []crd.Function{{Metadata: metav1.ObjectMeta{Name: "fn", UID: "fn-1"}}}
+	err := deploy.cleanupOrphanedFunctions(functions)
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+
+	deplList, err := client.ExtensionsV1beta1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing deployments: %v", err)
+	}
+	remaining := make(map[string]bool)
+	for _, depl := range deplList.Items {
+		remaining[depl.ObjectMeta.Name] = true
+	}
+	if len(remaining) != 2 || !remaining["live-fn"] || !remaining["pool"] {
+		t.Fatalf("expected only the live function and other executors' deployments to remain, got %v", remaining)
+	}
+
+	_, err = client.CoreV1().Services(testNamespace).Get("orphaned-fn", metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected service of deleted function to be cleaned up")
+	}
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package newdeploy
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"path/filepath"
 	"strconv"
@@ -45,144 +47,274 @@ const (
 	envVersion = "ENV_VERSION"
 )
 
-func (deploy *NewDeploy) createOrGetDeployment(fn *crd.Function, env *crd.Environment,
+func (deploy *NewDeploy) createOrGetDeployment(ctx context.Context, fn *crd.Function, env *crd.Environment,
 	deployName string, deployLabels map[string]string) (*v1beta1.Deployment, error) {
 
 	replicas := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
 	if replicas == 0 {
 		replicas = 1
 	}
-	targetFilename := "user"
-	userfunc := "userfunc"
 
 	existingDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(deployName, metav1.GetOptions{})
-	if err == nil && existingDepl.Status.ReadyReplicas >= replicas {
-		return existingDepl, err
+	if err == nil {
+		return deploy.waitForDeployment(ctx, existingDepl, replicas)
+	}
+	if !k8s_err.IsNotFound(err) {
+		return nil, err
 	}
 
-	if err != nil && k8s_err.IsNotFound(err) {
-		fetchReq := &fetcher.FetchRequest{
-			FetchType: fetcher.FETCH_DEPLOYMENT,
-			Package: metav1.ObjectMeta{
-				Namespace: fn.Spec.Package.PackageRef.Namespace,
-				Name:      fn.Spec.Package.PackageRef.Name,
-			},
-			Filename: targetFilename,
-		}
+	deployment, err := deploy.getDeploymentSpec(fn, env, deployName, deployLabels)
+	if err != nil {
+		return nil, err
+	}
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Create(deployment)
+	if err != nil {
+		log.Printf("Error while creating deployment: %v", err)
+		return nil, err
+	}
+	return deploy.waitForDeployment(ctx, depl, replicas)
+}
 
-		loadReq := fission.FunctionLoadRequest{
-			FilePath:         filepath.Join(deploy.sharedMountPath, targetFilename),
-			FunctionName:     fn.Spec.Package.FunctionName,
-			FunctionMetadata: &fn.Metadata,
+// waitForDeployment waits for at least replicas of the deployment's pods
+// to become ready, or until ctx is done.
+func (deploy *NewDeploy) waitForDeployment(ctx context.Context, depl *v1beta1.Deployment, replicas int32) (*v1beta1.Deployment, error) {
+	for i := 0; i < 120; i++ {
+		//TODO check for imagePullerror
+		if depl.Status.ReadyReplicas >= replicas {
+			return depl, nil
 		}
-
-		fetchPayload, err := json.Marshal(fetchReq)
-		if err != nil {
-			return nil, err
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
 		}
-		loadPayload, err := json.Marshal(loadReq)
+
+		latestDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(depl.Name, metav1.GetOptions{})
 		if err != nil {
 			return nil, err
 		}
+		depl = latestDepl
+	}
+	return nil, errors.New("Failed to create deployment within timeout window")
+}
 
-		deployment := &v1beta1.Deployment{
-			ObjectMeta: metav1.ObjectMeta{
-				Labels: deployLabels,
-				Name:   deployName,
+// updateDeployment rolls the function's deployment to the function's
+// current package and resources.  The fetcher of each new pod fetches
+// the package on startup; the deployment keeps serving from the old
+// pods while the new ones start.
+func (deploy *NewDeploy) updateDeployment(fn *crd.Function, env *crd.Environment, deployName string) (*v1beta1.Deployment, error) {
+	existingDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(deployName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The labels are part of the deployment's selector, so keep them
+	newDepl, err := deploy.getDeploymentSpec(fn, env, deployName, existingDepl.Spec.Template.ObjectMeta.Labels)
+	if err != nil {
+		return nil, err
+	}
+	existingDepl.Spec.Template = newDepl.Spec.Template
+
+	// The HPA manages the replica count, within the function's bounds
+	replicas := *existingDepl.Spec.Replicas
+	minScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
+	if replicas < minScale {
+		replicas = minScale
+	}
+	maxScale := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MaxScale)
+	if maxScale > 0 && replicas > maxScale {
+		replicas = maxScale
+	}
+	existingDepl.Spec.Replicas = &replicas
+
+	return deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Update(existingDepl)
+}
+
+// scaleDeployment sets the number of replicas of a deployment.
+func (deploy *NewDeploy) scaleDeployment(deployName string, replicas int32) error {
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(deployName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	depl.Spec.Replicas = &replicas
+	_, err = deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Update(depl)
+	return err
+}
+
+func (deploy *NewDeploy) getDeploymentSpec(fn *crd.Function, env *crd.Environment,
+	deployName string, deployLabels map[string]string) (*v1beta1.Deployment, error) {
+
+	replicas := int32(fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale)
+	if replicas == 0 {
+		replicas = 1
+	}
+	targetFilename := "user"
+	userfunc := "userfunc"
+
+	fetchReq := &fetcher.FetchRequest{
+		FetchType: fetcher.FETCH_DEPLOYMENT,
+		Package: metav1.ObjectMeta{
+			Namespace: fn.Spec.Package.PackageRef.Namespace,
+			Name:      fn.Spec.Package.PackageRef.Name,
+		},
+		Filename:     targetFilename,
+		PinNamespace: true,
+	}
+
+	loadReq := fission.FunctionLoadRequest{
+		FilePath:         filepath.Join(deploy.sharedMountPath, targetFilename),
+		FunctionName:     fn.Spec.Package.FunctionName,
+		FunctionMetadata: &fn.Metadata,
+	}
+
+	// Function pods run in the executor's namespace, so Secrets and
+	// ConfigMaps from the function's namespace can't be mounted as
+	// volumes; the fetcher copies them in at startup instead.
+	configFilename := targetFilename + "-config"
+	if fetchReq.SetFunctionConfig(fn, configFilename) {
+		loadReq.ConfigPath = filepath.Join(deploy.sharedMountPath, configFilename)
+	}
+
+	var fnEnv []apiv1.EnvVar
+	for _, e := range fn.Spec.Env {
+		fnEnv = append(fnEnv, apiv1.EnvVar{Name: e.Name, Value: e.Value})
+	}
+
+	fetchPayload, err := json.Marshal(fetchReq)
+	if err != nil {
+		return nil, err
+	}
+	loadPayload, err := json.Marshal(loadReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := getResources(env, fn)
+
+	deployment := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: deployLabels,
+			Name:   deployName,
+		},
+		Spec: v1beta1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: deployLabels,
 			},
-			Spec: v1beta1.DeploymentSpec{
-				Replicas: &replicas,
-				Selector: &metav1.LabelSelector{
-					MatchLabels: deployLabels,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: deployLabels,
 				},
-				Template: apiv1.PodTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{
-						Labels: deployLabels,
+				Spec: apiv1.PodSpec{
+					Volumes: []apiv1.Volume{
+						{
+							Name: userfunc,
+							VolumeSource: apiv1.VolumeSource{
+								EmptyDir: &apiv1.EmptyDirVolumeSource{},
+							},
+						},
 					},
-					Spec: apiv1.PodSpec{
-						Volumes: []apiv1.Volume{
-							{
-								Name: userfunc,
-								VolumeSource: apiv1.VolumeSource{
-									EmptyDir: &apiv1.EmptyDirVolumeSource{},
+					Containers: []apiv1.Container{
+						{
+							Name:                   env.Metadata.Name,
+							Image:                  env.Spec.Runtime.Image,
+							ImagePullPolicy:        apiv1.PullIfNotPresent,
+							TerminationMessagePath: "/dev/termination-log",
+							VolumeMounts: []apiv1.VolumeMount{
+								{
+									Name:      userfunc,
+									MountPath: deploy.sharedMountPath,
 								},
 							},
+							Env:       fnEnv,
+							Resources: resources,
 						},
-						Containers: []apiv1.Container{
-							{
-								Name:                   fn.Metadata.Name,
-								Image:                  env.Spec.Runtime.Image,
-								ImagePullPolicy:        apiv1.PullIfNotPresent,
-								TerminationMessagePath: "/dev/termination-log",
-								VolumeMounts: []apiv1.VolumeMount{
-									{
-										Name:      userfunc,
-										MountPath: deploy.sharedMountPath,
-									},
+						{
+							Name:                   "fetcher",
+							Image:                  deploy.fetcherImg,
+							ImagePullPolicy:        deploy.fetcherImagePullPolicy,
+							TerminationMessagePath: "/dev/termination-log",
+							VolumeMounts: []apiv1.VolumeMount{
+								{
+									Name:      userfunc,
+									MountPath: deploy.sharedMountPath,
 								},
-								Resources: env.Spec.Resources,
 							},
-							{
-								Name:                   "fetcher",
-								Image:                  deploy.fetcherImg,
-								ImagePullPolicy:        deploy.fetcherImagePullPolicy,
-								TerminationMessagePath: "/dev/termination-log",
-								VolumeMounts: []apiv1.VolumeMount{
-									{
-										Name:      userfunc,
-										MountPath: deploy.sharedMountPath,
-									},
-								},
-								Command: []string{"/fetcher", "-specialize-on-startup",
-									"-fetch-request", string(fetchPayload),
-									"-load-request", string(loadPayload),
-									deploy.sharedMountPath},
-								Env: []apiv1.EnvVar{
-									{
-										Name:  envVersion,
-										Value: strconv.Itoa(env.Spec.Version),
-									},
+							Command: []string{"/fetcher", "-specialize-on-startup",
+								"-fetch-request", string(fetchPayload),
+								"-load-request", string(loadPayload),
+								deploy.sharedMountPath},
+							Env: []apiv1.EnvVar{
+								{
+									Name:  envVersion,
+									Value: strconv.Itoa(env.Spec.Version),
 								},
-								// TBD Use smaller default resources, for now needed to make HPA work
-								Resources: env.Spec.Resources,
-								ReadinessProbe: &apiv1.Probe{
-									Handler: apiv1.Handler{
-										Exec: &apiv1.ExecAction{
-											Command: []string{"cat", "/tmp/ready"},
-										},
+							},
+							// TBD Use smaller default resources, for now needed to make HPA work
+							Resources: resources,
+							ReadinessProbe: &apiv1.Probe{
+								Handler: apiv1.Handler{
+									Exec: &apiv1.ExecAction{
+										Command: []string{"cat", "/tmp/ready"},
 									},
-									InitialDelaySeconds: 1,
-									PeriodSeconds:       1,
 								},
+								InitialDelaySeconds: 1,
+								PeriodSeconds:       1,
 							},
 						},
-						ServiceAccountName: "fission-fetcher",
 					},
+					ServiceAccountName: fetcher.SERVICE_ACCOUNT,
 				},
 			},
-		}
-		depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Create(deployment)
-		if err != nil {
-			log.Printf("Error while creating deployment: %v", err)
-			return nil, err
-		}
+		},
+	}
 
-		for i := 0; i < 120; i++ {
-			latestDepl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(depl.Name, metav1.GetOptions{})
-			if err != nil {
-				return nil, err
-			}
-			//TODO check for imagePullerror
-			if latestDepl.Status.ReadyReplicas == replicas {
-				return latestDepl, err
-			}
-			time.Sleep(time.Second)
+	// The environment's pod template addresses the runtime container
+	// by the environment's name, as in pools; the function's, by the
+	// function's name.
+	template, err := crd.MergePodTemplate(&deployment.Spec.Template, env.Spec.PodTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == env.Metadata.Name {
+			template.Spec.Containers[i].Name = fn.Metadata.Name
 		}
-		return nil, errors.New("Failed to create deployment within timeout window")
 	}
+	template, err = crd.MergePodTemplate(template, fn.Spec.PodTemplate)
+	if err != nil {
+		return nil, err
+	}
+	fetcher.MountServiceAccountToken(&template.Spec, "fetcher")
+	err = crd.ValidatePodTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod template for function %v: %v", fn.Metadata.Name, err)
+	}
+	deployment.Spec.Template = *template
 
-	return nil, err
+	return deployment, nil
+}
 
+// getResources returns the environment's resources, overridden by
+// those set on the function.
+func getResources(env *crd.Environment, fn *crd.Function) apiv1.ResourceRequirements {
+	resources := apiv1.ResourceRequirements{
+		Requests: make(apiv1.ResourceList),
+		Limits:   make(apiv1.ResourceList),
+	}
+	for name, quantity := range env.Spec.Resources.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range env.Spec.Resources.Limits {
+		resources.Limits[name] = quantity
+	}
+	for name, quantity := range fn.Spec.Resources.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range fn.Spec.Resources.Limits {
+		resources.Limits[name] = quantity
+	}
+	return resources
 }
 
 func (deploy *NewDeploy) deleteDeployment(ns string, name string) error {
@@ -240,6 +372,25 @@ func (deploy *NewDeploy) createOrGetHpa(hpaName string, execStrategy *fission.Ex
 
 }
 
+// updateHpa applies the function's scaling bounds to its HPA.
+func (deploy *NewDeploy) updateHpa(hpaName string, execStrategy *fission.ExecutionStrategy) (*asv1.HorizontalPodAutoscaler, error) {
+	minRepl := int32(execStrategy.MinScale)
+	if minRepl == 0 {
+		minRepl = 1
+	}
+	maxRepl := int32(execStrategy.MaxScale)
+	targetCPU := int32(execStrategy.TargetCPUPercent)
+
+	hpa, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(deploy.namespace).Get(hpaName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	hpa.Spec.MinReplicas = &minRepl
+	hpa.Spec.MaxReplicas = maxRepl
+	hpa.Spec.TargetCPUUtilizationPercentage = &targetCPU
+	return deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(deploy.namespace).Update(hpa)
+}
+
 func (deploy NewDeploy) deleteHpa(ns string, name string) error {
 	err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(ns).Delete(name, &metav1.DeleteOptions{})
 	return err
@@ -0,0 +1,409 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package newdeploy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/environments/fetcher"
+	"github.com/fission/fission/executor/fscache"
+)
+
+const testNamespace = "fission-function"
+
+func makeTestEnv() *crd.Environment {
+	return &crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "nodejs", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+		Spec: fission.EnvironmentSpec{
+			Version: 2,
+			Runtime: fission.Runtime{Image: "fission/node-env"},
+			Resources: apiv1.ResourceRequirements{
+				Limits: apiv1.ResourceList{
+					apiv1.ResourceCPU:    resource.MustParse("200m"),
+					apiv1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+			},
+		},
+	}
+}
+
+func makeTestFunction(resourceVersion string, pkg string, minScale int, maxScale int) *crd.Function {
+	return &crd.Function{
+		Metadata: metav1.ObjectMeta{
+			Name:            "hello",
+			Namespace:       metav1.NamespaceDefault,
+			UID:             "fn-1",
+			ResourceVersion: resourceVersion,
+		},
+		Spec: fission.FunctionSpec{
+			Environment: fission.EnvironmentReference{Name: "nodejs", Namespace: metav1.NamespaceDefault},
+			Package: fission.FunctionPackageRef{
+				PackageRef: fission.PackageRef{Name: pkg, Namespace: metav1.NamespaceDefault},
+			},
+			InvokeStrategy: fission.InvokeStrategy{
+				ExecutionStrategy: fission.ExecutionStrategy{
+					ExecutorType:     fission.ExecutorTypeNewdeploy,
+					MinScale:         minScale,
+					MaxScale:         maxScale,
+					TargetCPUPercent: 80,
+				},
+			},
+		},
+	}
+}
+
+// makeTestFuncObjects creates the objects of a running newdeploy
+// function and caches its service, as fnCreate would.
+func makeTestFuncObjects(t *testing.T, deploy *NewDeploy, fn *crd.Function, env *crd.Environment) {
+	objName := deploy.getObjName(fn)
+	deployLabels := map[string]string{
+		"functionName": fn.Metadata.Name,
+		"functionUid":  string(fn.Metadata.UID),
+		"executorType": fission.ExecutorTypeNewdeploy,
+	}
+
+	deployment, err := deploy.getDeploymentSpec(fn, env, objName, deployLabels)
+	if err != nil {
+		t.Fatalf("error building deployment: %v", err)
+	}
+	deployment.Status.ReadyReplicas = *deployment.Spec.Replicas
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Create(deployment)
+	if err != nil {
+		t.Fatalf("error creating deployment: %v", err)
+	}
+	svc, err := deploy.createOrGetSvc(deployLabels, objName)
+	if err != nil {
+		t.Fatalf("error creating service: %v", err)
+	}
+	_, err = deploy.createOrGetHpa(objName, &fn.Spec.InvokeStrategy.ExecutionStrategy, depl)
+	if err != nil {
+		t.Fatalf("error creating hpa: %v", err)
+	}
+
+	_, err = deploy.fsCache.Add(fscache.FuncSvc{
+		Name:              objName,
+		Function:          &fn.Metadata,
+		Environment:       env,
+		Address:           svc.Spec.ClusterIP,
+		KubernetesObjects: []api.ObjectReference{{Kind: "deployment", Name: objName}},
+		Executor:          fission.ExecutorTypeNewdeploy,
+	})
+	if err != nil {
+		t.Fatalf("error caching function service: %v", err)
+	}
+}
+
+func TestUpdateFunction(t *testing.T) {
+	deploy := MakeNewDeploy(nil, fake.NewSimpleClientset(), testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	env := makeTestEnv()
+	oldFn := makeTestFunction("1", "hello-pkg-v1", 1, 3)
+	makeTestFuncObjects(t, deploy, oldFn, env)
+
+	newFn := makeTestFunction("2", "hello-pkg-v2", 2, 5)
+	newFn.Spec.Resources = apiv1.ResourceRequirements{
+		Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")},
+	}
+	err := deploy.updateFuncObjects(oldFn, newFn, env)
+	if err != nil {
+		t.Fatalf("error updating function: %v", err)
+	}
+
+	// the deployment is rolled to the new package and resources
+	objName := deploy.getObjName(newFn)
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting deployment: %v", err)
+	}
+	if *depl.Spec.Replicas != 2 {
+		t.Fatalf("expected deployment to grow to the new minimum 2, got %v", *depl.Spec.Replicas)
+	}
+	containers := depl.Spec.Template.Spec.Containers
+	fetchCmd := strings.Join(containers[1].Command, " ")
+	if !strings.Contains(fetchCmd, "hello-pkg-v2") || strings.Contains(fetchCmd, "hello-pkg-v1") {
+		t.Fatalf("expected fetcher to fetch the new package, got %v", fetchCmd)
+	}
+	mem := containers[0].Resources.Limits[apiv1.ResourceMemory]
+	cpu := containers[0].Resources.Limits[apiv1.ResourceCPU]
+	if mem.String() != "512Mi" || cpu.String() != "200m" {
+		t.Fatalf("expected function resources over environment resources, got %v", containers[0].Resources.Limits)
+	}
+
+	// the HPA has the new bounds
+	hpa, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(testNamespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting hpa: %v", err)
+	}
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 5 {
+		t.Fatalf("expected hpa bounds 2-5, got %v-%v", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+
+	// the cached service moved to the new version of the function
+	_, err = deploy.fsCache.GetByFunction(&oldFn.Metadata)
+	if err == nil {
+		t.Fatalf("expected old version of the function to be uncached")
+	}
+	fsvc, err := deploy.fsCache.GetByFunction(&newFn.Metadata)
+	if err != nil {
+		t.Fatalf("expected new version of the function to be cached: %v", err)
+	}
+	if fsvc.Name != objName {
+		t.Fatalf("unexpected function service %v", fsvc)
+	}
+}
+
+func TestUpdateFunctionScaleOnly(t *testing.T) {
+	kubernetesClient := fake.NewSimpleClientset()
+	deploy := MakeNewDeploy(nil, kubernetesClient, testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	env := makeTestEnv()
+	oldFn := makeTestFunction("1", "hello-pkg", 1, 3)
+	makeTestFuncObjects(t, deploy, oldFn, env)
+	kubernetesClient.ClearActions()
+
+	// raising the maximum only touches the HPA
+	newFn := makeTestFunction("2", "hello-pkg", 1, 10)
+	err := deploy.updateFuncObjects(oldFn, newFn, env)
+	if err != nil {
+		t.Fatalf("error updating function: %v", err)
+	}
+
+	for _, action := range kubernetesClient.Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "deployments" {
+			t.Fatalf("expected deployment not to be rolled")
+		}
+	}
+	objName := deploy.getObjName(oldFn)
+	hpa, err := deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(testNamespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting hpa: %v", err)
+	}
+	if hpa.Spec.MaxReplicas != 10 {
+		t.Fatalf("expected hpa maximum 10, got %v", hpa.Spec.MaxReplicas)
+	}
+}
+
+func TestUpdateFunctionExecutorType(t *testing.T) {
+	deploy := MakeNewDeploy(nil, fake.NewSimpleClientset(), testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	env := makeTestEnv()
+	oldFn := makeTestFunction("1", "hello-pkg", 1, 3)
+	makeTestFuncObjects(t, deploy, oldFn, env)
+
+	// switching to poolmgr removes the function's objects
+	newFn := makeTestFunction("2", "hello-pkg", 1, 3)
+	newFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType = fission.ExecutorTypePoolmgr
+	err := deploy.fnUpdate(oldFn, newFn)
+	if err != nil {
+		t.Fatalf("error updating function: %v", err)
+	}
+
+	objName := deploy.getObjName(oldFn)
+	_, err = deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(objName, metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected deployment to be deleted")
+	}
+	_, err = deploy.kubernetesClient.CoreV1().Services(testNamespace).Get(objName, metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected service to be deleted")
+	}
+	_, err = deploy.kubernetesClient.AutoscalingV1().HorizontalPodAutoscalers(testNamespace).Get(objName, metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected hpa to be deleted")
+	}
+	_, err = deploy.fsCache.GetByFunction(&oldFn.Metadata)
+	if err == nil {
+		t.Fatalf("expected function to be uncached")
+	}
+
+	// switching a lazily created function from poolmgr creates nothing
+	backFn := makeTestFunction("3", "hello-pkg", 0, 3)
+	err = deploy.fnUpdate(newFn, backFn)
+	if err != nil {
+		t.Fatalf("error updating function: %v", err)
+	}
+	_, err = deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(objName, metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected no deployment for a function with minimum scale 0")
+	}
+}
+
+func getTestReplicas(t *testing.T, deploy *NewDeploy, objName string) int32 {
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting deployment: %v", err)
+	}
+	return *depl.Spec.Replicas
+}
+
+func TestScaleToZero(t *testing.T) {
+	kubernetesClient := fake.NewSimpleClientset()
+	deploy := MakeNewDeploy(nil, kubernetesClient, testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	deploy.idleTimeout = 0
+	env := makeTestEnv()
+	fn := makeTestFunction("1", "hello-pkg", 0, 3)
+	makeTestFuncObjects(t, deploy, fn, env)
+	objName := deploy.getObjName(fn)
+
+	// the idle function is uncached and scaled down
+	fsvc, err := deploy.fsCache.GetByFunction(&fn.Metadata)
+	if err != nil {
+		t.Fatalf("error getting function service: %v", err)
+	}
+	err = deploy.scaleDownFunction(fsvc, deploy.idleTimeout)
+	if err != nil {
+		t.Fatalf("error scaling down function: %v", err)
+	}
+	if replicas := getTestReplicas(t, deploy, objName); replicas != 0 {
+		t.Fatalf("expected deployment to be scaled to zero, got %v", replicas)
+	}
+	_, err = deploy.fsCache.GetByFunction(&fn.Metadata)
+	if err == nil {
+		t.Fatalf("expected scaled down function to be uncached")
+	}
+	if !deploy.isScaledToZero(fn) {
+		t.Fatalf("expected function to be scaled to zero")
+	}
+
+	// hold up the scale up until all requests are queued
+	release := make(chan struct{})
+	var updates int32
+	kubernetesClient.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&updates, 1)
+		<-release
+		return false, nil, nil
+	})
+
+	responses := make([]chan *fnResponse, 0)
+	for i := 0; i < 3; i++ {
+		c := make(chan *fnResponse)
+		deploy.requestChannel <- &fnRequest{
+			fn:              fn,
+			reqType:         FnCreate,
+			responseChannel: c,
+		}
+		responses = append(responses, c)
+	}
+	close(release)
+
+	for _, c := range responses {
+		resp := <-c
+		if resp.error != nil {
+			t.Fatalf("error waking function: %v", resp.error)
+		}
+		if resp.fSvc.Name != objName || resp.fSvc.Address != fsvc.Address {
+			t.Fatalf("unexpected function service %v", resp.fSvc)
+		}
+	}
+	if n := atomic.LoadInt32(&updates); n != 1 {
+		t.Fatalf("expected concurrent requests to scale up once, got %v updates", n)
+	}
+	if replicas := getTestReplicas(t, deploy, objName); replicas != 1 {
+		t.Fatalf("expected deployment to be scaled up to 1, got %v", replicas)
+	}
+	_, err = deploy.fsCache.GetByFunction(&fn.Metadata)
+	if err != nil {
+		t.Fatalf("expected woken function to be cached: %v", err)
+	}
+}
+
+func TestScaleDownSkipsActiveFunction(t *testing.T) {
+	deploy := MakeNewDeploy(nil, fake.NewSimpleClientset(), testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	env := makeTestEnv()
+	fn := makeTestFunction("1", "hello-pkg", 0, 3)
+	makeTestFuncObjects(t, deploy, fn, env)
+
+	// the function was used since it was found idle
+	fsvc, err := deploy.fsCache.GetByFunction(&fn.Metadata)
+	if err != nil {
+		t.Fatalf("error getting function service: %v", err)
+	}
+	err = deploy.scaleDownFunction(fsvc, deploy.idleTimeout)
+	if err != nil {
+		t.Fatalf("error scaling down function: %v", err)
+	}
+	if replicas := getTestReplicas(t, deploy, deploy.getObjName(fn)); replicas != 1 {
+		t.Fatalf("expected active function to keep its replica, got %v", replicas)
+	}
+
+	// unless it's evicted
+	err = deploy.Evict(fsvc)
+	if err != nil {
+		t.Fatalf("error evicting function: %v", err)
+	}
+	if replicas := getTestReplicas(t, deploy, deploy.getObjName(fn)); replicas != 0 {
+		t.Fatalf("expected evicted function to be scaled to zero, got %v", replicas)
+	}
+}
+
+func TestUpdateFunctionConfig(t *testing.T) {
+	deploy := MakeNewDeploy(nil, fake.NewSimpleClientset(), testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	env := makeTestEnv()
+	oldFn := makeTestFunction("1", "hello-pkg", 1, 3)
+	makeTestFuncObjects(t, deploy, oldFn, env)
+
+	newFn := makeTestFunction("2", "hello-pkg", 1, 3)
+	newFn.Spec.Env = []fission.EnvVar{{Name: "GREETING", Value: "hi"}}
+	newFn.Spec.Secrets = []fission.SecretReference{{Namespace: "other", Name: "db-password"}}
+	newFn.Spec.ConfigMaps = []fission.ConfigMapReference{{Name: "settings"}}
+	err := deploy.updateFuncObjects(oldFn, newFn, env)
+	if err != nil {
+		t.Fatalf("error updating function: %v", err)
+	}
+
+	objName := deploy.getObjName(newFn)
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting deployment: %v", err)
+	}
+	containers := depl.Spec.Template.Spec.Containers
+
+	// literal variables are set on the runtime container
+	fnEnv := containers[0].Env
+	if len(fnEnv) != 1 || fnEnv[0].Name != "GREETING" || fnEnv[0].Value != "hi" {
+		t.Fatalf("expected function environment variable, got %v", fnEnv)
+	}
+
+	// the fetcher copies the function's Secrets and ConfigMaps,
+	// and the runtime is told where
+	var fetchReq fetcher.FetchRequest
+	err = json.Unmarshal([]byte(containers[1].Command[3]), &fetchReq)
+	if err != nil {
+		t.Fatalf("error parsing fetch request: %v", err)
+	}
+	if fetchReq.Function.Name != newFn.Metadata.Name || fetchReq.Function.Namespace != metav1.NamespaceDefault {
+		t.Fatalf("expected config of the function, got %v", fetchReq.Function)
+	}
+	var loadReq fission.FunctionLoadRequest
+	err = json.Unmarshal([]byte(containers[1].Command[5]), &loadReq)
+	if err != nil {
+		t.Fatalf("error parsing load request: %v", err)
+	}
+	if loadReq.ConfigPath != "/userfunc/"+fetchReq.ConfigFilename {
+		t.Fatalf("expected config path under the shared volume, got %v", loadReq.ConfigPath)
+	}
+}
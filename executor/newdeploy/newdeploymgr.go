@@ -21,27 +21,25 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"time"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
 	"github.com/fission/fission/executor/fscache"
+	k8s_err "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api"
 	apiv1 "k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/rest"
-	k8sCache "k8s.io/client-go/tools/cache"
 )
 
 type (
 	requestType int
 
 	NewDeploy struct {
-		kubernetesClient *kubernetes.Clientset
+		kubernetesClient kubernetes.Interface
 		fissionClient    *crd.FissionClient
-		crdClient        *rest.RESTClient
 		instanceID       string
 
 		fetcherImg             string
@@ -52,14 +50,26 @@ type (
 		fsCache        *fscache.FunctionServiceCache // cache funcSvc's by function, address and podname
 		requestChannel chan *fnRequest
 
-		functions      []crd.Function
-		funcStore      k8sCache.Store
-		funcController k8sCache.Controller
+		// Functions with a minimum scale of 0 are scaled down to
+		// zero replicas after being idle this long
+		idleTimeout time.Duration
+
+		// requests waiting for a function that's being scaled up
+		// from zero, by function cache key, and the services of
+		// functions scaled down to zero, by function uid.  Owned by
+		// service().
+		wakeups    map[string][]chan *fnResponse
+		scaledDown map[string]*fscache.FuncSvc
 	}
 
 	fnRequest struct {
 		reqType         requestType
+		ctx             context.Context // FnCreate only
 		fn              *crd.Function
+		oldFn           *crd.Function    // FnUpdate only
+		fsvc            *fscache.FuncSvc // FnScaleDown only
+		minAge          time.Duration    // FnScaleDown only
+		result          *fnResponse      // FnWoken only
 		responseChannel chan *fnResponse
 	}
 
@@ -73,12 +83,13 @@ const (
 	FnCreate requestType = iota
 	FnDelete
 	FnUpdate
+	FnScaleDown
+	FnWoken
 )
 
 func MakeNewDeploy(
 	fissionClient *crd.FissionClient,
-	kubernetesClient *kubernetes.Clientset,
-	crdClient *rest.RESTClient,
+	kubernetesClient kubernetes.Interface,
 	namespace string,
 	fsCache *fscache.FunctionServiceCache,
 	instanceID string,
@@ -94,11 +105,19 @@ func MakeNewDeploy(
 	if len(fetcherImagePullPolicy) == 0 {
 		fetcherImagePullPolicy = "IfNotPresent"
 	}
+	idleTimeout := 2 * time.Minute
+	if t := os.Getenv("NEWDEPLOY_IDLE_TIMEOUT"); len(t) > 0 {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			log.Printf("Ignoring invalid NEWDEPLOY_IDLE_TIMEOUT '%v': %v", t, err)
+		} else {
+			idleTimeout = d
+		}
+	}
 
 	nd := &NewDeploy{
 		fissionClient:    fissionClient,
 		kubernetesClient: kubernetesClient,
-		crdClient:        crdClient,
 		instanceID:       instanceID,
 
 		namespace: namespace,
@@ -108,55 +127,68 @@ func MakeNewDeploy(
 		fetcherImagePullPolicy: apiv1.PullIfNotPresent,
 		sharedMountPath:        "/userfunc",
 
+		idleTimeout: idleTimeout,
+		wakeups:     make(map[string][]chan *fnResponse),
+		scaledDown:  make(map[string]*fscache.FuncSvc),
+
 		requestChannel: make(chan *fnRequest),
 	}
 
-	if nd.crdClient != nil {
-		fnStore, fnController := nd.initFuncController()
-		nd.funcStore = fnStore
-		nd.funcController = fnController
-	}
 	go nd.service()
 	return nd
 }
 
-func (deploy *NewDeploy) Run(ctx context.Context) {
-	go deploy.funcController.Run(ctx.Done())
-}
-
-func (deploy *NewDeploy) initFuncController() (k8sCache.Store, k8sCache.Controller) {
-	resyncPeriod := 30 * time.Second
-	listWatch := k8sCache.NewListWatchFromClient(deploy.crdClient, "functions", metav1.NamespaceDefault, fields.Everything())
-	store, controller := k8sCache.NewInformer(listWatch, &crd.Function{}, resyncPeriod, k8sCache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			fn := obj.(*crd.Function)
-			deploy.createFunction(fn)
-		},
-		DeleteFunc: func(obj interface{}) {
-			fn := obj.(*crd.Function)
-			deploy.deleteFunction(fn)
-		},
-		UpdateFunc: func(newObj interface{}, oldObj interface{}) {
-			//TBD
-		},
-	})
-	return store, controller
-}
-
 func (deploy *NewDeploy) service() {
 	for {
 		req := <-deploy.requestChannel
 		switch req.reqType {
 		case FnCreate:
-			fsvc, err := deploy.fnCreate(req.fn)
+			key := crd.CacheKey(&req.fn.Metadata)
+			if waiters, ok := deploy.wakeups[key]; ok {
+				deploy.wakeups[key] = append(waiters, req.responseChannel)
+				continue
+			}
+			if deploy.isScaledToZero(req.fn) {
+				// Scaling up takes a while; don't hold up other
+				// functions in the meantime
+				deploy.wakeups[key] = []chan *fnResponse{req.responseChannel}
+				go deploy.wakeFunction(req.fn, deploy.scaledDown[string(req.fn.Metadata.UID)])
+				continue
+			}
+			fsvc, err := deploy.fnCreate(req.ctx, req.fn)
 			req.responseChannel <- &fnResponse{
 				error: err,
 				fSvc:  fsvc,
 			}
 			continue
+		case FnWoken:
+			key := crd.CacheKey(&req.fn.Metadata)
+			for _, c := range deploy.wakeups[key] {
+				c <- req.result
+			}
+			delete(deploy.wakeups, key)
+			if req.result.error == nil {
+				delete(deploy.scaledDown, string(req.fn.Metadata.UID))
+			}
+			continue
+		case FnScaleDown:
+			err := deploy.fnScaleDown(req.fsvc, req.minAge)
+			req.responseChannel <- &fnResponse{
+				error: err,
+				fSvc:  nil,
+			}
+			continue
 		case FnUpdate:
-			// TBD
+			// the function's service is looked up again on wake up
+			delete(deploy.scaledDown, string(req.fn.Metadata.UID))
+			err := deploy.fnUpdate(req.oldFn, req.fn)
+			req.responseChannel <- &fnResponse{
+				error: err,
+				fSvc:  nil,
+			}
+			continue
 		case FnDelete:
+			delete(deploy.scaledDown, string(req.fn.Metadata.UID))
 			_, err := deploy.fnDelete(req.fn)
 			req.responseChannel <- &fnResponse{
 				error: err,
@@ -167,22 +199,32 @@ func (deploy *NewDeploy) service() {
 	}
 }
 
-func (deploy *NewDeploy) GetFuncSvc(metadata *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
-	c := make(chan *fnResponse)
-	fn, err := deploy.fissionClient.Functions(metadata.Namespace).Get(metadata.Name)
-	if err != nil {
-		return nil, err
-	}
+// GetFuncSvc returns the function's service, creating its objects or
+// scaling it up from zero if needed.  It stops waiting for the
+// deployment to become ready when ctx is done.
+func (deploy *NewDeploy) GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	return deploy.getFuncSvc(ctx, fn)
+}
+
+func (deploy *NewDeploy) getFuncSvc(ctx context.Context, fn *crd.Function) (*fscache.FuncSvc, error) {
+	// buffered, so that the service doesn't block on a response
+	// nobody waits for any more
+	c := make(chan *fnResponse, 1)
 	deploy.requestChannel <- &fnRequest{
+		ctx:             ctx,
 		fn:              fn,
 		reqType:         FnCreate,
 		responseChannel: c,
 	}
-	resp := <-c
-	if resp.error != nil {
-		return nil, resp.error
+	select {
+	case resp := <-c:
+		if resp.error != nil {
+			return nil, resp.error
+		}
+		return resp.fSvc, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return resp.fSvc, nil
 }
 
 func (deploy *NewDeploy) createFunction(fn *crd.Function) {
@@ -196,6 +238,7 @@ func (deploy *NewDeploy) createFunction(fn *crd.Function) {
 	log.Printf("Eagerly creating newDeploy objects for function")
 	c := make(chan *fnResponse)
 	deploy.requestChannel <- &fnRequest{
+		ctx:             context.Background(),
 		fn:              fn,
 		reqType:         FnCreate,
 		responseChannel: c,
@@ -221,7 +264,161 @@ func (deploy *NewDeploy) deleteFunction(fn *crd.Function) {
 	}
 }
 
-func (deploy *NewDeploy) fnCreate(fn *crd.Function) (*fscache.FuncSvc, error) {
+func (deploy *NewDeploy) updateFunction(oldFn *crd.Function, newFn *crd.Function) {
+	// resyncs call us with unchanged functions
+	if oldFn.Metadata.ResourceVersion == newFn.Metadata.ResourceVersion {
+		return
+	}
+	if oldFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeNewdeploy &&
+		newFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fission.ExecutorTypeNewdeploy {
+		return
+	}
+	c := make(chan *fnResponse)
+	deploy.requestChannel <- &fnRequest{
+		fn:              newFn,
+		oldFn:           oldFn,
+		reqType:         FnUpdate,
+		responseChannel: c,
+	}
+	resp := <-c
+	if resp.error != nil {
+		log.Printf("Error updating the function: %v", resp.error)
+	}
+}
+
+// isScaledToZero returns true if the function's deployment exists and
+// was scaled down to zero replicas.
+func (deploy *NewDeploy) isScaledToZero(fn *crd.Function) bool {
+	_, err := deploy.fsCache.GetByFunction(&fn.Metadata)
+	if err == nil {
+		return false
+	}
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(deploy.getObjName(fn), metav1.GetOptions{})
+	return err == nil && depl.Spec.Replicas != nil && *depl.Spec.Replicas == 0
+}
+
+// wakeFunction scales a function's deployment back up from zero and
+// answers all the requests that waited for it.  fsvc is the function's
+// service from before it was scaled down, if it's still known.
+func (deploy *NewDeploy) wakeFunction(fn *crd.Function, fsvc *fscache.FuncSvc) {
+	log.Printf("Scaling up idle function %v", fn.Metadata.Name)
+	objName := deploy.getObjName(fn)
+	err := deploy.scaleDeployment(objName, 1)
+	if err == nil {
+		if fsvc == nil {
+			// waits for the deployment to become ready; other
+			// requests may be waiting for it too, so it isn't
+			// cut short by any single one of them
+			fsvc, err = deploy.fnCreate(context.Background(), fn)
+		} else {
+			fsvc, err = deploy.restoreFuncSvc(context.Background(), fn, fsvc)
+		}
+	}
+	deploy.requestChannel <- &fnRequest{
+		fn:      fn,
+		reqType: FnWoken,
+		result: &fnResponse{
+			error: err,
+			fSvc:  fsvc,
+		},
+	}
+}
+
+// restoreFuncSvc caches the service of a function scaled up from zero
+// once its deployment is ready again.
+func (deploy *NewDeploy) restoreFuncSvc(ctx context.Context, fn *crd.Function, fsvc *fscache.FuncSvc) (*fscache.FuncSvc, error) {
+	depl, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(fsvc.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	_, err = deploy.waitForDeployment(ctx, depl, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := *fsvc
+	restored.Function = &fn.Metadata
+	restored.Addresses = nil
+	_, err = deploy.fsCache.Add(restored)
+	if err != nil {
+		return nil, err
+	}
+	return deploy.fsCache.GetByFunction(&fn.Metadata)
+}
+
+// Reap scales down the deployments of idle functions with a minimum
+// scale of 0.
+func (deploy *NewDeploy) Reap() {
+	envs, err := deploy.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Failed to get environment list: %v", err)
+		return
+	}
+	for i := range envs.Items {
+		funcSvcs, err := deploy.fsCache.ListOld(&envs.Items[i].Metadata, deploy.idleTimeout)
+		if err != nil {
+			log.Printf("Error listing idle functions: %v", err)
+			continue
+		}
+		for _, fsvc := range funcSvcs {
+			if fsvc.Executor != fission.ExecutorTypeNewdeploy {
+				continue
+			}
+			fn, err := deploy.fissionClient.Functions(fsvc.Function.Namespace).Get(fsvc.Function.Name)
+			if err != nil {
+				log.Printf("Error getting function %v: %v", fsvc.Function.Name, err)
+				continue
+			}
+			if fn.Spec.InvokeStrategy.ExecutionStrategy.MinScale > 0 {
+				continue
+			}
+			err = deploy.scaleDownFunction(fsvc, deploy.idleTimeout)
+			if err != nil {
+				log.Printf("Error scaling down function %v: %v", fsvc.Function.Name, err)
+			}
+		}
+	}
+}
+
+// Evict scales a function's deployment down to zero right away.  The
+// next request scales it back up, with new pods.
+func (deploy *NewDeploy) Evict(fsvc *fscache.FuncSvc) error {
+	return deploy.scaleDownFunction(fsvc, 0)
+}
+
+func (deploy *NewDeploy) scaleDownFunction(fsvc *fscache.FuncSvc, minAge time.Duration) error {
+	c := make(chan *fnResponse)
+	deploy.requestChannel <- &fnRequest{
+		fsvc:            fsvc,
+		minAge:          minAge,
+		reqType:         FnScaleDown,
+		responseChannel: c,
+	}
+	resp := <-c
+	return resp.error
+}
+
+// fnScaleDown uncaches a function that's been idle for at least minAge
+// and scales its deployment to zero.  The HPA leaves deployments with
+// zero replicas alone, so it stays in place for when the function is
+// scaled back up.
+func (deploy *NewDeploy) fnScaleDown(fsvc *fscache.FuncSvc, minAge time.Duration) error {
+	// DeleteOld checks the idle time again, in case the function was
+	// used since it was listed
+	deleted, err := deploy.fsCache.DeleteOld(fsvc, minAge)
+	if err != nil || !deleted {
+		return err
+	}
+	log.Printf("Scaling down function %v", fsvc.Function.Name)
+	err = deploy.scaleDeployment(fsvc.Name, 0)
+	if err != nil {
+		return err
+	}
+	deploy.scaledDown[string(fsvc.Function.UID)] = fsvc
+	return nil
+}
+
+func (deploy *NewDeploy) fnCreate(ctx context.Context, fn *crd.Function) (*fscache.FuncSvc, error) {
 	fsvc, err := deploy.fsCache.GetByFunction(&fn.Metadata)
 	if err == nil {
 		return fsvc, err
@@ -245,7 +442,7 @@ func (deploy *NewDeploy) fnCreate(fn *crd.Function) (*fscache.FuncSvc, error) {
 		"executorType":                    fission.ExecutorTypeNewdeploy,
 	}
 
-	depl, err := deploy.createOrGetDeployment(fn, env, objName, deployLabels)
+	depl, err := deploy.createOrGetDeployment(ctx, fn, env, objName, deployLabels)
 	if err != nil {
 		log.Printf("Error creating the deployment %v: %v", objName, err)
 		return fsvc, err
@@ -298,7 +495,7 @@ func (deploy *NewDeploy) fnCreate(fn *crd.Function) (*fscache.FuncSvc, error) {
 		Environment:       env,
 		Address:           svcAddress,
 		KubernetesObjects: kubeObjRefs,
-		Executor:          fscache.NEWDEPLOY,
+		Executor:          fission.ExecutorTypeNewdeploy,
 	}
 
 	_, err = deploy.fsCache.Add(*fsvc)
@@ -324,7 +521,7 @@ func (deploy *NewDeploy) fnDelete(fn *crd.Function) (*fscache.FuncSvc, error) {
 			delError = err
 		}
 	}
-	objName := fsvc.Name
+	objName := deploy.getObjName(fn)
 
 	err = deploy.deleteDeployment(deploy.namespace, objName)
 	if err != nil {
@@ -350,6 +547,97 @@ func (deploy *NewDeploy) fnDelete(fn *crd.Function) (*fscache.FuncSvc, error) {
 	return nil, nil
 }
 
+func (deploy *NewDeploy) fnUpdate(oldFn *crd.Function, newFn *crd.Function) error {
+	wasNewdeploy := oldFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeNewdeploy
+	isNewdeploy := newFn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType == fission.ExecutorTypeNewdeploy
+
+	if wasNewdeploy && !isNewdeploy {
+		// Switched to poolmgr, which specializes a pod on the next request
+		log.Printf("Function %v switched executor type, deleting its deployment", newFn.Metadata.Name)
+		_, err := deploy.fnDelete(oldFn)
+		return err
+	}
+	if !wasNewdeploy {
+		// Switched from poolmgr.  The pods specialized for the old
+		// function get no more requests and are reaped once idle.
+		if newFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale <= 0 {
+			return nil
+		}
+		_, err := deploy.fnCreate(context.Background(), newFn)
+		return err
+	}
+
+	env, err := deploy.fissionClient.
+		Environments(newFn.Spec.Environment.Namespace).
+		Get(newFn.Spec.Environment.Name)
+	if err != nil {
+		return err
+	}
+	return deploy.updateFuncObjects(oldFn, newFn, env)
+}
+
+// updateFuncObjects brings the deployment and HPA of a newdeploy function
+// in line with its updated spec, and moves its cache entry over to the
+// new version of the function.
+func (deploy *NewDeploy) updateFuncObjects(oldFn *crd.Function, newFn *crd.Function, env *crd.Environment) error {
+	objName := deploy.getObjName(oldFn)
+
+	_, err := deploy.kubernetesClient.ExtensionsV1beta1().Deployments(deploy.namespace).Get(objName, metav1.GetOptions{})
+	if err != nil {
+		if !k8s_err.IsNotFound(err) {
+			return err
+		}
+		// Not created yet; nothing to update
+		if newFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale <= 0 {
+			return nil
+		}
+		_, err = deploy.fnCreate(context.Background(), newFn)
+		return err
+	}
+
+	if !reflect.DeepEqual(oldFn.Spec.Package, newFn.Spec.Package) ||
+		!reflect.DeepEqual(oldFn.Spec.Resources, newFn.Spec.Resources) ||
+		oldFn.Spec.Environment != newFn.Spec.Environment ||
+		!reflect.DeepEqual(oldFn.Spec.Secrets, newFn.Spec.Secrets) ||
+		!reflect.DeepEqual(oldFn.Spec.ConfigMaps, newFn.Spec.ConfigMaps) ||
+		!reflect.DeepEqual(oldFn.Spec.Env, newFn.Spec.Env) ||
+		!reflect.DeepEqual(oldFn.Spec.PodTemplate, newFn.Spec.PodTemplate) ||
+		oldFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale != newFn.Spec.InvokeStrategy.ExecutionStrategy.MinScale {
+		log.Printf("Rolling deployment %v to the updated function", objName)
+		_, err = deploy.updateDeployment(newFn, env, objName)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldStrategy := &oldFn.Spec.InvokeStrategy.ExecutionStrategy
+	newStrategy := &newFn.Spec.InvokeStrategy.ExecutionStrategy
+	if oldStrategy.MinScale != newStrategy.MinScale ||
+		oldStrategy.MaxScale != newStrategy.MaxScale ||
+		oldStrategy.TargetCPUPercent != newStrategy.TargetCPUPercent {
+		_, err = deploy.updateHpa(objName, newStrategy)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Requests for the new version of the function go to the same service
+	fsvc, err := deploy.fsCache.GetByFunction(&oldFn.Metadata)
+	if err != nil {
+		// not cached, the next request for the function adds it
+		return nil
+	}
+	_, err = deploy.fsCache.DeleteOld(fsvc, time.Second*0)
+	if err != nil {
+		return err
+	}
+	fsvc.Function = &newFn.Metadata
+	fsvc.Environment = env
+	fsvc.Addresses = nil
+	_, err = deploy.fsCache.Add(*fsvc)
+	return err
+}
+
 func (deploy *NewDeploy) getObjName(fn *crd.Function) string {
 	return fmt.Sprintf("%v-%v",
 		fn.Metadata.Name,
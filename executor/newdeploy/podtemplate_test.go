@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package newdeploy
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/testutil"
+)
+
+func renderTestDeployment(env *crd.Environment, fn *crd.Function) (*v1beta1.Deployment, error) {
+	deploy := MakeNewDeploy(nil, fake.NewSimpleClientset(), testNamespace, fscache.MakeFunctionServiceCache(), "test")
+	deployLabels := map[string]string{
+		"functionName": fn.Metadata.Name,
+		"functionUid":  string(fn.Metadata.UID),
+		"executorType": fission.ExecutorTypeNewdeploy,
+	}
+	return deploy.getDeploymentSpec(fn, env, deploy.getObjName(fn), deployLabels)
+}
+
+func TestDeploymentSpecGolden(t *testing.T) {
+	depl, err := renderTestDeployment(makeTestEnv(), makeTestFunction("1", "hello-pkg", 1, 3))
+	if err != nil {
+		t.Fatalf("error rendering deployment: %v", err)
+	}
+	testutil.CheckGoldenDeployment(t, "deployment", depl)
+}
+
+func TestDeploymentSpecPodTemplateGolden(t *testing.T) {
+	runAsNonRoot := true
+	env := makeTestEnv()
+	env.Spec.PodTemplate = &apiv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"sidecar.istio.io/inject": "true"},
+		},
+		Spec: apiv1.PodSpec{
+			NodeSelector: map[string]string{"disktype": "ssd"},
+			Tolerations: []apiv1.Toleration{
+				{Key: "dedicated", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule},
+			},
+		},
+	}
+
+	fn := makeTestFunction("1", "hello-pkg", 1, 3)
+	fn.Spec.PodTemplate = &apiv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			// can't take over the deployment's selector
+			Labels: map[string]string{"functionName": "other", "team": "web"},
+		},
+		Spec: apiv1.PodSpec{
+			ServiceAccountName: "hello",
+			Volumes: []apiv1.Volume{
+				{
+					Name: "certs",
+					VolumeSource: apiv1.VolumeSource{
+						ConfigMap: &apiv1.ConfigMapVolumeSource{
+							LocalObjectReference: apiv1.LocalObjectReference{Name: "ca-certs"},
+						},
+					},
+				},
+			},
+			Containers: []apiv1.Container{
+				{
+					Name:            "hello",
+					SecurityContext: &apiv1.SecurityContext{RunAsNonRoot: &runAsNonRoot},
+				},
+				{
+					Name:         "proxy",
+					Image:        "envoyproxy/envoy",
+					VolumeMounts: []apiv1.VolumeMount{{Name: "certs", MountPath: "/etc/certs"}},
+				},
+			},
+		},
+	}
+
+	depl, err := renderTestDeployment(env, fn)
+	if err != nil {
+		t.Fatalf("error rendering deployment: %v", err)
+	}
+	testutil.CheckGoldenDeployment(t, "deployment-podtemplate", depl)
+}
+
+func TestDeploymentSpecInvalidPodTemplate(t *testing.T) {
+	fn := makeTestFunction("1", "hello-pkg", 1, 3)
+	fn.Spec.PodTemplate = &apiv1.PodTemplateSpec{
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{Name: "sidecar"}},
+		},
+	}
+	_, err := renderTestDeployment(makeTestEnv(), fn)
+	if err == nil {
+		t.Fatalf("expected a container without an image to be rejected")
+	}
+}
+
+func TestDeploymentSpecEnvPodTemplateRuntime(t *testing.T) {
+	// the environment's template patches the runtime container by
+	// the environment's name, as it does in pools
+	env := makeTestEnv()
+	env.Spec.PodTemplate = &apiv1.PodTemplateSpec{
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{
+					Name: env.Metadata.Name,
+					Env:  []apiv1.EnvVar{{Name: "NODE_ENV", Value: "production"}},
+				},
+			},
+		},
+	}
+	fn := makeTestFunction("1", "hello-pkg", 1, 3)
+
+	depl, err := renderTestDeployment(env, fn)
+	if err != nil {
+		t.Fatalf("error rendering deployment: %v", err)
+	}
+	containers := depl.Spec.Template.Spec.Containers
+	if len(containers) != 2 {
+		t.Fatalf("expected the runtime and fetcher containers only, got %v", len(containers))
+	}
+	runtime := containers[0]
+	if runtime.Name != fn.Metadata.Name || runtime.Image != env.Spec.Runtime.Image {
+		t.Fatalf("expected the runtime container to be named after the function, got %v (%v)", runtime.Name, runtime.Image)
+	}
+	found := false
+	for _, e := range runtime.Env {
+		if e.Name == "NODE_ENV" && e.Value == "production" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the environment's template to patch the runtime container: %v", runtime.Env)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// how often pools are resized
+	poolAutoscaleInterval = 10 * time.Second
+
+	// A pool is scaled up as soon as more pods are needed, but only
+	// scaled down once its recommended size has stayed below the
+	// current size for this long.  This keeps the pool from flapping
+	// between bursts.
+	poolScaleDownStabilization = 2 * time.Minute
+
+	// weight of the latest interval in the specialization rate average
+	poolDemandSmoothing = 0.5
+
+	// warm pods to keep per specialization expected in the next interval
+	poolDemandHeadroom = 1.5
+)
+
+type (
+	// poolAutoscaler recommends the number of warm generic pods for a
+	// pool, from the rate at which the pool's pods get specialized.
+	poolAutoscaler struct {
+		minReplicas int32
+		maxReplicas int32
+
+		// moving average of specializations per interval
+		demand float64
+
+		// recommendations within the scale down stabilization window
+		recommendations []poolRecommendation
+	}
+	poolRecommendation struct {
+		time     time.Time
+		replicas int32
+	}
+)
+
+func makePoolAutoscaler(minReplicas, maxReplicas int) *poolAutoscaler {
+	if minReplicas <= 0 {
+		minReplicas = 1
+	}
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+	return &poolAutoscaler{
+		minReplicas: int32(minReplicas),
+		maxReplicas: int32(maxReplicas),
+	}
+}
+
+func (pa *poolAutoscaler) clamp(replicas int32) int32 {
+	if replicas < pa.minReplicas {
+		return pa.minReplicas
+	}
+	if replicas > pa.maxReplicas {
+		return pa.maxReplicas
+	}
+	return replicas
+}
+
+// recommend returns the pool size for the next interval. current is
+// the pool's size, idle the number of ready generic pods, specialized
+// the number of pods chosen from the pool during the last interval and
+// waiting the number of requests currently waiting for a pod.
+func (pa *poolAutoscaler) recommend(now time.Time, current int32, idle, specialized, waiting int) int32 {
+	pa.demand = poolDemandSmoothing*float64(specialized) + (1-poolDemandSmoothing)*pa.demand
+	desired := int32(math.Ceil(pa.demand * poolDemandHeadroom))
+
+	// The pool ran dry and requests are waiting for pods: grow by at
+	// least the shortfall.
+	if idle == 0 && waiting > 0 && current+int32(waiting) > desired {
+		desired = current + int32(waiting)
+	}
+	desired = pa.clamp(desired)
+
+	// forget recommendations older than the stabilization window
+	pa.recommendations = append(pa.recommendations, poolRecommendation{time: now, replicas: desired})
+	i := 0
+	for i < len(pa.recommendations) && now.Sub(pa.recommendations[i].time) > poolScaleDownStabilization {
+		i++
+	}
+	pa.recommendations = pa.recommendations[i:]
+
+	if desired >= current {
+		return desired
+	}
+
+	// Scale down only as far as the largest recent recommendation.
+	for _, r := range pa.recommendations {
+		if r.replicas > desired {
+			desired = r.replicas
+		}
+	}
+	if desired > current {
+		return current
+	}
+	return desired
+}
+
+// autoscaleService periodically resizes the pool, until the pool is
+// destroyed.
+func (gp *GenericPool) autoscaleService() {
+	ticker := time.NewTicker(poolAutoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gp.stopCh:
+			return
+		case <-ticker.C:
+			err := gp.autoscale(time.Now())
+			if err != nil {
+				log.Printf("[%v] Error autoscaling pool: %v", gp.env.Metadata.Name, err)
+			}
+		}
+	}
+}
+
+// autoscale resizes the pool's deployment to the autoscaler's
+// recommendation. Only called from autoscaleService (and tests).
+func (gp *GenericPool) autoscale(now time.Time) error {
+	idle := gp.getReadyPodCount()
+	specialized := int(atomic.SwapInt32(&gp.specializations, 0))
+	waiting := int(atomic.LoadInt32(&gp.waiting))
+
+	replicas := gp.autoscaler.recommend(now, gp.replicas, idle, specialized, waiting)
+	if replicas == gp.replicas {
+		return nil
+	}
+
+	log.Printf("[%v] Resizing pool from %v to %v (%v idle, %v specialized, %v waiting)",
+		gp.env.Metadata.Name, gp.replicas, replicas, idle, specialized, waiting)
+
+	deployments := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace)
+	depl, err := deployments.Get(gp.deployment.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	depl.Spec.Replicas = &replicas
+	_, err = deployments.Update(depl)
+	if err != nil {
+		return err
+	}
+	gp.replicas = replicas
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+const testNamespace = "fission-function"
+
+func TestPoolAutoscalerHysteresis(t *testing.T) {
+	pa := makePoolAutoscaler(2, 10)
+	now := time.Now()
+	current := int32(2)
+
+	// no demand: stay at the minimum
+	current = pa.recommend(now, current, 2, 0, 0)
+	if current != 2 {
+		t.Fatalf("expected pool to stay at 2, got %v", current)
+	}
+
+	// a burst scales up right away
+	now = now.Add(poolAutoscaleInterval)
+	current = pa.recommend(now, current, 0, 6, 0)
+	if current != 5 {
+		t.Fatalf("expected pool to grow to 5 after burst, got %v", current)
+	}
+
+	// a bigger burst is capped at the maximum
+	now = now.Add(poolAutoscaleInterval)
+	current = pa.recommend(now, current, 0, 30, 4)
+	if current != 10 {
+		t.Fatalf("expected pool to grow to the maximum 10, got %v", current)
+	}
+
+	// the pool keeps its size while the burst is recent
+	for i := 0; i < 5; i++ {
+		now = now.Add(poolAutoscaleInterval)
+		current = pa.recommend(now, current, int(current), 0, 0)
+		if current != 10 {
+			t.Fatalf("expected pool to stay at 10 within the stabilization window, got %v", current)
+		}
+	}
+
+	// and shrinks back to the minimum once demand has stayed low
+	for i := 0; i < int(poolScaleDownStabilization/poolAutoscaleInterval)+1; i++ {
+		now = now.Add(poolAutoscaleInterval)
+		current = pa.recommend(now, current, int(current), 0, 0)
+	}
+	if current != 2 {
+		t.Fatalf("expected pool to shrink to 2, got %v", current)
+	}
+}
+
+func TestPoolAutoscalerDrainedPool(t *testing.T) {
+	pa := makePoolAutoscaler(1, 20)
+	now := time.Now()
+
+	// requests waiting on an empty pool grow it by the shortfall even
+	// before any pod was specialized
+	replicas := pa.recommend(now, 3, 0, 0, 7)
+	if replicas != 10 {
+		t.Fatalf("expected drained pool to grow to 10, got %v", replicas)
+	}
+}
+
+func makeTestPod(name string, podLabels map[string]string, ready bool) *apiv1.Pod {
+	pod := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    podLabels,
+		},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodPending,
+		},
+	}
+	if ready {
+		pod.Status.Phase = apiv1.PodRunning
+		pod.Status.PodIP = "10.0.0.1"
+		pod.Status.ContainerStatuses = []apiv1.ContainerStatus{{Ready: true}, {Ready: true}}
+	}
+	return pod
+}
+
+func getPoolReplicas(t *testing.T, gp *GenericPool) int32 {
+	depl, err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(
+		gp.deployment.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting pool deployment: %v", err)
+	}
+	return *depl.Spec.Replicas
+}
+
+func TestGenericPoolAutoscale(t *testing.T) {
+	kubernetesClient := fake.NewSimpleClientset()
+	env := &crd.Environment{
+		Metadata: metav1.ObjectMeta{
+			Name:      "nodejs",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "1234",
+		},
+		Spec: fission.EnvironmentSpec{
+			Version:     1,
+			Runtime:     fission.Runtime{Image: "fission/node-env"},
+			Poolsize:    3,
+			MinPoolsize: 2,
+			MaxPoolsize: 8,
+		},
+	}
+
+	gp, err := MakeGenericPool(nil, kubernetesClient, env, int32(env.Spec.Poolsize), testNamespace, nil, "test")
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	defer close(gp.stopCh)
+
+	if gp.autoscaler == nil {
+		t.Fatalf("expected pool to be autoscaled")
+	}
+	if replicas := getPoolReplicas(t, gp); replicas != 3 {
+		t.Fatalf("expected initial pool size 3, got %v", replicas)
+	}
+
+	// three warm pods, one of them still starting
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 3; i++ {
+		pod := makeTestPod(fmt.Sprintf("pod-%v", i), gp.labelsForPool, i < 2)
+		gp.podChanged(pod)
+		pods = append(pods, pod)
+	}
+
+	// a burst of specializations grows the pool
+	now := time.Now()
+	atomic.StoreInt32(&gp.specializations, 9)
+	err = gp.autoscale(now)
+	if err != nil {
+		t.Fatalf("error autoscaling: %v", err)
+	}
+	if replicas := getPoolReplicas(t, gp); replicas != 7 {
+		t.Fatalf("expected pool to grow to 7, got %v", replicas)
+	}
+	if atomic.LoadInt32(&gp.specializations) != 0 {
+		t.Fatalf("expected specialization count to be reset")
+	}
+
+	// a second burst on a drained pool, with requests waiting
+	now = now.Add(poolAutoscaleInterval)
+	atomic.StoreInt32(&gp.specializations, 4)
+	atomic.StoreInt32(&gp.waiting, 3)
+	for _, pod := range pods {
+		gp.podDeleted(pod)
+	}
+	err = gp.autoscale(now)
+	if err != nil {
+		t.Fatalf("error autoscaling: %v", err)
+	}
+	if replicas := getPoolReplicas(t, gp); replicas != 8 {
+		t.Fatalf("expected pool to grow to the maximum 8, got %v", replicas)
+	}
+	atomic.StoreInt32(&gp.waiting, 0)
+
+	// quiet period: no change until the stabilization window passes
+	end := now.Add(poolScaleDownStabilization)
+	for now.Before(end) {
+		now = now.Add(poolAutoscaleInterval)
+		err = gp.autoscale(now)
+		if err != nil {
+			t.Fatalf("error autoscaling: %v", err)
+		}
+		if now.Before(end) {
+			if replicas := getPoolReplicas(t, gp); replicas != 8 {
+				t.Fatalf("pool shrank to %v within the stabilization window", replicas)
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		now = now.Add(poolAutoscaleInterval)
+		err = gp.autoscale(now)
+		if err != nil {
+			t.Fatalf("error autoscaling: %v", err)
+		}
+	}
+	if replicas := getPoolReplicas(t, gp); replicas != 2 {
+		t.Fatalf("expected pool to shrink to the minimum 2, got %v", replicas)
+	}
+}
+
+func TestGenericPoolFixedSize(t *testing.T) {
+	env := &crd.Environment{
+		Metadata: metav1.ObjectMeta{
+			Name:      "python",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "5678",
+		},
+		Spec: fission.EnvironmentSpec{
+			Version:  1,
+			Runtime:  fission.Runtime{Image: "fission/python-env"},
+			Poolsize: 3,
+		},
+	}
+
+	gp, err := MakeGenericPool(nil, fake.NewSimpleClientset(), env, int32(env.Spec.Poolsize), testNamespace, nil, "test")
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	defer close(gp.stopCh)
+
+	if gp.autoscaler != nil {
+		t.Fatalf("pool without a maximum size should not be autoscaled")
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	k8s_err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// Specialized pods are deleted after being idle this long, unless
+// their environment sets an IdlePodTTL.
+const defaultIdlePodReapTime = 2 * time.Minute
+
+// Pods specialized for an old version of a function are deleted once
+// the routers report no requests in flight for it, or at the end of
+// the drain grace period, unless POOLMGR_DRAIN_GRACE_PERIOD says
+// otherwise.  drainSettleTime gives the routers, which report every
+// few seconds, time to report the requests in flight at the drain.
+const (
+	drainSettleTime         = 15 * time.Second
+	defaultDrainGracePeriod = 5 * time.Minute
+)
+
+func init() {
+	backend.Register(fission.ExecutorTypePoolmgr, MakeBackend)
+}
+
+// MakeBackend makes the pool manager executor backend.
+func MakeBackend(config *backend.Config) (backend.ExecutorBackend, error) {
+	return MakeGenericPoolManager(
+		config.FissionClient, config.KubernetesClient, config.FissionNamespace,
+		config.FunctionNamespace, config.FsCache, config.InstanceID), nil
+}
+
+// GetFuncSvc specializes a pod for fn, from the environment's pool or
+// from the sub-pool for fn's resources.
+func (gpm *GenericPoolManager) GetFuncSvc(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	pool, err := gpm.getFunctionPool(fn, env)
+	if err != nil {
+		return nil, err
+	}
+	// from GenericPool -> get one function container
+	// (this also adds to the cache)
+	log.Printf("[%v] getting function service from pool", fn.Metadata.Name)
+	return pool.GetFuncSvc(ctx, &fn.Metadata)
+}
+
+// ScaleOut specializes another pod for a busy function.
+func (gpm *GenericPoolManager) ScaleOut(ctx context.Context, fn *crd.Function, env *crd.Environment) (*fscache.FuncSvc, error) {
+	pool, err := gpm.getFunctionPool(fn, env)
+	if err != nil {
+		return nil, err
+	}
+	return pool.ScaleOut(ctx, &fn.Metadata)
+}
+
+// CountPods returns 1 for the pod of a function service, or a share
+// of it if the environment loads several functions per pod.
+func (gpm *GenericPoolManager) CountPods(fsvc *fscache.FuncSvc) (float64, error) {
+	if fsvc.Environment == nil ||
+		fsvc.Environment.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite {
+		return 1, nil
+	}
+	fsvcs, err := gpm.fsCache.List()
+	if err != nil {
+		return 0, err
+	}
+	// function services of the same pod share its name
+	sharing := 0
+	for _, other := range fsvcs {
+		if other.Name == fsvc.Name {
+			sharing++
+		}
+	}
+	if sharing == 0 {
+		return 1, nil
+	}
+	return 1 / float64(sharing), nil
+}
+
+func (gpm *GenericPoolManager) TapService(fsvc *fscache.FuncSvc, address string) error {
+	return gpm.fsCache.TouchByAddress(address)
+}
+
+// OnFunctionUpdate drains the pods specialized for the old version of
+// an updated or deleted function: they get no new requests, and are
+// deleted by Reap once the requests they got are done.  Pods for the
+// new version are specialized on request.
+func (gpm *GenericPoolManager) OnFunctionUpdate(oldFn *crd.Function, newFn *crd.Function) {
+	if oldFn == nil {
+		return
+	}
+	drained, err := gpm.fsCache.Drain(&oldFn.Metadata)
+	if err != nil {
+		log.Printf("[%v] Error draining function pods: %v", oldFn.Metadata.Name, err)
+		return
+	}
+	for _, fsvc := range drained {
+		log.Printf("[%v] Draining pod %v of old function version %v",
+			oldFn.Metadata.Name, fsvc.Name, oldFn.Metadata.ResourceVersion)
+	}
+}
+
+// getDrainGracePeriod returns how long the pods of old function
+// versions may keep serving, from POOLMGR_DRAIN_GRACE_PERIOD.
+func getDrainGracePeriod() time.Duration {
+	value := os.Getenv("POOLMGR_DRAIN_GRACE_PERIOD")
+	if len(value) == 0 {
+		return defaultDrainGracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod < 0 {
+		log.Printf("Invalid POOLMGR_DRAIN_GRACE_PERIOD '%v', using %v", value, defaultDrainGracePeriod)
+		return defaultDrainGracePeriod
+	}
+	return gracePeriod
+}
+
+// Reap deletes specialized pods that have been idle for longer than
+// their environment's IdlePodTTL, and draining pods that are done.
+func (gpm *GenericPoolManager) Reap() {
+	gpm.reapDrained()
+
+	envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Failed to get environment list: %v", err)
+		return
+	}
+
+	for i := range envs.Items {
+		env := envs.Items[i]
+		if env.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+			continue
+		}
+		idleTime := defaultIdlePodReapTime
+		if env.Spec.IdlePodTTL.Duration > 0 {
+			idleTime = env.Spec.IdlePodTTL.Duration
+		}
+		funcSvcs, err := gpm.fsCache.ListOld(&env.Metadata, idleTime)
+		if err != nil {
+			log.Printf("Error reaping idle pods: %v", err)
+			continue
+		}
+
+		for _, fsvc := range funcSvcs {
+			if fsvc.Executor != fission.ExecutorTypePoolmgr {
+				continue
+			}
+			gpm.releaseFuncSvc(fsvc, idleTime)
+		}
+	}
+}
+
+// reapDrained deletes the pods of old function versions whose requests
+// are done, or whose grace period is over.  Pods shared by
+// several functions are only uncached.
+func (gpm *GenericPoolManager) reapDrained() {
+	drained, err := gpm.fsCache.ListDrained(drainSettleTime, gpm.drainGracePeriod)
+	if err != nil {
+		log.Printf("Error listing draining pods: %v", err)
+		return
+	}
+	for _, fsvc := range drained {
+		if fsvc.Executor != fission.ExecutorTypePoolmgr {
+			continue
+		}
+		log.Printf("[%v] Releasing drained pod %v", fsvc.Function.Name, fsvc.Name)
+		if fsvc.Environment != nil &&
+			fsvc.Environment.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+			_, err = gpm.fsCache.DeleteOld(fsvc, 0)
+			if err != nil {
+				log.Printf("Error uncaching drained function service %v: %v", fsvc.Name, err)
+			}
+			continue
+		}
+		gpm.releaseFuncSvc(fsvc, 0)
+	}
+}
+
+// Evict deletes a specialized pod right away.
+func (gpm *GenericPoolManager) Evict(fsvc *fscache.FuncSvc) error {
+	gpm.releaseFuncSvc(fsvc, 0)
+	return nil
+}
+
+// releaseFuncSvc uncaches a function service that's been idle for at
+// least minAge, and deletes its pod.
+func (gpm *GenericPoolManager) releaseFuncSvc(fsvc *fscache.FuncSvc, minAge time.Duration) {
+	deleted, err := gpm.fsCache.DeleteOld(fsvc, minAge)
+	if err != nil {
+		log.Printf("Error deleting Kubernetes objects for fsvc '%v': %v", fsvc, err)
+		log.Printf("Object Name| Object Kind | Object Namespace")
+		for _, kubeobj := range fsvc.KubernetesObjects {
+			log.Printf("%v | %v | %v", kubeobj.Name, kubeobj.Kind, kubeobj.Namespace)
+		}
+	}
+
+	if !deleted {
+		return
+	}
+	for _, kubeobj := range fsvc.KubernetesObjects {
+		gpm.deleteKubeobject(&kubeobj)
+	}
+}
+
+// deleteKubeobject deletes a specialized pod, or the service in front
+// of it.
+func (gpm *GenericPoolManager) deleteKubeobject(kubeobj *api.ObjectReference) {
+	var err error
+	switch strings.ToLower(kubeobj.Kind) {
+	case "pod":
+		err = gpm.kubernetesClient.CoreV1().Pods(kubeobj.Namespace).Delete(kubeobj.Name, nil)
+	case "service":
+		err = gpm.kubernetesClient.CoreV1().Services(kubeobj.Namespace).Delete(kubeobj.Name, nil)
+	default:
+		log.Printf("There was an error identifying the object type: %v for obj: %v", kubeobj.Kind, kubeobj)
+		return
+	}
+	if err != nil && !k8s_err.IsNotFound(err) {
+		log.Printf("Error cleaning up %v %v: %v", kubeobj.Kind, kubeobj.Name, err)
+	}
+}
+
+// Cleanup deletes the pools of deleted environments left behind by an
+// earlier executor.
+func (gpm *GenericPoolManager) Cleanup() error {
+	envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	return gpm.cleanupOrphanedPools(envs.Items)
+}
+
+func (gpm *GenericPoolManager) cleanupOrphanedPools(envs []crd.Environment) error {
+	envUids := make(map[string]bool)
+	for _, env := range envs {
+		envUids[string(env.Metadata.UID)] = true
+	}
+
+	deplList, err := gpm.kubernetesClient.ExtensionsV1beta1().Deployments(gpm.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			fission.EXECUTOR_INSTANCEID_LABEL: gpm.instanceId,
+			"executorType":                    fission.ExecutorTypePoolmgr,
+		}).AsSelector().String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range deplList.Items {
+		depl := &deplList.Items[i]
+		if envUids[depl.ObjectMeta.Labels["environmentUid"]] {
+			continue
+		}
+		log.Printf("Cleaning up orphaned pool %v", depl.ObjectMeta.Name)
+		gpm.deleteOrphanedPool(depl)
+	}
+	return nil
+}
+
+// deleteOrphanedPool deletes a pool deployment along with its
+// ReplicaSets and pods, like GenericPool.destroy.
+func (gpm *GenericPoolManager) deleteOrphanedPool(depl *v1beta1.Deployment) {
+	err := gpm.kubernetesClient.ExtensionsV1beta1().Deployments(gpm.namespace).Delete(depl.ObjectMeta.Name, nil)
+	if err != nil {
+		log.Printf("Error deleting deployment %v: %v", depl.ObjectMeta.Name, err)
+	}
+
+	selector := metav1.ListOptions{LabelSelector: labels.Set(depl.ObjectMeta.Labels).AsSelector().String()}
+	rsList, err := gpm.kubernetesClient.ExtensionsV1beta1().ReplicaSets(gpm.namespace).List(selector)
+	if err != nil {
+		log.Printf("Error getting replicasets for deployment %v: %v", depl.ObjectMeta.Name, err)
+	} else {
+		for _, rs := range rsList.Items {
+			err = gpm.kubernetesClient.ExtensionsV1beta1().ReplicaSets(gpm.namespace).Delete(rs.ObjectMeta.Name, nil)
+			if err != nil {
+				log.Printf("Error deleting replicaset, ignoring: %v", err)
+			}
+		}
+	}
+
+	podList, err := gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).List(selector)
+	if err != nil {
+		log.Printf("Error getting pods for deployment %v: %v", depl.ObjectMeta.Name, err)
+		return
+	}
+	for _, pod := range podList.Items {
+		err = gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).Delete(pod.ObjectMeta.Name, nil)
+		if err != nil {
+			log.Printf("Error deleting pod, ignoring: %v", err)
+		}
+	}
+}
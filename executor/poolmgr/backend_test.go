@@ -0,0 +1,165 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/backend"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func TestBackendRegistered(t *testing.T) {
+	if !backend.IsRegistered(fission.ExecutorTypePoolmgr) {
+		t.Fatalf("poolmgr backend isn't registered")
+	}
+}
+
+func TestCleanupOrphanedPools(t *testing.T) {
+	poolLabels := func(instanceId string, envUid string) map[string]string {
+		return map[string]string{
+			"executorType":                    fission.ExecutorTypePoolmgr,
+			"environmentUid":                  envUid,
+			fission.EXECUTOR_INSTANCEID_LABEL: instanceId,
+		}
+	}
+	client := fake.NewSimpleClientset(
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "live-pool", Namespace: testNamespace, Labels: poolLabels("abcd1234", "env-1")},
+		},
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphaned-pool", Namespace: testNamespace, Labels: poolLabels("abcd1234", "env-2")},
+		},
+		&apiv1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "orphaned-pool-pod", Namespace: testNamespace, Labels: poolLabels("abcd1234", "env-2")},
+		},
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "newdeploy-fn",
+				Namespace: testNamespace,
+				Labels: map[string]string{
+					"executorType":                    fission.ExecutorTypeNewdeploy,
+					"functionUid":                     "fn-1",
+					fission.EXECUTOR_INSTANCEID_LABEL: "abcd1234",
+				},
+			},
+		},
+	)
+	gpm := &GenericPoolManager{
+		kubernetesClient: client,
+		namespace:        testNamespace,
+		instanceId:       "abcd1234",
+	}
+
+	envs := []crd.Environment{{Metadata: metav1.ObjectMeta{Name: "env", UID: "env-1"}}}
+	err := gpm.cleanupOrphanedPools(envs)
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+
+	deplList, err := client.ExtensionsV1beta1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing deployments: %v", err)
+	}
+	remaining := make(map[string]bool)
+	for _, depl := range deplList.Items {
+		remaining[depl.ObjectMeta.Name] = true
+	}
+	if len(remaining) != 2 || !remaining["live-pool"] || !remaining["newdeploy-fn"] {
+		t.Fatalf("expected only the live pool and other executors' deployments to remain, got %v", remaining)
+	}
+
+	_, err = client.CoreV1().Pods(testNamespace).Get("orphaned-pool-pod", metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected pods of the orphaned pool to be cleaned up")
+	}
+}
+
+func TestDrainOnFunctionUpdate(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "busy", Namespace: testNamespace}},
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "quiet", Namespace: testNamespace}},
+	)
+	fsCache := fscache.MakeFunctionServiceCache()
+	gpm := &GenericPoolManager{
+		kubernetesClient: client,
+		namespace:        testNamespace,
+		fsCache:          fsCache,
+		drainGracePeriod: time.Hour,
+	}
+
+	env := &crd.Environment{Metadata: metav1.ObjectMeta{Name: "env", UID: "env-1"}}
+	makeFn := func(name string, resourceVersion string) *crd.Function {
+		return &crd.Function{Metadata: metav1.ObjectMeta{
+			Name: name, Namespace: metav1.NamespaceDefault, UID: "uid-" + name, ResourceVersion: resourceVersion,
+		}}
+	}
+	for _, name := range []string{"busy", "quiet"} {
+		_, err := fsCache.Add(fscache.FuncSvc{
+			Name:              name,
+			Function:          &makeFn(name, "1").Metadata,
+			Environment:       env,
+			Address:           name + ":8888",
+			KubernetesObjects: []api.ObjectReference{{Kind: "pod", Name: name, Namespace: testNamespace}},
+			Executor:          fission.ExecutorTypePoolmgr,
+		})
+		if err != nil {
+			t.Fatalf("error adding function service: %v", err)
+		}
+	}
+
+	for _, name := range []string{"busy", "quiet"} {
+		gpm.OnFunctionUpdate(makeFn(name, "1"), makeFn(name, "2"))
+		_, err := fsCache.GetByFunction(&makeFn(name, "1").Metadata)
+		if err == nil {
+			t.Fatalf("expected old version of %v to get no new requests", name)
+		}
+	}
+
+	// nothing is done draining yet
+	gpm.reapDrained()
+	for _, name := range []string{"busy", "quiet"} {
+		_, err := client.CoreV1().Pods(testNamespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected draining pod %v to be kept: %v", name, err)
+		}
+	}
+
+	// at the end of the grace period, even busy pods go
+	gpm.drainGracePeriod = 0
+	fsCache.ReportLoad(&makeFn("busy", "1").Metadata, "router", 1)
+	gpm.reapDrained()
+	for _, name := range []string{"busy", "quiet"} {
+		_, err := client.CoreV1().Pods(testNamespace).Get(name, metav1.GetOptions{})
+		if err == nil {
+			t.Fatalf("expected drained pod %v to be deleted", name)
+		}
+	}
+	all, err := fsCache.List()
+	if err != nil || len(all) != 0 {
+		t.Fatalf("expected drained function services to be uncached, got %v, %v", all, err)
+	}
+}
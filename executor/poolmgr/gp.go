@@ -18,27 +18,35 @@ package poolmgr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dchest/uniuri"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api"
 	apiv1 "k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	k8sCache "k8s.io/client-go/tools/cache"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
@@ -52,36 +60,74 @@ const POD_PHASE_RUNNING string = "Running"
 type (
 	GenericPool struct {
 		env                    *crd.Environment
+		profile                *resourceProfile              // resources of a sub-pool's pods; nil for the environment's pool
 		replicas               int32                         // num idle pods
 		deployment             *v1beta1.Deployment           // kubernetes deployment
 		namespace              string                        // namespace to keep our resources
-		podReadyTimeout        time.Duration                 // timeout for generic pods to become ready
-		idlePodReapTime        time.Duration                 // pods unused for idlePodReapTime are deleted
 		fsCache                *fscache.FunctionServiceCache // cache funcSvc's by function, address and podname
-		useSvc                 bool                          // create k8s service for specialized pods
 		poolInstanceId         string                        // small random string to uniquify pod names
 		fetcherImage           string
 		fetcherImagePullPolicy apiv1.PullPolicy
-		runtimeImagePullPolicy apiv1.PullPolicy // pull policy for generic pool to created env deployment
-		kubernetesClient       *kubernetes.Clientset
+		defaultPullPolicy      apiv1.PullPolicy // runtime image pull policy, unless the env sets one
+		tuningLock             sync.RWMutex
+		tuning                 poolTuning // guarded by tuningLock
+		kubernetesClient       kubernetes.Interface
 		fissionClient          *crd.FissionClient
 		instanceId             string // poolmgr instance id
 		labelsForPool          map[string]string
-		requestChannel         chan *choosePodRequest
-		sharedMountPath        string // used by generic pool when creating env deployment to specify the share volume path for fetcher & env
+		poolSelector           labels.Selector // matches the pool's unspecialized pods
+		envSelector            labels.Selector // matches all of the environment's pods, specialized or not
+		podSelector            podSelector     // picks pods to specialize
+		requestChannel         chan *poolRequest
+		sharedMountPath        string          // used by generic pool when creating env deployment to specify the share volume path for fetcher & env
+		autoscaler             *poolAutoscaler // resizes the pool; nil if the pool has a fixed size
+		specializations        int32           // pods chosen since the last autoscale (atomic)
+		waiting                int32           // choosePod calls in progress (atomic)
+		stopCh                 chan struct{}
+	}
+
+	// poolTuning is the part of the pool's configuration that follows
+	// updates of the environment without replacing the pool.
+	poolTuning struct {
+		env                    *crd.Environment // latest version of the environment
+		podReadyTimeout        time.Duration    // timeout for generic pods to become ready
+		useSvc                 bool             // create k8s service for specialized pods
+		specializeRetries      int              // retries of the specialize call while the runtime starts
+		runtimeImagePullPolicy apiv1.PullPolicy // pull policy for generic pool to created env deployment
 	}
 
-	// serialize the choosing of pods so that choices don't conflict
-	choosePodRequest struct {
+	// Requests to the pool's service goroutine.  It keeps track of
+	// the pool's ready pods and serializes the choosing of pods so
+	// that choices don't conflict.
+	poolRequest struct {
+		requestType     poolRequestType
 		newLabels       map[string]string
-		responseChannel chan *choosePodResponse
+		pod             *apiv1.Pod
+		waiter          *poolRequest // request to cancel
+		responseChannel chan *poolResponse
 	}
-	choosePodResponse struct {
-		pod *apiv1.Pod
+	poolResponse struct {
+		pod         *apiv1.Pod
+		relabelTime time.Duration
+		readyPods   int
 		error
 	}
 )
 
+type poolRequestType int
+
+// errPoolStopped fails calls waiting for a pod of a pool that was
+// stopped or destroyed.
+var errPoolStopped = errors.New("the pool was stopped")
+
+const (
+	CHOOSE_POD poolRequestType = iota
+	CANCEL_CHOOSE_POD
+	POD_CHANGED
+	POD_DELETED
+	GET_READY_POD_COUNT
+)
+
 func getImagePullPolicy(policy string) apiv1.PullPolicy {
 	switch policy {
 	case "Always":
@@ -93,21 +139,74 @@ func getImagePullPolicy(policy string) apiv1.PullPolicy {
 	}
 }
 
+func makePoolTuning(env *crd.Environment, defaultPullPolicy apiv1.PullPolicy) poolTuning {
+	tuning := poolTuning{
+		env:                    env,
+		podReadyTimeout:        5 * time.Minute,
+		useSvc:                 env.Spec.UseSvc, // defaults off -- svc takes a second or more to become routable, slowing cold start
+		specializeRetries:      20,
+		runtimeImagePullPolicy: defaultPullPolicy,
+	}
+	if env.Spec.ReadinessTimeout.Duration > 0 {
+		tuning.podReadyTimeout = env.Spec.ReadinessTimeout.Duration
+	}
+	if env.Spec.SpecializationRetries > 0 {
+		tuning.specializeRetries = env.Spec.SpecializationRetries
+	}
+	if len(env.Spec.ImagePullPolicy) > 0 {
+		tuning.runtimeImagePullPolicy = getImagePullPolicy(string(env.Spec.ImagePullPolicy))
+	}
+	return tuning
+}
+
+// getFetcherImage returns the image of the fetcher containers of pools,
+// from FETCHER_IMAGE.
+func getFetcherImage() string {
+	fetcherImage := os.Getenv("FETCHER_IMAGE")
+	if len(fetcherImage) == 0 {
+		fetcherImage = "fission/fetcher"
+	}
+	return fetcherImage
+}
+
+// getTuning returns the pool's current tuning parameters
+func (gp *GenericPool) getTuning() poolTuning {
+	gp.tuningLock.RLock()
+	defer gp.tuningLock.RUnlock()
+	return gp.tuning
+}
+
 func MakeGenericPool(
 	fissionClient *crd.FissionClient,
-	kubernetesClient *kubernetes.Clientset,
+	kubernetesClient kubernetes.Interface,
 	env *crd.Environment,
 	initialReplicas int32,
 	namespace string,
 	fsCache *fscache.FunctionServiceCache,
 	instanceId string) (*GenericPool, error) {
 
-	log.Printf("Creating pool for environment %v", env.Metadata)
+	return makeGenericPool(fissionClient, kubernetesClient, env, nil, initialReplicas, namespace, fsCache, instanceId)
+}
 
-	fetcherImage := os.Getenv("FETCHER_IMAGE")
-	if len(fetcherImage) == 0 {
-		fetcherImage = "fission/fetcher"
+// makeGenericPool makes the pool of an environment, or with a profile,
+// one of its sub-pools.
+func makeGenericPool(
+	fissionClient *crd.FissionClient,
+	kubernetesClient kubernetes.Interface,
+	env *crd.Environment,
+	profile *resourceProfile,
+	initialReplicas int32,
+	namespace string,
+	fsCache *fscache.FunctionServiceCache,
+	instanceId string) (*GenericPool, error) {
+
+	if profile != nil {
+		log.Printf("Creating pool for environment %v, resource profile %v", env.Metadata, profile.key)
+	} else {
+		log.Printf("Creating pool for environment %v", env.Metadata)
 	}
+
+	fetcherImage := getFetcherImage()
 	fetcherImagePullPolicy := os.Getenv("FETCHER_IMAGE_PULL_POLICY")
 	if len(fetcherImagePullPolicy) == 0 {
 		fetcherImagePullPolicy = "IfNotPresent"
@@ -117,30 +216,45 @@ func MakeGenericPool(
 		runtimeImagePullPolicy = "IfNotPresent"
 	}
 
-	// TODO: in general we need to provide the user a way to configure pools.  Initial
-	// replicas, autoscaling params, various timeouts, etc.
+	// Pools are configured through their environment; see poolTuning
+	// for the settings that can change while the pool is running.
 	gp := &GenericPool{
-		env:              env,
-		replicas:         initialReplicas, // TODO make this an env param instead?
-		requestChannel:   make(chan *choosePodRequest),
-		fissionClient:    fissionClient,
-		kubernetesClient: kubernetesClient,
-		namespace:        namespace,
-		podReadyTimeout:  5 * time.Minute, // TODO make this an env param?
-		idlePodReapTime:  3 * time.Minute, // TODO make this configurable
-		fsCache:          fsCache,
-		poolInstanceId:   uniuri.NewLen(8),
-		instanceId:       instanceId,
-		fetcherImage:     fetcherImage,
-		useSvc:           false,       // defaults off -- svc takes a second or more to become routable, slowing cold start
-		sharedMountPath:  "/userfunc", // change this may break v1 compatibility, since most of the v1 environments have hard-coded "/userfunc" in loading path
-	}
-
-	gp.runtimeImagePullPolicy = getImagePullPolicy(runtimeImagePullPolicy)
+		env:               env,
+		profile:           profile,
+		replicas:          initialReplicas, // TODO make this an env param instead?
+		requestChannel:    make(chan *poolRequest),
+		stopCh:            make(chan struct{}),
+		fissionClient:     fissionClient,
+		kubernetesClient:  kubernetesClient,
+		namespace:         namespace,
+		fsCache:           fsCache,
+		poolInstanceId:    uniuri.NewLen(8),
+		instanceId:        instanceId,
+		fetcherImage:      fetcherImage,
+		defaultPullPolicy: getImagePullPolicy(runtimeImagePullPolicy),
+		sharedMountPath:   "/userfunc", // change this may break v1 compatibility, since most of the v1 environments have hard-coded "/userfunc" in loading path
+	}
+	gp.tuning = makePoolTuning(env, gp.defaultPullPolicy)
+
+	podSelector, err := makePodSelector(env.Spec.PodSelectionStrategy)
+	if err != nil {
+		log.Printf("[%v] %v, using random pod selection", env.Metadata.Name, err)
+		podSelector = randomSelector{}
+	}
+	gp.podSelector = podSelector
 
 	gp.fetcherImagePullPolicy = getImagePullPolicy(fetcherImagePullPolicy)
 	log.Printf("fetcher image: %v, pull policy: %v", gp.fetcherImage, gp.fetcherImagePullPolicy)
 
+	// Pools of pods that get used up by functions can be autoscaled
+	if env.Spec.MaxPoolsize > 0 &&
+		env.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite {
+		gp.autoscaler = makePoolAutoscaler(env.Spec.MinPoolsize, env.Spec.MaxPoolsize)
+		gp.replicas = gp.autoscaler.clamp(gp.replicas)
+		log.Printf("[%v] Autoscaling pool between %v and %v pods",
+			env.Metadata.Name, gp.autoscaler.minReplicas, gp.autoscaler.maxReplicas)
+	}
+
 	// Labels for generic deployment/RS/pods.
 	gp.labelsForPool = map[string]string{
 		"environmentName":                 gp.env.Metadata.Name,
@@ -148,123 +262,317 @@ func MakeGenericPool(
 		fission.EXECUTOR_INSTANCEID_LABEL: gp.instanceId,
 		"executorType":                    fission.ExecutorTypePoolmgr,
 	}
+	if profile != nil {
+		gp.labelsForPool[RESOURCE_PROFILE_LABEL] = profile.key
+	}
+	gp.poolSelector = labels.SelectorFromSet(gp.labelsForPool)
+	if profile == nil {
+		// sub-pools' pods have all of this pool's labels, too
+		noProfile, err := labels.NewRequirement(RESOURCE_PROFILE_LABEL, selection.DoesNotExist, nil)
+		if err != nil {
+			return nil, err
+		}
+		gp.poolSelector = gp.poolSelector.Add(*noProfile)
+	}
+	gp.envSelector = labels.SelectorFromSet(map[string]string{
+		"environmentUid":                  string(gp.env.Metadata.UID),
+		fission.EXECUTOR_INSTANCEID_LABEL: gp.instanceId,
+	})
 
-	// create the pool
-	err := gp.createPool()
+	// adopt the pool left by a previous executor instance, or
+	// create one
+	adopted, err := gp.adoptPool()
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("[%v] Deployment created", env.Metadata)
+	if adopted {
+		log.Printf("[%v] Deployment adopted", env.Metadata)
+	} else {
+		err = gp.createPool()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[%v] Deployment created", env.Metadata)
+	}
 
 	go gp.choosePodService()
+	gp.startReadyPodController()
+	if gp.autoscaler != nil {
+		go gp.autoscaleService()
+	}
 
 	return gp, nil
 }
 
-// choosePodService serializes the choosing of pods
+// choosePodService keeps the set of ready, unspecialized pods in the
+// pool (as reported by the pod informer), and hands them out to
+// waiting choosePod calls in order.  It also keeps track of the nodes
+// that specialized pods run on, for the pool's pod selector.  It
+// returns when the pool is stopped, failing the calls still waiting.
 func (gp *GenericPool) choosePodService() {
+	readyPods := make(map[string]*apiv1.Pod)
+	waiters := make([]*poolRequest, 0)
+	usage := makeNodeUsage()
+
 	for {
+		var req *poolRequest
 		select {
-		case req := <-gp.requestChannel:
-			pod, err := gp._choosePod(req.newLabels)
+		case req = <-gp.requestChannel:
+		case <-gp.stopCh:
+			for _, w := range waiters {
+				w.responseChannel <- &poolResponse{error: errPoolStopped}
+			}
+			return
+		}
+		switch req.requestType {
+		case CHOOSE_POD:
+			waiters = append(waiters, req)
+		case CANCEL_CHOOSE_POD:
+			for i, w := range waiters {
+				if w == req.waiter {
+					waiters = append(waiters[:i], waiters[i+1:]...)
+					break
+				}
+			}
+			req.responseChannel <- &poolResponse{}
+		case POD_CHANGED:
+			pod := req.pod
+			alive := pod.ObjectMeta.DeletionTimestamp == nil &&
+				pod.Status.Phase != apiv1.PodSucceeded && pod.Status.Phase != apiv1.PodFailed
+			if alive && IsPodReady(pod) && gp.poolSelector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+				readyPods[pod.ObjectMeta.Name] = pod
+			} else {
+				delete(readyPods, pod.ObjectMeta.Name)
+			}
+			if fnUid, ok := pod.ObjectMeta.Labels["functionUid"]; ok && alive {
+				usage.podSpecialized(pod, fnUid, time.Now())
+			} else {
+				usage.podGone(pod.ObjectMeta.Name)
+			}
+		case POD_DELETED:
+			delete(readyPods, req.pod.ObjectMeta.Name)
+			usage.podGone(req.pod.ObjectMeta.Name)
+		case GET_READY_POD_COUNT:
+			req.responseChannel <- &poolResponse{readyPods: len(readyPods)}
+		}
+
+		// hand out ready pods to waiters
+		for len(waiters) > 0 && len(readyPods) > 0 {
+			relabelStart := time.Now()
+			pod, err := gp._choosePod(readyPods, waiters[0].newLabels, usage)
 			if err != nil {
-				req.responseChannel <- &choosePodResponse{error: err}
 				continue
 			}
-			req.responseChannel <- &choosePodResponse{pod: pod}
+			waiters[0].responseChannel <- &poolResponse{pod: pod, relabelTime: time.Since(relabelStart)}
+			waiters = waiters[1:]
 		}
 	}
 }
 
-// choosePod picks a ready pod from the pool and relabels it, waiting if necessary.
-// returns the pod API object.
-func (gp *GenericPool) choosePod(newLabels map[string]string) (*apiv1.Pod, error) {
-	req := &choosePodRequest{
-		newLabels:       newLabels,
-		responseChannel: make(chan *choosePodResponse),
+// choosePod picks a ready pod from the pool and relabels it, waiting if necessary,
+// until ctx is done.  returns the pod API object.  The time spent is
+// added to timeline, if it's not nil.
+func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]string, timeline *fission.ColdStart) (*apiv1.Pod, error) {
+	startTime := time.Now()
+	req := &poolRequest{
+		requestType: CHOOSE_POD,
+		newLabels:   newLabels,
+		// buffered, so that the service never blocks on a waiter
+		// that's giving up
+		responseChannel: make(chan *poolResponse, 1),
 	}
-	gp.requestChannel <- req
-	resp := <-req.responseChannel
-	return resp.pod, resp.error
-}
+	atomic.AddInt32(&gp.waiting, 1)
+	defer atomic.AddInt32(&gp.waiting, -1)
 
-// _choosePod is called serially by choosePodService
-func (gp *GenericPool) _choosePod(newLabels map[string]string) (*apiv1.Pod, error) {
-	startTime := time.Now()
-	for {
-		// Retries took too long, error out.
-		if time.Since(startTime) > gp.podReadyTimeout {
-			log.Printf("[%v] Erroring out, timed out", newLabels)
-			return nil, errors.New("timeout: waited too long to get a ready pod")
-		}
+	select {
+	case gp.requestChannel <- req:
+	case <-gp.stopCh:
+		return nil, errPoolStopped
+	}
 
-		// Get pods; filter the ones that are ready
-		podList, err := gp.kubernetesClient.CoreV1().Pods(gp.namespace).List(
-			metav1.ListOptions{
-				LabelSelector: labels.Set(
-					gp.deployment.Spec.Selector.MatchLabels).AsSelector().String(),
-			})
-		if err != nil {
-			return nil, err
+	var resp *poolResponse
+	var waitErr error
+	select {
+	case resp = <-req.responseChannel:
+	case <-time.After(gp.getTuning().podReadyTimeout):
+		waitErr = errors.New("timeout: waited too long to get a ready pod")
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+	if resp == nil {
+		// Stop waiting.  The service may have handed us a pod
+		// just before it saw the cancellation, or failed us
+		// because the pool stopped; use its response if so.
+		cancelResp := make(chan *poolResponse)
+		select {
+		case gp.requestChannel <- &poolRequest{
+			requestType:     CANCEL_CHOOSE_POD,
+			waiter:          req,
+			responseChannel: cancelResp,
+		}:
+			<-cancelResp
+		case <-gp.stopCh:
+		}
+		select {
+		case resp = <-req.responseChannel:
+		default:
+			log.Printf("[%v] Erroring out: %v", newLabels, waitErr)
+			return nil, waitErr
 		}
-		readyPods := make([]*apiv1.Pod, 0, len(podList.Items))
-		for i := range podList.Items {
-			pod := podList.Items[i]
+	}
+	if resp.error != nil {
+		log.Printf("[%v] Erroring out: %v", newLabels, resp.error)
+		return nil, resp.error
+	}
 
-			// If a pod has no IP it's not ready
-			if len(pod.Status.PodIP) == 0 || string(pod.Status.Phase) != POD_PHASE_RUNNING {
-				continue
-			}
+	atomic.AddInt32(&gp.specializations, 1)
+	log.Printf("Chosen pod: %v (in %v)", resp.pod.ObjectMeta.Name, time.Since(startTime))
+	if timeline != nil {
+		timeline.Pod = resp.pod.ObjectMeta.Name
+		timeline.ChoosePod = metav1.Duration{Duration: time.Since(startTime) - resp.relabelTime}
+		timeline.Relabel = metav1.Duration{Duration: resp.relabelTime}
+	}
+	return resp.pod, nil
+}
 
-			// Wait for all containers in the pod to be ready
-			podReady := true
-			for _, cs := range pod.Status.ContainerStatuses {
-				podReady = podReady && cs.Ready
-			}
+// _choosePod is called serially by choosePodService.  It picks a pod
+// from readyPods with the pool's pod selector, and relabels it unless
+// the pod is shared between functions.  Chosen or unusable pods are
+// removed from readyPods.
+func (gp *GenericPool) _choosePod(readyPods map[string]*apiv1.Pod, newLabels map[string]string,
+	usage *nodeUsage) (*apiv1.Pod, error) {
 
-			// add it to the list of ready pods
-			if podReady {
-				readyPods = append(readyPods, &pod)
-			}
-		}
-		log.Printf("[%v] found %v ready pods of %v total", newLabels, len(readyPods), len(podList.Items))
+	candidates := make([]*apiv1.Pod, 0, len(readyPods))
+	for _, pod := range readyPods {
+		candidates = append(candidates, pod)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ObjectMeta.Name < candidates[j].ObjectMeta.Name
+	})
+	now := time.Now()
+	chosenPod := gp.podSelector.selectPod(candidates, newLabels["functionUid"], usage, now)
 
-		// If there are no ready pods, wait and retry.
-		if len(readyPods) == 0 {
-			err = gp.waitForReadyPod()
-			if err != nil {
-				return nil, err
-			}
-			continue
-		}
+	if gp.env.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+		return chosenPod, nil
+	}
 
-		// Pick a ready pod.  For now just choose randomly;
-		// ideally we'd care about which node it's running on,
-		// and make a good scheduling decision.
-		chosenPod := readyPods[rand.Intn(len(readyPods))]
-
-		if gp.env.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite {
-			// Relabel.  If the pod already got picked and
-			// modified, this should fail; in that case just
-			// retry.
-			chosenPod.ObjectMeta.Labels = newLabels
-			log.Printf("relabeling pod: [%v]", chosenPod.ObjectMeta.Name)
-			_, err = gp.kubernetesClient.CoreV1().Pods(gp.namespace).Update(chosenPod)
-			if err != nil {
-				log.Printf("failed to relabel pod [%v]: %v", chosenPod.ObjectMeta.Name, err)
-				continue
-			}
+	// Relabel.  If the pod already got picked and modified, or
+	// our copy is stale, this fails; the informer will tell us
+	// about the pod's current state.  Pods from the informer are
+	// shared, so relabel a copy.
+	delete(readyPods, chosenPod.ObjectMeta.Name)
+	pod := *chosenPod
+	pod.ObjectMeta.Labels = newLabels
+	log.Printf("relabeling pod: [%v]", pod.ObjectMeta.Name)
+	_, err := gp.kubernetesClient.CoreV1().Pods(gp.namespace).Update(&pod)
+	if err != nil {
+		log.Printf("failed to relabel pod [%v]: %v", pod.ObjectMeta.Name, err)
+		return nil, err
+	}
+	usage.podSpecialized(&pod, newLabels["functionUid"], now)
+	return &pod, nil
+}
+
+// getReadyPodCount returns the number of ready, unspecialized pods in
+// the pool.
+func (gp *GenericPool) getReadyPodCount() int {
+	req := &poolRequest{
+		requestType:     GET_READY_POD_COUNT,
+		responseChannel: make(chan *poolResponse),
+	}
+	select {
+	case gp.requestChannel <- req:
+	case <-gp.stopCh:
+		return 0
+	}
+	resp := <-req.responseChannel
+	return resp.readyPods
+}
+
+// IsPodReady returns true if the pod has an IP and all its containers
+// are ready.
+func IsPodReady(pod *apiv1.Pod) bool {
+	if len(pod.Status.PodIP) == 0 || string(pod.Status.Phase) != POD_PHASE_RUNNING {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false
 		}
-		log.Printf("Chosen pod: %v (in %v)", chosenPod.ObjectMeta.Name, time.Since(startTime))
-		return chosenPod, nil
 	}
+	return true
+}
+
+// podChanged and podDeleted are called by the pool's pod informer
+func (gp *GenericPool) podChanged(pod *apiv1.Pod) {
+	select {
+	case gp.requestChannel <- &poolRequest{
+		requestType: POD_CHANGED,
+		pod:         pod,
+	}:
+	case <-gp.stopCh:
+	}
+}
+
+func (gp *GenericPool) podDeleted(pod *apiv1.Pod) {
+	select {
+	case gp.requestChannel <- &poolRequest{
+		requestType: POD_DELETED,
+		pod:         pod,
+	}:
+	case <-gp.stopCh:
+	}
+}
+
+// startReadyPodController watches the environment's pods, keeping the
+// pool's set of ready pods and the placement of specialized pods up to
+// date.
+func (gp *GenericPool) startReadyPodController() {
+	selector := gp.envSelector.String()
+	listWatch := &k8sCache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return gp.kubernetesClient.CoreV1().Pods(gp.namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return gp.kubernetesClient.CoreV1().Pods(gp.namespace).Watch(options)
+		},
+	}
+	resyncPeriod := 30 * time.Second
+	_, controller := k8sCache.NewInformer(listWatch, &apiv1.Pod{}, resyncPeriod,
+		k8sCache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				gp.podChanged(obj.(*apiv1.Pod))
+			},
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				gp.podChanged(newObj.(*apiv1.Pod))
+			},
+			DeleteFunc: func(obj interface{}) {
+				pod, ok := obj.(*apiv1.Pod)
+				if !ok {
+					tombstone, ok := obj.(k8sCache.DeletedFinalStateUnknown)
+					if !ok {
+						return
+					}
+					pod, ok = tombstone.Obj.(*apiv1.Pod)
+					if !ok {
+						return
+					}
+				}
+				gp.podDeleted(pod)
+			},
+		})
+	go controller.Run(gp.stopCh)
 }
 
 func (gp *GenericPool) labelsForFunction(metadata *metav1.ObjectMeta) map[string]string {
 	return map[string]string{
+		"environmentUid":                  string(gp.env.Metadata.UID),
 		"functionName":                    metadata.Name,
 		"functionUid":                     string(metadata.UID),
-		"unmanaged":                       "true", // this allows us to easily find pods not managed by the deployment
+		"functionResourceVersion":         metadata.ResourceVersion, // lets a restarted executor adopt the pod
+		"unmanaged":                       "true",                   // this allows us to easily find pods not managed by the deployment
 		fission.EXECUTOR_INSTANCEID_LABEL: gp.instanceId,
 	}
 }
@@ -325,10 +633,70 @@ func (gp *GenericPool) getSpecializeUrl(podIP string, version int) string {
 	}
 }
 
+// makeSpecializeRequests returns the fetcher request that copies fn
+// and its configuration into a pod, and the load request that
+// tells the runtime where to find them.
+func (gp *GenericPool) makeSpecializeRequests(fn *crd.Function) (*fetcher.FetchRequest, *fission.FunctionLoadRequest) {
+	// for backward compatibility, since most v1 env
+	// still try to load user function from hard coded
+	// path /userfunc/user
+	targetFilename := "user"
+	if gp.env.Spec.Version == 2 {
+		targetFilename = string(fn.Metadata.UID)
+	}
+	fnUrl := gp.functionUrl(&fn.Metadata)
+	if len(fnUrl) > 0 {
+		// pods running several functions may get several
+		// versions of a function, too
+		targetFilename = crd.CacheKey(&fn.Metadata)
+	}
+
+	fetchReq := &fetcher.FetchRequest{
+		FetchType: fetcher.FETCH_DEPLOYMENT,
+		Package: metav1.ObjectMeta{
+			Namespace: fn.Spec.Package.PackageRef.Namespace,
+			Name:      fn.Spec.Package.PackageRef.Name,
+		},
+		Filename: targetFilename,
+		// a pod running several functions may run functions of
+		// any namespace
+		PinNamespace: len(fnUrl) == 0,
+	}
+
+	loadReq := &fission.FunctionLoadRequest{
+		FilePath:         filepath.Join(gp.sharedMountPath, targetFilename),
+		FunctionName:     fn.Spec.Package.FunctionName,
+		URL:              fnUrl,
+		FunctionMetadata: &fn.Metadata,
+	}
+
+	// Secrets and ConfigMaps can't be mounted into an already
+	// running pod, so the fetcher copies them next to the function.
+	configFilename := targetFilename + "-config"
+	if fetchReq.SetFunctionConfig(fn, configFilename) {
+		loadReq.ConfigPath = filepath.Join(gp.sharedMountPath, configFilename)
+	}
+
+	return fetchReq, loadReq
+}
+
+// functionUrl returns the URL a function is loaded at in pods that run
+// several functions, or "" for pods running a single function at their
+// root.  Each version of a function gets its own URL, since pods can't
+// unload functions.
+func (gp *GenericPool) functionUrl(m *metav1.ObjectMeta) string {
+	if gp.env.Spec.AllowedFunctionsPerContainer != fission.AllowedFunctionsPerContainerInfinite ||
+		gp.env.Spec.Version < 2 {
+		return ""
+	}
+	return fmt.Sprintf("/fission-function/%v", crd.CacheKey(m))
+}
+
 // specializePod chooses a pod, copies the required user-defined function to that pod
 // (via fetcher), and calls the function-run container to load it, resulting in a
-// specialized pod.
-func (gp *GenericPool) specializePod(pod *apiv1.Pod, metadata *metav1.ObjectMeta) error {
+// specialized pod.  The time spent is added to timeline.  Gives up when
+// ctx is done.
+func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, metadata *metav1.ObjectMeta, timeline *fission.ColdStart) error {
 	// for fetcher we don't need to create a service, just talk to the pod directly
 	podIP := pod.Status.PodIP
 	if len(podIP) == 0 {
@@ -346,52 +714,51 @@ func (gp *GenericPool) specializePod(pod *apiv1.Pod, metadata *metav1.ObjectMeta
 		return err
 	}
 
-	// for backward compatibility, since most v1 env
-	// still try to load user function from hard coded
-	// path /userfunc/user
-	targetFilename := "user"
-	if gp.env.Spec.Version == 2 {
-		targetFilename = string(fn.Metadata.UID)
-	}
-
-	err = fetcherClient.MakeClient(fetcherUrl).Fetch(&fetcher.FetchRequest{
-		FetchType: fetcher.FETCH_DEPLOYMENT,
-		Package: metav1.ObjectMeta{
-			Namespace: fn.Spec.Package.PackageRef.Namespace,
-			Name:      fn.Spec.Package.PackageRef.Name,
-		},
-		Filename: targetFilename,
-	})
+	fetchReq, loadReq := gp.makeSpecializeRequests(fn)
+	fetchStart := time.Now()
+	stats, err := fetcherClient.MakeClient(fetcherUrl).FetchWithStats(ctx, fetchReq)
+	timeline.Fetch = metav1.Duration{Duration: time.Since(fetchStart)}
 	if err != nil {
 		return err
 	}
+	timeline.FetchDownload = stats.DownloadDuration
+	timeline.FetchUnarchive = stats.UnarchiveDuration
+	timeline.FetchBytes = stats.Bytes
 
 	// get function run container to specialize
 	log.Printf("[%v] specializing pod", metadata.Name)
 
 	// retry the specialize call a few times in case the env server hasn't come up yet
-	maxRetries := 20
-
-	loadReq := fission.FunctionLoadRequest{
-		FilePath:         filepath.Join(gp.sharedMountPath, targetFilename),
-		FunctionName:     fn.Spec.Package.FunctionName,
-		FunctionMetadata: &fn.Metadata,
-	}
+	maxRetries := gp.getTuning().specializeRetries
 
 	body, err := json.Marshal(loadReq)
 	if err != nil {
 		return err
 	}
 
+	specializeStart := time.Now()
+	defer func() {
+		timeline.Specialize = metav1.Duration{Duration: time.Since(specializeStart)}
+	}()
 	for i := 0; i < maxRetries; i++ {
+		timeline.SpecializeRetries = i
+		var req *http.Request
 		var resp2 *http.Response
 		if gp.env.Spec.Version == 2 {
-			specializeUrl := gp.getSpecializeUrl(podIP, 2)
-			resp2, err = http.Post(specializeUrl, "application/json", bytes.NewReader(body))
+			req, err = http.NewRequest("POST", gp.getSpecializeUrl(podIP, 2), bytes.NewReader(body))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
 		} else {
-			specializeUrl := gp.getSpecializeUrl(podIP, 1)
-			resp2, err = http.Post(specializeUrl, "text/plain", bytes.NewReader([]byte{}))
+			req, err = http.NewRequest("POST", gp.getSpecializeUrl(podIP, 1), bytes.NewReader([]byte{}))
+			if err == nil {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+		}
+		if err != nil {
+			return err
 		}
+		resp2, err = http.DefaultClient.Do(req.WithContext(ctx))
 		if err == nil && resp2.StatusCode < 300 {
 			// Success
 			resp2.Body.Close()
@@ -403,7 +770,11 @@ func (gp *GenericPool) specializePod(pod *apiv1.Pod, metadata *metav1.ObjectMeta
 			if netErr, ok := urlErr.Err.(*net.OpError); ok {
 				if netErr.Op == "dial" {
 					if i < maxRetries-1 {
-						time.Sleep(500 * time.Duration(2*i) * time.Millisecond)
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-time.After(500 * time.Duration(2*i) * time.Millisecond):
+						}
 						log.Printf("Error connecting to pod (%v), retrying", netErr)
 						continue
 					}
@@ -421,19 +792,65 @@ func (gp *GenericPool) specializePod(pod *apiv1.Pod, metadata *metav1.ObjectMeta
 	return nil
 }
 
+// adoptPool looks for an existing deployment for this pool, created by
+// an earlier executor with the same instance id.  Its pods keep
+// serving; the deployment is only resized if the pool size changed.
+func (gp *GenericPool) adoptPool() (bool, error) {
+	deployments := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace)
+	deplList, err := deployments.List(metav1.ListOptions{
+		LabelSelector: gp.poolSelector.String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(deplList.Items) == 0 {
+		return false, nil
+	}
+
+	depl := &deplList.Items[0]
+
+	// The environment's image may have changed while no executor was
+	// running; such a pool is replaced rather than adopted.
+	containers := depl.Spec.Template.Spec.Containers
+	if len(containers) == 0 || containers[0].Image != gp.env.Spec.Runtime.Image {
+		log.Printf("[%v] Replacing stale pool deployment %v", gp.env.Metadata.Name, depl.ObjectMeta.Name)
+		err = deployments.Delete(depl.ObjectMeta.Name, nil)
+		if err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if depl.Spec.Replicas == nil || *depl.Spec.Replicas != gp.replicas {
+		replicas := gp.replicas
+		depl.Spec.Replicas = &replicas
+		depl, err = deployments.Update(depl)
+		if err != nil {
+			return false, err
+		}
+	}
+	gp.deployment = depl
+	return true, nil
+}
+
 // A pool is a deployment of generic containers for an env.  This
 // creates the pool but doesn't wait for any pods to be ready.
 func (gp *GenericPool) createPool() error {
 	poolDeploymentName := fmt.Sprintf("%v-%v-%v",
 		gp.env.Metadata.Name, gp.env.Metadata.UID, strings.ToLower(gp.poolInstanceId))
 
+	// the deployment gets its own copy; gp.replicas changes when the
+	// pool is autoscaled
+	replicas := gp.replicas
+
 	deployment := &v1beta1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   poolDeploymentName,
-			Labels: gp.labelsForPool,
+			Name:      poolDeploymentName,
+			Namespace: gp.namespace,
+			Labels:    gp.labelsForPool,
 		},
 		Spec: v1beta1.DeploymentSpec{
-			Replicas: &gp.replicas,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: gp.labelsForPool,
 			},
@@ -454,7 +871,7 @@ func (gp *GenericPool) createPool() error {
 						{
 							Name:                   gp.env.Metadata.Name,
 							Image:                  gp.env.Spec.Runtime.Image,
-							ImagePullPolicy:        gp.runtimeImagePullPolicy,
+							ImagePullPolicy:        gp.getTuning().runtimeImagePullPolicy,
 							TerminationMessagePath: "/dev/termination-log",
 							VolumeMounts: []apiv1.VolumeMount{
 								{
@@ -462,7 +879,7 @@ func (gp *GenericPool) createPool() error {
 									MountPath: gp.sharedMountPath,
 								},
 							},
-							Resources: gp.env.Spec.Resources,
+							Resources: gp.podResources(),
 						},
 						{
 							Name:                   "fetcher",
@@ -478,11 +895,23 @@ func (gp *GenericPool) createPool() error {
 							Command: []string{"/fetcher", gp.sharedMountPath},
 						},
 					},
-					ServiceAccountName: "fission-fetcher",
+					ServiceAccountName: fetcher.SERVICE_ACCOUNT,
 				},
 			},
 		},
 	}
+
+	template, err := crd.MergePodTemplate(&deployment.Spec.Template, gp.env.Spec.PodTemplate)
+	if err != nil {
+		return err
+	}
+	fetcher.MountServiceAccountToken(&template.Spec, "fetcher")
+	err = crd.ValidatePodTemplate(template)
+	if err != nil {
+		return fmt.Errorf("invalid pod template for environment %v: %v", gp.env.Metadata.Name, err)
+	}
+	deployment.Spec.Template = *template
+
 	depl, err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace).Create(deployment)
 	if err != nil {
 		return err
@@ -491,26 +920,13 @@ func (gp *GenericPool) createPool() error {
 	return nil
 }
 
-func (gp *GenericPool) waitForReadyPod() error {
-	startTime := time.Now()
-	for {
-		// TODO: for now we just poll; use a watch instead
-		depl, err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace).Get(
-			gp.deployment.ObjectMeta.Name, metav1.GetOptions{})
-		if err != nil {
-			log.Printf("err: %v", err)
-			return err
-		}
-		gp.deployment = depl
-		if gp.deployment.Status.AvailableReplicas > 0 {
-			return nil
-		}
-
-		if time.Since(startTime) > gp.podReadyTimeout {
-			return errors.New("timeout: waited too long for pod to be ready")
-		}
-		time.Sleep(1000 * time.Millisecond)
+// podResources returns the resources of the runtime container of the
+// pool's pods.
+func (gp *GenericPool) podResources() apiv1.ResourceRequirements {
+	if gp.profile != nil {
+		return gp.profile.resources
 	}
+	return gp.env.Spec.Resources
 }
 
 func (gp *GenericPool) createSvc(name string, labels map[string]string) (*apiv1.Service, error) {
@@ -534,16 +950,57 @@ func (gp *GenericPool) createSvc(name string, labels map[string]string) (*apiv1.
 	return svc, err
 }
 
-func (gp *GenericPool) GetFuncSvc(m *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+func (gp *GenericPool) GetFuncSvc(ctx context.Context, m *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	fsvc, err := gp.specializeFuncSvc(ctx, m, gp.getTuning().useSvc)
+	if err != nil {
+		return nil, err
+	}
 
+	_, err = gp.fsCache.Add(*fsvc)
+	if err != nil {
+		return nil, err
+	}
+	return fsvc, nil
+}
+
+// ScaleOut specializes another pod for a function that already has
+// one, and adds it to the function's instances.  The extra pod is
+// always reached by its IP; with useSvc, the function's service picks
+// it up too.
+func (gp *GenericPool) ScaleOut(ctx context.Context, m *metav1.ObjectMeta) (*fscache.FuncSvc, error) {
+	fsvc, err := gp.specializeFuncSvc(ctx, m, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gp.fsCache.AddInstance(*fsvc)
+	if err != nil {
+		// the function was reaped or updated in the meantime
+		log.Printf("[%v] Error adding instance %v: %v", m.Name, fsvc.Name, err)
+		gp.kubernetesClient.CoreV1().Pods(gp.namespace).Delete(fsvc.Name, nil)
+		return nil, err
+	}
+	return fsvc, nil
+}
+
+// specializeFuncSvc chooses a pod from the pool and specializes it for
+// a function, giving up when ctx is done.
+func (gp *GenericPool) specializeFuncSvc(ctx context.Context, m *metav1.ObjectMeta, useSvc bool) (*fscache.FuncSvc, error) {
 	log.Printf("[%v] Choosing pod from pool", m.Name)
+	timeline := &fission.ColdStart{
+		Function:    m.Name,
+		Namespace:   m.Namespace,
+		Environment: gp.env.Metadata.Name,
+		Executor:    fission.ExecutorTypePoolmgr,
+		StartTime:   time.Now(),
+	}
 	newLabels := gp.labelsForFunction(m)
-	pod, err := gp.choosePod(newLabels)
+	pod, err := gp.choosePod(ctx, newLabels, timeline)
 	if err != nil {
 		return nil, err
 	}
 
-	err = gp.specializePod(pod, m)
+	err = gp.specializePod(ctx, pod, m, timeline)
 	if err != nil {
 		gp.scheduleDeletePod(pod.ObjectMeta.Name)
 		return nil, err
@@ -551,7 +1008,7 @@ func (gp *GenericPool) GetFuncSvc(m *metav1.ObjectMeta) (*fscache.FuncSvc, error
 	log.Printf("Specialized pod: %v", pod.ObjectMeta.Name)
 
 	var svcHost string
-	if gp.useSvc {
+	if useSvc {
 		svcName := fmt.Sprintf("svc-%v", m.Name)
 		if len(m.UID) > 0 {
 			svcName = fmt.Sprintf("%s-%v", svcName, m.UID)
@@ -575,6 +1032,8 @@ func (gp *GenericPool) GetFuncSvc(m *metav1.ObjectMeta) (*fscache.FuncSvc, error
 		log.Printf("Using pod IP for specialized pod")
 		svcHost = fmt.Sprintf("%v:8888", pod.Status.PodIP)
 	}
+	// the router sends requests to the function's URL
+	svcHost += gp.functionUrl(m)
 
 	kubeObjRefs := []api.ObjectReference{
 		{
@@ -593,20 +1052,70 @@ func (gp *GenericPool) GetFuncSvc(m *metav1.ObjectMeta) (*fscache.FuncSvc, error
 		Environment:       gp.env,
 		Address:           svcHost,
 		KubernetesObjects: kubeObjRefs,
-		Executor:          fscache.POOLMGR,
+		Executor:          fission.ExecutorTypePoolmgr,
+		ColdStart:         timeline,
 		Ctime:             time.Now(),
 		Atime:             time.Now(),
 	}
+	return fsvc, nil
+}
 
-	_, err = gp.fsCache.Add(*fsvc)
-	if err != nil {
-		return nil, err
+// updateEnv applies an update of the pool's environment.  Returns false
+// if the update changes more than the pool's tuning, in which case the
+// pool has to be replaced.  A new image pull policy rolls the pool's
+// warm pods; everything else only affects later specializations.
+func (gp *GenericPool) updateEnv(env *crd.Environment) (bool, error) {
+	current := gp.getTuning()
+	if !onlyTuningChanged(&current.env.Spec, &env.Spec) {
+		return false, nil
 	}
-	return fsvc, nil
+
+	tuning := makePoolTuning(env, gp.defaultPullPolicy)
+	if tuning.runtimeImagePullPolicy != current.runtimeImagePullPolicy {
+		deployments := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace)
+		depl, err := deployments.Get(gp.deployment.ObjectMeta.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		depl.Spec.Template.Spec.Containers[0].ImagePullPolicy = tuning.runtimeImagePullPolicy
+		_, err = deployments.Update(depl)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	gp.tuningLock.Lock()
+	gp.tuning = tuning
+	gp.tuningLock.Unlock()
+	log.Printf("[%v] Updated pool settings", env.Metadata.Name)
+	return true, nil
+}
+
+// onlyTuningChanged returns true if two versions of an environment spec
+// differ only in settings that poolTuning covers.
+func onlyTuningChanged(oldSpec *fission.EnvironmentSpec, newSpec *fission.EnvironmentSpec) bool {
+	withoutTuning := func(spec fission.EnvironmentSpec) fission.EnvironmentSpec {
+		spec.ReadinessTimeout = metav1.Duration{}
+		spec.IdlePodTTL = metav1.Duration{}
+		spec.UseSvc = false
+		spec.ImagePullPolicy = ""
+		spec.SpecializationRetries = 0
+		spec.PrePullImages = false
+		return spec
+	}
+	return reflect.DeepEqual(withoutTuning(*oldSpec), withoutTuning(*newSpec))
+}
+
+// stop stops the pool's goroutines, and leaves its deployment and
+// pods alone.
+func (gp *GenericPool) stop() {
+	close(gp.stopCh)
 }
 
 // destroys the pool -- the deployment, replicaset and pods
 func (gp *GenericPool) destroy() error {
+	close(gp.stopCh)
+
 	// Destroy deployment
 	err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(gp.namespace).Delete(gp.deployment.ObjectMeta.Name, nil)
 	if err != nil {
@@ -618,7 +1127,7 @@ func (gp *GenericPool) destroy() error {
 	// automatically but post-1.6 K8s will, and may beat us to it,
 	// so don't error out if we fail.
 	rsList, err := gp.kubernetesClient.ExtensionsV1beta1().ReplicaSets(gp.namespace).List(metav1.ListOptions{
-		LabelSelector: labels.Set(gp.labelsForPool).AsSelector().String(),
+		LabelSelector: gp.poolSelector.String(),
 	})
 	if len(rsList.Items) >= 0 {
 		for _, rs := range rsList.Items {
@@ -631,7 +1140,7 @@ func (gp *GenericPool) destroy() error {
 
 	// Destroy Pods.  See note above.
 	podList, err := gp.kubernetesClient.CoreV1().Pods(gp.namespace).List(metav1.ListOptions{
-		LabelSelector: labels.Set(gp.labelsForPool).AsSelector().String(),
+		LabelSelector: gp.poolSelector.String(),
 	})
 	if len(podList.Items) >= 0 {
 		for _, pod := range podList.Items {
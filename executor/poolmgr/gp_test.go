@@ -0,0 +1,433 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func makeTestPool(t *testing.T) *GenericPool {
+	env := &crd.Environment{
+		Metadata: metav1.ObjectMeta{
+			Name:      "go",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "4321",
+		},
+		Spec: fission.EnvironmentSpec{
+			Version:  2,
+			Runtime:  fission.Runtime{Image: "fission/go-env"},
+			Poolsize: 3,
+		},
+	}
+	gp, err := MakeGenericPool(nil, fake.NewSimpleClientset(), env, int32(env.Spec.Poolsize), testNamespace, nil, "test")
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	return gp
+}
+
+func TestChoosePodWaitsForReadyPod(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "42"}
+	newLabels := gp.labelsForFunction(fn)
+
+	type chooseResult struct {
+		pod *apiv1.Pod
+		err error
+	}
+	resultCh := make(chan chooseResult, 1)
+	go func() {
+		pod, err := gp.choosePod(context.Background(), newLabels, nil)
+		resultCh <- chooseResult{pod, err}
+	}()
+
+	// a starting pod isn't handed out
+	gp.podChanged(makeTestPod("pod-1", gp.labelsForPool, false))
+	select {
+	case r := <-resultCh:
+		t.Fatalf("choosePod returned before a pod was ready: %v, %v", r.pod, r.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// as soon as it's ready, the waiter gets it
+	pod := makeTestPod("pod-1", gp.labelsForPool, true)
+	_, err := gp.kubernetesClient.CoreV1().Pods(testNamespace).Create(pod)
+	if err != nil {
+		t.Fatalf("error creating pod: %v", err)
+	}
+	gp.podChanged(pod)
+
+	var r chooseResult
+	select {
+	case r = <-resultCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("choosePod didn't return after a pod became ready")
+	}
+	if r.err != nil {
+		t.Fatalf("error choosing pod: %v", r.err)
+	}
+	if r.pod.ObjectMeta.Name != "pod-1" {
+		t.Fatalf("expected pod-1, got %v", r.pod.ObjectMeta.Name)
+	}
+
+	// the chosen pod is relabeled, and no longer in the pool
+	p, err := gp.kubernetesClient.CoreV1().Pods(testNamespace).Get("pod-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting pod: %v", err)
+	}
+	if p.ObjectMeta.Labels["functionName"] != fn.Name {
+		t.Fatalf("chosen pod wasn't relabeled: %v", p.ObjectMeta.Labels)
+	}
+	if pod.ObjectMeta.Labels["functionName"] == fn.Name {
+		t.Fatalf("pod from the informer was modified")
+	}
+	if n := gp.getReadyPodCount(); n != 0 {
+		t.Fatalf("expected no ready pods left, got %v", n)
+	}
+
+	// the relabeled pod doesn't come back into the pool
+	gp.podChanged(p)
+	if n := gp.getReadyPodCount(); n != 0 {
+		t.Fatalf("relabeled pod was added back to the pool")
+	}
+}
+
+func TestChoosePodTimeout(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+	gp.tuning.podReadyTimeout = 100 * time.Millisecond
+
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "42"}
+	_, err := gp.choosePod(context.Background(), gp.labelsForFunction(fn), nil)
+	if err == nil {
+		t.Fatalf("expected choosePod to time out")
+	}
+
+	// a pod becoming ready later isn't taken by the waiter that gave up
+	gp.podChanged(makeTestPod("pod-1", gp.labelsForPool, true))
+	if n := gp.getReadyPodCount(); n != 1 {
+		t.Fatalf("expected 1 ready pod, got %v", n)
+	}
+
+	// pods that go away leave the pool
+	gp.podDeleted(makeTestPod("pod-1", gp.labelsForPool, true))
+	if n := gp.getReadyPodCount(); n != 0 {
+		t.Fatalf("expected no ready pods, got %v", n)
+	}
+}
+
+func TestChoosePodPoolStopped(t *testing.T) {
+	gp := makeTestPool(t)
+
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "42"}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := gp.choosePod(context.Background(), gp.labelsForFunction(fn), nil)
+		errCh <- err
+	}()
+	for atomic.LoadInt32(&gp.waiting) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// stopping the pool fails the waiter rather than leaving it
+	// to time out
+	close(gp.stopCh)
+	select {
+	case err := <-errCh:
+		if err != errPoolStopped {
+			t.Fatalf("expected the pool to be stopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("choosePod didn't return after the pool was stopped")
+	}
+
+	// the informer's last events don't block on the stopped service
+	gp.podChanged(makeTestPod("pod-1", gp.labelsForPool, true))
+	if n := gp.getReadyPodCount(); n != 0 {
+		t.Fatalf("expected no ready pods in a stopped pool, got %v", n)
+	}
+}
+
+func TestGenericPoolAdoptsDeployment(t *testing.T) {
+	gp := makeTestPool(t)
+	close(gp.stopCh)
+	existing := gp.deployment
+
+	// a new pool with the same instance id reuses the deployment
+	adopted, err := MakeGenericPool(nil, gp.kubernetesClient, gp.env, 5, testNamespace, nil, "test")
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	defer close(adopted.stopCh)
+
+	if adopted.deployment.ObjectMeta.Name != existing.ObjectMeta.Name {
+		t.Fatalf("expected deployment %v to be adopted, got %v",
+			existing.ObjectMeta.Name, adopted.deployment.ObjectMeta.Name)
+	}
+	deplList, err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error listing deployments: %v", err)
+	}
+	if len(deplList.Items) != 1 {
+		t.Fatalf("expected a single pool deployment, got %v", len(deplList.Items))
+	}
+	if replicas := getPoolReplicas(t, adopted); replicas != 5 {
+		t.Fatalf("expected adopted pool to be resized to 5, got %v", replicas)
+	}
+}
+
+func TestMakePoolTuning(t *testing.T) {
+	env := &crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "go", Namespace: metav1.NamespaceDefault, UID: "4321"},
+	}
+	tuning := makePoolTuning(env, apiv1.PullIfNotPresent)
+	if tuning.podReadyTimeout != 5*time.Minute || tuning.useSvc || tuning.specializeRetries != 20 ||
+		tuning.runtimeImagePullPolicy != apiv1.PullIfNotPresent {
+		t.Fatalf("unexpected default tuning %+v", tuning)
+	}
+
+	env.Spec.ReadinessTimeout = metav1.Duration{Duration: time.Minute}
+	env.Spec.UseSvc = true
+	env.Spec.SpecializationRetries = 3
+	env.Spec.ImagePullPolicy = apiv1.PullAlways
+	tuning = makePoolTuning(env, apiv1.PullIfNotPresent)
+	if tuning.podReadyTimeout != time.Minute || !tuning.useSvc || tuning.specializeRetries != 3 ||
+		tuning.runtimeImagePullPolicy != apiv1.PullAlways {
+		t.Fatalf("environment settings not applied: %+v", tuning)
+	}
+}
+
+func TestGenericPoolUpdateEnv(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+
+	// tuning changes are applied in place
+	env := *gp.env
+	env.Metadata.ResourceVersion = "2"
+	env.Spec.ReadinessTimeout = metav1.Duration{Duration: 30 * time.Second}
+	env.Spec.ImagePullPolicy = apiv1.PullAlways
+	updated, err := gp.updateEnv(&env)
+	if err != nil {
+		t.Fatalf("error updating pool: %v", err)
+	}
+	if !updated {
+		t.Fatalf("expected tuning change to be applied in place")
+	}
+	tuning := gp.getTuning()
+	if tuning.podReadyTimeout != 30*time.Second || tuning.env.Metadata.ResourceVersion != "2" {
+		t.Fatalf("tuning not updated: %+v", tuning)
+	}
+	depl, err := gp.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(
+		gp.deployment.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting pool deployment: %v", err)
+	}
+	if policy := depl.Spec.Template.Spec.Containers[0].ImagePullPolicy; policy != apiv1.PullAlways {
+		t.Fatalf("expected pool to use the new pull policy, got %v", policy)
+	}
+
+	// a new image needs a new pool
+	env.Metadata.ResourceVersion = "3"
+	env.Spec.Runtime.Image = "fission/go-env:2"
+	updated, err = gp.updateEnv(&env)
+	if err != nil {
+		t.Fatalf("error updating pool: %v", err)
+	}
+	if updated {
+		t.Fatalf("expected image change to require a new pool")
+	}
+}
+
+func TestPoolManagerAppliesEnvUpdates(t *testing.T) {
+	gpm := &GenericPoolManager{
+		pools:            make(map[string]*GenericPool),
+		subPoolsUsed:     make(map[string]time.Time),
+		kubernetesClient: fake.NewSimpleClientset(),
+		namespace:        testNamespace,
+		instanceId:       "test",
+		requestChannel:   make(chan *request),
+	}
+	go gpm.service()
+
+	env := crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "go", Namespace: metav1.NamespaceDefault, UID: "4321", ResourceVersion: "1"},
+		Spec: fission.EnvironmentSpec{
+			Version:  2,
+			Runtime:  fission.Runtime{Image: "fission/go-env"},
+			Poolsize: 3,
+		},
+	}
+	pool, err := gpm.GetPool(&env)
+	if err != nil {
+		t.Fatalf("error getting pool: %v", err)
+	}
+
+	// the pool is kept when only its tuning changes
+	env.Metadata.ResourceVersion = "2"
+	env.Spec.SpecializationRetries = 5
+	gpm.CleanupPools([]crd.Environment{env})
+	sameEnv := env
+	sameEnv.Metadata.ResourceVersion = "1"
+	samePool, err := gpm.GetPool(&sameEnv)
+	if err != nil {
+		t.Fatalf("error getting pool: %v", err)
+	}
+	if samePool != pool {
+		t.Fatalf("expected pool to be updated in place")
+	}
+	if retries := pool.getTuning().specializeRetries; retries != 5 {
+		t.Fatalf("expected 5 specialization retries, got %v", retries)
+	}
+
+	// and replaced when the environment changes otherwise
+	env.Metadata.ResourceVersion = "3"
+	env.Spec.Poolsize = 4
+	gpm.CleanupPools([]crd.Environment{env})
+	newPool, err := gpm.GetPool(&env)
+	if err != nil {
+		t.Fatalf("error getting pool: %v", err)
+	}
+	defer close(newPool.stopCh)
+	if newPool == pool {
+		t.Fatalf("expected pool to be replaced")
+	}
+	if replicas := getPoolReplicas(t, newPool); replicas != 4 {
+		t.Fatalf("expected replacement pool of 4, got %v", replicas)
+	}
+}
+
+func TestPoolManagerStop(t *testing.T) {
+	gpm := &GenericPoolManager{
+		pools:            make(map[string]*GenericPool),
+		subPoolsUsed:     make(map[string]time.Time),
+		kubernetesClient: fake.NewSimpleClientset(),
+		namespace:        testNamespace,
+		instanceId:       "test",
+		requestChannel:   make(chan *request),
+		stopCh:           make(chan struct{}),
+	}
+	go gpm.service()
+
+	env := crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "go", Namespace: metav1.NamespaceDefault, UID: "4321", ResourceVersion: "1"},
+		Spec: fission.EnvironmentSpec{
+			Version:  2,
+			Runtime:  fission.Runtime{Image: "fission/go-env"},
+			Poolsize: 3,
+		},
+	}
+	pool, err := gpm.GetPool(&env)
+	if err != nil {
+		t.Fatalf("error getting pool: %v", err)
+	}
+
+	gpm.Stop()
+	select {
+	case <-pool.stopCh:
+	default:
+		t.Fatalf("expected the pool to be stopped")
+	}
+	// the next leader adopts the pool's deployment
+	if replicas := getPoolReplicas(t, pool); replicas != 3 {
+		t.Fatalf("expected the pool's deployment to be left alone, got %v replicas", replicas)
+	}
+}
+
+func TestMakeSpecializeRequests(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+
+	fn := &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1"},
+		Spec: fission.FunctionSpec{
+			Package: fission.FunctionPackageRef{
+				FunctionName: "Handler",
+				PackageRef:   fission.PackageRef{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+			},
+		},
+	}
+
+	// without configuration nothing extra is fetched
+	fetchReq, loadReq := gp.makeSpecializeRequests(fn)
+	if fetchReq.Filename != "fn-1" || len(fetchReq.ConfigFilename) > 0 || len(loadReq.ConfigPath) > 0 {
+		t.Fatalf("unexpected requests %+v %+v", fetchReq, loadReq)
+	}
+
+	// the fetcher is asked for the function's config, which it
+	// looks up itself
+	fn.Spec.Secrets = []fission.SecretReference{{Namespace: "kube-system", Name: "db-password"}}
+	fn.Spec.ConfigMaps = []fission.ConfigMapReference{{Name: "settings"}}
+	fn.Spec.Env = []fission.EnvVar{{Name: "GREETING", Value: "hi"}}
+	fetchReq, loadReq = gp.makeSpecializeRequests(fn)
+	if fetchReq.Function.Name != "hello" || fetchReq.Function.Namespace != metav1.NamespaceDefault {
+		t.Fatalf("expected config of the function, got %v", fetchReq.Function)
+	}
+	if fetchReq.ConfigFilename != "fn-1-config" || loadReq.ConfigPath != "/userfunc/fn-1-config" {
+		t.Fatalf("unexpected config location %v, %v", fetchReq.ConfigFilename, loadReq.ConfigPath)
+	}
+	// the pod only runs this function
+	if !fetchReq.PinNamespace {
+		t.Fatalf("expected the fetcher to be pinned to the function's namespace")
+	}
+}
+
+func TestMultiFunctionSpecializeRequests(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+	gp.env.Spec.AllowedFunctionsPerContainer = fission.AllowedFunctionsPerContainerInfinite
+
+	fn := &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1", ResourceVersion: "3"},
+		Spec: fission.FunctionSpec{
+			Package: fission.FunctionPackageRef{
+				FunctionName: "Handler",
+				PackageRef:   fission.PackageRef{Name: "hello-pkg", Namespace: metav1.NamespaceDefault},
+			},
+		},
+	}
+
+	// each version of a function is loaded at its own URL
+	fetchReq, loadReq := gp.makeSpecializeRequests(fn)
+	if loadReq.URL != "/fission-function/fn-1_3" {
+		t.Fatalf("unexpected function URL %v", loadReq.URL)
+	}
+	if fetchReq.Filename != "fn-1_3" || loadReq.FilePath != "/userfunc/fn-1_3" {
+		t.Fatalf("unexpected function location %v, %v", fetchReq.Filename, loadReq.FilePath)
+	}
+	// the pod may run functions of other namespaces, too
+	if fetchReq.PinNamespace {
+		t.Fatalf("expected a pod running several functions not to be pinned to a namespace")
+	}
+
+	// v1 environments can't load functions at a URL
+	gp.env.Spec.Version = 1
+	if url := gp.functionUrl(&fn.Metadata); len(url) > 0 {
+		t.Fatalf("expected v1 function at the root, got %v", url)
+	}
+}
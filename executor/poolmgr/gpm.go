@@ -17,6 +17,7 @@ limitations under the License.
 package poolmgr
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -33,22 +34,34 @@ type requestType int
 const (
 	GET_POOL requestType = iota
 	CLEANUP_POOLS
+	STOP_POOLS
 )
 
+// Sub-pools that no function got pods from for this long are destroyed
+const subPoolIdleTime = 15 * time.Minute
+
 type (
 	GenericPoolManager struct {
-		pools            map[string]*GenericPool
-		kubernetesClient *kubernetes.Clientset
+		pools            map[string]*GenericPool // by poolKey
+		subPoolsUsed     map[string]time.Time    // last GET_POOL of each sub-pool, by poolKey
+		kubernetesClient kubernetes.Interface
 		namespace        string
 
 		fissionClient  *crd.FissionClient
 		fsCache        *fscache.FunctionServiceCache
 		instanceId     string
 		requestChannel chan *request
+
+		// how long pods of old function versions may keep serving
+		drainGracePeriod time.Duration
+
+		// closed when the executor is no longer the leader
+		stopCh chan struct{}
 	}
 	request struct {
 		requestType
 		env             *crd.Environment
+		profile         *resourceProfile
 		envList         []crd.Environment
 		responseChannel chan *response
 	}
@@ -60,7 +73,7 @@ type (
 
 func MakeGenericPoolManager(
 	fissionClient *crd.FissionClient,
-	kubernetesClient *kubernetes.Clientset,
+	kubernetesClient kubernetes.Interface,
 	fissionNamespace string,
 	functionNamespace string,
 	fsCache *fscache.FunctionServiceCache,
@@ -68,15 +81,20 @@ func MakeGenericPoolManager(
 
 	gpm := &GenericPoolManager{
 		pools:            make(map[string]*GenericPool),
+		subPoolsUsed:     make(map[string]time.Time),
 		kubernetesClient: kubernetesClient,
 		namespace:        functionNamespace,
 		fissionClient:    fissionClient,
 		fsCache:          fsCache,
 		instanceId:       instanceId,
 		requestChannel:   make(chan *request),
+		drainGracePeriod: getDrainGracePeriod(),
+		stopCh:           make(chan struct{}),
 	}
 	go gpm.service()
 	go gpm.eagerPoolCreator()
+	go gpm.watchSpecializedPods(gpm.stopCh)
+	go gpm.prePuller()
 
 	return gpm
 }
@@ -87,54 +105,132 @@ func (gpm *GenericPoolManager) service() {
 		switch req.requestType {
 		case GET_POOL:
 			var err error
-			pool, ok := gpm.pools[crd.CacheKey(&req.env.Metadata)]
+			// Updates of the environment are picked up by
+			// CLEANUP_POOLS, from the latest list of environments
+			key := poolKey(req.env, req.profile)
+			pool, ok := gpm.pools[key]
 			if !ok {
 				var poolSize = int32(req.env.Spec.Poolsize)
 				switch req.env.Spec.AllowedFunctionsPerContainer {
 				case fission.AllowedFunctionsPerContainerInfinite:
 					poolSize = 1
 				}
+				if req.profile != nil {
+					// sub-pools serve few functions; keep one warm pod
+					poolSize = 1
+				}
 
-				pool, err = MakeGenericPool(
-					gpm.fissionClient, gpm.kubernetesClient, req.env, poolSize,
+				pool, err = makeGenericPool(
+					gpm.fissionClient, gpm.kubernetesClient, req.env, req.profile, poolSize,
 					gpm.namespace, gpm.fsCache, gpm.instanceId)
 				if err != nil {
 					req.responseChannel <- &response{error: err}
 					continue
 				}
-				gpm.pools[crd.CacheKey(&req.env.Metadata)] = pool
+				gpm.pools[key] = pool
+			}
+			if req.profile != nil {
+				gpm.subPoolsUsed[key] = time.Now()
 			}
 			req.responseChannel <- &response{pool: pool}
 		case CLEANUP_POOLS:
-			latestEnvSet := make(map[string]bool)
-			latestEnvPoolsize := make(map[string]int)
-			for _, env := range req.envList {
-				latestEnvSet[crd.CacheKey(&env.Metadata)] = true
-				latestEnvPoolsize[crd.CacheKey(&env.Metadata)] = env.Spec.Poolsize
+			latestEnvs := make(map[string]*crd.Environment)
+			for i := range req.envList {
+				latestEnvs[string(req.envList[i].Metadata.UID)] = &req.envList[i]
 			}
 			for key, pool := range gpm.pools {
-				_, ok := latestEnvSet[key]
-				poolsize := latestEnvPoolsize[key]
-				if !ok || poolsize == 0 {
+				env, ok := latestEnvs[string(pool.env.Metadata.UID)]
+				if !ok || env.Spec.Poolsize == 0 {
 					// Env no longer exists or pool size changed to zero
 
 					log.Printf("Destroying generic pool for environment [%v]", key)
-					delete(gpm.pools, key)
+					gpm.deletePool(key)
 
 					// and delete the pool asynchronously.
 					go pool.destroy()
+					continue
+				}
+				if pool.profile != nil && time.Since(gpm.subPoolsUsed[key]) > subPoolIdleTime {
+					log.Printf("Destroying idle generic pool [%v]", key)
+					gpm.deletePool(key)
+					go pool.destroy()
+					continue
 				}
+				gpm.updatePool(key, pool, env)
 			}
 			// no response, caller doesn't wait
+		case STOP_POOLS:
+			for _, pool := range gpm.pools {
+				pool.stop()
+			}
+			req.responseChannel <- &response{}
+			return
 		}
 	}
 }
 
+// updatePool applies an update of a pool's environment, replacing the
+// pool if the update can't be applied in place.  Called from service().
+func (gpm *GenericPoolManager) updatePool(key string, pool *GenericPool, env *crd.Environment) {
+	if pool.getTuning().env.Metadata.ResourceVersion == env.Metadata.ResourceVersion {
+		return
+	}
+	updated, err := pool.updateEnv(env)
+	if err != nil {
+		log.Printf("Error updating generic pool for environment [%v]: %v", key, err)
+		return
+	}
+	if updated {
+		return
+	}
+
+	// The replacement pool has the same labels, so this one must be
+	// gone before the replacement is created; the next GET_POOL
+	// creates it.
+	log.Printf("Replacing generic pool for updated environment [%v]", key)
+	gpm.deletePool(key)
+	err = pool.destroy()
+	if err != nil {
+		log.Printf("Error destroying generic pool for environment [%v]: %v", key, err)
+	}
+}
+
+// deletePool forgets a pool.  Called from service().
+func (gpm *GenericPoolManager) deletePool(key string) {
+	delete(gpm.pools, key)
+	delete(gpm.subPoolsUsed, key)
+}
+
+// poolKey identifies the pool of an environment, or one of its
+// sub-pools.
+func poolKey(env *crd.Environment, profile *resourceProfile) string {
+	if profile == nil {
+		return string(env.Metadata.UID)
+	}
+	return fmt.Sprintf("%v/%v", env.Metadata.UID, profile.key)
+}
+
+// GetPool returns the environment's pool, creating it if necessary.
 func (gpm *GenericPoolManager) GetPool(env *crd.Environment) (*GenericPool, error) {
+	return gpm.getPool(env, nil)
+}
+
+// getFunctionPool returns the pool that fn's pods come from: the
+// environment's, or if fn needs different resources, a sub-pool of it.
+func (gpm *GenericPoolManager) getFunctionPool(fn *crd.Function, env *crd.Environment) (*GenericPool, error) {
+	profile, err := getResourceProfile(env, fn)
+	if err != nil {
+		return nil, err
+	}
+	return gpm.getPool(env, profile)
+}
+
+func (gpm *GenericPoolManager) getPool(env *crd.Environment, profile *resourceProfile) (*GenericPool, error) {
 	c := make(chan *response)
 	gpm.requestChannel <- &request{
 		requestType:     GET_POOL,
 		env:             env,
+		profile:         profile,
 		responseChannel: c,
 	}
 	resp := <-c
@@ -148,10 +244,27 @@ func (gpm *GenericPoolManager) CleanupPools(envs []crd.Environment) {
 	}
 }
 
+// Stop stops the pool manager's goroutines and those of its pools.
+// The pools' deployments and pods are left for the next leader to
+// adopt.
+func (gpm *GenericPoolManager) Stop() {
+	close(gpm.stopCh)
+	c := make(chan *response)
+	gpm.requestChannel <- &request{
+		requestType:     STOP_POOLS,
+		responseChannel: c,
+	}
+	<-c
+}
+
 func (gpm *GenericPoolManager) eagerPoolCreator() {
 	pollSleep := time.Duration(2 * time.Second)
 	for {
-		time.Sleep(pollSleep)
+		select {
+		case <-gpm.stopCh:
+			return
+		case <-time.After(pollSleep):
+		}
 
 		// get list of envs from controller
 		envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
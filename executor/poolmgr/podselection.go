@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+)
+
+// A node is assumed to still have a function's package cached for
+// this long after a pod on it last fetched the package.
+const packageCacheTTL = 10 * time.Minute
+
+type (
+	// podSelector picks which ready pod of the pool gets specialized
+	// for a function.
+	podSelector interface {
+		// selectPod returns one of candidates, which is non-empty
+		// and sorted by pod name.
+		selectPod(candidates []*apiv1.Pod, functionUid string, usage *nodeUsage, now time.Time) *apiv1.Pod
+	}
+
+	// nodeUsage tracks which nodes the pool's specialized pods run on.
+	// It's owned by choosePodService.
+	nodeUsage struct {
+		placements   map[string]podPlacement         // pod name -> placement
+		nodePods     map[string]int                  // node -> specialized pods
+		functionPods map[string]map[string]int       // node -> function uid -> specialized pods
+		packages     map[string]map[string]time.Time // function uid -> node -> last fetch
+	}
+	podPlacement struct {
+		node        string
+		functionUid string
+	}
+
+	randomSelector       struct{}
+	spreadSelector       struct{}
+	packageCacheSelector struct{}
+	leastLoadedSelector  struct{}
+)
+
+func makePodSelector(strategy fission.PodSelectionStrategy) (podSelector, error) {
+	switch strategy {
+	case "", fission.PodSelectionStrategyRandom:
+		return randomSelector{}, nil
+	case fission.PodSelectionStrategySpread:
+		return spreadSelector{}, nil
+	case fission.PodSelectionStrategyPackageCache:
+		return packageCacheSelector{}, nil
+	case fission.PodSelectionStrategyLeastLoaded:
+		return leastLoadedSelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown pod selection strategy '%v'", strategy)
+	}
+}
+
+func makeNodeUsage() *nodeUsage {
+	return &nodeUsage{
+		placements:   make(map[string]podPlacement),
+		nodePods:     make(map[string]int),
+		functionPods: make(map[string]map[string]int),
+		packages:     make(map[string]map[string]time.Time),
+	}
+}
+
+// podSpecialized records a pod specialized for a function.  Calling it
+// again for the same pod only refreshes the package fetch time.
+func (nu *nodeUsage) podSpecialized(pod *apiv1.Pod, functionUid string, now time.Time) {
+	node := pod.Spec.NodeName
+	if _, ok := nu.placements[pod.ObjectMeta.Name]; !ok {
+		nu.placements[pod.ObjectMeta.Name] = podPlacement{node: node, functionUid: functionUid}
+		nu.nodePods[node]++
+		if nu.functionPods[node] == nil {
+			nu.functionPods[node] = make(map[string]int)
+		}
+		nu.functionPods[node][functionUid]++
+	}
+
+	nodes := nu.packages[functionUid]
+	if nodes == nil {
+		nodes = make(map[string]time.Time)
+		nu.packages[functionUid] = nodes
+	}
+	nodes[node] = now
+	for n, t := range nodes {
+		if now.Sub(t) > packageCacheTTL {
+			delete(nodes, n)
+		}
+	}
+}
+
+// podGone forgets a specialized pod.  The node keeps the package.
+func (nu *nodeUsage) podGone(name string) {
+	p, ok := nu.placements[name]
+	if !ok {
+		return
+	}
+	delete(nu.placements, name)
+	nu.nodePods[p.node]--
+	if nu.nodePods[p.node] <= 0 {
+		delete(nu.nodePods, p.node)
+	}
+	nu.functionPods[p.node][p.functionUid]--
+	if nu.functionPods[p.node][p.functionUid] <= 0 {
+		delete(nu.functionPods[p.node], p.functionUid)
+	}
+	if len(nu.functionPods[p.node]) == 0 {
+		delete(nu.functionPods, p.node)
+	}
+}
+
+func (nu *nodeUsage) hasPackage(node string, functionUid string, now time.Time) bool {
+	t, ok := nu.packages[functionUid][node]
+	return ok && now.Sub(t) <= packageCacheTTL
+}
+
+// selectFirstMin returns the first candidate with the lowest score.
+func selectFirstMin(candidates []*apiv1.Pod, score func(*apiv1.Pod) int) *apiv1.Pod {
+	chosen, min := candidates[0], score(candidates[0])
+	for _, pod := range candidates[1:] {
+		if s := score(pod); s < min {
+			chosen, min = pod, s
+		}
+	}
+	return chosen
+}
+
+func (randomSelector) selectPod(candidates []*apiv1.Pod, functionUid string, usage *nodeUsage, now time.Time) *apiv1.Pod {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+func (spreadSelector) selectPod(candidates []*apiv1.Pod, functionUid string, usage *nodeUsage, now time.Time) *apiv1.Pod {
+	// fewest pods of this function first, then fewest pods overall
+	return selectFirstMin(candidates, func(pod *apiv1.Pod) int {
+		node := pod.Spec.NodeName
+		return usage.functionPods[node][functionUid]*(len(usage.placements)+1) + usage.nodePods[node]
+	})
+}
+
+func (packageCacheSelector) selectPod(candidates []*apiv1.Pod, functionUid string, usage *nodeUsage, now time.Time) *apiv1.Pod {
+	cached := make([]*apiv1.Pod, 0)
+	for _, pod := range candidates {
+		if usage.hasPackage(pod.Spec.NodeName, functionUid, now) {
+			cached = append(cached, pod)
+		}
+	}
+	if len(cached) == 0 {
+		cached = candidates
+	}
+	return leastLoadedSelector{}.selectPod(cached, functionUid, usage, now)
+}
+
+func (leastLoadedSelector) selectPod(candidates []*apiv1.Pod, functionUid string, usage *nodeUsage, now time.Time) *apiv1.Pod {
+	return selectFirstMin(candidates, func(pod *apiv1.Pod) int {
+		return usage.nodePods[pod.Spec.NodeName]
+	})
+}
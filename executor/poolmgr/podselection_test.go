@@ -0,0 +1,192 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+)
+
+func makeNodePod(name string, node string) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec:       apiv1.PodSpec{NodeName: node},
+	}
+}
+
+// makeCandidates returns one ready pod on each of the given nodes
+func makeCandidates(nodes ...string) []*apiv1.Pod {
+	pods := make([]*apiv1.Pod, 0)
+	for i, node := range nodes {
+		pods = append(pods, makeNodePod(fmt.Sprintf("pool-%v", i), node))
+	}
+	return pods
+}
+
+// makeUsage fabricates specialized pods: function uid -> nodes
+func makeUsage(now time.Time, specialized map[string][]string) *nodeUsage {
+	usage := makeNodeUsage()
+	for fnUid, nodes := range specialized {
+		for i, node := range nodes {
+			usage.podSpecialized(makeNodePod(fmt.Sprintf("%v-%v", fnUid, i), node), fnUid, now)
+		}
+	}
+	return usage
+}
+
+func TestSpreadSelector(t *testing.T) {
+	now := time.Now()
+	usage := makeUsage(now, map[string][]string{
+		"fn-a": {"node-1", "node-1", "node-2"},
+		"fn-b": {"node-3", "node-3"},
+	})
+	candidates := makeCandidates("node-1", "node-2", "node-3")
+
+	// node-3 runs no pods of fn-a
+	pod := spreadSelector{}.selectPod(candidates, "fn-a", usage, now)
+	if pod.Spec.NodeName != "node-3" {
+		t.Fatalf("expected fn-a to spread to node-3, got %v", pod.Spec.NodeName)
+	}
+
+	// fn-c runs nowhere; prefer the node with the fewest pods overall
+	pod = spreadSelector{}.selectPod(candidates, "fn-c", usage, now)
+	if pod.Spec.NodeName != "node-2" {
+		t.Fatalf("expected fn-c on the least loaded node-2, got %v", pod.Spec.NodeName)
+	}
+}
+
+func TestLeastLoadedSelector(t *testing.T) {
+	now := time.Now()
+	usage := makeUsage(now, map[string][]string{
+		"fn-a": {"node-1", "node-2"},
+		"fn-b": {"node-1", "node-3", "node-3"},
+	})
+
+	pod := leastLoadedSelector{}.selectPod(makeCandidates("node-1", "node-3", "node-2"), "fn-a", usage, now)
+	if pod.Spec.NodeName != "node-2" {
+		t.Fatalf("expected least loaded node-2, got %v", pod.Spec.NodeName)
+	}
+
+	// nodes without specialized pods are the least loaded
+	pod = leastLoadedSelector{}.selectPod(makeCandidates("node-1", "node-4"), "fn-a", usage, now)
+	if pod.Spec.NodeName != "node-4" {
+		t.Fatalf("expected empty node-4, got %v", pod.Spec.NodeName)
+	}
+}
+
+func TestPackageCacheSelector(t *testing.T) {
+	now := time.Now()
+	usage := makeUsage(now.Add(-time.Minute), map[string][]string{
+		"fn-a": {"node-2"},
+		"fn-b": {"node-1", "node-3"},
+	})
+	candidates := makeCandidates("node-1", "node-2", "node-3")
+
+	pod := packageCacheSelector{}.selectPod(candidates, "fn-a", usage, now)
+	if pod.Spec.NodeName != "node-2" {
+		t.Fatalf("expected node-2 with fn-a's package, got %v", pod.Spec.NodeName)
+	}
+
+	// the node keeps the package after the pod is gone...
+	usage.podGone("fn-a-0")
+	pod = packageCacheSelector{}.selectPod(candidates, "fn-a", usage, now)
+	if pod.Spec.NodeName != "node-2" {
+		t.Fatalf("expected node-2 to keep fn-a's package, got %v", pod.Spec.NodeName)
+	}
+
+	// ...but not forever; fall back to the least loaded node
+	pod = packageCacheSelector{}.selectPod(candidates, "fn-a", usage, now.Add(packageCacheTTL))
+	if pod.Spec.NodeName != "node-2" {
+		t.Fatalf("expected least loaded node-2, got %v", pod.Spec.NodeName)
+	}
+	usage.podSpecialized(makeNodePod("fn-b-9", "node-2"), "fn-b", now)
+	pod = packageCacheSelector{}.selectPod(candidates, "fn-a", usage, now.Add(packageCacheTTL))
+	if pod.Spec.NodeName != "node-1" {
+		t.Fatalf("expected fallback to node-1 once fn-a's package expired, got %v", pod.Spec.NodeName)
+	}
+}
+
+func TestNodeUsage(t *testing.T) {
+	now := time.Now()
+	usage := makeNodeUsage()
+	pod := makeNodePod("fn-pod", "node-1")
+
+	// repeated updates of the same pod count once
+	usage.podSpecialized(pod, "fn-a", now)
+	usage.podSpecialized(pod, "fn-a", now)
+	if usage.nodePods["node-1"] != 1 || usage.functionPods["node-1"]["fn-a"] != 1 {
+		t.Fatalf("expected one pod on node-1, got %v", usage.functionPods)
+	}
+
+	usage.podGone("fn-pod")
+	usage.podGone("fn-pod")
+	if len(usage.nodePods) != 0 || len(usage.functionPods) != 0 {
+		t.Fatalf("expected no pods left, got %v, %v", usage.nodePods, usage.functionPods)
+	}
+}
+
+func TestMakePodSelector(t *testing.T) {
+	for _, strategy := range []fission.PodSelectionStrategy{"",
+		fission.PodSelectionStrategyRandom, fission.PodSelectionStrategySpread,
+		fission.PodSelectionStrategyPackageCache, fission.PodSelectionStrategyLeastLoaded} {
+		_, err := makePodSelector(strategy)
+		if err != nil {
+			t.Fatalf("error making pod selector '%v': %v", strategy, err)
+		}
+	}
+	_, err := makePodSelector("nearest")
+	if err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+}
+
+func TestChoosePodSpreadsFunction(t *testing.T) {
+	gp := makeTestPool(t)
+	defer close(gp.stopCh)
+	gp.podSelector = spreadSelector{}
+
+	for i, node := range []string{"node-1", "node-1", "node-2"} {
+		pod := makeTestPod(fmt.Sprintf("pod-%v", i), gp.labelsForPool, true)
+		pod.Spec.NodeName = node
+		_, err := gp.kubernetesClient.CoreV1().Pods(testNamespace).Create(pod)
+		if err != nil {
+			t.Fatalf("error creating pod: %v", err)
+		}
+		gp.podChanged(pod)
+	}
+
+	// two pods for one function land on different nodes
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "42"}
+	nodes := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		pod, err := gp.choosePod(context.Background(), gp.labelsForFunction(fn), nil)
+		if err != nil {
+			t.Fatalf("error choosing pod: %v", err)
+		}
+		nodes[pod.Spec.NodeName] = true
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected the function's pods on two nodes, got %v", nodes)
+	}
+}
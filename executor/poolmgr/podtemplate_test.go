@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/testutil"
+)
+
+func makeTestPoolEnv(podTemplate *apiv1.PodTemplateSpec) *crd.Environment {
+	return &crd.Environment{
+		Metadata: metav1.ObjectMeta{
+			Name:      "go",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "4321",
+		},
+		Spec: fission.EnvironmentSpec{
+			Version:     2,
+			Runtime:     fission.Runtime{Image: "fission/go-env"},
+			Poolsize:    3,
+			PodTemplate: podTemplate,
+		},
+	}
+}
+
+// renderTestPool creates a pool for env and returns its deployment.
+func renderTestPool(t *testing.T, env *crd.Environment) *v1beta1.Deployment {
+	gp, err := MakeGenericPool(nil, fake.NewSimpleClientset(), env, int32(env.Spec.Poolsize), testNamespace, nil, "test")
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	close(gp.stopCh)
+
+	// the pool's instance id, and so its name, is random
+	depl := *gp.deployment
+	depl.ObjectMeta.Name = ""
+	return &depl
+}
+
+func TestPoolDeploymentGolden(t *testing.T) {
+	depl := renderTestPool(t, makeTestPoolEnv(nil))
+	testutil.CheckGoldenDeployment(t, "pool", depl)
+}
+
+func TestPoolDeploymentPodTemplateGolden(t *testing.T) {
+	runAsUser := int64(1000)
+	env := makeTestPoolEnv(&apiv1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"sidecar.istio.io/inject": "true"},
+		},
+		Spec: apiv1.PodSpec{
+			NodeSelector: map[string]string{"disktype": "ssd"},
+			Tolerations: []apiv1.Toleration{
+				{Key: "dedicated", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule},
+			},
+			SecurityContext: &apiv1.PodSecurityContext{RunAsUser: &runAsUser},
+			Containers: []apiv1.Container{
+				{
+					Name: "go",
+					Env:  []apiv1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}},
+				},
+			},
+		},
+	})
+	depl := renderTestPool(t, env)
+	testutil.CheckGoldenDeployment(t, "pool-podtemplate", depl)
+}
+
+func TestPoolInvalidPodTemplate(t *testing.T) {
+	env := makeTestPoolEnv(&apiv1.PodTemplateSpec{
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{{
+				Name:         "go",
+				VolumeMounts: []apiv1.VolumeMount{{Name: "missing", MountPath: "/data"}},
+			}},
+		},
+	})
+	_, err := MakeGenericPool(nil, fake.NewSimpleClientset(), env, int32(env.Spec.Poolsize), testNamespace, nil, "test")
+	if err == nil {
+		t.Fatalf("expected pool with an invalid pod template to fail")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// podFailure returns why a specialized pod can no longer serve its
+// function, or an empty string if it's fine.  A restarted container
+// has lost its specialization, so it's as good as dead.
+func podFailure(oldPod *apiv1.Pod, newPod *apiv1.Pod) string {
+	if newPod.Status.Phase == apiv1.PodFailed {
+		return "pod failed"
+	}
+	if newPod.ObjectMeta.DeletionTimestamp != nil {
+		return "pod is being deleted"
+	}
+	if oldPod == nil {
+		return ""
+	}
+	restarts := make(map[string]int32)
+	for _, cs := range oldPod.Status.ContainerStatuses {
+		restarts[cs.Name] = cs.RestartCount
+	}
+	for _, cs := range newPod.Status.ContainerStatuses {
+		if cs.RestartCount > restarts[cs.Name] {
+			return fmt.Sprintf("container %v restarted", cs.Name)
+		}
+	}
+	return ""
+}
+
+// findPodFuncSvc returns the cached function service of a specialized
+// pod.  Pods are matched by name and UID rather than address, since
+// the address may be a service, and pod IPs get reused.
+func (gpm *GenericPoolManager) findPodFuncSvc(pod *apiv1.Pod) (*fscache.FuncSvc, error) {
+	fsvcs, err := gpm.fsCache.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, fsvc := range fsvcs {
+		if fsvc.Executor != fission.ExecutorTypePoolmgr {
+			continue
+		}
+		for _, obj := range fsvc.KubernetesObjects {
+			if strings.ToLower(obj.Kind) == "pod" &&
+				obj.Name == pod.ObjectMeta.Name && obj.UID == pod.ObjectMeta.UID {
+				return fsvc, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// evictPod uncaches the function service of a dead pod, so that the
+// next request for the function specializes a new one.  Pods released
+// by the reaper are already gone from the cache, so this does nothing
+// for them.
+func (gpm *GenericPoolManager) evictPod(pod *apiv1.Pod, reason string) {
+	fsvc, err := gpm.findPodFuncSvc(pod)
+	if err != nil {
+		log.Printf("Error finding function service of pod %v: %v", pod.ObjectMeta.Name, err)
+		return
+	}
+	if fsvc == nil {
+		return
+	}
+	log.Printf("[%v] Evicting function service %v at %v: %v",
+		fsvc.Function.Name, fsvc.Name, fsvc.Address, reason)
+	gpm.releaseFuncSvc(fsvc, 0)
+}
+
+// watchSpecializedPods evicts the function services of specialized
+// pods that are deleted, fail, or restart.  Without this, a crashed
+// pod keeps getting requests until it's reaped for being idle.
+func (gpm *GenericPoolManager) watchSpecializedPods(stopCh <-chan struct{}) {
+	selector := labels.Set(map[string]string{
+		"unmanaged":                       "true",
+		fission.EXECUTOR_INSTANCEID_LABEL: gpm.instanceId,
+	}).AsSelector().String()
+	listWatch := &k8sCache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).Watch(options)
+		},
+	}
+	resyncPeriod := 30 * time.Second
+	_, controller := k8sCache.NewInformer(listWatch, &apiv1.Pod{}, resyncPeriod,
+		k8sCache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				pod := obj.(*apiv1.Pod)
+				if reason := podFailure(nil, pod); len(reason) > 0 {
+					gpm.evictPod(pod, reason)
+				}
+			},
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				pod := newObj.(*apiv1.Pod)
+				if reason := podFailure(oldObj.(*apiv1.Pod), pod); len(reason) > 0 {
+					gpm.evictPod(pod, reason)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				pod, ok := obj.(*apiv1.Pod)
+				if !ok {
+					tombstone, ok := obj.(k8sCache.DeletedFinalStateUnknown)
+					if !ok {
+						return
+					}
+					pod, ok = tombstone.Obj.(*apiv1.Pod)
+					if !ok {
+						return
+					}
+				}
+				gpm.evictPod(pod, "pod deleted")
+			},
+		})
+	controller.Run(stopCh)
+}
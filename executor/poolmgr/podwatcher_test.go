@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/fscache"
+)
+
+func makeWatcherTestPod(name string, restarts int32) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: "pod-uid-1"},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodRunning,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "fetcher", Ready: true},
+				{Name: "nodejs", Ready: true, RestartCount: restarts},
+			},
+		},
+	}
+}
+
+func TestPodFailure(t *testing.T) {
+	healthy := makeWatcherTestPod("hello-1", 0)
+	if reason := podFailure(nil, healthy); len(reason) > 0 {
+		t.Fatalf("expected new running pod to be fine, got '%v'", reason)
+	}
+	if reason := podFailure(healthy, healthy); len(reason) > 0 {
+		t.Fatalf("expected unchanged pod to be fine, got '%v'", reason)
+	}
+
+	restarted := makeWatcherTestPod("hello-1", 1)
+	if reason := podFailure(healthy, restarted); reason != "container nodejs restarted" {
+		t.Fatalf("expected container restart to be detected, got '%v'", reason)
+	}
+	// the restart was seen already
+	if reason := podFailure(restarted, restarted); len(reason) > 0 {
+		t.Fatalf("expected old restart to be ignored, got '%v'", reason)
+	}
+
+	failed := makeWatcherTestPod("hello-1", 0)
+	failed.Status.Phase = apiv1.PodFailed
+	if reason := podFailure(healthy, failed); reason != "pod failed" {
+		t.Fatalf("expected failed pod to be detected, got '%v'", reason)
+	}
+
+	deleting := makeWatcherTestPod("hello-1", 0)
+	now := metav1.Now()
+	deleting.ObjectMeta.DeletionTimestamp = &now
+	if reason := podFailure(healthy, deleting); len(reason) == 0 {
+		t.Fatalf("expected terminating pod to be detected")
+	}
+}
+
+func TestEvictPod(t *testing.T) {
+	pod := makeWatcherTestPod("hello-1", 0)
+	client := fake.NewSimpleClientset(pod)
+	fsCache := fscache.MakeFunctionServiceCache()
+	gpm := &GenericPoolManager{
+		kubernetesClient: client,
+		namespace:        testNamespace,
+		fsCache:          fsCache,
+		instanceId:       "abcd1234",
+	}
+
+	fn := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1"}
+	env := &crd.Environment{Metadata: metav1.ObjectMeta{Name: "nodejs", UID: "env-1"}}
+	_, err := fsCache.Add(fscache.FuncSvc{
+		Name:        pod.ObjectMeta.Name,
+		Function:    fn,
+		Environment: env,
+		Address:     "10.0.0.1:8888",
+		KubernetesObjects: []api.ObjectReference{
+			{Kind: "pod", Name: pod.ObjectMeta.Name, Namespace: testNamespace, UID: pod.ObjectMeta.UID},
+		},
+		Executor: fission.ExecutorTypePoolmgr,
+		Ctime:    time.Now(),
+		Atime:    time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("error caching function service: %v", err)
+	}
+
+	// a pod that reuses the name but isn't the one we specialized
+	other := makeWatcherTestPod("hello-1", 0)
+	other.ObjectMeta.UID = "other-uid"
+	gpm.evictPod(other, "pod deleted")
+	_, err = fsCache.GetByFunction(fn)
+	if err != nil {
+		t.Fatalf("expected function service of another pod to stay cached: %v", err)
+	}
+
+	gpm.evictPod(makeWatcherTestPod("hello-1", 1), "container nodejs restarted")
+	_, err = fsCache.GetByFunction(fn)
+	if err == nil {
+		t.Fatalf("expected function service of restarted pod to be evicted")
+	}
+	_, err = client.CoreV1().Pods(testNamespace).Get(pod.ObjectMeta.Name, metav1.GetOptions{})
+	if err == nil {
+		t.Fatalf("expected restarted pod to be deleted")
+	}
+
+	// evicting again, e.g. when the delete is observed, does nothing
+	gpm.evictPod(pod, "pod deleted")
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// Label of the pre-pull DaemonSets and their pods, with a hash of the
+// images they pull
+const PREPULL_IMAGES_LABEL = "prePullImages"
+
+const (
+	prePullInterval = 10 * time.Second
+
+	// the pre-pull pods' only long-running container
+	prePullPauseImage = "gcr.io/google_containers/pause-amd64:3.0"
+)
+
+// Pre-pulling runs a DaemonSet for each environment with PrePullImages
+// set.  Its pods pull the environment's images in init containers,
+// which only run a shell to exit right away, so the images need a
+// /bin/sh.  The DaemonSet is replaced when the images change and
+// deleted with the environment.
+//
+// The progress is kept in an annotation of the DaemonSet, not in the
+// environment: writing the environment would change its resource
+// version, which other components take for a new version of the
+// environment.  The controller reads it from there when it serves the
+// environment.
+
+// prePullImages returns the images pulled for env.
+func prePullImages(env *crd.Environment, fetcherImage string) []string {
+	images := []string{env.Spec.Runtime.Image}
+	if len(env.Spec.Builder.Image) > 0 {
+		images = append(images, env.Spec.Builder.Image)
+	}
+	return append(images, fetcherImage)
+}
+
+func prePullHash(images []string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join(images, "\n")))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+func prePullLabels(env *crd.Environment, images []string) map[string]string {
+	return map[string]string{
+		"environmentName":    env.Metadata.Name,
+		"environmentUid":     string(env.Metadata.UID),
+		"executorType":       fission.ExecutorTypePoolmgr,
+		PREPULL_IMAGES_LABEL: prePullHash(images),
+	}
+}
+
+// prePuller keeps the pre-pull DaemonSets in line with the environments,
+// and records their progress.
+func (gpm *GenericPoolManager) prePuller() {
+	for {
+		select {
+		case <-gpm.stopCh:
+			return
+		case <-time.After(prePullInterval):
+		}
+
+		envs, err := gpm.fissionClient.Environments(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			log.Printf("Error listing environments for image pre-pulls: %v", err)
+			continue
+		}
+		daemonSets, err := gpm.cleanupPrePulls(envs.Items)
+		if err != nil {
+			log.Printf("Error cleaning up image pre-pulls: %v", err)
+			continue
+		}
+
+		for i := range envs.Items {
+			env := &envs.Items[i]
+			if !env.Spec.PrePullImages {
+				continue
+			}
+			ds, status, err := gpm.syncPrePull(env, daemonSets[env.Metadata.UID])
+			if err != nil {
+				log.Printf("[%v] Error pre-pulling images: %v", env.Metadata.Name, err)
+				continue
+			}
+			if prePullStatusChanged(getPrePullAnnotation(ds), status) {
+				status.UpdateTime = metav1.Now()
+				gpm.setPrePullStatus(env, ds, status)
+			}
+		}
+	}
+}
+
+// cleanupPrePulls deletes the pre-pull DaemonSets of deleted
+// environments, of environments that no longer pre-pull, and of old
+// images.  It returns the remaining ones, by environment UID.
+func (gpm *GenericPoolManager) cleanupPrePulls(envs []crd.Environment) (map[types.UID]*v1beta1.DaemonSet, error) {
+	selector := labels.SelectorFromSet(map[string]string{
+		"executorType": fission.ExecutorTypePoolmgr,
+	})
+	prePull, err := labels.NewRequirement(PREPULL_IMAGES_LABEL, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	selector = selector.Add(*prePull)
+	dsList, err := gpm.kubernetesClient.ExtensionsV1beta1().DaemonSets(gpm.namespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[types.UID]*crd.Environment)
+	for i := range envs {
+		if envs[i].Spec.PrePullImages {
+			wanted[envs[i].Metadata.UID] = &envs[i]
+		}
+	}
+	fetcherImage := getFetcherImage()
+	daemonSets := make(map[types.UID]*v1beta1.DaemonSet)
+	for i := range dsList.Items {
+		ds := &dsList.Items[i]
+		uid := types.UID(ds.ObjectMeta.Labels["environmentUid"])
+		env, ok := wanted[uid]
+		if ok && ds.ObjectMeta.Labels[PREPULL_IMAGES_LABEL] == prePullHash(prePullImages(env, fetcherImage)) {
+			daemonSets[uid] = ds
+			continue
+		}
+		log.Printf("Deleting image pre-pull %v", ds.ObjectMeta.Name)
+		gpm.deletePrePull(ds)
+	}
+	return daemonSets, nil
+}
+
+// deletePrePull deletes a pre-pull DaemonSet and its pods.
+func (gpm *GenericPoolManager) deletePrePull(ds *v1beta1.DaemonSet) {
+	err := gpm.kubernetesClient.ExtensionsV1beta1().DaemonSets(gpm.namespace).Delete(ds.ObjectMeta.Name, nil)
+	if err != nil {
+		log.Printf("Error deleting daemonset, ignoring: %v", err)
+	}
+
+	// Like ReplicaSets of pool deployments, the pods may or may not
+	// be deleted with the DaemonSet, depending on the K8s version.
+	podList, err := gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(ds.ObjectMeta.Labels).String(),
+	})
+	if err != nil {
+		log.Printf("Error listing pods, ignoring: %v", err)
+		return
+	}
+	for _, pod := range podList.Items {
+		err = gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).Delete(pod.ObjectMeta.Name, nil)
+		if err != nil {
+			log.Printf("Error deleting pod, ignoring: %v", err)
+		}
+	}
+}
+
+// syncPrePull creates env's pre-pull DaemonSet unless ds is it, and
+// returns the DaemonSet and the progress of the pull.
+func (gpm *GenericPoolManager) syncPrePull(env *crd.Environment, ds *v1beta1.DaemonSet) (*v1beta1.DaemonSet, *fission.ImagePrePullStatus, error) {
+	images := prePullImages(env, getFetcherImage())
+	if ds == nil {
+		var err error
+		ds, err = gpm.createPrePull(env, images)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("[%v] Pre-pulling images %v", env.Metadata.Name, images)
+	}
+
+	podList, err := gpm.kubernetesClient.CoreV1().Pods(gpm.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(ds.ObjectMeta.Labels).String(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ds, getPrePullStatus(ds, podList.Items, images), nil
+}
+
+func (gpm *GenericPoolManager) createPrePull(env *crd.Environment, images []string) (*v1beta1.DaemonSet, error) {
+	prePullLabels := prePullLabels(env, images)
+
+	initContainers := make([]apiv1.Container, 0, len(images))
+	names := []string{"runtime", "builder", "fetcher"}
+	if len(env.Spec.Builder.Image) == 0 {
+		names = []string{"runtime", "fetcher"}
+	}
+	for i, image := range images {
+		initContainers = append(initContainers, apiv1.Container{
+			Name:            names[i],
+			Image:           image,
+			ImagePullPolicy: apiv1.PullIfNotPresent,
+			Command:         []string{"/bin/sh", "-c", "true"},
+		})
+	}
+
+	var gracePeriod int64 = 0
+	podSpec := apiv1.PodSpec{
+		InitContainers: initContainers,
+		Containers: []apiv1.Container{
+			{
+				Name:  "pause",
+				Image: prePullPauseImage,
+			},
+		},
+		TerminationGracePeriodSeconds: &gracePeriod,
+	}
+	// the pods go wherever the environment's pods may go
+	if template := env.Spec.PodTemplate; template != nil {
+		podSpec.NodeSelector = template.Spec.NodeSelector
+		podSpec.Affinity = template.Spec.Affinity
+		podSpec.Tolerations = template.Spec.Tolerations
+		podSpec.ImagePullSecrets = template.Spec.ImagePullSecrets
+	}
+
+	ds := &v1beta1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("prepull-%v-%v", env.Metadata.Name, env.Metadata.UID),
+			Namespace: gpm.namespace,
+			Labels:    prePullLabels,
+		},
+		Spec: v1beta1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: prePullLabels,
+			},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: prePullLabels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	return gpm.kubernetesClient.ExtensionsV1beta1().DaemonSets(gpm.namespace).Create(ds)
+}
+
+// isImagePullError is true for the reasons of containers that wait
+// because their image can't be pulled.
+func isImagePullError(reason string) bool {
+	switch reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull":
+		return true
+	}
+	return false
+}
+
+// getPrePullStatus computes the progress of a pre-pull DaemonSet from
+// its pods.  A node has pulled the images once all of its pod's init
+// containers have image IDs.
+func getPrePullStatus(ds *v1beta1.DaemonSet, pods []apiv1.Pod, images []string) *fission.ImagePrePullStatus {
+	status := &fission.ImagePrePullStatus{
+		Images:       images,
+		NodesDesired: int(ds.Status.DesiredNumberScheduled),
+	}
+	failures := make([]string, 0)
+	for _, pod := range pods {
+		pulled := len(pod.Status.InitContainerStatuses) == len(images)
+		for _, cs := range pod.Status.InitContainerStatuses {
+			if len(cs.ImageID) == 0 {
+				pulled = false
+			}
+			if w := cs.State.Waiting; w != nil && isImagePullError(w.Reason) {
+				failures = append(failures, fmt.Sprintf("%v on node %v: %v", cs.Image, pod.Spec.NodeName, w.Reason))
+			}
+		}
+		if pulled {
+			status.NodesPulled++
+		}
+	}
+
+	switch {
+	case len(failures) > 0:
+		status.Phase = fission.ImagePrePullFailed
+		status.Message = failures[0]
+		if len(failures) > 1 {
+			status.Message += fmt.Sprintf(" (and %v more)", len(failures)-1)
+		}
+	case status.NodesDesired == 0:
+		status.Phase = fission.ImagePrePullPending
+	case status.NodesPulled >= status.NodesDesired:
+		status.Phase = fission.ImagePrePullReady
+	default:
+		status.Phase = fission.ImagePrePullPulling
+	}
+	return status
+}
+
+// prePullStatusChanged is true if status should be written over old,
+// i.e. anything but its update time changed.
+func prePullStatusChanged(old *fission.ImagePrePullStatus, status *fission.ImagePrePullStatus) bool {
+	if old == nil {
+		return true
+	}
+	unchanged := *status
+	unchanged.UpdateTime = old.UpdateTime
+	return !reflect.DeepEqual(*old, unchanged)
+}
+
+// getPrePullAnnotation returns the pre-pull status written to ds, or
+// nil if there's none.
+func getPrePullAnnotation(ds *v1beta1.DaemonSet) *fission.ImagePrePullStatus {
+	value, ok := ds.ObjectMeta.Annotations[fission.IMAGE_PREPULL_STATUS_ANNOTATION]
+	if !ok {
+		return nil
+	}
+	status := &fission.ImagePrePullStatus{}
+	err := json.Unmarshal([]byte(value), status)
+	if err != nil {
+		log.Printf("Ignoring invalid image pre-pull status of %v: %v", ds.ObjectMeta.Name, err)
+		return nil
+	}
+	return status
+}
+
+// setPrePullStatus writes the pre-pull status of env to its DaemonSet.
+// Conflicting updates of the DaemonSet fail, and are retried by the
+// next round of prePuller.
+func (gpm *GenericPoolManager) setPrePullStatus(env *crd.Environment, ds *v1beta1.DaemonSet, status *fission.ImagePrePullStatus) {
+	value, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("[%v] Error encoding image pre-pull status: %v", env.Metadata.Name, err)
+		return
+	}
+	updated := *ds
+	updated.ObjectMeta.Annotations = make(map[string]string)
+	for k, v := range ds.ObjectMeta.Annotations {
+		updated.ObjectMeta.Annotations[k] = v
+	}
+	updated.ObjectMeta.Annotations[fission.IMAGE_PREPULL_STATUS_ANNOTATION] = string(value)
+	_, err = gpm.kubernetesClient.ExtensionsV1beta1().DaemonSets(gpm.namespace).Update(&updated)
+	if err != nil {
+		log.Printf("[%v] Error updating image pre-pull status: %v", env.Metadata.Name, err)
+	}
+}
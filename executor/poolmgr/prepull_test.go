@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func makePrePullTestPod(node string, statuses ...apiv1.ContainerStatus) apiv1.Pod {
+	return apiv1.Pod{
+		Spec:   apiv1.PodSpec{NodeName: node},
+		Status: apiv1.PodStatus{InitContainerStatuses: statuses},
+	}
+}
+
+func pulledStatus(image string) apiv1.ContainerStatus {
+	return apiv1.ContainerStatus{Image: image, ImageID: "docker-pullable://" + image + "@sha256:1234"}
+}
+
+func waitingStatus(image string, reason string) apiv1.ContainerStatus {
+	return apiv1.ContainerStatus{
+		Image: image,
+		State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: reason}},
+	}
+}
+
+func TestGetPrePullStatus(t *testing.T) {
+	images := []string{"fission/go-env", "fission/fetcher"}
+	ds := &v1beta1.DaemonSet{Status: v1beta1.DaemonSetStatus{DesiredNumberScheduled: 2}}
+
+	tests := []struct {
+		desired int32
+		pods    []apiv1.Pod
+		phase   fission.ImagePrePullPhase
+		pulled  int
+	}{
+		{0, nil, fission.ImagePrePullPending, 0},
+		{2, []apiv1.Pod{
+			makePrePullTestPod("node-1", pulledStatus(images[0]), pulledStatus(images[1])),
+			makePrePullTestPod("node-2", pulledStatus(images[0]), waitingStatus(images[1], "PodInitializing")),
+		}, fission.ImagePrePullPulling, 1},
+		{2, []apiv1.Pod{
+			makePrePullTestPod("node-1", pulledStatus(images[0]), pulledStatus(images[1])),
+			makePrePullTestPod("node-2", pulledStatus(images[0]), pulledStatus(images[1])),
+		}, fission.ImagePrePullReady, 2},
+		{2, []apiv1.Pod{
+			makePrePullTestPod("node-1", pulledStatus(images[0]), pulledStatus(images[1])),
+			makePrePullTestPod("node-2", waitingStatus(images[0], "ImagePullBackOff"), waitingStatus(images[1], "PodInitializing")),
+		}, fission.ImagePrePullFailed, 1},
+	}
+	for i, test := range tests {
+		ds.Status.DesiredNumberScheduled = test.desired
+		status := getPrePullStatus(ds, test.pods, images)
+		if status.Phase != test.phase || status.NodesPulled != test.pulled {
+			t.Errorf("test %v: expected %v with %v nodes pulled, got %v with %v",
+				i, test.phase, test.pulled, status.Phase, status.NodesPulled)
+		}
+		if status.Phase == fission.ImagePrePullFailed && status.Message != "fission/go-env on node node-2: ImagePullBackOff" {
+			t.Errorf("test %v: unexpected message %v", i, status.Message)
+		}
+	}
+}
+
+func TestPrePullStatusChanged(t *testing.T) {
+	now := time.Now()
+	old := &fission.ImagePrePullStatus{
+		Phase:        fission.ImagePrePullPulling,
+		Images:       []string{"fission/go-env"},
+		NodesDesired: 3,
+		NodesPulled:  1,
+		UpdateTime:   metav1.NewTime(now),
+	}
+	same := *old
+	same.UpdateTime = metav1.NewTime(now.Add(time.Minute))
+	if prePullStatusChanged(old, &same) {
+		t.Errorf("expected an unchanged status not to be written")
+	}
+	progress := *old
+	progress.NodesPulled = 2
+	if !prePullStatusChanged(old, &progress) {
+		t.Errorf("expected progress to be written")
+	}
+	ready := progress
+	ready.Phase = fission.ImagePrePullReady
+	if !prePullStatusChanged(old, &ready) {
+		t.Errorf("expected phase change to be written")
+	}
+	if !prePullStatusChanged(nil, old) {
+		t.Errorf("expected first status to be written")
+	}
+}
+
+func TestPrePullDaemonSets(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	gpm := &GenericPoolManager{
+		kubernetesClient: client,
+		namespace:        testNamespace,
+	}
+	env := crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "go", Namespace: metav1.NamespaceDefault, UID: "env-1"},
+		Spec: fission.EnvironmentSpec{
+			Version:       2,
+			Runtime:       fission.Runtime{Image: "fission/go-env"},
+			Builder:       fission.Builder{Image: "fission/go-builder"},
+			PrePullImages: true,
+		},
+	}
+
+	daemonSets, err := gpm.cleanupPrePulls([]crd.Environment{env})
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+	if len(daemonSets) != 0 {
+		t.Fatalf("expected no daemonsets, got %v", len(daemonSets))
+	}
+	created, status, err := gpm.syncPrePull(&env, nil)
+	if err != nil {
+		t.Fatalf("error creating pre-pull: %v", err)
+	}
+	if status.Phase != fission.ImagePrePullPending || len(status.Images) != 3 {
+		t.Fatalf("unexpected status %v", status)
+	}
+
+	// the status goes to the daemonset, not the environment
+	if getPrePullAnnotation(created) != nil {
+		t.Fatalf("expected a new daemonset to have no status")
+	}
+	gpm.setPrePullStatus(&env, created, status)
+
+	dsList, err := client.ExtensionsV1beta1().DaemonSets(testNamespace).List(metav1.ListOptions{})
+	if err != nil || len(dsList.Items) != 1 {
+		t.Fatalf("expected one daemonset, got %v, %v", dsList, err)
+	}
+	ds := dsList.Items[0]
+	written := getPrePullAnnotation(&ds)
+	if written == nil || prePullStatusChanged(written, status) {
+		t.Fatalf("expected status %v on the daemonset, got %v", status, written)
+	}
+	initContainers := ds.Spec.Template.Spec.InitContainers
+	if len(initContainers) != 3 || initContainers[1].Name != "builder" || initContainers[1].Image != "fission/go-builder" {
+		t.Fatalf("unexpected init containers %v", initContainers)
+	}
+	_, err = client.CoreV1().Pods(testNamespace).Create(&apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prepull-pod", Namespace: testNamespace, Labels: ds.ObjectMeta.Labels},
+	})
+	if err != nil {
+		t.Fatalf("error creating pod: %v", err)
+	}
+
+	// unchanged environments keep their daemonset
+	daemonSets, err = gpm.cleanupPrePulls([]crd.Environment{env})
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+	if daemonSets[env.Metadata.UID] == nil {
+		t.Fatalf("expected the daemonset to be kept")
+	}
+
+	// a new image replaces it
+	env.Spec.Runtime.Image = "fission/go-env:1.9"
+	daemonSets, err = gpm.cleanupPrePulls([]crd.Environment{env})
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+	if len(daemonSets) != 0 {
+		t.Fatalf("expected the old daemonset to be deleted")
+	}
+	podList, err := client.CoreV1().Pods(testNamespace).List(metav1.ListOptions{})
+	if err != nil || len(podList.Items) != 0 {
+		t.Fatalf("expected the old daemonset's pods to be deleted, got %v, %v", podList, err)
+	}
+	_, _, err = gpm.syncPrePull(&env, nil)
+	if err != nil {
+		t.Fatalf("error creating pre-pull: %v", err)
+	}
+
+	// deleted environments lose it
+	_, err = gpm.cleanupPrePulls(nil)
+	if err != nil {
+		t.Fatalf("error cleaning up: %v", err)
+	}
+	dsList, err = client.ExtensionsV1beta1().DaemonSets(testNamespace).List(metav1.ListOptions{})
+	if err != nil || len(dsList.Items) != 0 {
+		t.Fatalf("expected no daemonsets, got %v, %v", dsList, err)
+	}
+}
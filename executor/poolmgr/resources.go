@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission/crd"
+)
+
+// Label of the pods of a sub-pool, with the sub-pool's profile key
+const RESOURCE_PROFILE_LABEL = "resourceProfile"
+
+// A resourceProfile describes the pods of a sub-pool.  Functions whose
+// resources differ from their environment's get pods from a sub-pool
+// of the environment, one for each distinct set of resources.
+type resourceProfile struct {
+	key       string // hash of the resources, usable as a label value
+	resources apiv1.ResourceRequirements
+}
+
+// getResources returns the environment's resources, overridden by
+// those set on the function.
+func getResources(env *crd.Environment, fn *crd.Function) apiv1.ResourceRequirements {
+	resources := apiv1.ResourceRequirements{
+		Requests: make(apiv1.ResourceList),
+		Limits:   make(apiv1.ResourceList),
+	}
+	for name, quantity := range env.Spec.Resources.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range env.Spec.Resources.Limits {
+		resources.Limits[name] = quantity
+	}
+	for name, quantity := range fn.Spec.Resources.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range fn.Spec.Resources.Limits {
+		resources.Limits[name] = quantity
+	}
+	return resources
+}
+
+// getResourceProfile returns the profile of the sub-pool that fn's pods
+// come from, or nil if fn can use the environment's pool.  It fails if
+// the function's resources don't make a valid pod, e.g. because the
+// function requests more than the environment's limit.
+func getResourceProfile(env *crd.Environment, fn *crd.Function) (*resourceProfile, error) {
+	resources := getResources(env, fn)
+	for name, request := range resources.Requests {
+		limit, ok := resources.Limits[name]
+		if ok && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("function %v requests %v of %v, more than its limit of %v",
+				fn.Metadata.Name, request.String(), name, limit.String())
+		}
+	}
+
+	spec := resourceSpec(resources)
+	if spec == resourceSpec(env.Spec.Resources) {
+		return nil, nil
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(spec))
+	return &resourceProfile{
+		key:       fmt.Sprintf("%08x", hash.Sum32()),
+		resources: resources,
+	}, nil
+}
+
+// resourceSpec formats resources canonically, so that equal resources
+// have equal specs.
+func resourceSpec(resources apiv1.ResourceRequirements) string {
+	format := func(list apiv1.ResourceList) string {
+		quantities := make([]string, 0, len(list))
+		for name, quantity := range list {
+			quantities = append(quantities, fmt.Sprintf("%v=%v", name, quantity.String()))
+		}
+		sort.Strings(quantities)
+		return strings.Join(quantities, ",")
+	}
+	return fmt.Sprintf("requests:%v;limits:%v", format(resources.Requests), format(resources.Limits))
+}
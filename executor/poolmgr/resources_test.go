@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+func makeResourceTestEnv() *crd.Environment {
+	return &crd.Environment{
+		Metadata: metav1.ObjectMeta{Name: "go", Namespace: metav1.NamespaceDefault, UID: "4321", ResourceVersion: "1"},
+		Spec: fission.EnvironmentSpec{
+			Version:  2,
+			Runtime:  fission.Runtime{Image: "fission/go-env"},
+			Poolsize: 3,
+			Resources: apiv1.ResourceRequirements{
+				Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("64Mi")},
+				Limits:   apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("128Mi")},
+			},
+		},
+	}
+}
+
+func makeResourceTestFunction(resources apiv1.ResourceRequirements) *crd.Function {
+	return &crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "fn-1"},
+		Spec:     fission.FunctionSpec{Resources: resources},
+	}
+}
+
+func TestGetResourceProfile(t *testing.T) {
+	env := makeResourceTestEnv()
+
+	// no resources of its own, or the same as the environment's
+	for _, resources := range []apiv1.ResourceRequirements{
+		{},
+		{Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("128Mi")}},
+	} {
+		profile, err := getResourceProfile(env, makeResourceTestFunction(resources))
+		if err != nil {
+			t.Fatalf("error getting resource profile: %v", err)
+		}
+		if profile != nil {
+			t.Fatalf("expected function to use the environment's pool, got profile %v", profile.key)
+		}
+	}
+
+	// more memory, and a CPU limit
+	big := makeResourceTestFunction(apiv1.ResourceRequirements{
+		Limits: apiv1.ResourceList{
+			apiv1.ResourceMemory: resource.MustParse("512Mi"),
+			apiv1.ResourceCPU:    resource.MustParse("500m"),
+		},
+	})
+	profile, err := getResourceProfile(env, big)
+	if err != nil {
+		t.Fatalf("error getting resource profile: %v", err)
+	}
+	if profile == nil {
+		t.Fatalf("expected function to need a sub-pool")
+	}
+	memRequest := profile.resources.Requests[apiv1.ResourceMemory]
+	memLimit := profile.resources.Limits[apiv1.ResourceMemory]
+	if memRequest.String() != "64Mi" || memLimit.String() != "512Mi" {
+		t.Fatalf("expected function resources to override the environment's, got %v", resourceSpec(profile.resources))
+	}
+
+	// the same resources, written differently, get the same sub-pool
+	same := makeResourceTestFunction(apiv1.ResourceRequirements{
+		Limits: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("0.5"),
+			apiv1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	})
+	sameProfile, err := getResourceProfile(env, same)
+	if err != nil {
+		t.Fatalf("error getting resource profile: %v", err)
+	}
+	if sameProfile == nil || sameProfile.key != profile.key {
+		t.Fatalf("expected equal resources to have the same profile")
+	}
+
+	// requests above the environment's limit can't be satisfied
+	tooBig := makeResourceTestFunction(apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("256Mi")},
+	})
+	_, err = getResourceProfile(env, tooBig)
+	if err == nil {
+		t.Fatalf("expected request above the limit to fail")
+	}
+}
+
+func TestPoolManagerSubPools(t *testing.T) {
+	gpm := &GenericPoolManager{
+		pools:            make(map[string]*GenericPool),
+		subPoolsUsed:     make(map[string]time.Time),
+		kubernetesClient: fake.NewSimpleClientset(),
+		namespace:        testNamespace,
+		instanceId:       "test",
+		requestChannel:   make(chan *request),
+	}
+	go gpm.service()
+
+	env := makeResourceTestEnv()
+	pool, err := gpm.getFunctionPool(makeResourceTestFunction(apiv1.ResourceRequirements{}), env)
+	if err != nil {
+		t.Fatalf("error getting pool: %v", err)
+	}
+	defer close(pool.stopCh)
+	if pool.profile != nil {
+		t.Fatalf("expected the environment's pool")
+	}
+
+	big := makeResourceTestFunction(apiv1.ResourceRequirements{
+		Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")},
+	})
+	subPool, err := gpm.getFunctionPool(big, env)
+	if err != nil {
+		t.Fatalf("error getting sub-pool: %v", err)
+	}
+	defer close(subPool.stopCh)
+	if subPool == pool || subPool.profile == nil {
+		t.Fatalf("expected a sub-pool for the function's resources")
+	}
+	if replicas := getPoolReplicas(t, subPool); replicas != 1 {
+		t.Fatalf("expected sub-pool of 1, got %v", replicas)
+	}
+
+	// the sub-pool's pods have the function's resources
+	depl, err := gpm.kubernetesClient.ExtensionsV1beta1().Deployments(testNamespace).Get(
+		subPool.deployment.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting sub-pool deployment: %v", err)
+	}
+	memLimit := depl.Spec.Template.Spec.Containers[0].Resources.Limits[apiv1.ResourceMemory]
+	if memLimit.String() != "512Mi" {
+		t.Fatalf("expected sub-pool pods with 512Mi of memory, got %v", memLimit.String())
+	}
+
+	// and aren't counted as the environment pool's pods
+	subPoolLabels := labels.Set(subPool.labelsForPool)
+	if pool.poolSelector.Matches(subPoolLabels) {
+		t.Fatalf("expected environment pool not to match sub-pool pods")
+	}
+	if !subPool.poolSelector.Matches(subPoolLabels) || subPool.poolSelector.Matches(labels.Set(pool.labelsForPool)) {
+		t.Fatalf("expected sub-pool to match only its own pods")
+	}
+
+	// functions with the same resources share the sub-pool
+	sameSubPool, err := gpm.getFunctionPool(big, env)
+	if err != nil {
+		t.Fatalf("error getting sub-pool: %v", err)
+	}
+	if sameSubPool != subPool {
+		t.Fatalf("expected sub-pool to be reused")
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fission/fission/executor/fscache"
+)
+
+// Specializations that take longer than this fail, unless
+// EXECUTOR_SPECIALIZATION_TIMEOUT says otherwise.
+const defaultSpecializationTimeout = 5 * time.Minute
+
+type (
+	specializationRequestType int
+
+	// specializeFunc specializes an instance of a function.  ctx is
+	// done once nobody is waiting for the result any more.
+	specializeFunc func(ctx context.Context) (*fscache.FuncSvc, error)
+
+	// specializationGroup coalesces concurrent specializations of the
+	// same function: the first request starts one, later ones wait
+	// for its result.  A specialization's context is done when it
+	// exceeds the group's deadline, or when all its waiters give up.
+	//
+	// A call stays registered until its backend returns, so that a
+	// function is never specialized twice at once.  Requests that
+	// arrive while an abandoned call winds down wait for it: they get
+	// its function service if it finished anyway, and start over
+	// otherwise.
+	//
+	// All state is owned by the service goroutine.
+	specializationGroup struct {
+		timeout        time.Duration
+		calls          map[string]*specializationCall // by function cache key
+		requestChannel chan *specializationRequest
+	}
+
+	specializationCall struct {
+		key     string
+		waiters int
+		cancel  context.CancelFunc
+
+		// set once all waiters gave up; pending holds the requests
+		// that joined since
+		abandoned bool
+		pending   []*specializationRequest
+
+		// closed once fsvc and err are set
+		done chan struct{}
+		fsvc *fscache.FuncSvc
+		err  error
+	}
+
+	specializationRequest struct {
+		requestType     specializationRequestType
+		key             string
+		specialize      specializeFunc
+		wait            bool
+		call            *specializationCall
+		fsvc            *fscache.FuncSvc
+		err             error
+		responseChannel chan *specializationCall
+	}
+)
+
+const (
+	JOIN_SPECIALIZATION specializationRequestType = iota
+	LEAVE_SPECIALIZATION
+	FINISH_SPECIALIZATION
+)
+
+func makeSpecializationGroup(timeout time.Duration) *specializationGroup {
+	g := &specializationGroup{
+		timeout:        timeout,
+		calls:          make(map[string]*specializationCall),
+		requestChannel: make(chan *specializationRequest),
+	}
+	go g.service()
+	return g
+}
+
+// getSpecializationTimeout returns the specialization deadline, from
+// EXECUTOR_SPECIALIZATION_TIMEOUT.
+func getSpecializationTimeout() time.Duration {
+	value := os.Getenv("EXECUTOR_SPECIALIZATION_TIMEOUT")
+	if len(value) == 0 {
+		return defaultSpecializationTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("Invalid EXECUTOR_SPECIALIZATION_TIMEOUT '%v', using %v", value, defaultSpecializationTimeout)
+		return defaultSpecializationTimeout
+	}
+	return timeout
+}
+
+func (g *specializationGroup) service() {
+	for {
+		req := <-g.requestChannel
+		switch req.requestType {
+		case JOIN_SPECIALIZATION:
+			call, found := g.calls[req.key]
+			if !found {
+				call = g.start(req.key, req.specialize)
+			} else if call.abandoned && req.wait {
+				call.pending = append(call.pending, req)
+				continue
+			} else if !req.wait {
+				// nobody waits for this request; drop it
+				req.responseChannel <- nil
+				continue
+			}
+			if req.wait {
+				call.waiters++
+			}
+			req.responseChannel <- call
+		case LEAVE_SPECIALIZATION:
+			call := req.call
+			call.waiters--
+			if call.waiters == 0 {
+				// Abandon the call.  No-op if it's finished already.
+				call.abandoned = true
+				call.cancel()
+			}
+		case FINISH_SPECIALIZATION:
+			call := req.call
+			delete(g.calls, call.key)
+			call.fsvc, call.err = req.fsvc, req.err
+			call.cancel()
+			close(call.done)
+			if len(call.pending) == 0 {
+				continue
+			}
+			next := call
+			if call.err != nil {
+				// the abandoned call didn't get anywhere; start over
+				// for the requests that joined since
+				next = g.start(call.key, call.pending[0].specialize)
+				next.waiters = len(call.pending)
+			}
+			for _, p := range call.pending {
+				p.responseChannel <- next
+			}
+		}
+	}
+}
+
+// start runs a specialization.  Called from service().
+func (g *specializationGroup) start(key string, specialize specializeFunc) *specializationCall {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	call := &specializationCall{
+		key:    key,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	g.calls[key] = call
+
+	go func() {
+		fsvc, err := specialize(ctx)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("specialization timed out after %v", g.timeout)
+		}
+		g.requestChannel <- &specializationRequest{
+			requestType: FINISH_SPECIALIZATION,
+			call:        call,
+			fsvc:        fsvc,
+			err:         err,
+		}
+	}()
+	return call
+}
+
+// do returns the result of specializing key, starting a specialization
+// unless there's one in progress already.  It returns early with ctx's
+// error if ctx is done first; an abandoned specialization in progress
+// is waited for regardless, since it's canceled already.
+func (g *specializationGroup) do(ctx context.Context, key string, specialize specializeFunc) (*fscache.FuncSvc, error) {
+	responseChannel := make(chan *specializationCall)
+	g.requestChannel <- &specializationRequest{
+		requestType:     JOIN_SPECIALIZATION,
+		key:             key,
+		specialize:      specialize,
+		wait:            true,
+		responseChannel: responseChannel,
+	}
+	call := <-responseChannel
+
+	select {
+	case <-call.done:
+		return call.fsvc, call.err
+	case <-ctx.Done():
+		g.requestChannel <- &specializationRequest{
+			requestType: LEAVE_SPECIALIZATION,
+			call:        call,
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// startIfIdle starts a specialization nobody waits for, unless there's
+// one in progress for key already.  Returns false if there is.
+func (g *specializationGroup) startIfIdle(key string, specialize specializeFunc) bool {
+	responseChannel := make(chan *specializationCall)
+	g.requestChannel <- &specializationRequest{
+		requestType:     JOIN_SPECIALIZATION,
+		key:             key,
+		specialize:      specialize,
+		responseChannel: responseChannel,
+	}
+	return <-responseChannel != nil
+}
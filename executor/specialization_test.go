@@ -0,0 +1,256 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fission/fission/executor/fscache"
+)
+
+// slowSpecialization blocks until it's released or canceled, counting
+// its calls.  With ignoreCancel it runs on until it's released.
+type slowSpecialization struct {
+	calls        int32
+	started      chan struct{}
+	release      chan struct{}
+	fsvc         *fscache.FuncSvc
+	err          error
+	canceled     chan struct{}
+	ignoreCancel bool
+}
+
+func makeSlowSpecialization(fsvc *fscache.FuncSvc, err error) *slowSpecialization {
+	return &slowSpecialization{
+		started:  make(chan struct{}, 100),
+		release:  make(chan struct{}),
+		fsvc:     fsvc,
+		err:      err,
+		canceled: make(chan struct{}, 100),
+	}
+}
+
+func (s *slowSpecialization) specialize(ctx context.Context) (*fscache.FuncSvc, error) {
+	atomic.AddInt32(&s.calls, 1)
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		s.canceled <- struct{}{}
+		if !s.ignoreCancel {
+			return nil, ctx.Err()
+		}
+		<-s.release
+	}
+	return s.fsvc, s.err
+}
+
+func expectSignal(t *testing.T, ch chan struct{}, what string) {
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", what)
+	}
+}
+
+type specializationResult struct {
+	fsvc *fscache.FuncSvc
+	err  error
+}
+
+// startWaiters calls do from n goroutines, returning their results
+func startWaiters(g *specializationGroup, ctx context.Context, n int, s *slowSpecialization) chan specializationResult {
+	results := make(chan specializationResult, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			fsvc, err := g.do(ctx, "fn", s.specialize)
+			results <- specializationResult{fsvc: fsvc, err: err}
+		}()
+	}
+	return results
+}
+
+func TestSpecializationCoalescing(t *testing.T) {
+	g := makeSpecializationGroup(time.Minute)
+	s := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.1:8888"}, nil)
+
+	results := startWaiters(g, context.Background(), 10, s)
+	expectSignal(t, s.started, "specialization to start")
+	// let every waiter join before finishing
+	time.Sleep(50 * time.Millisecond)
+	close(s.release)
+
+	for i := 0; i < 10; i++ {
+		r := <-results
+		if r.err != nil || r.fsvc == nil || r.fsvc.Address != "10.0.0.1:8888" {
+			t.Fatalf("unexpected result %v, %v", r.fsvc, r.err)
+		}
+	}
+	if calls := atomic.LoadInt32(&s.calls); calls != 1 {
+		t.Fatalf("expected 1 specialization, got %v", calls)
+	}
+
+	// once it's done, the next request starts over
+	fsvc, err := g.do(context.Background(), "fn", s.specialize)
+	if err != nil || fsvc.Address != "10.0.0.1:8888" {
+		t.Fatalf("unexpected result %v, %v", fsvc, err)
+	}
+	if calls := atomic.LoadInt32(&s.calls); calls != 2 {
+		t.Fatalf("expected a second specialization, got %v", calls)
+	}
+}
+
+func TestSpecializationFailure(t *testing.T) {
+	g := makeSpecializationGroup(time.Minute)
+	s := makeSlowSpecialization(nil, errors.New("no pods"))
+
+	results := startWaiters(g, context.Background(), 5, s)
+	expectSignal(t, s.started, "specialization to start")
+	time.Sleep(50 * time.Millisecond)
+	close(s.release)
+
+	for i := 0; i < 5; i++ {
+		r := <-results
+		if r.err == nil || r.err.Error() != "no pods" {
+			t.Fatalf("expected every waiter to get the error, got %v", r.err)
+		}
+	}
+}
+
+func TestSpecializationTimeout(t *testing.T) {
+	g := makeSpecializationGroup(100 * time.Millisecond)
+	s := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.1:8888"}, nil)
+	defer close(s.release)
+
+	results := startWaiters(g, context.Background(), 3, s)
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-results:
+			if r.err == nil || r.err.Error() != "specialization timed out after 100ms" {
+				t.Fatalf("expected hung specialization to time out, got %v", r.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("waiters weren't released at the deadline")
+		}
+	}
+	expectSignal(t, s.canceled, "specialization to be canceled")
+}
+
+func TestSpecializationCancellation(t *testing.T) {
+	g := makeSpecializationGroup(time.Minute)
+	s := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.1:8888"}, nil)
+	defer close(s.release)
+
+	// one waiter gives up; the other still waits
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	results1 := startWaiters(g, ctx1, 1, s)
+	expectSignal(t, s.started, "specialization to start")
+	results2 := startWaiters(g, ctx2, 1, s)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel1()
+	r := <-results1
+	if r.err != context.Canceled {
+		t.Fatalf("expected canceled waiter to get %v, got %v", context.Canceled, r.err)
+	}
+	select {
+	case <-s.canceled:
+		t.Fatalf("specialization was canceled while someone was still waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the last waiter gives up, and the specialization is abandoned
+	cancel2()
+	r = <-results2
+	if r.err != context.Canceled {
+		t.Fatalf("expected canceled waiter to get %v, got %v", context.Canceled, r.err)
+	}
+	expectSignal(t, s.canceled, "specialization to be canceled")
+
+	// a later request starts a new specialization
+	s2 := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.2:8888"}, nil)
+	close(s2.release)
+	fsvc, err := g.do(context.Background(), "fn", s2.specialize)
+	if err != nil || fsvc.Address != "10.0.0.2:8888" {
+		t.Fatalf("unexpected result %v, %v", fsvc, err)
+	}
+}
+
+func TestSpecializationAbandonedRunsOn(t *testing.T) {
+	g := makeSpecializationGroup(time.Minute)
+	s := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.1:8888"}, nil)
+	s.ignoreCancel = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := startWaiters(g, ctx, 1, s)
+	expectSignal(t, s.started, "specialization to start")
+	cancel()
+	<-results
+	expectSignal(t, s.canceled, "specialization to be canceled")
+
+	// the backend is still at it, so a new request waits for it
+	// rather than specializing the function a second time
+	later := startWaiters(g, context.Background(), 1, s)
+	time.Sleep(50 * time.Millisecond)
+	if calls := atomic.LoadInt32(&s.calls); calls != 1 {
+		t.Fatalf("expected 1 specialization, got %v", calls)
+	}
+	close(s.release)
+	r := <-later
+	if r.err != nil || r.fsvc == nil || r.fsvc.Address != "10.0.0.1:8888" {
+		t.Fatalf("unexpected result %v, %v", r.fsvc, r.err)
+	}
+	if calls := atomic.LoadInt32(&s.calls); calls != 1 {
+		t.Fatalf("expected 1 specialization, got %v", calls)
+	}
+}
+
+func TestSpecializationStartIfIdle(t *testing.T) {
+	g := makeSpecializationGroup(time.Minute)
+	s := makeSlowSpecialization(&fscache.FuncSvc{Address: "10.0.0.1:8888"}, nil)
+
+	if !g.startIfIdle("fn", s.specialize) {
+		t.Fatalf("expected scale out of idle function to start")
+	}
+	expectSignal(t, s.started, "specialization to start")
+	if g.startIfIdle("fn", s.specialize) {
+		t.Fatalf("expected scale out to be dropped while another is in progress")
+	}
+
+	// requests for the function wait for the one in progress
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fsvc, err := g.do(context.Background(), "fn", s.specialize)
+		if err != nil || fsvc.Address != "10.0.0.1:8888" {
+			t.Errorf("unexpected result %v, %v", fsvc, err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(s.release)
+	wg.Wait()
+	if calls := atomic.LoadInt32(&s.calls); calls != 1 {
+		t.Fatalf("expected 1 specialization, got %v", calls)
+	}
+}
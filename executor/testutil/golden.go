@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil has helpers shared by the executor's tests.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// normalizeDeployment round-trips serialized deployment through the
+// Deployment type, so that golden files only need the fields that
+// are set, in any order.
+func normalizeDeployment(t *testing.T, b []byte) []byte {
+	var depl v1beta1.Deployment
+	err := json.Unmarshal(b, &depl)
+	if err != nil {
+		t.Fatalf("error parsing deployment: %v", err)
+	}
+	out, err := json.MarshalIndent(&depl, "", "  ")
+	if err != nil {
+		t.Fatalf("error serializing deployment: %v", err)
+	}
+	return out
+}
+
+// CheckGoldenDeployment compares depl with testdata/<name>.json of
+// the package under test.  Run the tests with -update to rewrite the
+// golden files.
+func CheckGoldenDeployment(t *testing.T, name string, depl *v1beta1.Deployment) {
+	path := filepath.Join("testdata", name+".json")
+
+	rendered, err := json.MarshalIndent(depl, "", "  ")
+	if err != nil {
+		t.Fatalf("error serializing deployment: %v", err)
+	}
+	if *updateGolden {
+		err = ioutil.WriteFile(path, append(rendered, '\n'), 0644)
+		if err != nil {
+			t.Fatalf("error writing %v: %v", path, err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %v: %v", path, err)
+	}
+	actual := normalizeDeployment(t, rendered)
+	expected := normalizeDeployment(t, golden)
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("rendered deployment doesn't match %v (run with -update to regenerate):\n%s", path, actual)
+	}
+}
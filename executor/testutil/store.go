@@ -0,0 +1,53 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"fmt"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/crd"
+)
+
+// Store is a backend.FunctionStore serving a fixed set of functions,
+// by name, and one environment and package, whatever the namespace.
+// Tests may change them between calls.
+type Store struct {
+	Functions   map[string]*crd.Function
+	Environment *crd.Environment
+	Package     *crd.Package
+}
+
+func (s *Store) GetFunction(namespace string, name string) (*crd.Function, error) {
+	fn, ok := s.Functions[name]
+	if !ok {
+		return nil, fission.MakeError(fission.ErrorNotFound, fmt.Sprintf("function %v not found", name))
+	}
+	return fn, nil
+}
+func (s *Store) GetEnvironment(namespace string, name string) (*crd.Environment, error) {
+	return s.Environment, nil
+}
+func (s *Store) ListEnvironments() ([]crd.Environment, error) {
+	return []crd.Environment{*s.Environment}, nil
+}
+func (s *Store) GetPackage(namespace string, name string) (*crd.Package, error) {
+	if s.Package == nil {
+		return nil, fission.MakeError(fission.ErrorNotFound, name)
+	}
+	return s.Package, nil
+}
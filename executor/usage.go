@@ -0,0 +1,336 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	"github.com/fission/fission/executor/backend"
+	executorClient "github.com/fission/fission/executor/client"
+	"github.com/fission/fission/executor/fscache"
+)
+
+// Usage accounting: the executor periodically charges each running
+// function service's pods, and the resources they requested, to its
+// function, and routers report how often they invoked each function.
+// Both add up in one record per function and hour, which the executor
+// keeps in a JSON file given by EXECUTOR_USAGE_STORE, or only in
+// memory if that's not set.  The file should be on a volume that
+// outlives the executor's pod: each new leader loads it.
+const (
+	usageInterval         = 30 * time.Second
+	usagePeriod           = time.Hour
+	defaultUsageRetention = 90 * 24 * time.Hour
+	gibibyte              = 1 << 30
+)
+
+type (
+	usageStore struct {
+		lock      sync.Mutex
+		saveLock  sync.Mutex // serializes writes of the file
+		path      string     // empty if not persisted
+		retention time.Duration
+		records   map[usageKey]*fission.UsageRecord
+	}
+
+	usageKey struct {
+		namespace string
+		function  string
+		start     int64 // unix seconds
+	}
+)
+
+// getUsageRetention returns how long usage records are kept, from
+// EXECUTOR_USAGE_RETENTION.
+func getUsageRetention() time.Duration {
+	value := os.Getenv("EXECUTOR_USAGE_RETENTION")
+	if len(value) == 0 {
+		return defaultUsageRetention
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		log.Printf("Invalid EXECUTOR_USAGE_RETENTION '%v', using %v", value, defaultUsageRetention)
+		return defaultUsageRetention
+	}
+	return retention
+}
+
+// makeUsageStore makes a usage store, with the records saved at path
+// earlier, if any.
+func makeUsageStore(path string, retention time.Duration) *usageStore {
+	store := &usageStore{
+		path:      path,
+		retention: retention,
+		records:   make(map[usageKey]*fission.UsageRecord),
+	}
+	if len(path) == 0 {
+		return store
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading usage store %v: %v", path, err)
+		}
+		return store
+	}
+	records := make([]fission.UsageRecord, 0)
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		log.Printf("Error parsing usage store %v, starting over: %v", path, err)
+		return store
+	}
+	for i := range records {
+		r := records[i]
+		store.records[usageKey{r.Namespace, r.Function, r.Start.Unix()}] = &r
+	}
+	log.Printf("Loaded %v usage records from %v", len(records), path)
+	return store
+}
+
+// record returns the record of a function for the period that
+// includes t.  The caller holds the lock.
+func (s *usageStore) record(namespace string, function string, t time.Time) *fission.UsageRecord {
+	start := t.Truncate(usagePeriod)
+	key := usageKey{namespace, function, start.Unix()}
+	r, ok := s.records[key]
+	if !ok {
+		r = &fission.UsageRecord{
+			Function:  function,
+			Namespace: namespace,
+			Start:     start.UTC(),
+			End:       start.Add(usagePeriod).UTC(),
+		}
+		s.records[key] = r
+	}
+	return r
+}
+
+// addPodTime charges a function for pods that ran for d, each with
+// the given resources.
+func (s *usageStore) addPodTime(namespace string, function string, t time.Time, d time.Duration,
+	pods float64, resources apiv1.ResourceRequirements) {
+
+	seconds := pods * d.Seconds()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := s.record(namespace, function, t)
+	r.PodSeconds += seconds
+	if cpu, ok := requested(resources, apiv1.ResourceCPU); ok {
+		r.CPUSeconds += seconds * float64(cpu.MilliValue()) / 1000
+	}
+	if memory, ok := requested(resources, apiv1.ResourceMemory); ok {
+		r.MemoryGBSeconds += seconds * float64(memory.Value()) / gibibyte
+	}
+}
+
+// requested returns the amount of a resource a pod requests.  Like
+// Kubernetes, a limit without a request counts as the request.
+func requested(resources apiv1.ResourceRequirements, name apiv1.ResourceName) (resource.Quantity, bool) {
+	if q, ok := resources.Requests[name]; ok {
+		return q, true
+	}
+	q, ok := resources.Limits[name]
+	return q, ok
+}
+
+func (s *usageStore) addInvocations(namespace string, function string, t time.Time, count int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.record(namespace, function, t).Invocations += count
+}
+
+// query returns the usage of each function over the periods that
+// overlap [start, end), sorted by namespace and function.  Empty
+// namespace or function names match all.
+func (s *usageStore) query(start time.Time, end time.Time, namespace string, function string) []fission.UsageRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	totals := make(map[usageKey]*fission.UsageRecord)
+	for key, r := range s.records {
+		if key.start < start.Truncate(usagePeriod).Unix() || key.start >= end.Unix() ||
+			(len(namespace) > 0 && key.namespace != namespace) ||
+			(len(function) > 0 && key.function != function) {
+			continue
+		}
+		totalKey := usageKey{namespace: key.namespace, function: key.function}
+		total, ok := totals[totalKey]
+		if !ok {
+			total = &fission.UsageRecord{
+				Function:  r.Function,
+				Namespace: r.Namespace,
+				Start:     r.Start,
+				End:       r.End,
+			}
+			totals[totalKey] = total
+		}
+		if r.Start.Before(total.Start) {
+			total.Start = r.Start
+		}
+		if r.End.After(total.End) {
+			total.End = r.End
+		}
+		total.PodSeconds += r.PodSeconds
+		total.CPUSeconds += r.CPUSeconds
+		total.MemoryGBSeconds += r.MemoryGBSeconds
+		total.Invocations += r.Invocations
+	}
+
+	records := make([]fission.UsageRecord, 0, len(totals))
+	for _, total := range totals {
+		records = append(records, *total)
+	}
+	sortUsageRecords(records)
+	return records
+}
+
+func sortUsageRecords(records []fission.UsageRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Namespace != records[j].Namespace {
+			return records[i].Namespace < records[j].Namespace
+		}
+		if records[i].Function != records[j].Function {
+			return records[i].Function < records[j].Function
+		}
+		return records[i].Start.Before(records[j].Start)
+	})
+}
+
+// save drops the records older than the retention time, and writes the
+// rest to the store's file.  The file is replaced in one step, so a
+// crash leaves either the old or the new records.
+func (s *usageStore) save(now time.Time) error {
+	s.lock.Lock()
+	records := make([]fission.UsageRecord, 0, len(s.records))
+	for key, r := range s.records {
+		if now.Sub(r.End) > s.retention {
+			delete(s.records, key)
+			continue
+		}
+		records = append(records, *r)
+	}
+	s.lock.Unlock()
+
+	if len(s.path) == 0 {
+		return nil
+	}
+	s.saveLock.Lock()
+	defer s.saveLock.Unlock()
+	sortUsageRecords(records)
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// usageMeter charges functions for their pods every usageInterval, and
+// saves the usage store.
+func (executor *Executor) usageMeter() {
+	last := time.Now()
+	for {
+		select {
+		case <-executor.stopCh:
+			return
+		case <-time.After(usageInterval):
+		}
+		now := time.Now()
+		executor.meterUsage(now, now.Sub(last))
+		last = now
+		err := executor.usage.save(now)
+		if err != nil {
+			log.Printf("Error saving usage: %v", err)
+		}
+	}
+}
+
+// meterUsage charges the functions with running instances for d of
+// their pods' time and resources.
+func (executor *Executor) meterUsage(now time.Time, d time.Duration) {
+	fsvcs, err := executor.fsCache.List()
+	if err != nil {
+		log.Printf("Error listing function services: %v", err)
+		return
+	}
+	for _, fsvc := range fsvcs {
+		if fsvc.Function == nil {
+			continue
+		}
+		pods := float64(1)
+		if counter, ok := executor.backends[fsvc.Executor].(backend.PodCounter); ok {
+			pods, err = counter.CountPods(fsvc)
+			if err != nil {
+				log.Printf("Error counting pods of function service %v: %v", fsvc.Name, err)
+				continue
+			}
+		}
+		executor.usage.addPodTime(fsvc.Function.Namespace, fsvc.Function.Name, now, d,
+			pods, executor.podResources(fsvc))
+	}
+}
+
+// podResources returns the resources of a function service's pods: the
+// environment's, overridden by the function's unless the pods are
+// shared by several functions.  Deleted functions are charged for the
+// environment's resources.
+func (executor *Executor) podResources(fsvc *fscache.FuncSvc) apiv1.ResourceRequirements {
+	resources := apiv1.ResourceRequirements{
+		Requests: make(apiv1.ResourceList),
+		Limits:   make(apiv1.ResourceList),
+	}
+	if fsvc.Environment != nil {
+		mergeResources(&resources, fsvc.Environment.Spec.Resources)
+		if fsvc.Environment.Spec.AllowedFunctionsPerContainer == fission.AllowedFunctionsPerContainerInfinite {
+			return resources
+		}
+	}
+	fn, err := executor.getFunction(fsvc.Function)
+	if err == nil {
+		mergeResources(&resources, fn.Spec.Resources)
+	}
+	return resources
+}
+
+func mergeResources(resources *apiv1.ResourceRequirements, from apiv1.ResourceRequirements) {
+	for name, quantity := range from.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range from.Limits {
+		resources.Limits[name] = quantity
+	}
+}
+
+// reportInvocations adds the invocation counts of a router's report.
+func (executor *Executor) reportInvocations(invocations []executorClient.Invocations, now time.Time) {
+	for _, i := range invocations {
+		executor.usage.addInvocations(i.Function.Namespace, i.Function.Name, now, i.Count)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiv1 "k8s.io/client-go/pkg/api/v1"
+
+	"github.com/fission/fission"
+	executorClient "github.com/fission/fission/executor/client"
+	"github.com/fission/fission/executor/fscache"
+	"github.com/fission/fission/executor/testutil"
+)
+
+func TestUsageStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatalf("error making temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "usage.json")
+
+	resources := apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("500m")},
+		// only a limit, which counts as the request
+		Limits: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("512Mi")},
+	}
+	hour := time.Date(2017, 11, 1, 10, 0, 0, 0, time.UTC)
+	store := makeUsageStore(path, time.Hour)
+	store.addPodTime("default", "hello", hour.Add(time.Minute), 30*time.Second, 2, resources)
+	store.addPodTime("default", "hello", hour.Add(70*time.Minute), 30*time.Second, 1, resources)
+	store.addInvocations("default", "hello", hour.Add(time.Minute), 3)
+	store.addInvocations("other", "world", hour.Add(time.Minute), 1)
+
+	records := store.query(hour, hour.Add(2*time.Hour), "", "")
+	if len(records) != 2 || records[0].Function != "hello" || records[1].Function != "world" {
+		t.Fatalf("unexpected records %v", records)
+	}
+	hello := records[0]
+	if hello.PodSeconds != 90 || hello.CPUSeconds != 45 || hello.MemoryGBSeconds != 45 || hello.Invocations != 3 {
+		t.Fatalf("unexpected usage %v", hello)
+	}
+	if !hello.Start.Equal(hour) || !hello.End.Equal(hour.Add(2*time.Hour)) {
+		t.Fatalf("unexpected period %v to %v", hello.Start, hello.End)
+	}
+
+	// hours that overlap the range count
+	records = store.query(hour.Add(30*time.Minute), hour.Add(time.Hour), "default", "")
+	if len(records) != 1 || records[0].PodSeconds != 60 {
+		t.Fatalf("expected the first hour's usage, got %v", records)
+	}
+
+	// the records survive a restart
+	err = store.save(hour.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("error saving usage: %v", err)
+	}
+	store = makeUsageStore(path, time.Hour)
+	records = store.query(hour, hour.Add(2*time.Hour), "default", "hello")
+	if len(records) != 1 || records[0].PodSeconds != 90 || records[0].Invocations != 3 {
+		t.Fatalf("unexpected records after reload %v", records)
+	}
+
+	// and expire after the retention time
+	err = store.save(hour.Add(4 * time.Hour))
+	if err != nil {
+		t.Fatalf("error saving usage: %v", err)
+	}
+	if records = store.query(hour, hour.Add(2*time.Hour), "", ""); len(records) != 0 {
+		t.Fatalf("expected old records to expire, got %v", records)
+	}
+}
+
+func TestUsageApi(t *testing.T) {
+	executor, _ := makeTestExecutor()
+	store := executor.store.(*testutil.Store)
+	store.Environment.Spec.Resources = apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("1"),
+			apiv1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	}
+	hello := store.Functions["hello"]
+	hello.Spec.Resources = apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("250m")},
+	}
+	_, err := executor.getServiceForFunction(context.Background(), &hello.Metadata)
+	if err != nil {
+		t.Fatalf("error specializing function: %v", err)
+	}
+	now := time.Now()
+	executor.meterUsage(now, 10*time.Second)
+
+	server := httptest.NewServer(executor.getHandler())
+	defer server.Close()
+
+	body, err := json.Marshal([]executorClient.Invocations{{Function: hello.Metadata, Count: 5}})
+	if err != nil {
+		t.Fatalf("error encoding invocations: %v", err)
+	}
+	resp, err := http.Post(server.URL+"/v2/reportInvocations", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("error reporting invocations: %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	query := url.Values{}
+	query.Set("start", now.Add(-time.Hour).Format(time.RFC3339))
+	query.Set("end", now.Add(time.Hour).Format(time.RFC3339))
+	resp, err = http.Get(server.URL + "/v2/usage?" + query.Encode())
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("error getting usage: %v, %v", resp, err)
+	}
+	defer resp.Body.Close()
+	records := make([]fission.UsageRecord, 0)
+	err = json.NewDecoder(resp.Body).Decode(&records)
+	if err != nil {
+		t.Fatalf("error decoding usage: %v", err)
+	}
+
+	// the function's CPU request overrides the environment's
+	if len(records) != 1 {
+		t.Fatalf("expected usage of one function, got %v", records)
+	}
+	r := records[0]
+	if r.Function != "hello" || r.PodSeconds != 10 || r.CPUSeconds != 2.5 || r.MemoryGBSeconds != 10 || r.Invocations != 5 {
+		t.Fatalf("unexpected usage %v", r)
+	}
+
+	resp, err = http.Get(server.URL + "/v2/usage?start=yesterday")
+	if err != nil {
+		t.Fatalf("error getting usage: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected invalid start time to be rejected, got %v", resp.StatusCode)
+	}
+}
+
+// halfPodBackend's function services each take half a pod
+type halfPodBackend struct {
+	*testBackend
+}
+
+func (b halfPodBackend) CountPods(fsvc *fscache.FuncSvc) (float64, error) { return 0.5, nil }
+
+func TestUsagePodCounter(t *testing.T) {
+	executor, b := makeTestExecutor()
+	executor.backends[fission.ExecutorTypePoolmgr] = halfPodBackend{b}
+	store := executor.store.(*testutil.Store)
+	for _, name := range []string{"hello", "world"} {
+		_, err := executor.getServiceForFunction(context.Background(), &store.Functions[name].Metadata)
+		if err != nil {
+			t.Fatalf("error specializing function: %v", err)
+		}
+	}
+
+	now := time.Now()
+	executor.meterUsage(now, time.Minute)
+	records := executor.usage.query(now.Add(-time.Hour), now.Add(time.Hour), metav1.NamespaceDefault, "")
+	if len(records) != 2 || records[0].PodSeconds != 30 || records[1].PodSeconds != 30 {
+		t.Fatalf("unexpected usage %v", records)
+	}
+}
@@ -22,8 +22,13 @@ func runController(port int) {
 	log.Fatalf("Error: Controller exited.")
 }
 
-func runRouter(port int, executorUrl string) {
-	router.Start(port, executorUrl)
+func runRouter(port int, internalPort int, executorUrl string) {
+	router.Start(port, internalPort, executorUrl)
+	log.Fatalf("Error: Router exited.")
+}
+
+func runLocalRouter(port int, internalPort int, executorUrl string, storePath string) {
+	router.StartLocal(port, internalPort, executorUrl, storePath)
 	log.Fatalf("Error: Router exited.")
 }
 
@@ -34,6 +39,13 @@ func runExecutor(port int, fissionNamespace, functionNamespace string) {
 	}
 }
 
+func runLocalExecutor(port int, storePath string) {
+	err := executor.StartLocalExecutor(storePath, port)
+	if err != nil {
+		log.Fatalf("Error starting local executor: %v", err)
+	}
+}
+
 func runKubeWatcher(routerUrl string) {
 	err := kubewatcher.Start(routerUrl)
 	if err != nil {
@@ -98,10 +110,13 @@ Use it to start one or more of the fission servers:
 
  Pool manager maintains a pool of generalized function containers, and
  specializes them on-demand. Executor must be run from a pod in a
- Kubernetes cluster.
+ Kubernetes cluster, unless it's started with --localStore: then it
+ reads functions from a directory and runs them as local processes.
 
  Router implements HTTP triggers: it routes to running instances,
- working with the controller and executor.
+ working with the controller and executor.  With --localStore, it
+ reads triggers and functions from the same directory as a local
+ executor, so neither needs Kubernetes.
 
  Kubewatcher implements Kubernetes Watch triggers: it watches
  Kubernetes resources and invokes functions described in the
@@ -113,8 +128,9 @@ Use it to start one or more of the fission servers:
 
 Usage:
   fission-bundle --controllerPort=<port>
-  fission-bundle --routerPort=<port> [--executorUrl=<url>]
+  fission-bundle --routerPort=<port> [--routerInternalPort=<port>] [--executorUrl=<url>] [--localStore=<dir>]
   fission-bundle --executorPort=<port> [--namespace=<namespace>] [--fission-namespace=<namespace>]
+  fission-bundle --executorPort=<port> --localStore=<dir>
   fission-bundle --kubewatcher [--routerUrl=<url>]
   fission-bundle --storageServicePort=<port> --filePath=<filePath>
   fission-bundle --builderMgr [--storageSvcUrl=<url>] [--envbuilder-namespace=<namespace>]
@@ -123,6 +139,7 @@ Usage:
 Options:
   --controllerPort=<port>         Port that the controller should listen on.
   --routerPort=<port>             Port that the router should listen on.
+  --routerInternalPort=<port>     Port of the router's internal API, for the controller. Defaults to 8889.
   --executorPort=<port>           Port that the executor should listen on.
   --storageServicePort=<port>     Port that the storage service should listen on.
   --executorUrl=<url>             Executor URL. Not required if --executorPort is specified.
@@ -130,6 +147,7 @@ Options:
   --etcdUrl=<etcdUrl>             Etcd URL.
   --storageSvcUrl=<url>           StorageService URL.
   --filePath=<filePath>           Directory to store functions in.
+  --localStore=<dir>              Directory of function, environment, package and trigger files for a local executor or router.
   --namespace=<namespace>         Kubernetes namespace in which to run function containers. Defaults to 'fission-function'.
   --kubewatcher                   Start Kubernetes events watcher.
   --timer                         Start Timer.
@@ -156,12 +174,21 @@ Options:
 
 	if arguments["--routerPort"] != nil {
 		port := getPort(arguments["--routerPort"])
-		runRouter(port, executorUrl)
+		internalPort := getPort(getStringArgWithDefault(arguments["--routerInternalPort"], "8889"))
+		if arguments["--localStore"] != nil {
+			runLocalRouter(port, internalPort, executorUrl, arguments["--localStore"].(string))
+		} else {
+			runRouter(port, internalPort, executorUrl)
+		}
 	}
 
 	if arguments["--executorPort"] != nil {
 		port := getPort(arguments["--executorPort"])
-		runExecutor(port, fissionNs, functionNs)
+		if arguments["--localStore"] != nil {
+			runLocalExecutor(port, arguments["--localStore"].(string))
+		} else {
+			runExecutor(port, fissionNs, functionNs)
+		}
 	}
 
 	if arguments["--kubewatcher"] == true {
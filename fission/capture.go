@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+// getCaptureConfig returns a capture config for the --capturerate
+// flag, or nil if the flag isn't set.
+func getCaptureConfig(c *cli.Context) *fission.RequestCaptureConfig {
+	if !c.IsSet("capturerate") {
+		return nil
+	}
+	rate := c.Float64("capturerate")
+	if rate < 0 || rate > 1 {
+		fatal("Capture rate must be a value between 0 and 1")
+	}
+	if rate == 0 {
+		return nil
+	}
+	return &fission.RequestCaptureConfig{
+		SampleRate: rate,
+	}
+}
+
+func fnCaptures(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	var m *metav1.ObjectMeta
+	fnName := c.String("name")
+	if len(fnName) > 0 {
+		m = &metav1.ObjectMeta{
+			Name:      fnName,
+			Namespace: metav1.NamespaceDefault,
+		}
+	}
+
+	captures, err := client.CaptureList(m)
+	checkErr(err, "list captured requests")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "ID", "TIME", "FUNCTION", "METHOD", "URL", "STATUS", "LATENCY")
+	for _, capture := range captures {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			capture.ID, capture.Timestamp.Format(time.RFC3339), capture.FunctionName,
+			capture.Method, capture.URL, capture.StatusCode, capture.Latency)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func fnReplay(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	captureId := c.String("capture")
+	if len(captureId) == 0 {
+		fatal("Need a captured request id, use --capture")
+	}
+
+	routerURL := os.Getenv("FISSION_ROUTER")
+	if len(routerURL) == 0 {
+		fatal("Need FISSION_ROUTER set to your fission router.")
+	}
+
+	capture, err := client.CaptureGet(captureId)
+	checkErr(err, "get captured request")
+
+	if capture.RequestBodyTruncated {
+		warn("Warning: the captured request body was truncated, the replayed request will differ from the original.")
+	}
+
+	// replay against the same function unless told otherwise
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		fnName = capture.FunctionName
+	}
+
+	u := fmt.Sprintf("http://%s%s", routerURL, fission.UrlForFunction(fnName))
+	if orig, err := url.Parse(capture.URL); err == nil && len(orig.RawQuery) > 0 {
+		u = u + "?" + orig.RawQuery
+	}
+
+	req, err := http.NewRequest(capture.Method, u, bytes.NewReader(capture.RequestBody))
+	checkErr(err, "create HTTP request")
+	for k, v := range capture.RequestHeaders {
+		// the router sets fission headers itself
+		if strings.HasPrefix(k, "X-Fission-") || k == "Content-Length" {
+			continue
+		}
+		// credentials aren't captured
+		if len(v) == 1 && v[0] == fission.RequestCaptureRedacted {
+			warn(fmt.Sprintf("Warning: header %v wasn't captured, the replayed request doesn't have it.", k))
+			continue
+		}
+		req.Header[k] = v
+	}
+
+	startTime := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(err, "replay request")
+	defer resp.Body.Close()
+	latency := time.Since(startTime)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	checkErr(err, "read response")
+
+	fmt.Printf("Replayed capture %v to function '%v'\n", capture.ID, fnName)
+	fmt.Printf("status:  %v -> %v\n", capture.StatusCode, resp.StatusCode)
+	fmt.Printf("latency: %v -> %v\n", capture.Latency, latency)
+
+	origType := capture.ResponseHeaders.Get("Content-Type")
+	newType := resp.Header.Get("Content-Type")
+	if origType != newType {
+		fmt.Printf("content-type: %v -> %v\n", origType, newType)
+	}
+
+	if capture.ResponseBodyTruncated && int64(len(body)) > int64(len(capture.ResponseBody)) {
+		body = body[:len(capture.ResponseBody)]
+		fmt.Printf("(comparing the first %v bytes of the response body only)\n", len(body))
+	}
+
+	if bytes.Equal(capture.ResponseBody, body) {
+		fmt.Println("response body: identical")
+		return nil
+	}
+	fmt.Println("response body:")
+	for _, line := range diffLines(string(capture.ResponseBody), string(body)) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// diffLines returns a minimal line diff of a and b, with removed lines
+// prefixed by "-", added lines by "+" and common lines by " ".
+func diffLines(a, b string) []string {
+	al := strings.Split(a, "\n")
+	bl := strings.Split(b, "\n")
+
+	// longest common subsequence table
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := make([]string, 0)
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			diff = append(diff, " "+al[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+al[i])
+			i++
+		default:
+			diff = append(diff, "+"+bl[j])
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		diff = append(diff, "-"+al[i])
+	}
+	for ; j < len(bl); j++ {
+		diff = append(diff, "+"+bl[j])
+	}
+	return diff
+}
@@ -84,8 +84,18 @@ func envCreate(c *cli.Context) error {
 				Image:   envBuilderImg,
 				Command: envBuildCmd,
 			},
-			Poolsize:  poolsize,
-			Resources: resourceReq,
+			Poolsize:             poolsize,
+			MinPoolsize:          c.Int("minpoolsize"),
+			MaxPoolsize:          c.Int("maxpoolsize"),
+			PodSelectionStrategy: fission.PodSelectionStrategy(c.String("podselection")),
+			Resources:            resourceReq,
+
+			ReadinessTimeout:      metav1.Duration{Duration: c.Duration("readinesstimeout")},
+			IdlePodTTL:            metav1.Duration{Duration: c.Duration("idlettl")},
+			UseSvc:                c.Bool("usesvc"),
+			ImagePullPolicy:       v1.PullPolicy(c.String("imagepullpolicy")),
+			SpecializationRetries: c.Int("specializeretries"),
+			PrePullImages:         c.Bool("prepull"),
 		},
 	}
 
@@ -112,13 +122,30 @@ func envGet(c *cli.Context) error {
 	checkErr(err, "get environment")
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-	fmt.Fprintf(w, "%v\t%v\t%v\n", "NAME", "UID", "IMAGE")
-	fmt.Fprintf(w, "%v\t%v\t%v\n",
-		env.Metadata.Name, env.Metadata.UID, env.Spec.Runtime.Image)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", "NAME", "UID", "IMAGE", "PREPULL")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\n",
+		env.Metadata.Name, env.Metadata.UID, env.Spec.Runtime.Image, formatPrePull(env))
 	w.Flush()
 	return nil
 }
 
+// formatPrePull describes the progress of the environment's image
+// pre-pull, e.g. "pulling (1/3)".
+func formatPrePull(env *crd.Environment) string {
+	if !env.Spec.PrePullImages {
+		return "-"
+	}
+	status := env.Status.ImagePrePull
+	if status == nil {
+		return fission.ImagePrePullPending
+	}
+	s := fmt.Sprintf("%v (%v/%v)", status.Phase, status.NodesPulled, status.NodesDesired)
+	if len(status.Message) > 0 {
+		s += ": " + status.Message
+	}
+	return s
+}
+
 func envUpdate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
@@ -131,8 +158,9 @@ func envUpdate(c *cli.Context) error {
 	envBuilderImg := c.String("builder")
 	envBuildCmd := c.String("buildcmd")
 
-	if len(envImg) == 0 && len(envBuilderImg) == 0 && len(envBuildCmd) == 0 {
-		fatal("Need --image to specify env image, or use --builder to specify env builder, or use --buildcmd to specify new build command.")
+	if len(envImg) == 0 && len(envBuilderImg) == 0 && len(envBuildCmd) == 0 &&
+		!c.IsSet("poolsize") && !c.IsSet("minpoolsize") && !c.IsSet("maxpoolsize") && !c.IsSet("prepull") {
+		fatal("Need --image to specify env image, or use --builder to specify env builder, or use --buildcmd to specify new build command, or --poolsize/--minpoolsize/--maxpoolsize to resize the pool.")
 	}
 
 	env, err := client.EnvironmentGet(&metav1.ObjectMeta{
@@ -159,6 +187,33 @@ func envUpdate(c *cli.Context) error {
 	if c.IsSet("poolsize") {
 		env.Spec.Poolsize = c.Int("poolsize")
 	}
+	if c.IsSet("minpoolsize") {
+		env.Spec.MinPoolsize = c.Int("minpoolsize")
+	}
+	if c.IsSet("maxpoolsize") {
+		env.Spec.MaxPoolsize = c.Int("maxpoolsize")
+	}
+	if c.IsSet("podselection") {
+		env.Spec.PodSelectionStrategy = fission.PodSelectionStrategy(c.String("podselection"))
+	}
+	if c.IsSet("readinesstimeout") {
+		env.Spec.ReadinessTimeout = metav1.Duration{Duration: c.Duration("readinesstimeout")}
+	}
+	if c.IsSet("idlettl") {
+		env.Spec.IdlePodTTL = metav1.Duration{Duration: c.Duration("idlettl")}
+	}
+	if c.IsSet("usesvc") {
+		env.Spec.UseSvc = c.Bool("usesvc")
+	}
+	if c.IsSet("imagepullpolicy") {
+		env.Spec.ImagePullPolicy = v1.PullPolicy(c.String("imagepullpolicy"))
+	}
+	if c.IsSet("specializeretries") {
+		env.Spec.SpecializationRetries = c.Int("specializeretries")
+	}
+	if c.IsSet("prepull") {
+		env.Spec.PrePullImages = c.Bool("prepull")
+	}
 
 	_, err = client.EnvironmentUpdate(env)
 	checkErr(err, "update environment")
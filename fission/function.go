@@ -24,12 +24,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
+	"github.com/robfig/cron"
 	"github.com/satori/go.uuid"
 	"github.com/urfave/cli"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
 
 	"github.com/fission/fission"
 	"github.com/fission/fission/crd"
@@ -185,6 +188,11 @@ func fnCreate(c *cli.Context) error {
 	}
 
 	invokeStrategy := getInvokeStrategy(c.Int("minscale"), c.Int("maxscale"), c.String("executortype"), targetCPU)
+	if c.Int("targetconcurrency") < 0 || c.Duration("targetlatency") < 0 {
+		fatal("Target concurrency and latency must not be negative")
+	}
+	invokeStrategy.ExecutionStrategy.TargetConcurrency = c.Int("targetconcurrency")
+	invokeStrategy.ExecutionStrategy.TargetLatency = metav1.Duration{Duration: c.Duration("targetlatency")}
 
 	function := &crd.Function{
 		Metadata: metav1.ObjectMeta{
@@ -206,8 +214,11 @@ func fnCreate(c *cli.Context) error {
 			},
 			Resources:      resourceReq,
 			InvokeStrategy: invokeStrategy,
+			Capture:        getCaptureConfig(c),
 		},
 	}
+	setFunctionConfig(c, function)
+	setKeepWarm(c, function)
 
 	_, err = client.FunctionCreate(function)
 	checkErr(err, "create function")
@@ -293,6 +304,102 @@ func fnGetMeta(c *cli.Context) error {
 	return err
 }
 
+// setFunctionConfig replaces the function's Secrets, ConfigMaps and
+// environment variables with those given on the command line, if any.
+func setFunctionConfig(c *cli.Context, function *crd.Function) {
+	if c.IsSet("secret") {
+		function.Spec.Secrets = nil
+		for _, name := range c.StringSlice("secret") {
+			function.Spec.Secrets = append(function.Spec.Secrets, fission.SecretReference{
+				Namespace: function.Metadata.Namespace,
+				Name:      name,
+			})
+		}
+	}
+	if c.IsSet("configmap") {
+		function.Spec.ConfigMaps = nil
+		for _, name := range c.StringSlice("configmap") {
+			function.Spec.ConfigMaps = append(function.Spec.ConfigMaps, fission.ConfigMapReference{
+				Namespace: function.Metadata.Namespace,
+				Name:      name,
+			})
+		}
+	}
+	if c.IsSet("envvar") {
+		function.Spec.Env = nil
+		for _, kv := range c.StringSlice("envvar") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 {
+				fatal(fmt.Sprintf("Environment variable '%v' must be of the form NAME=VALUE", kv))
+			}
+			function.Spec.Env = append(function.Spec.Env, fission.EnvVar{
+				Name:  parts[0],
+				Value: parts[1],
+			})
+		}
+	}
+}
+
+// setResources updates the function's resources with those given on
+// the command line, if any.  Functions of pool manager environments get
+// pods of these sizes from sub-pools of their environment's pool.
+func setResources(c *cli.Context, function *crd.Function) {
+	resources := getResourceReq(c.Int("mincpu"), c.Int("maxcpu"), c.Int("minmemory"), c.Int("maxmemory"))
+	if function.Spec.Resources.Requests == nil {
+		function.Spec.Resources.Requests = make(v1.ResourceList)
+	}
+	if function.Spec.Resources.Limits == nil {
+		function.Spec.Resources.Limits = make(v1.ResourceList)
+	}
+	for name, quantity := range resources.Requests {
+		function.Spec.Resources.Requests[name] = quantity
+	}
+	for name, quantity := range resources.Limits {
+		function.Spec.Resources.Limits[name] = quantity
+	}
+}
+
+// setKeepWarm updates the function's keep-warm settings with those
+// given on the command line, if any.
+func setKeepWarm(c *cli.Context, function *crd.Function) {
+	if !c.IsSet("keepwarmttl") && !c.IsSet("keepwarmschedule") && !c.IsSet("keepwarmwindow") {
+		return
+	}
+	strategy := &function.Spec.InvokeStrategy.ExecutionStrategy
+	keepWarm := fission.KeepWarm{}
+	if strategy.KeepWarm != nil {
+		keepWarm = *strategy.KeepWarm
+	}
+	if c.IsSet("keepwarmttl") {
+		keepWarm.IdleTTL = metav1.Duration{Duration: c.Duration("keepwarmttl")}
+	}
+	if c.IsSet("keepwarmschedule") {
+		keepWarm.Schedule = c.String("keepwarmschedule")
+	}
+	if c.IsSet("keepwarmwindow") {
+		keepWarm.WindowDuration = metav1.Duration{Duration: c.Duration("keepwarmwindow")}
+	}
+
+	if keepWarm.IdleTTL.Duration < 0 || keepWarm.WindowDuration.Duration < 0 {
+		fatal("Keep-warm TTL and window must not be negative")
+	}
+	if len(keepWarm.Schedule) > 0 {
+		_, err := cron.Parse(keepWarm.Schedule)
+		if err != nil {
+			fatal(fmt.Sprintf("Keep-warm schedule '%v' is not a valid cron spec: %v", keepWarm.Schedule, err))
+		}
+		if keepWarm.WindowDuration.Duration == 0 {
+			fatal("Need --keepwarmwindow with --keepwarmschedule")
+		}
+	}
+
+	if keepWarm == (fission.KeepWarm{}) {
+		strategy.KeepWarm = nil
+	} else {
+		strategy.KeepWarm = &keepWarm
+	}
+}
+
 func fnUpdate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
@@ -327,8 +434,19 @@ func fnUpdate(c *cli.Context) error {
 	force := c.Bool("force")
 
 	if len(envName) == 0 && len(deployArchiveName) == 0 && len(srcArchiveName) == 0 && len(pkgName) == 0 &&
-		len(entrypoint) == 0 && len(buildcmd) == 0 {
-		fatal("Need --env or --deploy or --src or --pkg or --entrypoint or --buildcmd argument.")
+		len(entrypoint) == 0 && len(buildcmd) == 0 && !c.IsSet("capturerate") &&
+		!c.IsSet("secret") && !c.IsSet("configmap") && !c.IsSet("envvar") &&
+		!c.IsSet("keepwarmttl") && !c.IsSet("keepwarmschedule") && !c.IsSet("keepwarmwindow") &&
+		!c.IsSet("mincpu") && !c.IsSet("maxcpu") && !c.IsSet("minmemory") && !c.IsSet("maxmemory") {
+		fatal("Need --env or --deploy or --src or --pkg or --entrypoint or --buildcmd or --capturerate or --secret or --configmap or --envvar or --keepwarm* or resource argument.")
+	}
+
+	setFunctionConfig(c, function)
+	setKeepWarm(c, function)
+	setResources(c, function)
+
+	if c.IsSet("capturerate") {
+		function.Spec.Capture = getCaptureConfig(c)
 	}
 
 	if len(envName) > 0 {
@@ -513,6 +631,10 @@ func fnLogs(c *cli.Context) error {
 }
 
 func fnPods(c *cli.Context) error {
+	if c.Bool("live") {
+		return fnLivePods(c)
+	}
+
 	client := getClient(c.GlobalString("server"))
 
 	fnName := c.String("name")
@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+func printFunctionServices(infos []fission.FunctionServiceInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", "FUNCTION", "NAME", "ADDRESS", "POD", "EXECUTOR", "CREATED", "LAST USED")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			info.Function, info.Name, info.Address, info.Pod, info.Executor,
+			info.Ctime.Format(time.RFC3339), info.Atime.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// fnLivePods lists the running instances of a function, or of all
+// functions, as the executor sees them.
+func fnLivePods(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	var m *metav1.ObjectMeta
+	fnName := c.String("name")
+	if len(fnName) > 0 {
+		m = &metav1.ObjectMeta{
+			Name:      fnName,
+			Namespace: metav1.NamespaceDefault,
+		}
+	}
+
+	infos, err := client.FunctionServiceList(m)
+	checkErr(err, "list function instances")
+
+	printFunctionServices(infos)
+	return nil
+}
+
+func fnEvict(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		fatal("Need name of function, use --name")
+	}
+	m := &metav1.ObjectMeta{
+		Name:      fnName,
+		Namespace: metav1.NamespaceDefault,
+	}
+
+	if c.Bool("respecialize") {
+		infos, err := client.FunctionServiceSpecialize(m)
+		checkErr(err, "re-specialize function")
+		printFunctionServices(infos)
+		return nil
+	}
+
+	err := client.FunctionServiceEvict(m)
+	checkErr(err, "evict function")
+
+	fmt.Printf("function '%v' evicted\n", fnName)
+	return nil
+}
+
+func fnWarm(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		fatal("Need name of function, use --name")
+	}
+	m := &metav1.ObjectMeta{
+		Name:      fnName,
+		Namespace: metav1.NamespaceDefault,
+	}
+
+	if c.Duration("duration") < 0 {
+		fatal("Duration must not be negative")
+	}
+	infos, err := client.FunctionServiceWarm(m, c.Duration("duration"))
+	checkErr(err, "warm function")
+
+	printFunctionServices(infos)
+	return nil
+}
+
+// formatStep formats the duration of a cold start step, or "-" for
+// steps the function's executor doesn't have.
+func formatStep(d metav1.Duration) string {
+	if d.Duration == 0 {
+		return "-"
+	}
+	return d.Duration.String()
+}
+
+// fnColdStarts shows where the time of a function's latest cold starts
+// went.
+func fnColdStarts(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	fnName := c.String("name")
+	if len(fnName) == 0 {
+		fatal("Need name of function, use --name")
+	}
+	m := &metav1.ObjectMeta{
+		Name:      fnName,
+		Namespace: metav1.NamespaceDefault,
+	}
+
+	coldStarts, err := client.FunctionServiceColdStarts(m)
+	checkErr(err, "get cold starts of function")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+		"STARTED", "POD", "EXECUTOR", "CHOOSE POD", "RELABEL", "FETCH", "BYTES", "SPECIALIZE", "RETRIES", "TOTAL", "ERROR")
+	for _, cs := range coldStarts {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			cs.StartTime.Format(time.RFC3339), cs.Pod, cs.Executor, formatStep(cs.ChoosePod), formatStep(cs.Relabel),
+			formatStep(cs.Fetch), cs.FetchBytes, formatStep(cs.Specialize), cs.SpecializeRetries, cs.Total.Duration, cs.Error)
+	}
+	w.Flush()
+	return nil
+}
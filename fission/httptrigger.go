@@ -61,8 +61,12 @@ func htCreate(c *cli.Context) error {
 	client := getClient(c.GlobalString("server"))
 
 	fnName := c.String("function")
-	if len(fnName) == 0 {
-		fatal("Need a function name to create a trigger, use --function")
+	pipeline := c.String("pipeline")
+	if len(fnName) == 0 && len(pipeline) == 0 {
+		fatal("Need a function name to create a trigger, use --function (or --pipeline for a pipeline of functions)")
+	}
+	if len(fnName) > 0 && len(pipeline) > 0 {
+		fatal("Use either --function or --pipeline, not both")
 	}
 	triggerUrl := c.String("url")
 	if len(triggerUrl) == 0 {
@@ -82,12 +86,10 @@ func htCreate(c *cli.Context) error {
 			Namespace: metav1.NamespaceDefault,
 		},
 		Spec: fission.HTTPTriggerSpec{
-			RelativeURL: triggerUrl,
-			Method:      getMethod(method),
-			FunctionReference: fission.FunctionReference{
-				Type: fission.FunctionReferenceTypeFunctionName,
-				Name: fnName,
-			},
+			RelativeURL:       triggerUrl,
+			Method:            getMethod(method),
+			FunctionReference: getFunctionReference(fnName, pipeline),
+			Capture:           getCaptureConfig(c),
 		},
 	}
 
@@ -98,6 +100,41 @@ func htCreate(c *cli.Context) error {
 	return err
 }
 
+// getFunctionReference makes a reference to a single function, or to
+// a pipeline given as a comma-separated list of function names.
+func getFunctionReference(fnName string, pipeline string) fission.FunctionReference {
+	if len(pipeline) == 0 {
+		return fission.FunctionReference{
+			Type: fission.FunctionReferenceTypeFunctionName,
+			Name: fnName,
+		}
+	}
+
+	p := fission.FunctionPipeline{}
+	for _, name := range strings.Split(pipeline, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			fatal(fmt.Sprintf("Invalid pipeline '%v', use a comma-separated list of function names", pipeline))
+		}
+		p.Steps = append(p.Steps, fission.PipelineStep{FunctionName: name})
+	}
+	return fission.FunctionReference{
+		Type:     fission.FunctionReferenceTypeFunctionPipeline,
+		Pipeline: p,
+	}
+}
+
+func functionReferenceString(fr *fission.FunctionReference) string {
+	if fr.Type != fission.FunctionReferenceTypeFunctionPipeline {
+		return fr.Name
+	}
+	names := make([]string, 0, len(fr.Pipeline.Steps))
+	for _, s := range fr.Pipeline.Steps {
+		names = append(names, s.FunctionName)
+	}
+	return strings.Join(names, " -> ")
+}
+
 func htGet(c *cli.Context) error {
 	return nil
 }
@@ -111,8 +148,12 @@ func htUpdate(c *cli.Context) error {
 
 	// update function ref
 	newFn := c.String("function")
-	if len(newFn) == 0 {
-		fatal("Nothing to update. Use --function to specify a new function.")
+	newPipeline := c.String("pipeline")
+	if len(newFn) == 0 && len(newPipeline) == 0 && !c.IsSet("capturerate") {
+		fatal("Nothing to update. Use --function or --pipeline to specify new functions, or --capturerate to change request capturing.")
+	}
+	if len(newFn) > 0 && len(newPipeline) > 0 {
+		fatal("Use either --function or --pipeline, not both")
 	}
 
 	ht, err := client.HTTPTriggerGet(&metav1.ObjectMeta{
@@ -121,8 +162,12 @@ func htUpdate(c *cli.Context) error {
 	})
 	checkErr(err, "get HTTP trigger")
 
-	if len(newFn) > 0 {
-		ht.Spec.FunctionReference.Name = newFn
+	if len(newFn) > 0 || len(newPipeline) > 0 {
+		ht.Spec.FunctionReference = getFunctionReference(newFn, newPipeline)
+	}
+
+	if c.IsSet("capturerate") {
+		ht.Spec.Capture = getCaptureConfig(c)
 	}
 
 	_, err = client.HTTPTriggerUpdate(ht)
@@ -160,7 +205,7 @@ func htList(c *cli.Context) error {
 	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", "NAME", "METHOD", "HOST", "URL", "FUNCTION_NAME")
 	for _, ht := range hts {
 		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
-			ht.Metadata.Name, ht.Spec.Method, ht.Spec.Host, ht.Spec.RelativeURL, ht.Spec.FunctionReference.Name)
+			ht.Metadata.Name, ht.Spec.Method, ht.Spec.Host, ht.Spec.RelativeURL, functionReferenceString(&ht.Spec.FunctionReference))
 	}
 	w.Flush()
 
@@ -44,6 +44,8 @@ func main() {
 	minScale := cli.StringFlag{Name: "minscale", Usage: "Minmum number of pods (Uses resource inputs to configure HPA)"}
 	maxScale := cli.StringFlag{Name: "maxscale", Usage: "Maximum number of pods (Uses resource inputs to configure HPA)"}
 	targetcpu := cli.StringFlag{Name: "targetcpu", Usage: "Target average CPU across pods for scaling (In percentage, defaults to 80)"}
+	targetConcurrency := cli.IntFlag{Name: "targetconcurrency", Usage: "Requests in flight per pod above which a poolmgr function is scaled out, up to maxscale (defaults to 10)"}
+	targetLatency := cli.DurationFlag{Name: "targetlatency", Usage: "Average response time above which a poolmgr function is scaled out, up to maxscale (optional)"}
 
 	// functions
 	fnNameFlag := cli.StringFlag{Name: "name", Usage: "function name"}
@@ -64,26 +66,43 @@ func main() {
 	fnLogCountFlag := cli.StringFlag{Name: "recordcount", Usage: "the n most recent log records"}
 	fnForceFlag := cli.BoolFlag{Name: "force", Usage: "Force update a package even if it is used by one or more functions"}
 	fnExecutorTypeFlag := cli.StringFlag{Name: "executortype", Usage: "Executor type for execution; one of 'poolmgr', 'newdeploy' defaults to 'poolmgr'"}
+	fnSecretFlag := cli.StringSliceFlag{Name: "secret", Usage: "name of a Secret in the function's namespace to make available to the function (can be repeated)"}
+	fnConfigMapFlag := cli.StringSliceFlag{Name: "configmap", Usage: "name of a ConfigMap in the function's namespace to make available to the function (can be repeated)"}
+	fnEnvVarFlag := cli.StringSliceFlag{Name: "envvar", Usage: "environment variable NAME=VALUE for the function (can be repeated)"}
+	fnCaptureIdFlag := cli.StringFlag{Name: "capture", Usage: "ID of a captured request (see 'fission fn captures')"}
+	fnLiveFlag := cli.BoolFlag{Name: "live", Usage: "list the running instances the executor knows of, instead of pods from the log database"}
+	fnRespecializeFlag := cli.BoolFlag{Name: "respecialize", Usage: "start a freshly specialized instance right after evicting the old ones"}
+	fnWarmDurationFlag := cli.DurationFlag{Name: "duration", Usage: "keep the function's instances this long, even when idle (optional)"}
+	fnKeepWarmTTLFlag := cli.DurationFlag{Name: "keepwarmttl", Usage: "Idle time after which the function's instances are released, instead of the executor's (optional)"}
+	fnKeepWarmScheduleFlag := cli.StringFlag{Name: "keepwarmschedule", Usage: "Cron spec at which the function is specialized and kept warm for --keepwarmwindow ('0 0 9 * * 1-5', '@hourly')"}
+	fnKeepWarmWindowFlag := cli.DurationFlag{Name: "keepwarmwindow", Usage: "How long the function is kept warm after --keepwarmschedule fires"}
+	captureRateFlag := cli.Float64Flag{Name: "capturerate", Usage: "Fraction of requests to capture for replay, between 0 and 1 (0 disables capturing)"}
 
 	fnSubcommands := []cli.Command{
-		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnBuildCmdFlag, fnPkgNameFlag, htUrlFlag, htMethodFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu}, Action: fnCreate},
+		{Name: "create", Usage: "Create new function (and optionally, an HTTP route to it)", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnBuildCmdFlag, fnPkgNameFlag, htUrlFlag, htMethodFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu, targetConcurrency, targetLatency, fnKeepWarmTTLFlag, fnKeepWarmScheduleFlag, fnKeepWarmWindowFlag, captureRateFlag, fnSecretFlag, fnConfigMapFlag, fnEnvVarFlag}, Action: fnCreate},
 		{Name: "get", Usage: "Get function source code", Flags: []cli.Flag{fnNameFlag}, Action: fnGet},
 		{Name: "getmeta", Usage: "Get function metadata", Flags: []cli.Flag{fnNameFlag}, Action: fnGetMeta},
-		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnPkgNameFlag, fnBuildCmdFlag, fnForceFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu}, Action: fnUpdate},
+		{Name: "update", Usage: "Update function source code", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcArchiveFlag, fnDeployArchiveFlag, fnEntryPointFlag, fnPkgNameFlag, fnBuildCmdFlag, fnForceFlag, minCpu, maxCpu, minMem, maxMem, minScale, maxScale, fnExecutorTypeFlag, targetcpu, fnKeepWarmTTLFlag, fnKeepWarmScheduleFlag, fnKeepWarmWindowFlag, captureRateFlag, fnSecretFlag, fnConfigMapFlag, fnEnvVarFlag}, Action: fnUpdate},
 		{Name: "delete", Usage: "Delete function", Flags: []cli.Flag{fnNameFlag}, Action: fnDelete},
 		{Name: "list", Usage: "List all functions", Flags: []cli.Flag{}, Action: fnList},
 		{Name: "logs", Usage: "Display function logs", Flags: []cli.Flag{fnNameFlag, fnPodFlag, fnFollowFlag, fnDetailFlag, fnLogDBTypeFlag, fnLogCountFlag}, Action: fnLogs},
-		{Name: "pods", Usage: "Display function pods", Flags: []cli.Flag{fnNameFlag, fnLogDBTypeFlag}, Action: fnPods},
+		{Name: "pods", Usage: "Display function pods", Flags: []cli.Flag{fnNameFlag, fnLogDBTypeFlag, fnLiveFlag}, Action: fnPods},
+		{Name: "evict", Usage: "Release the running instances of a function", Flags: []cli.Flag{fnNameFlag, fnRespecializeFlag}, Action: fnEvict},
+		{Name: "warm", Usage: "Start an instance of a function ahead of requests", Flags: []cli.Flag{fnNameFlag, fnWarmDurationFlag}, Action: fnWarm},
+		{Name: "coldstarts", Usage: "Show where the time of a function's latest cold starts went", Flags: []cli.Flag{fnNameFlag}, Action: fnColdStarts},
 		{Name: "test", Usage: "Test a function", Flags: []cli.Flag{fnNameFlag, fnEnvNameFlag, fnCodeFlag, fnPackageFlag, fnSrcArchiveFlag, htMethodFlag, fnBodyFlag, fnHeaderFlag}, Action: fnTest},
+		{Name: "captures", Usage: "List captured requests", Flags: []cli.Flag{fnNameFlag}, Action: fnCaptures},
+		{Name: "replay", Usage: "Replay a captured request and diff the responses", Flags: []cli.Flag{fnCaptureIdFlag, fnNameFlag}, Action: fnReplay},
 	}
 
 	// httptriggers
 	htNameFlag := cli.StringFlag{Name: "name", Usage: "HTTP Trigger name"}
 	htFnNameFlag := cli.StringFlag{Name: "function", Usage: "Function name"}
+	htPipelineFlag := cli.StringFlag{Name: "pipeline", Usage: "Comma-separated function names, invoked in sequence with each response passed to the next function"}
 	htSubcommands := []cli.Command{
-		{Name: "create", Aliases: []string{"add"}, Usage: "Create HTTP trigger", Flags: []cli.Flag{htMethodFlag, htUrlFlag, htFnNameFlag}, Action: htCreate},
+		{Name: "create", Aliases: []string{"add"}, Usage: "Create HTTP trigger", Flags: []cli.Flag{htMethodFlag, htUrlFlag, htFnNameFlag, htPipelineFlag, captureRateFlag}, Action: htCreate},
 		{Name: "get", Usage: "Get HTTP trigger", Flags: []cli.Flag{htMethodFlag, htUrlFlag}, Action: htGet},
-		{Name: "update", Usage: "Update HTTP trigger", Flags: []cli.Flag{htNameFlag, htFnNameFlag}, Action: htUpdate},
+		{Name: "update", Usage: "Update HTTP trigger", Flags: []cli.Flag{htNameFlag, htFnNameFlag, htPipelineFlag, captureRateFlag}, Action: htUpdate},
 		{Name: "delete", Usage: "Delete HTTP trigger", Flags: []cli.Flag{htNameFlag}, Action: htDelete},
 		{Name: "list", Usage: "List HTTP triggers", Flags: []cli.Flag{}, Action: htList},
 	}
@@ -118,15 +137,24 @@ func main() {
 	// environments
 	envNameFlag := cli.StringFlag{Name: "name", Usage: "Environment name"}
 	envPoolsizeFlag := cli.IntFlag{Name: "poolsize", Usage: "Size of the pool, if not specified defaults to 3"}
+	envMinPoolsizeFlag := cli.IntFlag{Name: "minpoolsize", Usage: "Minimum size of an autoscaled pool (optional, defaults to 1)"}
+	envMaxPoolsizeFlag := cli.IntFlag{Name: "maxpoolsize", Usage: "Maximum size of the pool; enables pool autoscaling when set"}
+	envPodSelectionFlag := cli.StringFlag{Name: "podselection", Usage: "How warm pods are picked for functions: random (default), spread, packagecache or leastloaded"}
+	envReadinessTimeoutFlag := cli.DurationFlag{Name: "readinesstimeout", Usage: "How long functions wait for a warm pod to become ready (optional, defaults to 5m)"}
+	envIdleTTLFlag := cli.DurationFlag{Name: "idlettl", Usage: "How long a specialized pod may stay idle before it's deleted (optional, defaults to 2m)"}
+	envUseSvcFlag := cli.BoolFlag{Name: "usesvc", Usage: "Reach specialized pods through a Kubernetes service instead of the pod IP"}
+	envImagePullPolicyFlag := cli.StringFlag{Name: "imagepullpolicy", Usage: "Pull policy for the environment image: Always, Never or IfNotPresent (optional)"}
+	envSpecializeRetriesFlag := cli.IntFlag{Name: "specializeretries", Usage: "How often to retry connecting to a starting pod when specializing it (optional, defaults to 20)"}
+	envPrePullFlag := cli.BoolFlag{Name: "prepull", Usage: "Pull the environment's images to every node ahead of time; --prepull=false stops it"}
 	envImageFlag := cli.StringFlag{Name: "image", Usage: "Environment image URL"}
 	envBuilderImageFlag := cli.StringFlag{Name: "builder", Usage: "Environment builder image URL (optional)"}
 	envBuildCmdFlag := cli.StringFlag{Name: "buildcmd", Usage: "Build command for environment builder to build source package (optional)"}
 
 	envVersionFlag := cli.IntFlag{Name: "version", Usage: "Environment API version: defaults to 1 (means v1 interface)"}
 	envSubcommands := []cli.Command{
-		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: []cli.Flag{envNameFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, minCpu, maxCpu, minMem, maxMem, envVersionFlag}, Action: envCreate},
+		{Name: "create", Aliases: []string{"add"}, Usage: "Add an environment", Flags: []cli.Flag{envNameFlag, envPoolsizeFlag, envMinPoolsizeFlag, envMaxPoolsizeFlag, envPodSelectionFlag, envReadinessTimeoutFlag, envIdleTTLFlag, envUseSvcFlag, envImagePullPolicyFlag, envSpecializeRetriesFlag, envPrePullFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, minCpu, maxCpu, minMem, maxMem, envVersionFlag}, Action: envCreate},
 		{Name: "get", Usage: "Get environment details", Flags: []cli.Flag{envNameFlag}, Action: envGet},
-		{Name: "update", Usage: "Update environment", Flags: []cli.Flag{envNameFlag, envPoolsizeFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, minCpu, maxCpu, minMem, maxMem}, Action: envUpdate},
+		{Name: "update", Usage: "Update environment", Flags: []cli.Flag{envNameFlag, envPoolsizeFlag, envMinPoolsizeFlag, envMaxPoolsizeFlag, envPodSelectionFlag, envReadinessTimeoutFlag, envIdleTTLFlag, envUseSvcFlag, envImagePullPolicyFlag, envSpecializeRetriesFlag, envPrePullFlag, envImageFlag, envBuilderImageFlag, envBuildCmdFlag, minCpu, maxCpu, minMem, maxMem}, Action: envUpdate},
 		{Name: "delete", Usage: "Delete environment", Flags: []cli.Flag{envNameFlag}, Action: envDelete},
 		{Name: "list", Usage: "List all environments", Flags: []cli.Flag{}, Action: envList},
 	}
@@ -176,6 +204,12 @@ func main() {
 		{Name: "restore", Usage: "Restore state dumped from a pre-0.4 Fission cluster. Requires Fission 0.4, which uses Kubernetes CustomResources.", Flags: []cli.Flag{migrateFileFlag}, Action: migrateRestoreCRD},
 	}
 
+	// usage
+	usageStartFlag := cli.StringFlag{Name: "start", Usage: "Start of the report, as an RFC 3339 time or a duration before now (defaults to 24h)"}
+	usageEndFlag := cli.StringFlag{Name: "end", Usage: "End of the report, as an RFC 3339 time or a duration before now (defaults to now)"}
+	usageNamespaceFlag := cli.StringFlag{Name: "namespace", Usage: "Only report the functions of this namespace (optional)"}
+	usageFunctionFlag := cli.StringFlag{Name: "name", Usage: "Only report this function (optional)"}
+
 	app.Commands = []cli.Command{
 		{Name: "function", Aliases: []string{"fn"}, Usage: "Create, update and manage functions", Subcommands: fnSubcommands},
 		{Name: "httptrigger", Aliases: []string{"ht", "route"}, Usage: "Manage HTTP triggers (routes) for functions", Subcommands: htSubcommands},
@@ -184,6 +218,7 @@ func main() {
 		{Name: "environment", Aliases: []string{"env"}, Usage: "Manage environments", Subcommands: envSubcommands},
 		{Name: "watch", Aliases: []string{"w"}, Usage: "Manage watches", Subcommands: wSubCommands},
 		{Name: "package", Aliases: []string{"pkg"}, Usage: "Manage packages", Subcommands: pkgSubCommands},
+		{Name: "usage", Usage: "Report the resource usage and invocations of functions", Flags: []cli.Flag{usageStartFlag, usageEndFlag, usageNamespaceFlag, usageFunctionFlag}, Action: usageReport},
 		{Name: "upgrade", Aliases: []string{}, Usage: "Upgrade tool from fission v0.1", Subcommands: upgradeSubCommands},
 		{Name: "tpr2crd", Aliases: []string{}, Usage: "Migrate tool for TPR to CRD", Subcommands: migrateSubCommands},
 	}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/fission/fission"
+)
+
+// parseUsageTime parses an RFC 3339 time, or a duration before now.
+func parseUsageTime(value string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("'%v' is neither an RFC 3339 time nor a duration", value)
+	}
+	return now.Add(-d), nil
+}
+
+func usageReport(c *cli.Context) error {
+	client := getClient(c.GlobalString("server"))
+
+	now := time.Now()
+	start := now.Add(-24 * time.Hour)
+	end := now
+	var err error
+	if value := c.String("start"); len(value) > 0 {
+		start, err = parseUsageTime(value, now)
+		checkErr(err, "parse start time")
+	}
+	if value := c.String("end"); len(value) > 0 {
+		end, err = parseUsageTime(value, now)
+		checkErr(err, "parse end time")
+	}
+	if !start.Before(end) {
+		fatal("The start of the report must be before its end.")
+	}
+
+	records, err := client.Usage(start, end, c.String("namespace"), c.String("name"))
+	checkErr(err, "get usage")
+
+	// usage is kept by the hour
+	if len(records) > 0 {
+		start, end = records[0].Start, records[0].End
+	}
+	total := fission.UsageRecord{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+		"NAMESPACE", "FUNCTION", "INVOCATIONS", "POD SECONDS", "CPU SECONDS", "MEMORY GB SECONDS")
+	for _, r := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.0f\t%.1f\t%.1f\n",
+			r.Namespace, r.Function, r.Invocations, r.PodSeconds, r.CPUSeconds, r.MemoryGBSeconds)
+		if r.Start.Before(start) {
+			start = r.Start
+		}
+		if r.End.After(end) {
+			end = r.End
+		}
+		total.Invocations += r.Invocations
+		total.PodSeconds += r.PodSeconds
+		total.CPUSeconds += r.CPUSeconds
+		total.MemoryGBSeconds += r.MemoryGBSeconds
+	}
+	fmt.Fprintf(w, "%v\t%v\t%v\t%.0f\t%.1f\t%.1f\n",
+		"TOTAL", "", total.Invocations, total.PodSeconds, total.CPUSeconds, total.MemoryGBSeconds)
+	w.Flush()
+	fmt.Printf("\nUsage from %v to %v\n", start.Local().Format(time.RFC3339), end.Local().Format(time.RFC3339))
+	return nil
+}
@@ -17,29 +17,41 @@ limitations under the License.
 package router
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/fission/fission"
 	executorClient "github.com/fission/fission/executor/client"
 )
 
+// how often the router reports the load of busy functions to the
+// executor
+const loadReportInterval = 5 * time.Second
+
 type functionHandler struct {
 	fmap     *functionServiceMap
 	executor *executorClient.Client
 	function *metav1.ObjectMeta
+
+	// optional request capturing
+	trigger       string
+	captureConfig *fission.RequestCaptureConfig
+	captures      *requestCaptureStore
 }
 
-func (fh *functionHandler) getServiceForFunction() (*url.URL, error) {
+func (fh *functionHandler) getServiceForFunction(ctx context.Context) (*url.URL, error) {
 	// call executor, get a url for a function
-	svcName, err := fh.executor.GetServiceForFunction(fh.function)
+	svcName, err := fh.executor.GetServiceForFunction(ctx, fh.function)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +85,11 @@ func (rrt RetryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, er
 			return resp, nil
 		}
 
+		// no point retrying a request that was cancelled or timed out
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+
 		timeout *= time.Duration(2)
 		log.Printf("Retrying request to %v in %v", req.URL.Host, timeout)
 		time.Sleep(timeout)
@@ -82,6 +99,18 @@ func (rrt RetryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, er
 	return http.DefaultTransport.RoundTrip(req)
 }
 
+// scheduleReport reports the function's load once the report interval
+// is over, unless a report is scheduled already.
+func (fh *functionHandler) scheduleReport(backends *functionBackends) {
+	if !atomic.CompareAndSwapInt32(&backends.reportScheduled, 0, 1) {
+		return
+	}
+	time.AfterFunc(loadReportInterval, func() {
+		atomic.StoreInt32(&backends.reportScheduled, 0)
+		fh.reportLoad(backends)
+	})
+}
+
 func (fh *functionHandler) tapService(serviceUrl *url.URL) {
 	if fh.executor == nil {
 		return
@@ -89,6 +118,69 @@ func (fh *functionHandler) tapService(serviceUrl *url.URL) {
 	fh.executor.TapService(serviceUrl)
 }
 
+// countInvocation counts a request for the function, for usage
+// accounting.
+func (fh *functionHandler) countInvocation() {
+	if fh.executor == nil {
+		return
+	}
+	fh.executor.CountInvocation(fh.function)
+}
+
+// reportLoad periodically tells the executor how busy the function is,
+// so that it can be scaled out, and picks up the function's current
+// instances.  It keeps reporting until a report says no requests are
+// in flight, which the executor waits for before releasing the
+// instances of an old version of the function.
+func (fh *functionHandler) reportLoad(backends *functionBackends) {
+	if fh.executor == nil {
+		return
+	}
+	inflight, latency, ok := backends.takeLoad(time.Now(), loadReportInterval)
+	if !ok {
+		fh.scheduleReport(backends)
+		return
+	}
+	if inflight > 0 {
+		fh.scheduleReport(backends)
+	}
+	go func() {
+		addresses, err := fh.executor.ReportLoad(&executorClient.LoadReport{
+			Function: *fh.function,
+			Inflight: inflight,
+			Latency:  metav1.Duration{Duration: latency},
+		})
+		if err != nil {
+			if fe, ok := err.(fission.Error); ok && fe.Code == fission.ErrorNotFound {
+				// the executor evicted the function's instances,
+				// e.g. because they crashed, or is draining them
+				// because the function was updated
+				log.Printf("Function %v has no instances, forgetting its service", fh.function.Name)
+				fh.fmap.remove(fh.function)
+				return
+			}
+			log.Printf("Error reporting load of function %v: %v", fh.function.Name, err)
+			return
+		}
+		if len(addresses) == 0 {
+			return
+		}
+		urls := make([]*url.URL, 0, len(addresses))
+		for _, address := range addresses {
+			u, err := url.Parse(fmt.Sprintf("http://%v", address))
+			if err != nil {
+				log.Printf("Error parsing service url of function %v: %v", fh.function.Name, err)
+				return
+			}
+			urls = append(urls, u)
+		}
+		if !backends.sameUrls(urls) {
+			log.Printf("Function %v is served by %v instances", fh.function.Name, len(urls))
+			fh.fmap.update(fh.function, urls)
+		}
+	}()
+}
+
 func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *http.Request) {
 	reqStartTime := time.Now()
 
@@ -102,13 +194,13 @@ func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *
 	MetadataToHeaders(HEADERS_FISSION_FUNCTION_PREFIX, fh.function, request)
 
 	// cache lookup
-	serviceUrl, err := fh.fmap.lookup(fh.function)
-	if err != nil {
+	backends, err := fh.fmap.lookupBackends(fh.function)
+	cached := err == nil
+	if !cached {
 		// Cache miss: request the Pool Manager to make a new service.
 		log.Printf("Not cached, getting new service for %v", fh.function)
 
-		var poolErr error
-		serviceUrl, poolErr = fh.getServiceForFunction()
+		serviceUrl, poolErr := fh.getServiceForFunction(request.Context())
 		if poolErr != nil {
 			log.Printf("Failed to get service for function %v: %v", fh.function.Name, poolErr)
 			// We might want a specific error code or header for fission
@@ -118,12 +210,25 @@ func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *
 		}
 
 		// add it to the map
-		fh.fmap.assign(fh.function, serviceUrl)
-	} else {
+		backends = fh.fmap.assign(fh.function, serviceUrl)
+	}
+
+	// pick one of the function's instances
+	backend := backends.acquire()
+	fh.reportLoad(backends)
+	serviceUrl := backends.urls[backend]
+	proxyStartTime := time.Now()
+	defer func() {
+		backends.release(backend, time.Since(proxyStartTime))
+		fh.reportLoad(backends)
+	}()
+
+	if cached {
 		// if we're using our cache, asynchronously tell
 		// executor we're using this service
 		go fh.tapService(serviceUrl)
 	}
+	go fh.countInvocation()
 
 	// Proxy off our request to the serviceUrl, and send the response back.
 	// TODO: As an optimization we may want to cache proxies too -- this might get us
@@ -136,10 +241,13 @@ func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *
 		req.URL.Host = serviceUrl.Host
 
 		// To keep the function run container simple, it
-		// doesn't do any routing.  In the future if we have
-		// multiple functions per container, we could use the
-		// function metadata here.
+		// doesn't do any routing: functions are at the root,
+		// unless the container runs several functions, in
+		// which case the service URL has the function's path.
 		req.URL.Path = "/"
+		if len(serviceUrl.Path) > 0 {
+			req.URL.Path = serviceUrl.Path
+		}
 
 		// Overwrite request host with internal host,
 		// or request will be blocked in some situations
@@ -167,5 +275,25 @@ func (fh *functionHandler) handler(responseWriter http.ResponseWriter, request *
 	if delay > 100*time.Millisecond {
 		log.Printf("Request delay for %v: %v", serviceUrl, delay)
 	}
-	proxy.ServeHTTP(responseWriter, request)
+
+	if fh.captures == nil || !shouldCapture(fh.captureConfig) {
+		proxy.ServeHTTP(responseWriter, request)
+		return
+	}
+
+	// Record this request and its response for later replay.
+	capture, err := startCapture(fh.captureConfig, request)
+	if err != nil {
+		log.Printf("Error capturing request for %v: %v", fh.function.Name, err)
+		http.Error(responseWriter, "Internal server error (fission)", 500)
+		return
+	}
+	capture.FunctionName = fh.function.Name
+	capture.FunctionNamespace = fh.function.Namespace
+	capture.Trigger = fh.trigger
+
+	cw := makeCaptureResponseWriter(responseWriter, fh.captureConfig)
+	proxy.ServeHTTP(cw, request)
+	cw.finish(capture)
+	fh.captures.add(capture)
 }
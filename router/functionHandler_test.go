@@ -17,13 +17,18 @@ limitations under the License.
 package router
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	executorClient "github.com/fission/fission/executor/client"
 )
 
 func createBackendService(testResponseString string) *url.URL {
@@ -60,3 +65,105 @@ func TestFunctionProxying(t *testing.T) {
 
 	testRequest(fhURL, testResponseString)
 }
+
+func TestFunctionProxyingToPath(t *testing.T) {
+	// a container running several functions serves each at its own path
+	backendServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backendServer.Close()
+	backendURL, err := url.Parse(backendServer.URL + "/fission-function/foo")
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	fn := &metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, backendURL)
+
+	fh := &functionHandler{fmap: fmap, function: fn}
+	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer functionHandlerServer.Close()
+
+	testRequest(functionHandlerServer.URL+"/some/trigger", "/fission-function/foo")
+}
+
+func TestFunctionLoadReport(t *testing.T) {
+	backendURL := createBackendService("hi")
+	extraURL := createBackendService("hi")
+
+	reports := make(chan executorClient.LoadReport, 10)
+	executor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/reportLoad" {
+			return
+		}
+		report := executorClient.LoadReport{}
+		err := json.NewDecoder(r.Body).Decode(&report)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		reports <- report
+		json.NewEncoder(w).Encode([]string{backendURL.Host, extraURL.Host})
+	}))
+	defer executor.Close()
+
+	fn := &metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault}
+	fmap := makeFunctionServiceMap(0)
+	fb := fmap.assign(fn, backendURL)
+	atomic.StoreInt64(&fb.lastReport, 0) // report on the next request
+
+	fh := &functionHandler{fmap: fmap, function: fn, executor: executorClient.MakeClient(executor.URL)}
+	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer functionHandlerServer.Close()
+	testRequest(functionHandlerServer.URL, "hi")
+
+	select {
+	case report := <-reports:
+		if report.Function.Name != fn.Name || report.Inflight != 1 {
+			t.Fatalf("unexpected load report %v", report)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("load wasn't reported")
+	}
+
+	// the router picks up the function's new instance
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		fb, err := fmap.lookupBackends(fn)
+		if err == nil && len(fb.urls) == 2 {
+			if *fb.urls[1] != *extraURL {
+				t.Fatalf("unexpected instance %v", fb.urls[1])
+			}
+			return
+		}
+	}
+	t.Fatalf("function instances weren't updated")
+}
+
+func TestFunctionEvictedByExecutor(t *testing.T) {
+	backendURL := createBackendService("hi")
+
+	executor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "function foo has no running instances", http.StatusNotFound)
+	}))
+	defer executor.Close()
+
+	fn := &metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault}
+	fmap := makeFunctionServiceMap(0)
+	fb := fmap.assign(fn, backendURL)
+	atomic.StoreInt64(&fb.lastReport, 0) // report on the next request
+
+	fh := &functionHandler{fmap: fmap, function: fn, executor: executorClient.MakeClient(executor.URL)}
+	functionHandlerServer := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer functionHandlerServer.Close()
+	testRequest(functionHandlerServer.URL, "hi")
+
+	// the router forgets the evicted instance
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		_, err := fmap.lookupBackends(fn)
+		if err != nil {
+			return
+		}
+	}
+	t.Fatalf("evicted function service wasn't forgotten")
+}
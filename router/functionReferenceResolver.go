@@ -17,6 +17,9 @@ limitations under the License.
 package router
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -45,27 +48,55 @@ type (
 
 	resolveResultType int
 
-	// resolveResult is the result of resolving a function reference; for now
-	// it's just the metadata of one function, but in the future could support
-	// a distribution of requests across two functions.
+	// resolveResult is the result of resolving a function reference; it's
+	// either the metadata of one function, or the resolved steps of a
+	// pipeline. In the future it could support a distribution of requests
+	// across two functions.
 	resolveResult struct {
 		resolveResultType
 		functionMetadata *metav1.ObjectMeta
+		pipeline         []pipelineStep
 	}
 
-	// namespacedFunctionReference is just a function reference plus a
-	// namespace. Since a function reference works on names, it's only
-	// meaningful within a namespace.
+	// pipelineStep is a resolved step of a function pipeline
+	pipelineStep struct {
+		functionMetadata *metav1.ObjectMeta
+		timeout          time.Duration
+		forwardHeaders   []string
+	}
+
+	// namespacedFunctionReference is the cache key of a function
+	// reference plus a namespace. Since a function reference works on
+	// names, it's only meaningful within a namespace. Pipelines are
+	// keyed by a hash of their steps, so that equal pipelines share a
+	// cache entry.
 	namespacedFunctionReference struct {
-		namespace         string
-		functionReference fission.FunctionReference
+		namespace    string
+		refType      fission.FunctionReferenceType
+		name         string
+		pipelineHash string
 	}
 )
 
 const (
 	resolveResultSingleFunction = iota
+	resolveResultPipeline
 )
 
+func makeNamespacedFunctionReference(namespace string, fr *fission.FunctionReference) namespacedFunctionReference {
+	nfr := namespacedFunctionReference{
+		namespace: namespace,
+		refType:   fr.Type,
+		name:      fr.Name,
+	}
+	if fr.Type == fission.FunctionReferenceTypeFunctionPipeline {
+		// the pipeline was decoded from JSON, so it encodes fine
+		b, _ := json.Marshal(fr.Pipeline)
+		nfr.pipelineHash = fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+	return nfr
+}
+
 func makeFunctionReferenceResolver(store k8sCache.Store) *functionReferenceResolver {
 	frr := &functionReferenceResolver{
 		refCache: cache.MakeCache(time.Minute, 0),
@@ -95,10 +126,7 @@ func makeK8SCache(crdClient *rest.RESTClient) (k8sCache.Store, k8sCache.Controll
 // (e.g. for incremental deployment), which will make the resolveResult a bit
 // more complex.
 func (frr *functionReferenceResolver) resolve(namespace string, fr *fission.FunctionReference) (*resolveResult, error) {
-	nfr := namespacedFunctionReference{
-		namespace:         namespace,
-		functionReference: *fr,
-	}
+	nfr := makeNamespacedFunctionReference(namespace, fr)
 
 	// check cache
 	rrInt, err := frr.refCache.Get(nfr)
@@ -116,6 +144,11 @@ func (frr *functionReferenceResolver) resolve(namespace string, fr *fission.Func
 		if err != nil {
 			return nil, err
 		}
+	case fission.FunctionReferenceTypeFunctionPipeline:
+		rr, err = frr.resolvePipeline(namespace, &fr.Pipeline)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("Unrecognized function reference type %v", fr.Type)
 	}
@@ -150,11 +183,51 @@ func (frr *functionReferenceResolver) resolveByName(namespace, name string) (*re
 	return &rr, nil
 }
 
-func (frr *functionReferenceResolver) delete(namespace string, fr *fission.FunctionReference) error {
-	nfr := namespacedFunctionReference{
-		namespace:         namespace,
-		functionReference: *fr,
+// resolvePipeline looks up each function of a pipeline by name.
+func (frr *functionReferenceResolver) resolvePipeline(namespace string, p *fission.FunctionPipeline) (*resolveResult, error) {
+	if len(p.Steps) == 0 {
+		return nil, errors.New("pipeline has no steps")
 	}
+
+	steps := make([]pipelineStep, 0, len(p.Steps))
+	for _, s := range p.Steps {
+		fnrr, err := frr.resolveByName(namespace, s.FunctionName)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, pipelineStep{
+			functionMetadata: fnrr.functionMetadata,
+			timeout:          s.Timeout.Duration,
+			forwardHeaders:   s.ForwardHeaders,
+		})
+	}
+
+	rr := resolveResult{
+		resolveResultType: resolveResultPipeline,
+		pipeline:          steps,
+	}
+	return &rr, nil
+}
+
+// isStale returns true if the resolve result refers to an older version
+// of the given function.
+func (rr *resolveResult) isStale(m *metav1.ObjectMeta) bool {
+	switch rr.resolveResultType {
+	case resolveResultSingleFunction:
+		return rr.functionMetadata.Name == m.Name &&
+			rr.functionMetadata.ResourceVersion != m.ResourceVersion
+	case resolveResultPipeline:
+		for _, s := range rr.pipeline {
+			if s.functionMetadata.Name == m.Name &&
+				s.functionMetadata.ResourceVersion != m.ResourceVersion {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (frr *functionReferenceResolver) delete(nfr namespacedFunctionReference) error {
 	return frr.refCache.Delete(nfr)
 }
 
@@ -19,6 +19,7 @@ package router
 import (
 	"log"
 	"net/url"
+	"sync/atomic"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -28,7 +29,7 @@ import (
 
 type (
 	functionServiceMap struct {
-		cache *cache.Cache // map[metadataKey]*url.URL
+		cache *cache.Cache // map[metadataKey]*functionBackends
 	}
 
 	// metav1.ObjectMeta is not hashable, so we make a hashable copy
@@ -38,6 +39,23 @@ type (
 		Namespace       string
 		ResourceVersion string
 	}
+
+	// functionBackends are the service urls of a function, one per
+	// instance.  Requests go to the backend with the fewest requests
+	// in flight, preferring earlier ones; so the extra instances of a
+	// scaled out function only get traffic under load, and go idle
+	// (and get reaped by the executor) once the load drops.
+	functionBackends struct {
+		urls     []*url.URL
+		inflight []int32 // atomic, per url
+
+		// load since the last report to the executor
+		lastReport      int64 // atomic, unix nanoseconds
+		peakInflight    int32 // atomic
+		requests        int64 // atomic
+		latency         int64 // atomic, total nanoseconds
+		reportScheduled int32 // atomic, 1 while a report is scheduled
+	}
 )
 
 func makeFunctionServiceMap(expiry time.Duration) *functionServiceMap {
@@ -54,24 +72,136 @@ func keyFromMetadata(m *metav1.ObjectMeta) *metadataKey {
 	}
 }
 
-func (fmap *functionServiceMap) lookup(f *metav1.ObjectMeta) (*url.URL, error) {
+func makeFunctionBackends(urls []*url.URL) *functionBackends {
+	return &functionBackends{
+		urls:       urls,
+		inflight:   make([]int32, len(urls)),
+		lastReport: time.Now().UnixNano(),
+	}
+}
+
+func (fmap *functionServiceMap) lookupBackends(f *metav1.ObjectMeta) (*functionBackends, error) {
 	mk := keyFromMetadata(f)
 	item, err := fmap.cache.Get(*mk)
 	if err != nil {
 		return nil, err
 	}
-	u := item.(*url.URL)
-	return u, nil
+	return item.(*functionBackends), nil
 }
 
-func (fmap *functionServiceMap) assign(f *metav1.ObjectMeta, serviceUrl *url.URL) {
+func (fmap *functionServiceMap) lookup(f *metav1.ObjectMeta) (*url.URL, error) {
+	fb, err := fmap.lookupBackends(f)
+	if err != nil {
+		return nil, err
+	}
+	return fb.urls[fb.choose()], nil
+}
+
+// assign caches the service urls of a function, unless it already has
+// some.  Returns the function's backends.
+func (fmap *functionServiceMap) assign(f *metav1.ObjectMeta, serviceUrls ...*url.URL) *functionBackends {
 	mk := keyFromMetadata(f)
-	err, old := fmap.cache.Set(*mk, serviceUrl)
+	fb := makeFunctionBackends(serviceUrls)
+	err, old := fmap.cache.Set(*mk, fb)
 	if err != nil {
-		if *serviceUrl == *(old.(*url.URL)) {
-			return
+		oldFb := old.(*functionBackends)
+		if !oldFb.sameUrls(serviceUrls) {
+			log.Printf("error caching service url for function with a different value: %v", err)
 		}
-		log.Printf("error caching service url for function with a different value: %v", err)
 		// ignore error
+		return oldFb
+	}
+	return fb
+}
+
+// update replaces the service urls of a function, e.g. after it's
+// scaled out.
+func (fmap *functionServiceMap) update(f *metav1.ObjectMeta, serviceUrls []*url.URL) {
+	mk := keyFromMetadata(f)
+	fmap.cache.Delete(*mk)
+	fmap.cache.Set(*mk, makeFunctionBackends(serviceUrls))
+}
+
+// remove forgets the service urls of a function, e.g. after the
+// executor evicted its instances, so that the next request asks the
+// executor for new ones.
+func (fmap *functionServiceMap) remove(f *metav1.ObjectMeta) {
+	mk := keyFromMetadata(f)
+	fmap.cache.Delete(*mk)
+}
+
+func (fb *functionBackends) sameUrls(urls []*url.URL) bool {
+	if len(urls) != len(fb.urls) {
+		return false
+	}
+	for i := range urls {
+		if *urls[i] != *fb.urls[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// choose returns the index of the backend with the fewest requests in
+// flight, preferring earlier ones.
+func (fb *functionBackends) choose() int {
+	chosen, min := 0, atomic.LoadInt32(&fb.inflight[0])
+	for i := 1; i < len(fb.inflight); i++ {
+		if n := atomic.LoadInt32(&fb.inflight[i]); n < min {
+			chosen, min = i, n
+		}
+	}
+	return chosen
+}
+
+// acquire picks a backend for a request; release must be called with
+// the returned index when the request is done.
+func (fb *functionBackends) acquire() int {
+	i := fb.choose()
+	atomic.AddInt32(&fb.inflight[i], 1)
+
+	total := fb.totalInflight()
+	for {
+		peak := atomic.LoadInt32(&fb.peakInflight)
+		if total <= peak || atomic.CompareAndSwapInt32(&fb.peakInflight, peak, total) {
+			break
+		}
+	}
+	return i
+}
+
+// totalInflight returns the number of requests in flight at all the
+// function's instances.
+func (fb *functionBackends) totalInflight() int32 {
+	total := int32(0)
+	for i := range fb.inflight {
+		total += atomic.LoadInt32(&fb.inflight[i])
+	}
+	return total
+}
+
+func (fb *functionBackends) release(i int, latency time.Duration) {
+	atomic.AddInt32(&fb.inflight[i], -1)
+	atomic.AddInt64(&fb.requests, 1)
+	atomic.AddInt64(&fb.latency, int64(latency))
+}
+
+// takeLoad returns the peak number of requests in flight and the
+// average latency since the last report, if the last report is at
+// least interval ago; ok is false otherwise.  The peak counts the
+// requests still in flight at the last report, so zero means none were
+// in flight since then.
+func (fb *functionBackends) takeLoad(now time.Time, interval time.Duration) (inflight int, latency time.Duration, ok bool) {
+	last := atomic.LoadInt64(&fb.lastReport)
+	if now.UnixNano()-last < int64(interval) ||
+		!atomic.CompareAndSwapInt64(&fb.lastReport, last, now.UnixNano()) {
+		return 0, 0, false
+	}
+	inflight = int(atomic.SwapInt32(&fb.peakInflight, fb.totalInflight()))
+	requests := atomic.SwapInt64(&fb.requests, 0)
+	total := atomic.SwapInt64(&fb.latency, 0)
+	if requests > 0 {
+		latency = time.Duration(total / requests)
 	}
+	return inflight, latency, true
 }
@@ -19,6 +19,7 @@ package router
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -47,3 +48,69 @@ func TestFunctionServiceMap(t *testing.T) {
 		t.Errorf("No error on missing entry")
 	}
 }
+
+func TestFunctionBackends(t *testing.T) {
+	urls := make([]*url.URL, 0)
+	for _, s := range []string{"http://10.0.0.1:8888", "http://10.0.0.2:8888"} {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatalf("can't parse url: %v", err)
+		}
+		urls = append(urls, u)
+	}
+	fb := makeFunctionBackends(urls)
+
+	// sequential requests all go to the first instance
+	for i := 0; i < 3; i++ {
+		b := fb.acquire()
+		if b != 0 {
+			t.Fatalf("expected idle function to use its first instance, got %v", b)
+		}
+		fb.release(b, 10*time.Millisecond)
+	}
+
+	// concurrent ones are spread
+	b0 := fb.acquire()
+	b1 := fb.acquire()
+	b2 := fb.acquire()
+	if b0 != 0 || b1 != 1 || b2 != 0 {
+		t.Fatalf("expected requests to go to the least busy instance, got %v, %v, %v", b0, b1, b2)
+	}
+	fb.release(b0, 40*time.Millisecond)
+	fb.release(b1, 40*time.Millisecond)
+	fb.release(b2, 40*time.Millisecond)
+
+	// load is reported at most once per interval
+	now := time.Now()
+	_, _, ok := fb.takeLoad(now, time.Minute)
+	if ok {
+		t.Fatalf("load reported before the interval passed")
+	}
+	inflight, latency, ok := fb.takeLoad(now.Add(time.Minute), time.Minute)
+	if !ok {
+		t.Fatalf("expected load to be reported")
+	}
+	if inflight != 3 || latency != 25*time.Millisecond {
+		t.Fatalf("unexpected load: %v in flight, %v latency", inflight, latency)
+	}
+	inflight, _, _ = fb.takeLoad(now.Add(2*time.Minute), time.Minute)
+	if inflight != 0 {
+		t.Fatalf("expected load to be reset after reporting, got %v", inflight)
+	}
+
+	// requests still in flight count towards the next report
+	b0 = fb.acquire()
+	inflight, _, _ = fb.takeLoad(now.Add(3*time.Minute), time.Minute)
+	if inflight != 1 {
+		t.Fatalf("expected 1 request in flight, got %v", inflight)
+	}
+	fb.release(b0, 40*time.Millisecond)
+	inflight, _, _ = fb.takeLoad(now.Add(4*time.Minute), time.Minute)
+	if inflight != 1 {
+		t.Fatalf("expected the request in flight since the last report to count, got %v", inflight)
+	}
+	inflight, _, _ = fb.takeLoad(now.Add(5*time.Minute), time.Minute)
+	if inflight != 0 {
+		t.Fatalf("expected no requests in flight, got %v", inflight)
+	}
+}
@@ -47,6 +47,7 @@ type HTTPTriggerSet struct {
 	functions         []crd.Function
 	funcStore         k8sCache.Store
 	funcController    k8sCache.Controller
+	captures          *requestCaptureStore
 }
 
 func makeHTTPTriggerSet(fmap *functionServiceMap, fissionClient *crd.FissionClient,
@@ -57,6 +58,7 @@ func makeHTTPTriggerSet(fmap *functionServiceMap, fissionClient *crd.FissionClie
 		fissionClient:      fissionClient,
 		executor:           executor,
 		crdClient:          crdClient,
+		captures:           makeRequestCaptureStore(defaultCaptureBufferSize),
 	}
 	var tStore, fnStore k8sCache.Store
 	var tController, fnController k8sCache.Controller
@@ -107,18 +109,37 @@ func (ts *HTTPTriggerSet) getRouter() *mux.Router {
 			continue
 		}
 
-		if rr.resolveResultType != resolveResultSingleFunction {
+		var handler http.HandlerFunc
+		switch rr.resolveResultType {
+		case resolveResultSingleFunction:
+			// trigger-level capture config overrides the function's
+			captureConfig := trigger.Spec.Capture
+			if captureConfig == nil {
+				captureConfig = ts.functionCaptureConfig(rr.functionMetadata)
+			}
+
+			fh := &functionHandler{
+				fmap:          ts.functionServiceMap,
+				function:      rr.functionMetadata,
+				executor:      ts.executor,
+				trigger:       trigger.Metadata.Name,
+				captureConfig: captureConfig,
+				captures:      ts.captures,
+			}
+			handler = fh.handler
+		case resolveResultPipeline:
+			ph := &pipelineHandler{
+				fmap:     ts.functionServiceMap,
+				executor: ts.executor,
+				steps:    rr.pipeline,
+			}
+			handler = ph.handler
+		default:
 			// not implemented yet
 			log.Panicf("resolve result type not implemented (%v)", rr.resolveResultType)
 		}
 
-		fh := &functionHandler{
-			fmap:     ts.functionServiceMap,
-			function: rr.functionMetadata,
-			executor: ts.executor,
-		}
-
-		ht := muxRouter.HandleFunc(trigger.Spec.RelativeURL, fh.handler)
+		ht := muxRouter.HandleFunc(trigger.Spec.RelativeURL, handler)
 		ht.Methods(trigger.Spec.Method)
 		if trigger.Spec.Host != "" {
 			ht.Host(trigger.Spec.Host)
@@ -143,9 +164,11 @@ func (ts *HTTPTriggerSet) getRouter() *mux.Router {
 	for _, function := range ts.functions {
 		m := function.Metadata
 		fh := &functionHandler{
-			fmap:     ts.functionServiceMap,
-			function: &m,
-			executor: ts.executor,
+			fmap:          ts.functionServiceMap,
+			function:      &m,
+			executor:      ts.executor,
+			captureConfig: function.Spec.Capture,
+			captures:      ts.captures,
 		}
 		muxRouter.HandleFunc(fission.UrlForFunction(function.Metadata.Name), fh.handler)
 	}
@@ -153,6 +176,17 @@ func (ts *HTTPTriggerSet) getRouter() *mux.Router {
 	return muxRouter
 }
 
+// functionCaptureConfig returns the request capture config of a
+// function, if any.
+func (ts *HTTPTriggerSet) functionCaptureConfig(m *metav1.ObjectMeta) *fission.RequestCaptureConfig {
+	for _, f := range ts.functions {
+		if f.Metadata.Name == m.Name && f.Metadata.Namespace == m.Namespace {
+			return f.Spec.Capture
+		}
+	}
+	return nil
+}
+
 func (ts *HTTPTriggerSet) updateTriggerStatusFailed(ht *crd.HTTPTrigger, err error) {
 	// TODO
 }
@@ -188,23 +222,27 @@ func (ts *HTTPTriggerSet) initFunctionController() (k8sCache.Store, k8sCache.Con
 			},
 			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
 				fn := newObj.(*crd.Function)
-				// update resolver function reference cache
-				for key, rr := range ts.resolver.copy() {
-					if key.functionReference.Name == fn.Metadata.Name &&
-						rr.functionMetadata.ResourceVersion != fn.Metadata.ResourceVersion {
-						err := ts.resolver.delete(key.namespace, &key.functionReference)
-						if err != nil {
-							log.Printf("Error deleting functionReferenceResolver cache: %v", err)
-						}
-						break
-					}
-				}
+				ts.invalidateResolverCache(&fn.Metadata)
 				ts.syncTriggers()
 			},
 		})
 	return store, controller
 }
 
+// invalidateResolverCache updates the resolver's function reference
+// cache for a function that changed.  Several pipelines may use the
+// same function, so all entries are checked.
+func (ts *HTTPTriggerSet) invalidateResolverCache(m *metav1.ObjectMeta) {
+	for key, rr := range ts.resolver.copy() {
+		if rr.isStale(m) {
+			err := ts.resolver.delete(key)
+			if err != nil {
+				log.Printf("Error deleting functionReferenceResolver cache: %v", err)
+			}
+		}
+	}
+}
+
 func (ts *HTTPTriggerSet) runWatcher(ctx context.Context, controller k8sCache.Controller) {
 	go func() {
 		controller.Run(ctx.Done())
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/gorilla/handlers"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	executorClient "github.com/fission/fission/executor/client"
+	"github.com/fission/fission/executor/localprocess"
+)
+
+// localStorePollInterval is how often a router without Kubernetes
+// rereads its store.
+const localStorePollInterval = 2 * time.Second
+
+// StartLocal starts a router that reads HTTP triggers and functions
+// from a directory of files, the same one a local executor reads
+// (see localprocess.FileStore), instead of from Kubernetes.
+func StartLocal(port int, internalPort int, executorUrl string, storePath string) {
+	fmap := makeFunctionServiceMap(time.Minute)
+
+	executor := executorClient.MakeClient(executorUrl)
+	triggers, _, _ := makeHTTPTriggerSet(fmap, nil, executor, nil)
+	triggers.triggerStore = k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)
+	triggers.funcStore = k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)
+	resolver := makeFunctionReferenceResolver(triggers.funcStore)
+
+	log.Printf("Starting router internal API at port %v\n", internalPort)
+	go serveInternal(internalPort, triggers.captures)
+
+	log.Printf("Starting router at port %v, reading triggers from %v\n", port, storePath)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr := router(ctx, triggers, resolver)
+	go triggers.watchFileStore(ctx, localprocess.MakeFileStore(storePath))
+	url := fmt.Sprintf(":%v", port)
+	http.ListenAndServe(url, handlers.LoggingHandler(os.Stdout, mr))
+}
+
+// watchFileStore rereads the triggers and functions in store, and
+// updates the router whenever any of them changed.
+func (ts *HTTPTriggerSet) watchFileStore(ctx context.Context, store *localprocess.FileStore) {
+	var versions map[string]string
+	for {
+		latest, err := ts.loadFileStore(store)
+		if err != nil {
+			log.Printf("Error reading local store: %v", err)
+		} else if !reflect.DeepEqual(latest, versions) {
+			ts.syncTriggers()
+			versions = latest
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(localStorePollInterval):
+		}
+	}
+}
+
+// loadFileStore replaces the contents of the trigger and function
+// stores with the objects in store.  It returns the resource version
+// of each object, to tell whether anything changed since the last
+// load.
+func (ts *HTTPTriggerSet) loadFileStore(store *localprocess.FileStore) (map[string]string, error) {
+	triggers, err := store.ListHTTPTriggers()
+	if err != nil {
+		return nil, err
+	}
+	functions, err := store.ListFunctions()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string)
+	triggerObjs := make([]interface{}, 0, len(triggers))
+	for i := range triggers {
+		m := &triggers[i].Metadata
+		versions["httptriggers/"+m.Namespace+"/"+m.Name] = m.ResourceVersion
+		triggerObjs = append(triggerObjs, &triggers[i])
+	}
+	funcObjs := make([]interface{}, 0, len(functions))
+	for i := range functions {
+		m := &functions[i].Metadata
+		versions["functions/"+m.Namespace+"/"+m.Name] = m.ResourceVersion
+		funcObjs = append(funcObjs, &functions[i])
+		ts.invalidateResolverCache(m)
+	}
+
+	err = ts.triggerStore.Replace(triggerObjs, "")
+	if err != nil {
+		return nil, err
+	}
+	err = ts.funcStore.Replace(funcObjs, "")
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	"github.com/fission/fission/crd"
+	"github.com/fission/fission/executor/localprocess"
+)
+
+func writeStoreFile(t *testing.T, dir string, kind string, name string, contents string) {
+	err := os.MkdirAll(filepath.Join(dir, kind), 0755)
+	if err != nil {
+		t.Fatalf("error creating %v directory: %v", kind, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, kind, name+".json"), []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("error writing %v/%v: %v", kind, name, err)
+	}
+}
+
+func TestLocalStoreRouter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router-store")
+	if err != nil {
+		t.Fatalf("error creating store directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeStoreFile(t, dir, "functions", "hello", `{
+		"spec": {"environment": {"name": "go"}}
+	}`)
+	writeStoreFile(t, dir, "httptriggers", "hello", `{
+		"spec": {
+			"relativeurl": "/hello",
+			"method": "GET",
+			"functionref": {"type": "name", "name": "hello"}
+		}
+	}`)
+
+	fmap := makeFunctionServiceMap(0)
+	triggers, _, _ := makeHTTPTriggerSet(fmap, nil, nil, nil)
+	triggers.triggerStore = k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)
+	triggers.funcStore = k8sCache.NewStore(k8sCache.MetaNamespaceKeyFunc)
+	resolver := makeFunctionReferenceResolver(triggers.funcStore)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr := router(ctx, triggers, resolver)
+
+	versions, err := triggers.loadFileStore(localprocess.MakeFileStore(dir))
+	if err != nil {
+		t.Fatalf("error loading store: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected a function and a trigger, got %v", versions)
+	}
+	triggers.syncTriggers()
+
+	// the function is resolved from the store, and its service is
+	// one the executor would have returned
+	obj, exists, err := triggers.funcStore.Get(&crd.Function{
+		Metadata: metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault},
+	})
+	if err != nil || !exists {
+		t.Fatalf("expected function hello in the store: %v", err)
+	}
+	fn := obj.(*crd.Function)
+	testResponseString := "hi"
+	fmap.assign(&fn.Metadata, createBackendService(testResponseString))
+
+	server := httptest.NewServer(mr)
+	defer server.Close()
+	testRequest(server.URL+"/hello", testResponseString)
+}
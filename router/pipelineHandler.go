@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	executorClient "github.com/fission/fission/executor/client"
+)
+
+const (
+	// timeout of a pipeline step that doesn't specify one
+	defaultPipelineStepTimeout = 60 * time.Second
+
+	// response header listing the latency of each pipeline step
+	HEADER_FISSION_PIPELINE_TRACE = "X-Fission-Pipeline-Trace"
+)
+
+type (
+	// pipelineHandler runs the functions of a pipeline in sequence,
+	// feeding each function's response into the next one.
+	pipelineHandler struct {
+		fmap     *functionServiceMap
+		executor *executorClient.Client
+		steps    []pipelineStep
+	}
+
+	// bufferedResponseWriter holds a step's response in memory, so it
+	// can be inspected before it's passed on.
+	bufferedResponseWriter struct {
+		header     http.Header
+		statusCode int
+		body       bytes.Buffer
+	}
+)
+
+func makeBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		header:     make(http.Header),
+		statusCode: http.StatusOK,
+	}
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(code int) {
+	bw.statusCode = code
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
+
+func (ph *pipelineHandler) handler(responseWriter http.ResponseWriter, request *http.Request) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(responseWriter, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	method := request.Method
+	header := cloneHeader(request.Header)
+	trace := make([]string, 0, len(ph.steps))
+
+	var resp *bufferedResponseWriter
+	for i, step := range ph.steps {
+		startTime := time.Now()
+		resp, err = ph.runStep(request, &step, method, header, body)
+		trace = append(trace, fmt.Sprintf("%v=%v", step.functionMetadata.Name, time.Since(startTime)))
+		if err != nil {
+			log.Printf("Pipeline step %v (%v) failed: %v", i, step.functionMetadata.Name, err)
+			responseWriter.Header().Set(HEADER_FISSION_PIPELINE_TRACE, strings.Join(trace, ", "))
+			if err == context.DeadlineExceeded {
+				http.Error(responseWriter, fmt.Sprintf("Pipeline step %v (%v) timed out", i, step.functionMetadata.Name),
+					http.StatusGatewayTimeout)
+			} else {
+				http.Error(responseWriter, "Internal server error (fission)", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// stop at the first failing step, and return its response
+		if resp.statusCode < 200 || resp.statusCode >= 300 {
+			log.Printf("Pipeline step %v (%v) returned status %v", i, step.functionMetadata.Name, resp.statusCode)
+			break
+		}
+
+		// the next step gets this step's response body, its content
+		// type and any headers it asked to forward
+		method = http.MethodPost
+		body = resp.body.Bytes()
+		header = make(http.Header)
+		if ct := resp.header.Get("Content-Type"); len(ct) > 0 {
+			header.Set("Content-Type", ct)
+		}
+		for _, h := range step.forwardHeaders {
+			if v, ok := resp.header[http.CanonicalHeaderKey(h)]; ok {
+				header[http.CanonicalHeaderKey(h)] = v
+			}
+		}
+	}
+
+	for k, v := range resp.header {
+		responseWriter.Header()[k] = v
+	}
+	responseWriter.Header().Del("Content-Length")
+	responseWriter.Header().Set(HEADER_FISSION_PIPELINE_TRACE, strings.Join(trace, ", "))
+	responseWriter.WriteHeader(resp.statusCode)
+	responseWriter.Write(resp.body.Bytes())
+}
+
+// runStep invokes one function of the pipeline. Function errors are
+// returned as responses; an error is returned if the step couldn't be
+// run or didn't finish within its timeout.
+func (ph *pipelineHandler) runStep(orig *http.Request, step *pipelineStep,
+	method string, header http.Header, body []byte) (*bufferedResponseWriter, error) {
+
+	timeout := step.timeout
+	if timeout <= 0 {
+		timeout = defaultPipelineStepTimeout
+	}
+	ctx, cancel := context.WithTimeout(orig.Context(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, orig.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header = cloneHeader(header)
+	req.Header.Del("Content-Length")
+	req.Host = orig.Host
+	req.RemoteAddr = orig.RemoteAddr
+
+	fh := &functionHandler{
+		fmap:     ph.fmap,
+		function: step.functionMetadata,
+		executor: ph.executor,
+	}
+	resp := makeBufferedResponseWriter()
+	fh.handler(resp, req)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ctx.Err()
+	}
+	return resp, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createPipelineStep(t *testing.T, fmap *functionServiceMap, name string, h http.HandlerFunc) pipelineStep {
+	backend := httptest.NewServer(h)
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+	fn := &metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault}
+	fmap.assign(fn, backendURL)
+	return pipelineStep{functionMetadata: fn}
+}
+
+func TestPipeline(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+
+	upper := createPipelineStep(t, fmap, "upper", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/x-shout")
+		w.Header().Set("X-Step", "upper")
+		w.Header().Set("X-Not-Forwarded", "1")
+		w.Write(bytes.ToUpper(body))
+	})
+	upper.forwardHeaders = []string{"x-step"}
+
+	var lastReq *http.Request
+	exclaim := createPipelineStep(t, fmap, "exclaim", func(w http.ResponseWriter, r *http.Request) {
+		lastReq = r
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append(body, '!'))
+	})
+
+	ph := &pipelineHandler{fmap: fmap, steps: []pipelineStep{upper, exclaim}}
+	server := httptest.NewServer(http.HandlerFunc(ph.handler))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("error invoking pipeline: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "HELLO!" {
+		t.Fatalf("unexpected pipeline response %v: %v", resp.StatusCode, string(body))
+	}
+	if lastReq.Header.Get("Content-Type") != "text/x-shout" ||
+		lastReq.Header.Get("X-Step") != "upper" ||
+		len(lastReq.Header.Get("X-Not-Forwarded")) != 0 {
+		t.Fatalf("unexpected headers passed to second step: %v", lastReq.Header)
+	}
+	trace := resp.Header.Get(HEADER_FISSION_PIPELINE_TRACE)
+	if !strings.HasPrefix(trace, "upper=") || !strings.Contains(trace, ", exclaim=") {
+		t.Fatalf("unexpected pipeline trace '%v'", trace)
+	}
+}
+
+func TestPipelineStopsOnError(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+
+	fail := createPipelineStep(t, fmap, "fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	})
+	called := false
+	next := createPipelineStep(t, fmap, "next", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	ph := &pipelineHandler{fmap: fmap, steps: []pipelineStep{fail, next}}
+	server := httptest.NewServer(http.HandlerFunc(ph.handler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("error invoking pipeline: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest || strings.TrimSpace(string(body)) != "nope" {
+		t.Fatalf("expected the failing step's response, got %v: %v", resp.StatusCode, string(body))
+	}
+	if called {
+		t.Fatalf("step after a failing step should not be called")
+	}
+}
+
+func TestPipelineStepTimeout(t *testing.T) {
+	fmap := makeFunctionServiceMap(0)
+
+	slow := createPipelineStep(t, fmap, "slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	slow.timeout = 100 * time.Millisecond
+
+	ph := &pipelineHandler{fmap: fmap, steps: []pipelineStep{slow}}
+	server := httptest.NewServer(http.HandlerFunc(ph.handler))
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("error invoking pipeline: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("expected timeout, got status %v", resp.StatusCode)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("step timeout not enforced, took %v", time.Since(start))
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+
+	"github.com/fission/fission"
+)
+
+type captureRequestType int
+
+const (
+	CAPTURE_ADD captureRequestType = iota
+	CAPTURE_LIST
+	CAPTURE_GET
+)
+
+const (
+	// number of captured requests kept by a router, across all functions
+	defaultCaptureBufferSize = 100
+)
+
+// Headers that carry credentials aren't kept in captures; anyone who
+// can list captures would otherwise see them.
+var redactedCaptureHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+type (
+	// requestCaptureStore is a bounded ring buffer of captured
+	// requests. Like the other caches in fission, all access is
+	// serialized through a single goroutine.
+	requestCaptureStore struct {
+		captures       []*fission.CapturedRequest
+		next           int
+		requestChannel chan *captureRequest
+	}
+	captureRequest struct {
+		requestType       captureRequestType
+		capture           *fission.CapturedRequest
+		id                string
+		functionName      string
+		functionNamespace string
+		responseChannel   chan *captureResponse
+	}
+	captureResponse struct {
+		captures []fission.CapturedRequest
+		error
+	}
+
+	// captureResponseWriter records the status, headers and the
+	// beginning of the body of a response while passing it through.
+	captureResponseWriter struct {
+		http.ResponseWriter
+		statusCode  int
+		body        bytes.Buffer
+		maxBodySize int64
+		truncated   bool
+	}
+
+	readCloser struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+func makeRequestCaptureStore(size int) *requestCaptureStore {
+	rcs := &requestCaptureStore{
+		captures:       make([]*fission.CapturedRequest, size),
+		requestChannel: make(chan *captureRequest),
+	}
+	go rcs.service()
+	return rcs
+}
+
+func (rcs *requestCaptureStore) service() {
+	for {
+		req := <-rcs.requestChannel
+		resp := &captureResponse{}
+		switch req.requestType {
+		case CAPTURE_ADD:
+			// overwrite the oldest entry
+			rcs.captures[rcs.next] = req.capture
+			rcs.next = (rcs.next + 1) % len(rcs.captures)
+		case CAPTURE_LIST:
+			// newest first
+			resp.captures = make([]fission.CapturedRequest, 0)
+			for i := 1; i <= len(rcs.captures); i++ {
+				c := rcs.captures[(rcs.next-i+len(rcs.captures))%len(rcs.captures)]
+				if c == nil {
+					break
+				}
+				if len(req.functionName) > 0 && c.FunctionName != req.functionName {
+					continue
+				}
+				if len(req.functionNamespace) > 0 && c.FunctionNamespace != req.functionNamespace {
+					continue
+				}
+				resp.captures = append(resp.captures, *c)
+			}
+		case CAPTURE_GET:
+			resp.error = fission.MakeError(fission.ErrorNotFound,
+				fmt.Sprintf("capture '%v' not found", req.id))
+			for _, c := range rcs.captures {
+				if c != nil && c.ID == req.id {
+					resp.captures = []fission.CapturedRequest{*c}
+					resp.error = nil
+					break
+				}
+			}
+		}
+		req.responseChannel <- resp
+	}
+}
+
+func (rcs *requestCaptureStore) add(c *fission.CapturedRequest) {
+	responseChannel := make(chan *captureResponse)
+	rcs.requestChannel <- &captureRequest{
+		requestType:     CAPTURE_ADD,
+		capture:         c,
+		responseChannel: responseChannel,
+	}
+	<-responseChannel
+}
+
+func (rcs *requestCaptureStore) list(fnName, fnNamespace string) []fission.CapturedRequest {
+	responseChannel := make(chan *captureResponse)
+	rcs.requestChannel <- &captureRequest{
+		requestType:       CAPTURE_LIST,
+		functionName:      fnName,
+		functionNamespace: fnNamespace,
+		responseChannel:   responseChannel,
+	}
+	resp := <-responseChannel
+	return resp.captures
+}
+
+func (rcs *requestCaptureStore) get(id string) (*fission.CapturedRequest, error) {
+	responseChannel := make(chan *captureResponse)
+	rcs.requestChannel <- &captureRequest{
+		requestType:     CAPTURE_GET,
+		id:              id,
+		responseChannel: responseChannel,
+	}
+	resp := <-responseChannel
+	if resp.error != nil {
+		return nil, resp.error
+	}
+	return &resp.captures[0], nil
+}
+
+func (rcs *requestCaptureStore) listHandler(w http.ResponseWriter, r *http.Request) {
+	captures := rcs.list(r.URL.Query().Get("function"), r.URL.Query().Get("namespace"))
+	resp, err := json.Marshal(captures)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+func (rcs *requestCaptureStore) getHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := rcs.get(mux.Vars(r)["capture"])
+	if err != nil {
+		code, msg := fission.GetHTTPError(err)
+		http.Error(w, msg, code)
+		return
+	}
+	resp, err := json.Marshal(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(resp)
+}
+
+// shouldCapture decides whether a request should be sampled
+func shouldCapture(config *fission.RequestCaptureConfig) bool {
+	if config == nil || config.SampleRate <= 0 {
+		return false
+	}
+	return config.SampleRate >= 1 || rand.Float64() < config.SampleRate
+}
+
+func captureMaxBodySize(config *fission.RequestCaptureConfig) int64 {
+	if config.MaxBodySize > 0 {
+		return config.MaxBodySize
+	}
+	return fission.RequestCaptureDefaultMaxBodySize
+}
+
+// startCapture records the request side of a capture. The request
+// body is read up to the size limit and then stitched back together,
+// so the function still sees the whole body.
+func startCapture(config *fission.RequestCaptureConfig, request *http.Request) (*fission.CapturedRequest, error) {
+	maxBodySize := captureMaxBodySize(config)
+
+	c := &fission.CapturedRequest{
+		ID:             uuid.NewV4().String(),
+		Timestamp:      time.Now(),
+		Method:         request.Method,
+		URL:            request.URL.RequestURI(),
+		RequestHeaders: redactHeader(request.Header),
+	}
+
+	if request.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		request.Body = readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), request.Body),
+			Closer: request.Body,
+		}
+		if int64(len(body)) > maxBodySize {
+			body = body[:maxBodySize]
+			c.RequestBodyTruncated = true
+		}
+		c.RequestBody = body
+	}
+	return c, nil
+}
+
+func makeCaptureResponseWriter(w http.ResponseWriter, config *fission.RequestCaptureConfig) *captureResponseWriter {
+	return &captureResponseWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		maxBodySize:    captureMaxBodySize(config),
+	}
+}
+
+func (cw *captureResponseWriter) WriteHeader(code int) {
+	cw.statusCode = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureResponseWriter) Write(b []byte) (int, error) {
+	remaining := cw.maxBodySize - int64(cw.body.Len())
+	if int64(len(b)) > remaining {
+		cw.body.Write(b[:remaining])
+		cw.truncated = true
+	} else {
+		cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// finish fills in the response side of a capture
+func (cw *captureResponseWriter) finish(c *fission.CapturedRequest) {
+	c.Latency = time.Since(c.Timestamp)
+	c.StatusCode = cw.statusCode
+	c.ResponseHeaders = redactHeader(cw.Header())
+	c.ResponseBody = cw.body.Bytes()
+	c.ResponseBodyTruncated = cw.truncated
+}
+
+// redactHeader copies h, with the values of credential headers
+// replaced.
+func redactHeader(h http.Header) http.Header {
+	h2 := cloneHeader(h)
+	for _, k := range redactedCaptureHeaders {
+		if _, ok := h2[k]; ok {
+			h2[k] = []string{fission.RequestCaptureRedacted}
+		}
+	}
+	return h2
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
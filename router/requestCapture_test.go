@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fission/fission"
+)
+
+func TestRequestCapture(t *testing.T) {
+	testResponseString := "hi"
+	backendURL := createBackendService(testResponseString)
+
+	fn := &metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault}
+	fmap := makeFunctionServiceMap(0)
+	fmap.assign(fn, backendURL)
+
+	captures := makeRequestCaptureStore(2)
+	fh := &functionHandler{
+		fmap:     fmap,
+		function: fn,
+		captureConfig: &fission.RequestCaptureConfig{
+			SampleRate:  1,
+			MaxBodySize: 4,
+		},
+		captures: captures,
+	}
+	server := httptest.NewServer(http.HandlerFunc(fh.handler))
+	defer server.Close()
+
+	// send more requests than the store holds
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", fmt.Sprintf("%v/?i=%v", server.URL, i), strings.NewReader("request body"))
+		if err != nil {
+			log.Panicf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("X-Custom", "kept")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Panicf("failed to make request: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	list := captures.list(fn.Name, fn.Namespace)
+	if len(list) != 2 {
+		log.Panicf("expected 2 captures, found %v", len(list))
+	}
+
+	// newest first
+	c := list[0]
+	if c.URL != "/?i=2" {
+		log.Panicf("unexpected url of newest capture: %v", c.URL)
+	}
+	if string(c.RequestBody) != "requ" || !c.RequestBodyTruncated {
+		log.Panicf("unexpected request body: %v (truncated %v)", string(c.RequestBody), c.RequestBodyTruncated)
+	}
+	if c.StatusCode != 200 || string(c.ResponseBody) != testResponseString {
+		log.Panicf("unexpected response: %v %v", c.StatusCode, string(c.ResponseBody))
+	}
+
+	// credentials aren't captured
+	if c.RequestHeaders.Get("Authorization") != fission.RequestCaptureRedacted || c.RequestHeaders.Get("X-Custom") != "kept" {
+		log.Panicf("unexpected captured headers: %v", c.RequestHeaders)
+	}
+
+	c2, err := captures.get(c.ID)
+	if err != nil || c2.ID != c.ID {
+		log.Panicf("failed to get capture by id: %v", err)
+	}
+}
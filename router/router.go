@@ -71,7 +71,19 @@ func serve(ctx context.Context, port int, httpTriggerSet *HTTPTriggerSet, resolv
 	http.ListenAndServe(url, handlers.LoggingHandler(os.Stdout, mr))
 }
 
-func Start(port int, executorUrl string) {
+// serveInternal serves the router's internal API, which isn't exposed
+// to function callers: the captured requests, which the controller
+// collects from every router.
+func serveInternal(port int, captures *requestCaptureStore) {
+	r := mux.NewRouter()
+	r.HandleFunc("/fission-router/captures", captures.listHandler).Methods("GET")
+	r.HandleFunc("/fission-router/captures/{capture}", captures.getHandler).Methods("GET")
+	url := fmt.Sprintf(":%v", port)
+	err := http.ListenAndServe(url, handlers.LoggingHandler(os.Stdout, r))
+	log.Fatalf("Error serving router internal API: %v", err)
+}
+
+func Start(port int, internalPort int, executorUrl string) {
 	fmap := makeFunctionServiceMap(time.Minute)
 
 	fissionClient, _, _, err := crd.MakeFissionClient()
@@ -85,6 +97,9 @@ func Start(port int, executorUrl string) {
 	triggers, _, fnStore := makeHTTPTriggerSet(fmap, fissionClient, executor, restClient)
 	resolver := makeFunctionReferenceResolver(fnStore)
 
+	log.Printf("Starting router internal API at port %v\n", internalPort)
+	go serveInternal(internalPort, triggers.captures)
+
 	log.Printf("Starting router at port %v\n", port)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -48,10 +48,7 @@ func TestRouter(t *testing.T) {
 
 	// set up the resolver's cache for this function
 	frr := makeFunctionReferenceResolver(nil)
-	nfr := namespacedFunctionReference{
-		namespace:         metav1.NamespaceDefault,
-		functionReference: fr,
-	}
+	nfr := makeNamespacedFunctionReference(metav1.NamespaceDefault, &fr)
 	rr := resolveResult{
 		resolveResultType: resolveResultSingleFunction,
 		functionMetadata:  fn,
@@ -85,3 +82,24 @@ func TestRouter(t *testing.T) {
 	testUrl := fmt.Sprintf("http://localhost:%v%v", port, triggerUrl)
 	testRequest(testUrl, testResponseString)
 }
+
+func TestPipelineReferenceKey(t *testing.T) {
+	makeRef := func(names ...string) *fission.FunctionReference {
+		fr := &fission.FunctionReference{Type: fission.FunctionReferenceTypeFunctionPipeline}
+		for _, name := range names {
+			fr.Pipeline.Steps = append(fr.Pipeline.Steps, fission.PipelineStep{FunctionName: name})
+		}
+		return fr
+	}
+
+	// a trigger update with the same pipeline finds the cached result
+	k1 := makeNamespacedFunctionReference(metav1.NamespaceDefault, makeRef("a", "b"))
+	k2 := makeNamespacedFunctionReference(metav1.NamespaceDefault, makeRef("a", "b"))
+	if k1 != k2 {
+		t.Fatalf("expected equal pipelines to have the same key: %v, %v", k1, k2)
+	}
+	k3 := makeNamespacedFunctionReference(metav1.NamespaceDefault, makeRef("b", "a"))
+	if k1 == k3 {
+		t.Fatalf("expected different pipelines to have different keys")
+	}
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package fission
 
 import (
+	"net/http"
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/pkg/api/v1"
 )
@@ -67,6 +70,26 @@ type (
 		Name      string `json:"name"`
 	}
 
+	// SecretReference names a Kubernetes Secret that a function can
+	// read.  The Secret must live in the function's namespace.
+	SecretReference struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	}
+
+	// ConfigMapReference names a Kubernetes ConfigMap that a function
+	// can read.  The ConfigMap must live in the function's namespace.
+	ConfigMapReference struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	}
+
+	// EnvVar is a literal environment variable passed to a function.
+	EnvVar struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
 	BuildStatus string
 
 	PackageSpec struct {
@@ -124,6 +147,24 @@ type (
 
 		// InvokeStrategy is a set of controls which affect how function executes
 		InvokeStrategy InvokeStrategy
+
+		// Capture optionally enables recording of sampled requests to this
+		// function, so that they can be inspected and replayed later.
+		Capture *RequestCaptureConfig `json:"capture,omitempty"`
+
+		// Secrets and ConfigMaps made available to the function, and
+		// literal environment variables set for it. Secrets and
+		// ConfigMaps are always looked up in the function's namespace.
+		Secrets    []SecretReference    `json:"secrets,omitempty"`
+		ConfigMaps []ConfigMapReference `json:"configmaps,omitempty"`
+		Env        []EnvVar             `json:"env,omitempty"`
+
+		// PodTemplate overrides the environment's pod template for this
+		// function; it is merged after the environment's, and
+		// addresses the runtime container by the function's name.  Only
+		// newdeploy functions have pods of their own, so it can't be
+		// used with poolmgr.
+		PodTemplate *v1.PodTemplateSpec `json:"podTemplate,omitempty"`
 	}
 
 	/*InvokeStrategy is a set of controls over how the function executes.
@@ -151,19 +192,54 @@ type (
 
 	MaxScale is the maximum number of pods that function will scale to based on TargetCPUPercent
 	and resources allocated to the function pod.
+
+	With the pool manager, a function starts out with one specialized pod. Another pod is
+	specialized, up to MaxScale, when the requests in flight per pod exceed TargetConcurrency
+	or the function's average response time exceeds TargetLatency (if set).
+
+	KeepWarm keeps idle instances of latency-sensitive functions around for longer.
 	*/
 	ExecutionStrategy struct {
-		ExecutorType     ExecutorType
-		MinScale         int
-		MaxScale         int
-		TargetCPUPercent int
+		ExecutorType      ExecutorType
+		MinScale          int
+		MaxScale          int
+		TargetCPUPercent  int
+		TargetConcurrency int
+		TargetLatency     metav1.Duration
+		KeepWarm          *KeepWarm `json:"keepWarm,omitempty"`
+	}
+
+	// KeepWarm controls when the idle instances of a function are
+	// released.  Both settings are optional.
+	KeepWarm struct {
+		// IdleTTL replaces the executor's idle time for the
+		// function's instances.
+		IdleTTL metav1.Duration `json:"idleTTL,omitempty"`
+
+		// Schedule is a cron spec ('0 30 8 * * 1-5', '@hourly').  When
+		// it fires, the function is specialized if it isn't running,
+		// and its instances aren't released for WindowDuration.
+		Schedule       string          `json:"schedule,omitempty"`
+		WindowDuration metav1.Duration `json:"windowDuration,omitempty"`
+	}
+
+	// RequestCaptureConfig controls how the router samples request and
+	// response pairs of a function or trigger for debugging.
+	RequestCaptureConfig struct {
+		// SampleRate is the fraction of requests to capture, between 0
+		// and 1. Zero disables capturing.
+		SampleRate float64 `json:"samplerate"`
+
+		// MaxBodySize is the maximum number of bytes recorded from each
+		// request and response body. Optional; defaults to 64KB.
+		MaxBodySize int64 `json:"maxbodysize"`
 	}
 
 	FunctionReferenceType string
 
 	FunctionReference struct {
-		// Type indicates whether this function reference is by name or selector. For now,
-		// the only supported reference type is by name.  Future reference types:
+		// Type indicates whether this function reference is by name or a pipeline of
+		// functions.  Future reference types:
 		//   * Function by label or annotation
 		//   * Branch or tag of a versioned function
 		//   * A "rolling upgrade" from one version of a function to another
@@ -171,6 +247,31 @@ type (
 
 		// Name of the function.
 		Name string `json:"name"`
+
+		// Pipeline lists the functions of a pipeline reference. Only
+		// used when Type is FunctionReferenceTypeFunctionPipeline.
+		// References by name have an empty pipeline.
+		Pipeline FunctionPipeline `json:"pipeline"`
+	}
+
+	// FunctionPipeline is an ordered composition of functions. The
+	// router invokes each step with the response body of the previous
+	// step, stops at the first non-2xx response, and returns the
+	// response of the last step.
+	FunctionPipeline struct {
+		Steps []PipelineStep `json:"steps,omitempty"`
+	}
+
+	PipelineStep struct {
+		// Name of the function for this step.
+		FunctionName string `json:"functionName"`
+
+		// Timeout for this step. Optional; defaults to 60s.
+		Timeout metav1.Duration `json:"timeout"`
+
+		// ForwardHeaders lists the response headers of this step that
+		// are passed to the next step. Content-Type is always passed.
+		ForwardHeaders []string `json:"forwardHeaders,omitempty"`
 	}
 
 	//
@@ -226,10 +327,86 @@ type (
 
 		// The initial pool size for environment
 		Poolsize int `json:"poolsize"`
+
+		// Optional bounds for autoscaling the pool of warm generic
+		// pods. When MaxPoolsize is set, the pool is resized between
+		// MinPoolsize (defaults to 1) and MaxPoolsize according to the
+		// rate at which pods are being specialized. Otherwise the
+		// pool stays at Poolsize.
+		MinPoolsize int `json:"minpoolsize,omitempty"`
+		MaxPoolsize int `json:"maxpoolsize,omitempty"`
+
+		// Optional. How the pool picks a warm pod to specialize
+		// for a function. Defaults to 'random'.
+		PodSelectionStrategy PodSelectionStrategy `json:"podSelectionStrategy,omitempty"`
+
+		// Optional tuning of the pool. Changes to these are applied
+		// to a running pool without replacing it.
+
+		// How long a function waits for a warm pod to become ready.
+		// Defaults to 5 minutes.
+		ReadinessTimeout metav1.Duration `json:"readinessTimeout,omitempty"`
+
+		// How long a specialized pod may stay idle before it is
+		// deleted. Defaults to 2 minutes.
+		IdlePodTTL metav1.Duration `json:"idlePodTTL,omitempty"`
+
+		// Whether specialized pods are reached through a Kubernetes
+		// Service rather than by pod IP. Services take a while to
+		// become routable, which slows down cold starts.
+		UseSvc bool `json:"useSvc,omitempty"`
+
+		// Pull policy for the runtime image. Defaults to the
+		// executor's RUNTIME_IMAGE_PULL_POLICY.
+		ImagePullPolicy v1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+		// How many times to retry connecting to a pod's runtime when
+		// specializing it. Defaults to 20.
+		SpecializationRetries int `json:"specializationRetries,omitempty"`
+
+		// PodTemplate is an optional partial pod template merged into
+		// the pods created for this environment, using Kubernetes
+		// strategic merge semantics: containers and volumes are merged
+		// by name, so a new name adds a sidecar or volume, and an
+		// existing one (the runtime container is named after the
+		// environment) is patched.  Use it for nodeSelector, tolerations, affinity,
+		// securityContext, annotations and the like.  Labels set by
+		// Fission can't be overridden.
+		PodTemplate *v1.PodTemplateSpec `json:"podTemplate,omitempty"`
+
+		// Whether to pull the environment's runtime, builder and
+		// fetcher images to every node ahead of time, so that new
+		// pools don't wait for image pulls.  The progress is
+		// reported in the environment's status.
+		PrePullImages bool `json:"prePullImages,omitempty"`
+	}
+
+	// EnvironmentStatus is maintained by Fission, not by users.  It
+	// isn't stored with the environment, so that it doesn't change
+	// the environment's resource version: the controller fills it in
+	// when it serves the environment.
+	EnvironmentStatus struct {
+		// Pulling of the environment's images to the nodes; nil
+		// unless the environment's PrePullImages is set and its
+		// pull started.
+		ImagePrePull *ImagePrePullStatus `json:"imagePrePull,omitempty"`
 	}
 
+	ImagePrePullStatus struct {
+		Phase        ImagePrePullPhase `json:"phase"`
+		Images       []string          `json:"images"`
+		NodesDesired int               `json:"nodesDesired"` // nodes the images are pulled to
+		NodesPulled  int               `json:"nodesPulled"`  // nodes that have all the images
+		Message      string            `json:"message,omitempty"`
+		UpdateTime   metav1.Time       `json:"updateTime"`
+	}
+
+	ImagePrePullPhase string
+
 	AllowedFunctionsPerContainer string
 
+	PodSelectionStrategy string
+
 	//
 	// Triggers
 	//
@@ -239,6 +416,10 @@ type (
 		RelativeURL       string            `json:"relativeurl"`
 		Method            string            `json:"method"`
 		FunctionReference FunctionReference `json:"functionref"`
+
+		// Capture optionally enables request capturing for this
+		// trigger. Overrides the function's capture config.
+		Capture *RequestCaptureConfig `json:"capture,omitempty"`
 	}
 
 	KubernetesWatchTriggerSpec struct {
@@ -298,11 +479,123 @@ type (
 
 		// Metatdata
 		FunctionMetadata *metav1.ObjectMeta
+
+		// ConfigPath is an absolute filesystem path to the
+		// function's Secrets, ConfigMaps and environment
+		// variables, laid out as secrets/<name>/<key>,
+		// configmaps/<name>/<key> and env.json (an object
+		// mapping variable names to values). Empty if the
+		// function has no configuration. The go and binary
+		// environments set the variables in env.json for the
+		// function; in other environments functions read the
+		// files themselves.
+		ConfigPath string `json:"configPath,omitempty"`
+	}
+)
+
+//
+// Request capture. Captured requests are recorded by the router and
+// served to the CLI through the controller; they are not stored as
+// resources.
+//
+type (
+	// CapturedRequest is one sampled request to a function and the
+	// response it got.
+	CapturedRequest struct {
+		ID                string `json:"id"`
+		FunctionName      string `json:"functionName"`
+		FunctionNamespace string `json:"functionNamespace"`
+		Trigger           string `json:"trigger,omitempty"`
+
+		Timestamp time.Time     `json:"timestamp"`
+		Latency   time.Duration `json:"latency"`
+
+		Method               string      `json:"method"`
+		URL                  string      `json:"url"`
+		RequestHeaders       http.Header `json:"requestHeaders"`
+		RequestBody          []byte      `json:"requestBody"`
+		RequestBodyTruncated bool        `json:"requestBodyTruncated"`
+
+		StatusCode            int         `json:"statusCode"`
+		ResponseHeaders       http.Header `json:"responseHeaders"`
+		ResponseBody          []byte      `json:"responseBody"`
+		ResponseBodyTruncated bool        `json:"responseBodyTruncated"`
+	}
+)
+
+//
+// Function services. The executor reports the running instances of
+// functions through its administration API, which the CLI reaches
+// through the controller.
+//
+type (
+	// FunctionServiceInfo is one running instance of a function.
+	FunctionServiceInfo struct {
+		Function        string       `json:"function"`
+		Namespace       string       `json:"namespace"`
+		ResourceVersion string       `json:"resourceVersion"`
+		Environment     string       `json:"environment"`
+		Name            string       `json:"name"`
+		Address         string       `json:"address"`
+		Pod             string       `json:"pod,omitempty"` // empty if the instance isn't a pod of its own
+		Executor        ExecutorType `json:"executor"`
+		Draining        bool         `json:"draining,omitempty"` // serving an old version of the function until its requests are done
+		Ctime           time.Time    `json:"ctime"`
+		Atime           time.Time    `json:"atime"`
+	}
+
+	// ColdStart is the timeline of a specialization of a function.
+	// Backends fill in the steps they have; the rest are zero.
+	ColdStart struct {
+		Function    string       `json:"function"`
+		Namespace   string       `json:"namespace"`
+		Environment string       `json:"environment"`
+		Executor    ExecutorType `json:"executor"`
+		Pod         string       `json:"pod,omitempty"`
+		StartTime   time.Time    `json:"startTime"`
+
+		// waiting for a ready pod, and relabeling it
+		ChoosePod metav1.Duration `json:"choosePod"`
+		Relabel   metav1.Duration `json:"relabel"`
+
+		// copying the package into the pod, in total and for the
+		// fetcher's download and unarchive steps
+		Fetch          metav1.Duration `json:"fetch"`
+		FetchDownload  metav1.Duration `json:"fetchDownload"`
+		FetchUnarchive metav1.Duration `json:"fetchUnarchive"`
+		FetchBytes     int64           `json:"fetchBytes"`
+
+		// the environment's specialize call, with retries
+		Specialize        metav1.Duration `json:"specialize"`
+		SpecializeRetries int             `json:"specializeRetries"`
+
+		Total metav1.Duration `json:"total"`
+		Error string          `json:"error,omitempty"`
+	}
+
+	// UsageRecord is the resource usage of a function over a period:
+	// the time its specialized pods ran, what they reserved meanwhile,
+	// and its invocations.  The executor keeps one record per function
+	// and hour; reports add up the hours of a time range.
+	UsageRecord struct {
+		Function  string    `json:"function"`
+		Namespace string    `json:"namespace"`
+		Start     time.Time `json:"start"`
+		End       time.Time `json:"end"`
+
+		PodSeconds      float64 `json:"podSeconds"`
+		CPUSeconds      float64 `json:"cpuSeconds"`      // CPU cores requested, times seconds
+		MemoryGBSeconds float64 `json:"memoryGBSeconds"` // GiB of memory requested, times seconds
+		Invocations     int64   `json:"invocations"`
 	}
 )
 
 const EXECUTOR_INSTANCEID_LABEL string = "executorInstanceId"
 
+// Annotation of an environment's image pre-pull DaemonSet with the
+// ImagePrePullStatus of the pull, as JSON
+const IMAGE_PREPULL_STATUS_ANNOTATION string = "imagePrePullStatus"
+
 const (
 	ChecksumTypeSHA256 ChecksumType = "sha256"
 )
@@ -329,11 +622,36 @@ const (
 	AllowedFunctionsPerContainerInfinite = "infinite"
 )
 
+const (
+	// PodSelectionStrategyRandom picks any ready pod.
+	PodSelectionStrategyRandom = "random"
+
+	// PodSelectionStrategySpread prefers nodes running the fewest
+	// specialized pods of the same function, spreading a function's
+	// pods across nodes.
+	PodSelectionStrategySpread = "spread"
+
+	// PodSelectionStrategyPackageCache prefers nodes that recently
+	// fetched the function's package.
+	PodSelectionStrategyPackageCache = "packagecache"
+
+	// PodSelectionStrategyLeastLoaded prefers nodes running the fewest
+	// specialized pods of the environment.
+	PodSelectionStrategyLeastLoaded = "leastloaded"
+)
+
 const (
 	ExecutorTypePoolmgr   = "poolmgr"
 	ExecutorTypeNewdeploy = "newdeploy"
 )
 
+const (
+	ImagePrePullPending = "pending" // waiting for nodes to start pulling
+	ImagePrePullPulling = "pulling"
+	ImagePrePullReady   = "ready" // every node has the images
+	ImagePrePullFailed  = "failed"
+)
+
 const (
 	StrategyTypeExecution = "execution"
 )
@@ -343,6 +661,10 @@ const (
 	// reference is simply by function name.
 	FunctionReferenceTypeFunctionName = "name"
 
+	// FunctionReferenceTypeFunctionPipeline means that the function
+	// reference is a pipeline of functions, executed in sequence.
+	FunctionReferenceTypeFunctionPipeline = "pipeline"
+
 	// Other function reference types we'd like to support:
 	//   Versioned function, latest version
 	//   Versioned function. by semver "latest compatible"
@@ -378,3 +700,13 @@ var errorDescriptions = []string{
 const (
 	ArchiveLiteralSizeLimit int64 = 256 * 1024
 )
+
+const (
+	// RequestCaptureDefaultMaxBodySize is used when a capture config
+	// doesn't specify MaxBodySize.
+	RequestCaptureDefaultMaxBodySize int64 = 64 * 1024
+
+	// RequestCaptureRedacted replaces the values of captured headers
+	// that carry credentials.
+	RequestCaptureRedacted = "[redacted]"
+)